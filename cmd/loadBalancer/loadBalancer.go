@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/url"
@@ -31,6 +32,8 @@ func main() {
 		slog.String("env", cfg.Env),
 		slog.String("proxy_host", cfg.Proxy.Host),
 		slog.String("proxy_port", cfg.Proxy.Port),
+		slog.String("admin_host", cfg.Admin.Host),
+		slog.String("admin_port", cfg.Admin.Port),
 		slog.Group("backends",
 			slog.Int("count", len(cfg.Backends)),
 			slog.Any("urls", cfg.Backends),
@@ -40,8 +43,16 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			panic(fmt.Sprintf("unknown command %q", args[0]))
+		}
+		mustRunMigrate(ctx, log, cfg.PostgreSQL, args[1:])
+		return
+	}
+
 	application := app.New(ctx, log, cfg, backends, cfg.RateLimiting.DefaultCapacity, cfg.RateLimiting.DefaultRatePerSecond)
-	go application.Run(ctx, cfg.PostgreSQL.Connection)
+	go application.Run(ctx, cfg.PostgreSQL)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
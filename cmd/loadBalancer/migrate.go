@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/app/pgapp"
+	"github.com/kurochkinivan/load_balancer/internal/config"
+	"github.com/kurochkinivan/load_balancer/internal/lib/metrics"
+	"github.com/kurochkinivan/load_balancer/internal/lib/migrate"
+	"github.com/kurochkinivan/load_balancer/internal/lib/sl"
+)
+
+const migrateUsage = "usage: loadBalancer --path=config.yaml migrate up | down [steps] | baseline <version> | status"
+
+// runMigrate runs the migrate command: up applies the pending migrations, down rolls back
+// the given number of the latest ones (1 by default), baseline records the migrations up to the version
+// as applied without running them and status lists all migrations.
+func runMigrate(ctx context.Context, log *slog.Logger, cfg config.PostgreSQLConfig, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	pgApp := pgapp.New(ctx, log, cfg, metrics.NewRegistry())
+	defer pgApp.Stop()
+
+	if err := pgApp.Run(ctx, cfg.Connection.Attempts, cfg.Connection.Delay); err != nil {
+		return err
+	}
+
+	migrator, err := pgApp.Migrator()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		printMigrations("applied", applied)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive number, got %q", args[1])
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		printMigrations("rolled back", rolledBack)
+		return err
+	case "baseline":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("version must be a number, got %q", args[1])
+		}
+		recorded, err := migrator.Baseline(ctx, version)
+		printMigrations("recorded", recorded)
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatuses(statuses)
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, %s", args[0], migrateUsage)
+	}
+}
+
+func printMigrations(action string, migrations []*migrate.Migration) {
+	for _, migration := range migrations {
+		fmt.Printf("%s %d_%s\n", action, migration.Version, migration.Name)
+	}
+	if len(migrations) == 0 {
+		fmt.Printf("nothing is %s\n", action)
+	}
+}
+
+func printStatuses(statuses []migrate.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.Applied {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	w.Flush()
+}
+
+func mustRunMigrate(ctx context.Context, log *slog.Logger, cfg config.PostgreSQLConfig, args []string) {
+	if err := runMigrate(ctx, log, cfg, args); err != nil {
+		log.Error("migrate command failed", sl.Error(err))
+		os.Exit(1)
+	}
+}
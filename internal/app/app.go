@@ -1,47 +1,456 @@
 package app
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
 	"log/slog"
+	"os"
+	"time"
 
 	"github.com/kurochkinivan/load_balancer/internal/app/httpapp"
 	"github.com/kurochkinivan/load_balancer/internal/app/pgapp"
 	"github.com/kurochkinivan/load_balancer/internal/config"
+	v1 "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/api"
+	"github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/middleware"
 	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/metrics"
+	"github.com/kurochkinivan/load_balancer/internal/lib/sl"
 	"github.com/kurochkinivan/load_balancer/internal/usecase"
 	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/cache"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/file"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/memory"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/peer"
 	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/pg"
 )
 
+const (
+	distributedModeNone     = "none"
+	distributedModePostgres = "postgres"
+	distributedModePeer     = "peer"
+)
+
+const (
+	storageDriverPostgres = "postgres"
+	storageDriverMemory   = "memory"
+	storageDriverFile     = "file"
+)
+
 type App struct {
-	log           *slog.Logger
-	HTTPApp       *httpapp.App
-	PostgreSQLApp *pgapp.App
+	log     *slog.Logger
+	HTTPApp *httpapp.App
+	// PostgreSQLApp is nil if the storage driver is not postgres.
+	PostgreSQLApp  *pgapp.App
+	clientsUseCase *usecase.ClientsUseCase
+	// pgStorage is nil if the storage driver is not postgres.
+	pgStorage      *pg.Storage
+	quotasUseCase  *usecase.QuotasUseCase
+	unknownClients *usecase.UnknownClientsUseCase
+	accessUseCase  *usecase.AccessUseCase
+	bansUseCase    *usecase.BansUseCase
+	activity       *usecase.ActivityUseCase
+	// auth is nil if the management API is not authenticated.
+	auth        *usecase.AuthUseCase
+	cacheConfig config.Cache
+	// distributedLimiter is nil if rate limits are not shared between instances.
+	distributedLimiter *usecase.DistributedLimiter
 }
 
 func New(ctx context.Context, log *slog.Logger, cfg *config.Config, backends []*entity.Backend, defaultCapacity, defaultRatePerSecond int32) *App {
-	pgApp := pgapp.New(ctx, log, cfg.PostgreSQL)
+	registry := metrics.NewRegistry()
+
+	var (
+		pgApp          *pgapp.App
+		pgStorage      *pg.Storage
+		clientsStorage storage
+		// health stays a nil interface without PostgreSQL, the other drivers are always available.
+		health usecase.StorageHealth
+	)
+	switch cfg.Storage.Driver {
+	case storageDriverPostgres, "":
+		pgApp = pgapp.New(ctx, log, cfg.PostgreSQL, registry)
+		pgStorage = pg.New(pgApp.Pool)
+		clientsStorage, health = pgStorage, pgApp
+	case storageDriverMemory:
+		clientsStorage = memory.New()
+	case storageDriverFile:
+		fileStorage, err := file.Open(cfg.Storage.Path)
+		if err != nil {
+			panic(fmt.Sprintf("failed to open file storage: %v", err))
+		}
+		clientsStorage = fileStorage
+	default:
+		panic(fmt.Sprintf("unknown storage driver %q", cfg.Storage.Driver))
+	}
+	log.Info("storage is set up", slog.String("driver", cmp.Or(cfg.Storage.Driver, storageDriverPostgres)))
+
+	clientsCache := newClientsCache(log, cfg.Cache, registry)
+
+	clientsUseCase := usecase.New(log, clientsStorage, health, clientsCache)
+	plansUseCase := usecase.NewPlans(log, clientsStorage, clientsUseCase)
+
+	unknownClientPolicy := usecase.UnknownClientPolicy(cfg.RateLimiting.UnknownClients.Policy)
+	if !unknownClientPolicy.IsValid() {
+		panic(fmt.Sprintf("unknown policy for unknown clients %q", unknownClientPolicy))
+	}
+	storageFailurePolicy := usecase.StorageFailurePolicy(cfg.PostgreSQL.Unavailable.Policy)
+	if !storageFailurePolicy.IsValid() {
+		panic(fmt.Sprintf("unknown policy for unavailable database %q", storageFailurePolicy))
+	}
+	unknownClients := usecase.NewUnknownClients(log, usecase.UnknownClientsOptions{
+		Policy:                unknownClientPolicy,
+		StorageFailure:        storageFailurePolicy,
+		DefaultCapacity:       defaultCapacity,
+		DefaultRatePerSecond:  defaultRatePerSecond,
+		RegisterRatePerSecond: cfg.RateLimiting.UnknownClients.RegisterRatePerSecond,
+		BatchSize:             cfg.RateLimiting.UnknownClients.BatchSize,
+		FlushInterval:         cfg.RateLimiting.UnknownClients.FlushInterval,
+	}, clientsCache, clientsStorage, registry)
 
-	clientsStorage := pg.New(pgApp.Pool)
-	clientsCache := cache.NewClientsCache(log, cfg.Cache.MaxElements)
+	distributedLimiter, countersReceiver := newDistributedLimiter(log, cfg.RateLimiting.Distributed, pgApp)
 
-	clientsUseCase := usecase.New(log, clientsStorage, clientsCache)
+	var recorder usecase.ConsumptionRecorder
+	if distributedLimiter != nil {
+		recorder = distributedLimiter
+	}
+
+	policiesUseCase := usecase.NewPolicies(log, mapPolicies(cfg.RateLimiting.Policies), recorder)
+
+	location, err := time.LoadLocation(cfg.Quotas.TimeZone)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load quotas time zone: %v", err))
+	}
+	quotasUseCase := usecase.NewQuotas(log, clientsStorage, clientsUseCase, location, cfg.Quotas.FlushInterval)
+
+	accessUseCase := usecase.NewAccess(log, clientsStorage)
+	activityUseCase := usecase.NewActivity(log, usecase.ActivityOptions{
+		Window:     cfg.Activity.Window,
+		Retention:  cfg.Activity.Retention,
+		MaxClients: cfg.Activity.MaxClients,
+	})
+	bansUseCase := usecase.NewBans(log, usecase.BanOptions{
+		Threshold:   cfg.AutoBan.Threshold,
+		Window:      cfg.AutoBan.Window,
+		Duration:    cfg.AutoBan.BanDuration,
+		MaxDuration: cfg.AutoBan.MaxBanDuration,
+	})
 
-	httpApp := httpapp.New(log, cfg, backends, clientsCache, clientsUseCase, clientsUseCase, clientsUseCase, defaultCapacity, defaultRatePerSecond)
+	// authenticator stays a nil interface if the authentication is disabled.
+	var (
+		authUseCase   *usecase.AuthUseCase
+		authenticator middleware.Authenticator
+	)
+	if cfg.Auth.Enabled {
+		authUseCase = newAuth(log, cfg.Auth)
+		authenticator = authUseCase
+	} else {
+		log.Warn("management API is not authenticated, anyone who reaches it can change the clients")
+	}
+
+	backendsUseCase := usecase.NewBackends(log, backends)
+
+	tokenRefillers := []httpapp.TokenRefiller{clientsCache, clientsUseCase, policiesUseCase}
+	httpApp := httpapp.New(log, cfg, backends, tokenRefillers, clientsUseCase, plansUseCase, clientsUseCase, unknownClients, policiesUseCase, quotasUseCase, accessUseCase, bansUseCase, activityUseCase, backendsUseCase, health, authenticator, countersReceiver, mapPriorityRules(cfg.Priorities.Rules), registry)
 
 	return &App{
-		log:           log,
-		PostgreSQLApp: pgApp,
-		HTTPApp:       httpApp,
+		log:                log,
+		PostgreSQLApp:      pgApp,
+		HTTPApp:            httpApp,
+		clientsUseCase:     clientsUseCase,
+		pgStorage:          pgStorage,
+		quotasUseCase:      quotasUseCase,
+		unknownClients:     unknownClients,
+		accessUseCase:      accessUseCase,
+		bansUseCase:        bansUseCase,
+		activity:           activityUseCase,
+		auth:               authUseCase,
+		cacheConfig:        cfg.Cache,
+		distributedLimiter: distributedLimiter,
 	}
 }
 
-func (a *App) Run(ctx context.Context, cfg config.PostgreSQLConnection) {
-	go a.PostgreSQLApp.MustRun(ctx, cfg.Attempts, cfg.Delay)
+func (a *App) Run(ctx context.Context, cfg config.PostgreSQLConfig) {
+	if a.PostgreSQLApp == nil {
+		go a.load(ctx)
+		if a.cacheConfig.ResyncInterval > 0 {
+			go a.clientsUseCase.StartResync(ctx, a.cacheConfig.ResyncInterval)
+		}
+	} else {
+		go a.runPostgreSQL(ctx, cfg)
+	}
 	go a.HTTPApp.MustStart(ctx)
+	go a.quotasUseCase.StartFlusher(ctx)
+	go a.bansUseCase.StartJanitor(ctx)
+	go a.activity.StartJanitor(ctx)
+	go a.unknownClients.StartRegistrar(ctx)
+
+	if a.auth != nil {
+		go a.auth.StartJanitor(ctx)
+	}
+
+	if a.distributedLimiter != nil {
+		go a.distributedLimiter.StartSync(ctx)
+	}
+}
+
+// runPostgreSQL connects to the database and loads the state once it is available.
+//
+// A database that is unavailable at the start is not fatal: requests are served in the degraded mode
+// and the watcher starts the application with the database once it is reachable.
+func (a *App) runPostgreSQL(ctx context.Context, cfg config.PostgreSQLConfig) {
+	migrated := false
+	onConnected := func(ctx context.Context) {
+		if cfg.Migrations.Auto && !migrated {
+			if err := a.PostgreSQLApp.Migrate(ctx); err != nil {
+				a.log.Error("failed to apply migrations, they are applied again on the next reconnection", sl.Error(err))
+			} else {
+				migrated = true
+			}
+		}
+		a.load(ctx)
+	}
+
+	if err := a.PostgreSQLApp.Run(ctx, cfg.Connection.Attempts, cfg.Connection.Delay); err != nil {
+		a.log.Error("postgresql database is unavailable at start, serving in degraded mode", sl.Error(err))
+	} else {
+		onConnected(ctx)
+	}
+
+	// The listener reconnects on its own and resyncs the cache once it listens.
+	if cfg.Changes.Enabled {
+		go a.clientsUseCase.StartChangeListener(ctx, a.pgStorage, cfg.Changes.RetryDelay, cfg.Changes.MaxRetryDelay)
+	}
+	if a.cacheConfig.ResyncInterval > 0 {
+		go a.clientsUseCase.StartResync(ctx, a.cacheConfig.ResyncInterval)
+	}
+
+	// Requests are served in the degraded mode while the database is unavailable,
+	// and the state is loaded again once it is back.
+	a.PostgreSQLApp.StartWatcher(ctx, cfg.Unavailable.WatchInterval, cfg.Unavailable.PingTimeout, onConnected)
 }
 
 func (a *App) Stop(ctx context.Context) {
 	a.HTTPApp.Stop(ctx)
-	a.PostgreSQLApp.Stop()
+	// Persist the usage of quotas and the clients registered since the last flush.
+	a.quotasUseCase.Flush(ctx)
+	a.unknownClients.Flush(ctx)
+	if a.PostgreSQLApp != nil {
+		a.PostgreSQLApp.Stop()
+	}
+}
+
+// load loads the state kept in memory from the database. Loading the CIDR ranges also purges the cache
+// of clients, including the ones limited by the default limits while the database was unavailable,
+// so the cache is warmed up again.
+//
+// It runs in the background, requests are served while it loads.
+func (a *App) load(ctx context.Context) {
+	if err := a.clientsUseCase.LoadRanges(ctx); err != nil {
+		a.log.Error("failed to load cidr ranges", sl.Error(err))
+	}
+	if a.cacheConfig.WarmUp > 0 {
+		// Clients preloaded beyond the size of the cache would only evict each other.
+		limit := min(a.cacheConfig.WarmUp, a.cacheConfig.MaxElements)
+		if err := a.clientsUseCase.WarmUp(ctx, limit); err != nil {
+			a.log.Error("failed to warm up the cache", sl.Error(err))
+		}
+	}
+	if err := a.accessUseCase.LoadRules(ctx); err != nil {
+		a.log.Error("failed to load access rules", sl.Error(err))
+	}
+}
+
+func newAuth(log *slog.Logger, cfg config.Auth) *usecase.AuthUseCase {
+	tokens := make([]*entity.APIToken, len(cfg.Tokens))
+	for i, token := range cfg.Tokens {
+		hash, err := hex.DecodeString(token.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			panic(fmt.Sprintf("token %q must have a hex-encoded SHA-256 hash", token.Name))
+		}
+		tokens[i] = &entity.APIToken{
+			Name: token.Name,
+			Hash: hash,
+			Role: mustParseRole(token.Role),
+		}
+	}
+
+	certificates := make(map[string]entity.Role)
+	if cfg.MTLS.Enabled {
+		for _, client := range cfg.MTLS.Clients {
+			certificates[client.CommonName] = mustParseRole(client.Role)
+		}
+	}
+
+	return usecase.NewAuth(log, usecase.AuthOptions{
+		Tokens:        tokens,
+		Certificates:  certificates,
+		MaxFailures:   cfg.Failures.Max,
+		FailureWindow: cfg.Failures.Window,
+	})
+}
+
+func mustParseRole(name string) entity.Role {
+	role := entity.Role(name)
+	if !role.IsValid() {
+		panic(fmt.Sprintf("unknown role %q", name))
+	}
+	return role
+}
+
+func mapPolicies(policies []config.RateLimitPolicy) []*entity.Policy {
+	mapped := make([]*entity.Policy, len(policies))
+	for i, policy := range policies {
+		mapped[i] = &entity.Policy{
+			Name:          policy.Name,
+			Methods:       policy.Methods,
+			Path:          policy.Path,
+			Cost:          policy.Cost,
+			Scope:         entity.PolicyScope(policy.Scope),
+			Capacity:      policy.Capacity,
+			RatePerSecond: policy.RatePerSecond,
+		}
+	}
+	return mapped
+}
+
+func mapPriorityRules(rules []config.PriorityRule) []*entity.PriorityRule {
+	mapped := make([]*entity.PriorityRule, len(rules))
+	for i, rule := range rules {
+		priority, ok := entity.ParsePriority(rule.Priority)
+		if !ok {
+			panic(fmt.Sprintf("unknown priority %q", rule.Priority))
+		}
+
+		mapped[i] = &entity.PriorityRule{
+			Priority:    priority,
+			Plans:       rule.Plans,
+			Methods:     rule.Methods,
+			Path:        rule.Path,
+			Header:      rule.Header,
+			HeaderValue: rule.HeaderValue,
+		}
+	}
+	return mapped
+}
+
+// storage keeps clients, plans, access rules and the usage of quotas.
+type storage interface {
+	usecase.ClientStorage
+	usecase.ClientRegistrar
+	usecase.PlanStorage
+	usecase.AccessRuleStorage
+	usecase.QuotaStorage
+}
+
+// clientsCache is a cache of clients that refills the buckets of the cached clients.
+type clientsCache interface {
+	usecase.ClientCache
+	httpapp.TokenRefiller
+}
+
+// newClientsCache creates a single cache or a sharded one according to the config.
+func newClientsCache(log *slog.Logger, cfg config.Cache, registry *metrics.Registry) clientsCache {
+	policy := cache.Policy(cfg.Policy)
+	if !policy.IsValid() {
+		panic(fmt.Sprintf("unknown cache eviction policy %q", policy))
+	}
+
+	opts := cache.Options{
+		MaxElements: cfg.MaxElements,
+		Policy:      policy,
+		Stats:       cache.NewStats(registry),
+		TTL:         cfg.TTL,
+		TTLJitter:   cfg.TTLJitter,
+		NegativeTTL: cfg.NegativeTTL,
+		States:      cache.NewBucketStates(cfg.BucketStates),
+	}
+
+	if cfg.Shards > 1 {
+		return cache.NewShardedClientsCache(log, cfg.Shards, opts)
+	}
+	return cache.NewClientsCache(log, opts)
+}
+
+// newDistributedLimiter creates a limiter that shares rate limits between several instances according to the config.
+// Both returned values are nil if the limits are not shared. The receiver is not nil only in the peer mode.
+func newDistributedLimiter(log *slog.Logger, cfg config.Distributed, pgApp *pgapp.App) (*usecase.DistributedLimiter, v1.CountersReceiver) {
+	instanceID := cfg.InstanceID
+	if instanceID == "" {
+		instanceID = defaultInstanceID()
+	}
+
+	var (
+		backend  usecase.RateLimitBackend
+		receiver v1.CountersReceiver
+	)
+	switch cfg.Mode {
+	case distributedModeNone, "":
+		return nil, nil
+	case distributedModePostgres:
+		if pgApp == nil {
+			panic("distributed rate limiting mode postgres requires the postgres storage driver")
+		}
+		backend = pg.NewRateLimitCounters(pgApp.Pool, instanceID, cfg.StaleAfter)
+	case distributedModePeer:
+		if cfg.PeerAuth.Token == "" && cfg.PeerAuth.CertFile == "" {
+			log.Warn("counters are sent to the peers without credentials, they are rejected if the peers require authentication")
+		}
+		transport := peer.NewHTTPTransport(cfg.PeerTimeout, cfg.PeerAuth.Token, newPeerTLSConfig(cfg.PeerAuth))
+		peerBackend := peer.New(log, instanceID, cfg.Peers, transport)
+		backend, receiver = peerBackend, peerBackend
+	default:
+		panic(fmt.Sprintf("unknown distributed rate limiting mode %q", cfg.Mode))
+	}
+
+	log.Info("rate limits are shared between instances",
+		slog.String("mode", cfg.Mode),
+		slog.String("instance_id", instanceID),
+	)
+
+	return usecase.NewDistributedLimiter(log, backend, cfg.SyncInterval), receiver
+}
+
+// newPeerTLSConfig returns the TLS config with the client certificate and the CAs of the peers,
+// nil if neither is configured.
+func newPeerTLSConfig(cfg config.PeerAuth) *tls.Config {
+	if cfg.CertFile == "" && cfg.CAFile == "" {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to load peer client certificate: %v", err))
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to read peer CA file: %v", err))
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			panic(fmt.Sprintf("no certificates found in peer CA file %q", cfg.CAFile))
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig
+}
+
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
 }
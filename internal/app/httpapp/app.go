@@ -2,11 +2,15 @@ package httpapp
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"net"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -15,16 +19,28 @@ import (
 	"github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/middleware"
 	"github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/proxy"
 	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/adaptive"
 	roundrobin "github.com/kurochkinivan/load_balancer/internal/lib/roundRobin"
+	"github.com/kurochkinivan/load_balancer/internal/lib/semaphore"
+	"github.com/kurochkinivan/load_balancer/internal/lib/wfq"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/peer"
 )
 
+// App serves two listeners: the proxy listener proxies every path to the backends, and the admin listener
+// carries the management API, the backends, health, metrics and the internal endpoints of other instances.
 type App struct {
-	log                *slog.Logger
-	server             *http.Server
-	reverseProxy       *proxy.ReverseProxy
-	tokenRifiller      TokenRefiller
+	log          *slog.Logger
+	server       *http.Server
+	adminServer  *http.Server
+	reverseProxy *proxy.ReverseProxy
+	// adminSocket is the path of the Unix socket the admin server also listens on, empty if it does not.
+	adminSocket        string
+	tokenRifillers     []TokenRefiller
 	healtCheckInterval time.Duration
 	workers            int
+	// certFile and keyFile are empty if the admin server does not use TLS.
+	certFile string
+	keyFile  string
 }
 
 const (
@@ -35,33 +51,78 @@ type TokenRefiller interface {
 	StartTokenRefiller(ctx context.Context)
 }
 
+type AccessUseCase interface {
+	middleware.AccessChecker
+	v1.AccessUseCase
+}
+
+type BansUseCase interface {
+	middleware.BanList
+	v1.BansUseCase
+}
+
+type ActivityUseCase interface {
+	middleware.ActivityRecorder
+	v1.ClientActivity
+}
+
+type QuotasUseCase interface {
+	middleware.QuotaConsumer
+	v1.QuotasUseCase
+}
+
 func New(
 	log *slog.Logger,
 	cfg *config.Config,
 	backends []*entity.Backend,
-	tokenRifiller TokenRefiller,
+	tokenRifillers []TokenRefiller,
 	clientsUseCase v1.ClientsUseCase,
+	plansUseCase v1.PlansUseCase,
 	clientProvider middleware.ClientProvider,
-	clientCreator middleware.ClientCreator,
-	defaultCapacity, defaultRatePerSecond int32,
+	unknownClients middleware.UnknownClients,
+	policies middleware.RateLimitPolicies,
+	quotasUseCase QuotasUseCase,
+	accessUseCase AccessUseCase,
+	bansUseCase BansUseCase,
+	activityUseCase ActivityUseCase,
+	backendsUseCase v1.BackendsUseCase,
+	storageHealth v1.StorageHealth,
+	authenticator middleware.Authenticator,
+	countersReceiver v1.CountersReceiver,
+	priorityRules []*entity.PriorityRule,
+	metrics v1.MetricsWriter,
 ) *App {
-	r := httprouter.New()
-
 	balancer := roundrobin.New(backends)
 	reverseProxy := proxy.New(log, backends, balancer)
-	r.NotFound = reverseProxy
-
-	clientsHandler := v1.NewClientsHandler(clientsUseCase, bytesLimit)
-	clientsHandler.Register(r)
 
-	// Base handler
-	baseHandler := func(w http.ResponseWriter, req *http.Request) error {
-		r.ServeHTTP(w, req)
+	var globalConcurrency *semaphore.Semaphore
+	if cfg.Concurrency.MaxInFlight > 0 {
+		globalConcurrency = semaphore.New(cfg.Concurrency.MaxInFlight, cfg.Concurrency.QueueSize)
+	}
+	proxyHandler := func(w http.ResponseWriter, req *http.Request) error {
+		reverseProxy.ServeHTTP(w, req)
 		return nil
 	}
+	if cfg.LoadShedding.Enabled {
+		proxyHandler = newLoadShedding(log, cfg.LoadShedding, proxyHandler)
+	}
+	if cfg.Priorities.FairQueue.Enabled {
+		proxyHandler = newFairQueue(log, cfg.Priorities.FairQueue, proxyHandler)
+	}
+
+	// Base handler
+	baseHandler := middleware.ConcurrencyLimitingMiddleware(log, globalConcurrency, middleware.ConcurrencyOptions{
+		RejectStatus: cfg.Concurrency.RejectStatus,
+		QueueSize:    cfg.Concurrency.QueueSize,
+		QueueTimeout: cfg.Concurrency.QueueTimeout,
+	}, proxyHandler)
 
 	// Middleware chain
-	handler := middleware.RateLimitingMiddleware(log, clientProvider, clientCreator, defaultCapacity, defaultRatePerSecond, baseHandler)
+	handler := middleware.PriorityMiddleware(priorityRules, cfg.Priorities.Header, baseHandler)
+	handler = middleware.QuotaMiddleware(log, quotasUseCase, handler)
+	handler = middleware.RateLimitingMiddleware(log, clientProvider, unknownClients, policies, cfg.RateLimiting.IPv6PrefixLength, handler)
+	handler = middleware.AccessMiddleware(log, accessUseCase, bansUseCase, cfg.RateLimiting.IPv6PrefixLength, handler)
+	handler = middleware.ActivityMiddleware(log, activityUseCase, cfg.RateLimiting.IPv6PrefixLength, handler)
 	handler = middleware.LogMiddleware(log, handler)
 	finalHandler := middleware.ErrorMiddleware(handler)
 
@@ -73,38 +134,194 @@ func New(
 		IdleTimeout:  cfg.Proxy.IdleTimeout,
 	}
 
+	adminServer := newAdminServer(log, cfg, authenticator, func(r *httprouter.Router) {
+		v1.NewClientsHandler(clientsUseCase, activityUseCase, bytesLimit).Register(r)
+		v1.NewPlansHandler(plansUseCase, bytesLimit).Register(r)
+		v1.NewAccessHandler(accessUseCase, bansUseCase, bytesLimit).Register(r)
+		v1.NewQuotasHandler(quotasUseCase).Register(r)
+		v1.NewBackendsHandler(backendsUseCase).Register(r)
+		v1.NewHealthHandler(backendsUseCase, storageHealth).Register(r)
+		v1.NewMetricsHandler(metrics).Register(r)
+
+		if countersReceiver != nil {
+			v1.NewCountersHandler(countersReceiver, peer.CountersPath, bytesLimit).Register(r)
+		}
+	})
+
+	var certFile, keyFile string
+	if cfg.Auth.MTLS.Enabled {
+		adminServer.TLSConfig = mustClientTLSConfig(cfg.Auth.MTLS.ClientCAFile)
+		certFile, keyFile = cfg.Auth.MTLS.CertFile, cfg.Auth.MTLS.KeyFile
+	}
+
 	return &App{
 		log:                log,
 		server:             server,
+		adminServer:        adminServer,
 		reverseProxy:       reverseProxy,
-		tokenRifiller:      tokenRifiller,
+		adminSocket:        cfg.Admin.Socket,
+		tokenRifillers:     tokenRifillers,
 		healtCheckInterval: cfg.Proxy.HealthCheck.Interval,
 		workers:            cfg.Proxy.HealthCheck.WorkersCount,
+		certFile:           certFile,
+		keyFile:            keyFile,
+	}
+}
+
+// newAdminServer creates the server of the handlers registered by register. The requests to the management API
+// are authenticated, the limits of the proxied requests do not apply.
+func newAdminServer(log *slog.Logger, cfg *config.Config, authenticator middleware.Authenticator, register func(r *httprouter.Router)) *http.Server {
+	r := httprouter.New()
+	register(r)
+
+	baseHandler := func(w http.ResponseWriter, req *http.Request) error {
+		v1.RawPathRouting(req)
+		v1.CustomMethodRouting(req)
+		r.ServeHTTP(w, req)
+		return nil
+	}
+
+	handler := middleware.AuthMiddleware(log, authenticator, cfg.RateLimiting.IPv6PrefixLength, baseHandler)
+	handler = middleware.LogMiddleware(log, handler)
+
+	return &http.Server{
+		Addr:         net.JoinHostPort(cfg.Admin.Host, cfg.Admin.Port),
+		Handler:      middleware.ErrorMiddleware(handler),
+		ReadTimeout:  cfg.Admin.ReadTimeout,
+		WriteTimeout: cfg.Admin.WriteTimeout,
+		IdleTimeout:  cfg.Admin.IdleTimeout,
 	}
 }
 
+// mustClientTLSConfig verifies the client certificates with the CAs from the file. Certificates are optional
+// on the TLS level, so token-authenticated requests, health and metrics still pass; the management API checks them.
+func mustClientTLSConfig(clientCAFile string) *tls.Config {
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		panic(fmt.Sprintf("failed to read client CA file: %v", err))
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		panic(fmt.Sprintf("no certificates found in client CA file %q", clientCAFile))
+	}
+
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// newLoadShedding puts an adaptive concurrency limiter in front of the proxy.
+func newLoadShedding(log *slog.Logger, cfg config.LoadShedding, next middleware.AppHandler) middleware.AppHandler {
+	limiter := adaptive.New(adaptive.Options{
+		InitialLimit: cfg.InitialLimit,
+		MinLimit:     cfg.MinLimit,
+		MaxLimit:     cfg.MaxLimit,
+		Backoff:      cfg.Backoff,
+		Tolerance:    cfg.Tolerance,
+	})
+
+	return middleware.LoadSheddingMiddleware(log, limiter, middleware.LoadSheddingOptions{
+		RetryAfter: cfg.RetryAfter,
+		Shares: map[entity.Priority]float64{
+			entity.PriorityCritical: cfg.Shares.Critical,
+			entity.PriorityNormal:   cfg.Shares.Normal,
+			entity.PriorityLow:      cfg.Shares.Low,
+		},
+	}, next)
+}
+
+// newFairQueue admits requests to the proxy through a weighted fair queue of the priority classes.
+func newFairQueue(log *slog.Logger, cfg config.FairQueue, next middleware.AppHandler) middleware.AppHandler {
+	classes := map[entity.Priority]config.PriorityClass{
+		entity.PriorityCritical: cfg.Classes.Critical,
+		entity.PriorityNormal:   cfg.Classes.Normal,
+		entity.PriorityLow:      cfg.Classes.Low,
+	}
+
+	queueClasses := make([]wfq.Class, len(classes))
+	timeouts := make(map[entity.Priority]time.Duration, len(classes))
+	for priority, class := range classes {
+		queueClasses[priority] = wfq.Class{Weight: class.Weight, QueueSize: class.QueueSize}
+		timeouts[priority] = class.QueueTimeout
+	}
+
+	return middleware.FairQueueMiddleware(log, wfq.New(cfg.MaxInFlight, queueClasses), timeouts, next)
+}
+
 func (a *App) MustStart(ctx context.Context) {
 	if err := a.Start(ctx); err != nil {
 		panic(err)
 	}
 }
 
+// Start serves the proxy and admin listeners until they are stopped.
+// It returns the error of the first listener that fails.
 func (a *App) Start(ctx context.Context) error {
 	go a.reverseProxy.StartHealthChecks(ctx, a.healtCheckInterval, a.workers)
-	go a.tokenRifiller.StartTokenRefiller(ctx)
+	for _, tokenRifiller := range a.tokenRifillers {
+		go tokenRifiller.StartTokenRefiller(ctx)
+	}
 
-	a.log.Info("listening to the server...", slog.String("addr", a.server.Addr))
-	err := a.server.ListenAndServe()
-	if err != nil && !errors.Is(err, http.ErrServerClosed) {
-		return fmt.Errorf("failed to listen and serve: %w", err)
+	listeners := []func() error{a.serveProxy, a.serveAdmin}
+	if a.adminSocket != "" {
+		listeners = append(listeners, a.serveAdminSocket)
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, serve := range listeners {
+		go func() {
+			errs <- serve()
+		}()
+	}
+
+	for range listeners {
+		err := <-errs
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("failed to listen and serve: %w", err)
+		}
 	}
 	return nil
 }
 
+func (a *App) serveProxy() error {
+	a.log.Info("listening to the server...", slog.String("addr", a.server.Addr))
+	return a.server.ListenAndServe()
+}
+
+func (a *App) serveAdmin() error {
+	a.log.Info("listening to the admin server...", slog.String("addr", a.adminServer.Addr), slog.Bool("tls", a.certFile != ""))
+	if a.certFile != "" {
+		return a.adminServer.ListenAndServeTLS(a.certFile, a.keyFile)
+	}
+	return a.adminServer.ListenAndServe()
+}
+
+// serveAdminSocket serves the admin server on the Unix socket without TLS, the access to the socket
+// is limited by its file permissions. A socket left by a previous run is replaced.
+func (a *App) serveAdminSocket() error {
+	if err := os.Remove(a.adminSocket); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale socket %q: %w", a.adminSocket, err)
+	}
+
+	listener, err := net.Listen("unix", a.adminSocket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on socket %q: %w", a.adminSocket, err)
+	}
+
+	a.log.Info("listening to the admin socket...", slog.String("path", a.adminSocket))
+	return a.adminServer.Serve(listener)
+}
+
 func (a *App) Stop(ctx context.Context) {
-	a.log.Info("stopping http server")
+	a.log.Info("stopping http servers")
 
 	if err := a.server.Shutdown(ctx); err != nil {
 		a.log.Error("failed to shutdown the server", slog.String("err", err.Error()))
 	}
+	if err := a.adminServer.Shutdown(ctx); err != nil {
+		a.log.Error("failed to shutdown the admin server", slog.String("err", err.Error()))
+	}
 }
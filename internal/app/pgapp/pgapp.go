@@ -4,10 +4,15 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/kurochkinivan/load_balancer/internal/config"
+	"github.com/kurochkinivan/load_balancer/internal/lib/metrics"
+	"github.com/kurochkinivan/load_balancer/internal/lib/migrate"
+	"github.com/kurochkinivan/load_balancer/internal/lib/sl"
+	"github.com/kurochkinivan/load_balancer/migrations"
 	pgclient "github.com/kurochkinivan/pgClient"
 )
 
@@ -19,9 +24,13 @@ type App struct {
 	username string
 	password string
 	db       string
+
+	healthy atomic.Bool
+	up      *metrics.Gauge
+	outages *metrics.Counter
 }
 
-func New(ctx context.Context, log *slog.Logger, cfg config.PostgreSQLConfig) *App {
+func New(ctx context.Context, log *slog.Logger, cfg config.PostgreSQLConfig, registry *metrics.Registry) *App {
 	pool, err := pgclient.NewClient(ctx, cfg.Username, cfg.Password, cfg.Host, cfg.Port, cfg.DB)
 	if err != nil {
 		panic("pgapp.New: " + err.Error())
@@ -35,6 +44,8 @@ func New(ctx context.Context, log *slog.Logger, cfg config.PostgreSQLConfig) *Ap
 		username: cfg.Username,
 		password: cfg.Password,
 		db:       cfg.DB,
+		up:       registry.Gauge("lb_storage_up", "Whether the PostgreSQL database is reachable."),
+		outages:  registry.Counter("lb_storage_outages_total", "Number of times the PostgreSQL database became unreachable."),
 	}
 }
 
@@ -61,9 +72,108 @@ func (a *App) Run(ctx context.Context, maxAttempts int, delay time.Duration) err
 
 	log.Info("connection to postgresql database is established")
 
+	a.healthy.Store(true)
+	a.up.Set(1)
+
+	return nil
+}
+
+// Migrator returns the migrator of the schema migrations embedded in the binary.
+// It adopts the databases created by docker-entrypoint-initdb.d before the migrations were tracked.
+func (a *App) Migrator() (*migrate.Migrator, error) {
+	migrator, err := migrate.New(a.Pool, migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	migrator.Adopt(migrations.InitVersion, migrations.InitColumns)
+
+	return migrator, nil
+}
+
+// Migrate applies the pending schema migrations. Instances started at once apply them one by one,
+// so every migration is applied exactly once.
+func (a *App) Migrate(ctx context.Context) error {
+	const op = "pgapp.Migrate"
+	log := a.log.With(slog.String("op", op))
+
+	migrator, err := a.Migrator()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	for _, migration := range applied {
+		log.Info("migration is applied", slog.Int64("version", migration.Version), slog.String("name", migration.Name))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("database schema is up to date", slog.Int("applied", len(applied)))
+
 	return nil
 }
 
+// Healthy reports whether the database was reachable during the last check.
+// It is false until the connection is established by Run.
+func (a *App) Healthy() bool {
+	return a.healthy.Load()
+}
+
+// StartWatcher pings the database every interval until the context is cancelled.
+//
+// When a ping fails, the database is reported as unhealthy and the application works in the degraded mode.
+// When the database is reachable again, onRecovered is called to catch up with the changes missed during the outage.
+// If the database has not been reachable since the start (Run has failed), the first successful ping
+// calls onRecovered too, so the application starts with the database once it is available.
+func (a *App) StartWatcher(ctx context.Context, interval, timeout time.Duration, onRecovered func(ctx context.Context)) {
+	const op = "pgapp.StartWatcher"
+	log := a.log.With(slog.String("op", op), slog.String("host", a.host), slog.String("port", a.port))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var since time.Time
+	if !a.healthy.Load() {
+		since = time.Now()
+		a.up.Set(0)
+	}
+	for {
+		select {
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, timeout)
+			err := a.Pool.Ping(pingCtx)
+			cancel()
+
+			switch {
+			case err != nil && a.healthy.Load():
+				since = time.Now()
+				a.healthy.Store(false)
+				a.up.Set(0)
+				a.outages.Inc()
+				log.Error("postgresql database is unreachable, switching to degraded mode", sl.Error(err))
+			case err != nil:
+				log.Warn("postgresql database is still unreachable",
+					slog.Duration("outage", time.Since(since)),
+					sl.Error(err),
+				)
+			case !a.healthy.Load():
+				a.healthy.Store(true)
+				a.up.Set(1)
+				log.Info("postgresql database is reachable again, leaving degraded mode",
+					slog.Duration("outage", time.Since(since)),
+				)
+				if onRecovered != nil {
+					onRecovered(ctx)
+				}
+			}
+		case <-ctx.Done():
+			log.Info("database watcher is terminated due to context cancellation")
+			return
+		}
+	}
+}
+
 func (a *App) Stop() {
 	const op = "pgapp.Stop"
 
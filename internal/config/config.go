@@ -11,10 +11,19 @@ import (
 type Config struct {
 	Env          string           `yaml:"env" env-required:"true"`
 	Proxy        ProxyConfig      `yaml:"proxy" env-required:"true"`
+	Admin        AdminConfig      `yaml:"admin"`
+	Storage      Storage          `yaml:"storage"`
 	PostgreSQL   PostgreSQLConfig `yaml:"postgresql" env-required:"true"`
 	Cache        Cache            `yaml:"cache" env-required:"true"`
 	Backends     []string         `yaml:"backends" env-required:"true"`
 	RateLimiting RateLimiting     `yaml:"rate_limiting" env-required:"true"`
+	Concurrency  Concurrency      `yaml:"concurrency"`
+	Quotas       Quotas           `yaml:"quotas"`
+	AutoBan      AutoBan          `yaml:"auto_ban"`
+	Activity     Activity         `yaml:"activity"`
+	LoadShedding LoadShedding     `yaml:"load_shedding"`
+	Priorities   Priorities       `yaml:"priorities"`
+	Auth         Auth             `yaml:"auth"`
 }
 
 type ProxyConfig struct {
@@ -26,18 +35,46 @@ type ProxyConfig struct {
 	HealthCheck  HealthCheck   `yaml:"health_check"`
 }
 
+// AdminConfig configures the listener of the management API, the backends, health and metrics.
+// The proxy listener proxies every path to the backends.
+type AdminConfig struct {
+	Host string `yaml:"host" env-default:"localhost"`
+	Port string `yaml:"port" env-default:"8081"`
+	// Socket is the path of a Unix socket the admin server also listens on, empty disables it.
+	Socket       string        `yaml:"socket"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" env-default:"5s"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env-default:"1m"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" env-default:"1m"`
+}
+
 type HealthCheck struct {
 	Interval     time.Duration `yaml:"interval" env-default:"30s"`
 	WorkersCount int           `yaml:"workers_count" env-default:"10"`
 }
 
+// Storage configures where clients, plans, access rules and the usage of quotas are kept.
+type Storage struct {
+	// Driver is one of "postgres", "memory" (the data is lost on restart) or "file" (a JSON file at Path).
+	Driver string `yaml:"driver" env-default:"postgres"`
+	Path   string `yaml:"path" env-default:"storage.json"`
+}
+
 type PostgreSQLConfig struct {
-	Host       string               `yaml:"host" env-default:"localhost"`
-	Port       string               `yaml:"port" env-default:"5435"`
-	Username   string               `yaml:"username" env-default:"postgres"`
-	Password   string               `yaml:"password" env-default:"postgres"`
-	DB         string               `yaml:"db" env-default:"clients"`
-	Connection PostgreSQLConnection `yaml:"connection"`
+	Host        string                `yaml:"host" env-default:"localhost"`
+	Port        string                `yaml:"port" env-default:"5435"`
+	Username    string                `yaml:"username" env-default:"postgres"`
+	Password    string                `yaml:"password" env-default:"postgres"`
+	DB          string                `yaml:"db" env-default:"clients"`
+	Connection  PostgreSQLConnection  `yaml:"connection"`
+	Unavailable PostgreSQLUnavailable `yaml:"unavailable"`
+	Changes     PostgreSQLChanges     `yaml:"changes"`
+	Migrations  PostgreSQLMigrations  `yaml:"migrations"`
+}
+
+// PostgreSQLMigrations configures the schema migrations embedded in the binary.
+type PostgreSQLMigrations struct {
+	// Auto applies the pending migrations on startup, otherwise they are applied by the migrate command.
+	Auto bool `yaml:"auto" env-default:"true"`
 }
 
 type PostgreSQLConnection struct {
@@ -45,13 +82,239 @@ type PostgreSQLConnection struct {
 	Delay    time.Duration `yaml:"delay" env-default:"5s"`
 }
 
+// PostgreSQLChanges configures the invalidation of the cache of clients changed by other balancer instances
+// through LISTEN/NOTIFY.
+type PostgreSQLChanges struct {
+	Enabled bool `yaml:"enabled" env-default:"true"`
+	// RetryDelay is the delay before the first reconnection, it doubles up to MaxRetryDelay.
+	RetryDelay    time.Duration `yaml:"retry_delay" env-default:"1s"`
+	MaxRetryDelay time.Duration `yaml:"max_retry_delay" env-default:"30s"`
+}
+
+// PostgreSQLUnavailable configures the degraded mode used while the database is unreachable.
+type PostgreSQLUnavailable struct {
+	// Policy is either "fail_open" (clients that can not be resolved get the default limits)
+	// or "fail_closed" (their requests are rejected with 503).
+	Policy        string        `yaml:"policy" env-default:"fail_open"`
+	WatchInterval time.Duration `yaml:"watch_interval" env-default:"5s"`
+	PingTimeout   time.Duration `yaml:"ping_timeout" env-default:"1s"`
+}
+
 type Cache struct {
 	MaxElements int `yaml:"max_elements" env-default:"50"`
+	// Shards is the number of independently locked parts of the cache, 1 means a single part.
+	Shards int `yaml:"shards" env-default:"16"`
+	// Policy is the eviction policy, one of "lru", "lfu" or "tinylfu".
+	Policy string `yaml:"policy" env-default:"lru"`
+	// TTL is how long a client stays in the cache, 0 means until it is evicted.
+	TTL       time.Duration `yaml:"ttl" env-default:"5m"`
+	TTLJitter time.Duration `yaml:"ttl_jitter" env-default:"30s"`
+	// NegativeTTL is how long an address that is not found stays in the cache, 0 disables the negative cache.
+	NegativeTTL time.Duration `yaml:"negative_ttl" env-default:"10s"`
+	// BucketStates is the maximum number of token buckets kept for clients that left the cache, 0 disables them.
+	BucketStates int `yaml:"bucket_states" env-default:"10000"`
+	// WarmUp is the number of clients preloaded into the cache on startup, 0 disables the warm-up.
+	WarmUp int `yaml:"warm_up" env-default:"0"`
+	// ResyncInterval is how often the cache is compared with the clients table, 0 disables the resync.
+	ResyncInterval time.Duration `yaml:"resync_interval" env-default:"5m"`
 }
 
 type RateLimiting struct {
-	DefaultCapacity      int32 `yaml:"default_capacity" env-required:"true"`
-	DefaultRatePerSecond int32 `yaml:"default_rate_per_second" env-required:"true"`
+	DefaultCapacity      int32             `yaml:"default_capacity" env-required:"true"`
+	DefaultRatePerSecond int32             `yaml:"default_rate_per_second" env-required:"true"`
+	IPv6PrefixLength     int               `yaml:"ipv6_prefix_length" env-default:"64"`
+	Policies             []RateLimitPolicy `yaml:"policies"`
+	Distributed          Distributed       `yaml:"distributed"`
+	UnknownClients       UnknownClients    `yaml:"unknown_clients"`
+}
+
+// UnknownClients configures how the requests of clients that are not in the database are handled.
+type UnknownClients struct {
+	// Policy is one of "reject", "default" (an in-memory bucket with the default limits)
+	// or "register" (the same and the client is registered in the database in the background).
+	Policy string `yaml:"policy" env-default:"register"`
+	// RegisterRatePerSecond caps the number of clients registered per second.
+	RegisterRatePerSecond int32         `yaml:"register_rate_per_second" env-default:"100"`
+	BatchSize             int           `yaml:"batch_size" env-default:"100"`
+	FlushInterval         time.Duration `yaml:"flush_interval" env-default:"1s"`
+}
+
+// Distributed configures sharing of rate limits between several balancer instances.
+type Distributed struct {
+	// Mode is one of "none", "postgres" or "peer".
+	Mode string `yaml:"mode" env-default:"none"`
+	// InstanceID identifies this instance, defaults to the hostname and the process id.
+	InstanceID   string        `yaml:"instance_id"`
+	SyncInterval time.Duration `yaml:"sync_interval" env-default:"200ms"`
+	// Peers are the base URLs of the other instances, used in the "peer" mode.
+	Peers       []string      `yaml:"peers"`
+	PeerTimeout time.Duration `yaml:"peer_timeout" env-default:"1s"`
+	PeerAuth    PeerAuth      `yaml:"peer_auth"`
+	// StaleAfter is how long the counters of an instance are kept without updates in the "postgres" mode.
+	// Older counters, e.g. of stopped instances, are left out of the totals and deleted.
+	StaleAfter time.Duration `yaml:"stale_after" env-default:"1m"`
+}
+
+// PeerAuth is the credentials the instance sends its counters to the peers with in the "peer" mode.
+// The peers must map the token or the common name of the certificate to the "peer" role.
+type PeerAuth struct {
+	// Token is sent as a bearer token, its hash is in the auth tokens of the peers.
+	Token string `yaml:"token"`
+	// CertFile and KeyFile are the client certificate, CAFile verifies the certificates of the peers.
+	// Empty files are not used.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+}
+
+// RateLimitPolicy is a rate-limit policy attached to routes, path patterns or methods.
+type RateLimitPolicy struct {
+	Name          string   `yaml:"name" env-required:"true"`
+	Methods       []string `yaml:"methods"`
+	Path          string   `yaml:"path"`
+	Cost          int32    `yaml:"cost" env-default:"1"`
+	Scope         string   `yaml:"scope" env-default:"client"`
+	Capacity      int32    `yaml:"capacity"`
+	RatePerSecond int32    `yaml:"rate_per_second"`
+}
+
+// Concurrency limits the number of requests processed at the same time.
+type Concurrency struct {
+	// MaxInFlight is the global limit of concurrent requests, 0 means no limit.
+	MaxInFlight int `yaml:"max_in_flight" env-default:"0"`
+	// RejectStatus is the status code of rejected requests, either 429 or 503.
+	RejectStatus int           `yaml:"reject_status" env-default:"429"`
+	QueueSize    int           `yaml:"queue_size" env-default:"0"`
+	QueueTimeout time.Duration `yaml:"queue_timeout" env-default:"1s"`
+}
+
+// Quotas configures the calendar quotas of clients.
+type Quotas struct {
+	// TimeZone defines the boundaries of the calendar periods, e.g. "UTC" or "Europe/Moscow".
+	TimeZone string `yaml:"time_zone" env-default:"UTC"`
+	// FlushInterval is how often the usage of quotas is persisted.
+	FlushInterval time.Duration `yaml:"flush_interval" env-default:"5s"`
+}
+
+// AutoBan configures temporary bans of clients that are rejected by the rate limits too often.
+type AutoBan struct {
+	// Threshold is the number of 429 responses within Window after which a client is banned, 0 disables bans.
+	Threshold int           `yaml:"threshold" env-default:"0"`
+	Window    time.Duration `yaml:"window" env-default:"1m"`
+	// BanDuration is the duration of the first ban, every next one is twice as long up to MaxBanDuration.
+	BanDuration    time.Duration `yaml:"ban_duration" env-default:"1m"`
+	MaxBanDuration time.Duration `yaml:"max_ban_duration" env-default:"24h"`
+}
+
+// Activity configures the tracking of the recent activity of clients shown by the clients API.
+type Activity struct {
+	// Window is the duration the 429 responses of a client are counted within.
+	Window time.Duration `yaml:"window" env-default:"1m"`
+	// Retention is how long a client that sends no requests is remembered.
+	Retention  time.Duration `yaml:"retention" env-default:"1h"`
+	MaxClients int           `yaml:"max_clients" env-default:"100000"`
+}
+
+// LoadShedding configures the adaptive limit of requests in flight to the backends.
+type LoadShedding struct {
+	Enabled      bool `yaml:"enabled" env-default:"false"`
+	InitialLimit int  `yaml:"initial_limit" env-default:"100"`
+	MinLimit     int  `yaml:"min_limit" env-default:"10"`
+	MaxLimit     int  `yaml:"max_limit" env-default:"1000"`
+	// Backoff is the factor the limit is multiplied by when the backends are overloaded.
+	Backoff float64 `yaml:"backoff" env-default:"0.9"`
+	// Tolerance is how many times the latency may exceed the latency without load.
+	Tolerance  float64        `yaml:"tolerance" env-default:"2"`
+	RetryAfter time.Duration  `yaml:"retry_after" env-default:"1s"`
+	Shares     PriorityShares `yaml:"shares"`
+}
+
+// PriorityShares are the shares of the adaptive limit available to the priority classes.
+type PriorityShares struct {
+	Critical float64 `yaml:"critical" env-default:"1"`
+	Normal   float64 `yaml:"normal" env-default:"0.9"`
+	Low      float64 `yaml:"low" env-default:"0.7"`
+}
+
+// Priorities configures the classification of requests into the critical, normal and low priority classes.
+type Priorities struct {
+	// Header classifies the requests not matched by the rules, e.g. "X-Priority: low". Empty disables it.
+	Header    string         `yaml:"header" env-default:"X-Priority"`
+	Rules     []PriorityRule `yaml:"rules"`
+	FairQueue FairQueue      `yaml:"fair_queue"`
+}
+
+// PriorityRule classifies the requests matching all of its conditions, the first matching rule wins.
+type PriorityRule struct {
+	Priority    string   `yaml:"priority" env-required:"true"`
+	Plans       []string `yaml:"plans"`
+	Methods     []string `yaml:"methods"`
+	Path        string   `yaml:"path"`
+	Header      string   `yaml:"header"`
+	HeaderValue string   `yaml:"header_value"`
+}
+
+// FairQueue configures the admission of requests to the backends through a weighted fair queue.
+type FairQueue struct {
+	Enabled     bool            `yaml:"enabled" env-default:"false"`
+	MaxInFlight int             `yaml:"max_in_flight" env-default:"100"`
+	Classes     PriorityClasses `yaml:"classes"`
+}
+
+type PriorityClasses struct {
+	Critical PriorityClass `yaml:"critical"`
+	Normal   PriorityClass `yaml:"normal"`
+	Low      PriorityClass `yaml:"low"`
+}
+
+// PriorityClass configures the queue of a priority class.
+type PriorityClass struct {
+	Weight       float64       `yaml:"weight" env-default:"1"`
+	QueueSize    int           `yaml:"queue_size" env-default:"100"`
+	QueueTimeout time.Duration `yaml:"queue_timeout" env-default:"1s"`
+}
+
+// Auth configures the authentication of the management API (/v1/api/).
+type Auth struct {
+	// Enabled requires a token or a client certificate for every request to the management API.
+	Enabled  bool         `yaml:"enabled" env-default:"false"`
+	Tokens   []AuthToken  `yaml:"tokens"`
+	MTLS     MTLS         `yaml:"mtls"`
+	Failures AuthFailures `yaml:"failures"`
+}
+
+// AuthToken is a static token of the management API.
+type AuthToken struct {
+	Name string `yaml:"name"`
+	// Hash is the hex-encoded SHA-256 hash of the token, the token itself is not kept in the config.
+	Hash string `yaml:"hash"`
+	// Role is one of "read-only", "operator", "admin" or "peer".
+	Role string `yaml:"role"`
+}
+
+// MTLS configures the TLS of the admin server and the authentication by client certificates.
+type MTLS struct {
+	Enabled  bool   `yaml:"enabled" env-default:"false"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile holds the PEM-encoded certificates of the CAs the client certificates are verified with.
+	ClientCAFile string `yaml:"client_ca_file"`
+	// Clients map the common names of the client certificates to their roles.
+	Clients []CertificateRole `yaml:"clients"`
+}
+
+// CertificateRole is the role of the client certificates with the common name.
+type CertificateRole struct {
+	CommonName string `yaml:"common_name"`
+	Role       string `yaml:"role"`
+}
+
+// AuthFailures configures the limit of failed authentication attempts per client.
+type AuthFailures struct {
+	// Max is the number of failures within Window after which the client is locked out until the window is over,
+	// 0 disables the limit.
+	Max    int           `yaml:"max" env-default:"10"`
+	Window time.Duration `yaml:"window" env-default:"5m"`
 }
 
 func MustLoadConfig() *Config {
@@ -0,0 +1,162 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/middleware"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase"
+)
+
+type AccessUseCase interface {
+	Rules(ctx context.Context) ([]*entity.AccessRule, error)
+	CreateRule(ctx context.Context, rule *entity.AccessRule) error
+	DeleteRule(ctx context.Context, network string) error
+}
+
+type BansUseCase interface {
+	Bans() []*entity.Ban
+	Unban(key string) error
+	Clear()
+}
+
+type AccessHandler struct {
+	accessUseCase AccessUseCase
+	bansUseCase   BansUseCase
+	bytesLimit    int64
+}
+
+func NewAccessHandler(accessUseCase AccessUseCase, bansUseCase BansUseCase, bytesLimit int64) *AccessHandler {
+	return &AccessHandler{
+		accessUseCase: accessUseCase,
+		bansUseCase:   bansUseCase,
+		bytesLimit:    bytesLimit,
+	}
+}
+
+func (h *AccessHandler) Register(router *httprouter.Router) {
+	router.GET("/v1/api/access/", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleReadOnly, h.rules)))
+	router.POST("/v1/api/access/", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleAdmin, h.createRule)))
+	router.DELETE("/v1/api/access/:network", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleAdmin, h.deleteRule)))
+
+	router.GET("/v1/api/bans/", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleReadOnly, h.bans)))
+	router.DELETE("/v1/api/bans/", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleOperator, h.clearBans)))
+	router.DELETE("/v1/api/bans/:ip_address", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleOperator, h.unban)))
+}
+
+func (h *AccessHandler) rules(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	rules, err := h.accessUseCase.Rules(r.Context())
+	if err != nil {
+		return httperror.InternalServerError(err, "failed to get access rules")
+	}
+
+	err = json.NewEncoder(w).Encode(rules)
+	if err != nil {
+		return httperror.ErrSerialize(err)
+	}
+
+	return nil
+}
+
+type createRuleRequest struct {
+	Network string              `json:"network"`
+	Action  entity.AccessAction `json:"action"`
+	Comment string              `json:"comment"`
+}
+
+func (h *AccessHandler) createRule(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	var req createRuleRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.bytesLimit)).Decode(&req)
+	if err != nil {
+		return httperror.ErrDeserialize(err)
+	}
+
+	if !req.Action.IsValid() {
+		return httperror.BadRequest(nil, "action must be either \"allow\" or \"deny\"")
+	}
+
+	err = h.accessUseCase.CreateRule(r.Context(), &entity.AccessRule{
+		Network: req.Network,
+		Action:  req.Action,
+		Comment: req.Comment,
+	})
+	if err != nil {
+		if errors.Is(err, usecase.ErrRuleExists) {
+			return httperror.Conflict(err, "access rule already exists")
+		}
+		if errors.Is(err, usecase.ErrInvalidIPAddress) {
+			return httperror.BadRequest(err, "invalid network")
+		}
+
+		return httperror.InternalServerError(err, "failed to create access rule")
+	}
+
+	w.WriteHeader(http.StatusCreated)
+
+	return nil
+}
+
+func (h *AccessHandler) deleteRule(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	network, err := unescapedParam(params, "network")
+	if err != nil {
+		return err
+	}
+
+	err = h.accessUseCase.DeleteRule(r.Context(), network)
+	if err != nil {
+		if errors.Is(err, usecase.ErrRuleNotFound) {
+			return httperror.NotFound(err, "access rule not found")
+		}
+		if errors.Is(err, usecase.ErrInvalidIPAddress) {
+			return httperror.BadRequest(err, "invalid network")
+		}
+
+		return httperror.InternalServerError(err, "failed to delete access rule")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (h *AccessHandler) bans(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	err := json.NewEncoder(w).Encode(h.bansUseCase.Bans())
+	if err != nil {
+		return httperror.ErrSerialize(err)
+	}
+
+	return nil
+}
+
+func (h *AccessHandler) clearBans(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	h.bansUseCase.Clear()
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (h *AccessHandler) unban(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	ipAddress, err := ipAddressParam(params)
+	if err != nil {
+		return err
+	}
+
+	err = h.bansUseCase.Unban(ipAddress)
+	if err != nil {
+		if errors.Is(err, usecase.ErrBanNotFound) {
+			return httperror.NotFound(err, "ban not found")
+		}
+
+		return httperror.InternalServerError(err, "failed to unban client")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
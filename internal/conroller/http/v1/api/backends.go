@@ -0,0 +1,91 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/middleware"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase"
+)
+
+type BackendsUseCase interface {
+	Backends() []*entity.Backend
+	SetEnabled(host string, enabled bool) (*entity.Backend, error)
+}
+
+// BackendsHandler shows the backends and takes them out of rotation and back.
+type BackendsHandler struct {
+	backendsUseCase BackendsUseCase
+}
+
+func NewBackendsHandler(backendsUseCase BackendsUseCase) *BackendsHandler {
+	return &BackendsHandler{
+		backendsUseCase: backendsUseCase,
+	}
+}
+
+func (h *BackendsHandler) Register(router *httprouter.Router) {
+	router.GET("/v1/api/backends/", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleReadOnly, h.backends)))
+	router.POST("/v1/api/backends/:host/enable", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleOperator, h.enable)))
+	router.POST("/v1/api/backends/:host/disable", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleOperator, h.disable)))
+}
+
+type backendResponse struct {
+	URL string `json:"url"`
+	// Available is the result of the last health check.
+	Available bool `json:"available"`
+	Enabled   bool `json:"enabled"`
+}
+
+func newBackendResponse(backend *entity.Backend) *backendResponse {
+	return &backendResponse{
+		URL:       backend.URL.String(),
+		Available: backend.IsAvailable(),
+		Enabled:   backend.IsEnabled(),
+	}
+}
+
+func (h *BackendsHandler) backends(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	backends := h.backendsUseCase.Backends()
+
+	resp := make([]*backendResponse, len(backends))
+	for i, backend := range backends {
+		resp[i] = newBackendResponse(backend)
+	}
+
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		return httperror.ErrSerialize(err)
+	}
+
+	return nil
+}
+
+func (h *BackendsHandler) enable(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	return h.setEnabled(w, params, true)
+}
+
+func (h *BackendsHandler) disable(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	return h.setEnabled(w, params, false)
+}
+
+func (h *BackendsHandler) setEnabled(w http.ResponseWriter, params httprouter.Params, enabled bool) error {
+	backend, err := h.backendsUseCase.SetEnabled(params.ByName("host"), enabled)
+	if err != nil {
+		if errors.Is(err, usecase.ErrBackendNotFound) {
+			return httperror.NotFound(err, "backend was not found")
+		}
+		return httperror.InternalServerError(err, "failed to change the rotation of the backend")
+	}
+
+	err = json.NewEncoder(w).Encode(newBackendResponse(backend))
+	if err != nil {
+		return httperror.ErrSerialize(err)
+	}
+
+	return nil
+}
@@ -1,10 +1,21 @@
 package v1
 
 import (
+	"cmp"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
@@ -14,38 +25,92 @@ import (
 )
 
 type ClientsUseCase interface {
-	Clients(ctx context.Context) ([]*entity.Client, error)
+	ListClients(ctx context.Context, query entity.ClientsQuery) (*entity.ClientsPage, error)
+	ClientState(ctx context.Context, ipAdress string) (*entity.ClientState, error)
+	ResetBucket(ctx context.Context, ipAdress string) error
 	CreateClient(ctx context.Context, client *entity.Client) error
+	ImportClients(ctx context.Context, rows []entity.ImportRow, opts entity.ImportOptions) (*entity.ImportResult, error)
+	ExportClients(ctx context.Context, fn func(clients []*entity.Client) error) error
 	UpdateClient(ctx context.Context, client *entity.Client) error
 	DeleteClient(ctx context.Context, ipAdress string) error
 }
 
+// ClientActivity is an interface that defines the method to get the recent activity of a client.
+type ClientActivity interface {
+	Activity(key string) (entity.ClientActivity, bool)
+}
+
 type ClientsHandler struct {
 	clientsUseCase ClientsUseCase
+	activity       ClientActivity
 	bytesLimit     int64
 }
 
-func NewClientsHandler(clientsUseCase ClientsUseCase, bytesLimit int64) *ClientsHandler {
+func NewClientsHandler(clientsUseCase ClientsUseCase, activity ClientActivity, bytesLimit int64) *ClientsHandler {
 	return &ClientsHandler{
 		clientsUseCase: clientsUseCase,
+		activity:       activity,
 		bytesLimit:     bytesLimit,
 	}
 }
 
 func (h *ClientsHandler) Register(router *httprouter.Router) {
-	router.GET("/v1/api/clients/", middleware.ErrorMiddlewareParams(h.clients))
-	router.POST("/v1/api/clients/", middleware.ErrorMiddlewareParams(h.createClient))
-	router.PUT("/v1/api/clients/:ip_address", middleware.ErrorMiddlewareParams(h.updateClient))
-	router.DELETE("/v1/api/clients/:ip_address", middleware.ErrorMiddlewareParams(h.deleteClient))
+	router.GET("/v1/api/clients/", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleReadOnly, h.clients)))
+	router.POST("/v1/api/clients/", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleOperator, h.createClient)))
+	router.POST(customMethods["/v1/api/clients:import"], middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleOperator, h.importClients)))
+	router.GET(customMethods["/v1/api/clients:export"], middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleReadOnly, h.exportClients)))
+	router.GET("/v1/api/clients/:ip_address", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleReadOnly, h.client)))
+	router.POST("/v1/api/clients/:ip_address/reset", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleOperator, h.resetBucket)))
+	router.PUT("/v1/api/clients/:ip_address", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleOperator, h.updateClient)))
+	router.DELETE("/v1/api/clients/:ip_address", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleOperator, h.deleteClient)))
 }
 
+type clientsResponse struct {
+	Clients    []*entity.Client `json:"clients"`
+	Pagination pagination       `json:"pagination"`
+}
+
+type pagination struct {
+	Limit int `json:"limit"`
+	// Next is the cursor of the next page, it is passed as the after parameter.
+	Next    string `json:"next,omitempty"`
+	HasMore bool   `json:"has_more"`
+}
+
+// clients returns a page of clients.
+//
+// Query parameters: limit, after (the cursor of the previous page), ip_prefix, network (a CIDR range),
+// plan, min_capacity, max_capacity and sort (a field, descending if prefixed with "-", e.g. "-capacity").
 func (h *ClientsHandler) clients(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
-	clients, err := h.clientsUseCase.Clients(r.Context())
+	query, err := parseClientsQuery(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	page, err := h.clientsUseCase.ListClients(r.Context(), query)
 	if err != nil {
-		return httperror.InternalServerError(err, "failed to get all clients")
+		return httperror.InternalServerError(err, "failed to get clients")
 	}
 
-	err = json.NewEncoder(w).Encode(clients)
+	resp := clientsResponse{
+		Clients: page.Clients,
+		Pagination: pagination{
+			Limit:   cmp.Or(query.Limit, usecase.DefaultClientsLimit),
+			HasMore: page.Next != nil,
+		},
+	}
+	if page.Next != nil {
+		resp.Pagination.Next, err = encodeCursor(clientsCursor{
+			Sort:          cmp.Or(query.Sort, entity.ClientsSortID),
+			Desc:          query.Desc,
+			ClientsCursor: page.Next,
+		})
+		if err != nil {
+			return httperror.ErrSerialize(err)
+		}
+	}
+
+	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
 		return httperror.ErrSerialize(err)
 	}
@@ -53,28 +118,237 @@ func (h *ClientsHandler) clients(w http.ResponseWriter, r *http.Request, params
 	return nil
 }
 
+func parseClientsQuery(values url.Values) (entity.ClientsQuery, error) {
+	query := entity.ClientsQuery{
+		IPPrefix: values.Get("ip_prefix"),
+		Plan:     values.Get("plan"),
+	}
+
+	var err error
+	if query.Limit, err = intParam(values, "limit", 1, usecase.MaxClientsLimit); err != nil {
+		return query, err
+	}
+
+	minCapacity, err := intParam(values, "min_capacity", 1, math.MaxInt32)
+	if err != nil {
+		return query, err
+	}
+	maxCapacity, err := intParam(values, "max_capacity", 1, math.MaxInt32)
+	if err != nil {
+		return query, err
+	}
+	query.MinCapacity, query.MaxCapacity = int32(minCapacity), int32(maxCapacity)
+
+	if network := values.Get("network"); network != "" {
+		query.Network, err = netip.ParsePrefix(network)
+		if err != nil {
+			return query, httperror.BadRequest(err, "network must be a cidr range")
+		}
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		sort, query.Desc = strings.CutPrefix(sort, "-")
+		query.Sort = entity.ClientsSort(sort)
+		if !query.Sort.IsValid() {
+			return query, httperror.BadRequest(nil, "sort must be one of \"id\", \"ip_address\", \"capacity\" or \"rate_per_second\"")
+		}
+	}
+
+	if after := values.Get("after"); after != "" {
+		cursor, err := decodeCursor(after)
+		if err != nil {
+			return query, httperror.BadRequest(err, "invalid after")
+		}
+		if cursor.Sort != cmp.Or(query.Sort, entity.ClientsSortID) || cursor.Desc != query.Desc {
+			return query, httperror.BadRequest(nil, "after belongs to a different sort")
+		}
+		query.After = cursor.ClientsCursor
+	}
+
+	return query, nil
+}
+
+// intParam returns the optional integer query parameter within [minValue, maxValue], 0 if it is missing.
+func intParam(values url.Values, name string, minValue, maxValue int) (int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < minValue || n > maxValue {
+		return 0, httperror.BadRequest(err, fmt.Sprintf("%s must be an integer from %d to %d", name, minValue, maxValue))
+	}
+	return n, nil
+}
+
+// clientsCursor is the position of a client together with the order it was taken in,
+// so a cursor can not be used with a different order.
+type clientsCursor struct {
+	Sort entity.ClientsSort `json:"s"`
+	Desc bool               `json:"d,omitempty"`
+	*entity.ClientsCursor
+}
+
+// encodeCursor returns an opaque cursor that is safe to pass in a URL.
+func encodeCursor(cursor clientsCursor) (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(encoded string) (clientsCursor, error) {
+	var cursor clientsCursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, err
+	}
+	if cursor.ClientsCursor == nil {
+		return cursor, errors.New("empty cursor")
+	}
+	return cursor, nil
+}
+
+type clientResponse struct {
+	*entity.Client
+	Runtime clientRuntime `json:"runtime"`
+}
+
+// clientRuntime is the state of the client on this instance of the balancer.
+type clientRuntime struct {
+	Cached     bool       `json:"cached"`
+	Tokens     int32      `json:"tokens"`
+	LastRefill *time.Time `json:"last_refill,omitempty"`
+	// Rejections is the number of requests rejected with 429 within the recent window.
+	Rejections int        `json:"rejections"`
+	LastSeen   *time.Time `json:"last_seen,omitempty"`
+}
+
+func (h *ClientsHandler) client(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	ipAdress, err := ipAddressParam(params)
+	if err != nil {
+		return err
+	}
+
+	state, err := h.clientsUseCase.ClientState(r.Context(), ipAdress)
+	if err != nil {
+		if errors.Is(err, usecase.ErrClientNotFound) {
+			return httperror.NotFound(err, "client not found")
+		}
+		if errors.Is(err, usecase.ErrInvalidIPAddress) {
+			return httperror.BadRequest(err, "invalid ip_address")
+		}
+
+		return httperror.InternalServerError(err, "failed to get client")
+	}
+
+	resp := clientResponse{
+		Client: state.Client,
+		Runtime: clientRuntime{
+			Cached:     state.Cached,
+			Tokens:     state.Tokens,
+			LastRefill: optionalTime(state.LastRefill),
+		},
+	}
+	if activity, ok := h.activity.Activity(state.Client.IPAddress); ok {
+		resp.Runtime.Rejections = activity.Rejections
+		resp.Runtime.LastSeen = optionalTime(activity.LastSeen)
+	}
+
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		return httperror.ErrSerialize(err)
+	}
+
+	return nil
+}
+
+func (h *ClientsHandler) resetBucket(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	ipAdress, err := ipAddressParam(params)
+	if err != nil {
+		return err
+	}
+
+	err = h.clientsUseCase.ResetBucket(r.Context(), ipAdress)
+	if err != nil {
+		if errors.Is(err, usecase.ErrClientNotFound) {
+			return httperror.NotFound(err, "client not found")
+		}
+		if errors.Is(err, usecase.ErrInvalidIPAddress) {
+			return httperror.BadRequest(err, "invalid ip_address")
+		}
+
+		return httperror.InternalServerError(err, "failed to reset bucket")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// optionalTime returns nil for the zero time, so it is omitted from the response.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
 type createClientRequest struct {
-	IPAddress     string `json:"ip_address"`
-	Capacity      int32  `json:"capacity"`
-	RatePerSecond int32  `json:"rate_per_second"`
+	IPAddress       string                           `json:"ip_address"`
+	Capacity        int32                            `json:"capacity"`
+	RatePerSecond   int32                            `json:"rate_per_second"`
+	LimitMode       entity.LimitMode                 `json:"limit_mode"`
+	MaxConcurrent   int32                            `json:"max_concurrent"`
+	QuotaPeriod     entity.QuotaPeriod               `json:"quota_period"`
+	QuotaLimit      int64                            `json:"quota_limit"`
+	Plan            string                           `json:"plan"`
+	PolicyOverrides map[string]entity.PolicyOverride `json:"policy_overrides"`
+	Pinned          bool                             `json:"pinned"`
 }
 
 func (h *ClientsHandler) createClient(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
 	var req createClientRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeStrict(http.MaxBytesReader(w, r.Body, h.bytesLimit), &req)
 	if err != nil {
-		return httperror.ErrDeserialize(err)
+		return err
 	}
 
-	err = h.clientsUseCase.CreateClient(r.Context(), &entity.Client{
-		IPAddress:     req.IPAddress,
-		Capacity:      req.Capacity,
-		RatePerSecond: req.RatePerSecond,
-	})
+	client := &entity.Client{
+		IPAddress:       req.IPAddress,
+		Capacity:        req.Capacity,
+		RatePerSecond:   req.RatePerSecond,
+		LimitMode:       req.LimitMode,
+		MaxConcurrent:   req.MaxConcurrent,
+		QuotaPeriod:     req.QuotaPeriod,
+		QuotaLimit:      req.QuotaLimit,
+		Plan:            req.Plan,
+		PolicyOverrides: req.PolicyOverrides,
+		Pinned:          req.Pinned,
+	}
+	if errs := validateClient(client); len(errs) > 0 {
+		return httperror.Validation(errs...)
+	}
+
+	err = h.clientsUseCase.CreateClient(r.Context(), client)
 	if err != nil {
 		if errors.Is(err, usecase.ErrClientExists) {
 			return httperror.Conflict(err, "client already exists")
 		}
+		if errors.Is(err, usecase.ErrInvalidIPAddress) {
+			return httperror.BadRequest(err, "invalid ip_address")
+		}
+		if errors.Is(err, usecase.ErrInvalidClient) {
+			return httperror.BadRequest(err, "invalid client")
+		}
+		if errors.Is(err, usecase.ErrPlanNotFound) {
+			return httperror.BadRequest(err, "plan not found")
+		}
 
 		return httperror.InternalServerError(err, "failed to create client")
 	}
@@ -85,31 +359,59 @@ func (h *ClientsHandler) createClient(w http.ResponseWriter, r *http.Request, pa
 }
 
 type updateClientRequest struct {
-	Capacity      int32 `json:"capacity"`
-	RatePerSecond int32 `json:"rate_per_second"`
+	Capacity        int32                            `json:"capacity"`
+	RatePerSecond   int32                            `json:"rate_per_second"`
+	LimitMode       entity.LimitMode                 `json:"limit_mode"`
+	MaxConcurrent   int32                            `json:"max_concurrent"`
+	QuotaPeriod     entity.QuotaPeriod               `json:"quota_period"`
+	QuotaLimit      int64                            `json:"quota_limit"`
+	Plan            string                           `json:"plan"`
+	PolicyOverrides map[string]entity.PolicyOverride `json:"policy_overrides"`
+	Pinned          bool                             `json:"pinned"`
 }
 
 func (h *ClientsHandler) updateClient(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
-	ipAdress := params.ByName("ip_address")
-	if ipAdress == "" {
-		return httperror.BadRequest(nil, "ipAdress is required")
+	ipAdress, err := ipAddressParam(params)
+	if err != nil {
+		return err
 	}
 
 	var req updateClientRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err = decodeStrict(http.MaxBytesReader(w, r.Body, h.bytesLimit), &req)
 	if err != nil {
-		return httperror.ErrDeserialize(err)
+		return err
+	}
+
+	client := &entity.Client{
+		IPAddress:       ipAdress,
+		Capacity:        req.Capacity,
+		RatePerSecond:   req.RatePerSecond,
+		LimitMode:       req.LimitMode,
+		MaxConcurrent:   req.MaxConcurrent,
+		QuotaPeriod:     req.QuotaPeriod,
+		QuotaLimit:      req.QuotaLimit,
+		Plan:            req.Plan,
+		PolicyOverrides: req.PolicyOverrides,
+		Pinned:          req.Pinned,
+	}
+	if errs := validateClient(client); len(errs) > 0 {
+		return httperror.Validation(errs...)
 	}
 
-	err = h.clientsUseCase.UpdateClient(r.Context(), &entity.Client{
-		IPAddress:     ipAdress,
-		Capacity:      req.Capacity,
-		RatePerSecond: req.RatePerSecond,
-	})
+	err = h.clientsUseCase.UpdateClient(r.Context(), client)
 	if err != nil {
 		if errors.Is(err, usecase.ErrClientNotFound) {
 			return httperror.NotFound(err, "client not found")
 		}
+		if errors.Is(err, usecase.ErrInvalidIPAddress) {
+			return httperror.BadRequest(err, "invalid ip_address")
+		}
+		if errors.Is(err, usecase.ErrInvalidClient) {
+			return httperror.BadRequest(err, "invalid client")
+		}
+		if errors.Is(err, usecase.ErrPlanNotFound) {
+			return httperror.BadRequest(err, "plan not found")
+		}
 
 		return httperror.InternalServerError(err, "failed to update client")
 	}
@@ -120,16 +422,19 @@ func (h *ClientsHandler) updateClient(w http.ResponseWriter, r *http.Request, pa
 }
 
 func (h *ClientsHandler) deleteClient(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
-	ipAdress := params.ByName("ip_address")
-	if ipAdress == "" {
-		return httperror.BadRequest(nil, "ipAdress is required")
+	ipAdress, err := ipAddressParam(params)
+	if err != nil {
+		return err
 	}
 
-	err := h.clientsUseCase.DeleteClient(r.Context(), ipAdress)
+	err = h.clientsUseCase.DeleteClient(r.Context(), ipAdress)
 	if err != nil {
 		if errors.Is(err, usecase.ErrClientNotFound) {
 			return httperror.NotFound(err, "client not found")
 		}
+		if errors.Is(err, usecase.ErrInvalidIPAddress) {
+			return httperror.BadRequest(err, "invalid ip_address")
+		}
 
 		return httperror.InternalServerError(err, "failed to delete client")
 	}
@@ -138,3 +443,96 @@ func (h *ClientsHandler) deleteClient(w http.ResponseWriter, r *http.Request, pa
 
 	return nil
 }
+
+// ipAddressParam returns the ip_address path parameter.
+//
+// CIDR ranges contain a slash, so they are passed URL-encoded, e.g. /v1/api/clients/10.0.0.0%2F8,
+// and the API is routed by the raw path (see RawPathRouting).
+func ipAddressParam(params httprouter.Params) (string, error) {
+	return unescapedParam(params, "ip_address")
+}
+
+// unescapedParam returns the URL-unescaped path parameter, which is required.
+func unescapedParam(params httprouter.Params, name string) (string, error) {
+	value, err := url.PathUnescape(params.ByName(name))
+	if err != nil {
+		return "", httperror.BadRequest(err, "invalid "+name)
+	}
+	if value == "" {
+		return "", httperror.BadRequest(nil, name+" is required")
+	}
+	return value, nil
+}
+
+// RawPathRouting makes the router match the API paths by their raw (still escaped) form,
+// so an escaped slash in a path parameter does not split it. Other paths are left as is.
+func RawPathRouting(r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/v1/api/") && r.URL.RawPath != "" {
+		r.URL.Path = r.URL.RawPath
+		r.URL.RawPath = ""
+	}
+}
+
+// decodeStrict decodes the JSON body into v. Unknown fields and fields of a wrong type are reported
+// as invalid fields.
+func decodeStrict(body io.Reader, v any) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err == nil {
+		return nil
+	}
+
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) && typeError.Field != "" {
+		return httperror.Validation(httperror.FieldError{Field: typeError.Field, Message: "must be " + jsonType(typeError.Type)})
+	}
+	// The decoder has no distinct error type for unknown fields.
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if unquoted, err := strconv.Unquote(field); err == nil {
+			field = unquoted
+		}
+		return httperror.Validation(httperror.FieldError{Field: field, Message: "is unknown"})
+	}
+
+	return httperror.ErrDeserialize(err)
+}
+
+// jsonType describes the JSON type a Go value is decoded from.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// validateClient checks the fields of a client from a request (see entity.Client.Validate).
+// The limit mode defaults to the shared one.
+func validateClient(client *entity.Client) []httperror.FieldError {
+	if client.LimitMode == "" {
+		client.LimitMode = entity.LimitModeShared
+	}
+
+	invalid := client.Validate()
+	if len(invalid) == 0 {
+		return nil
+	}
+
+	errs := make([]httperror.FieldError, len(invalid))
+	for i, err := range invalid {
+		errs[i] = httperror.FieldError{Field: err.Field, Message: err.Message}
+	}
+	return errs
+}
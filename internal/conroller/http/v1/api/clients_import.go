@@ -0,0 +1,491 @@
+package v1
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase"
+)
+
+const (
+	// importBytesLimit is the maximum size of an imported file.
+	importBytesLimit = 32 << 20
+	// maxLineLength is the maximum length of a line of an imported JSON Lines file.
+	maxLineLength = 1 << 20
+)
+
+const (
+	formatJSONLines = "jsonl"
+	formatCSV       = "csv"
+)
+
+var contentTypes = map[string]string{
+	formatJSONLines: "application/x-ndjson",
+	formatCSV:       "text/csv",
+}
+
+// csvColumns are the columns of clients in CSV, policy_overrides holds a JSON object.
+var csvColumns = []string{
+	"ip_address",
+	"capacity",
+	"rate_per_second",
+	"limit_mode",
+	"max_concurrent",
+	"quota_period",
+	"quota_limit",
+	"plan",
+	"pinned",
+	"policy_overrides",
+}
+
+// customMethods maps the custom methods of the API to the paths they are registered at,
+// httprouter can not route them as a colon starts a path parameter.
+var customMethods = map[string]string{
+	"/v1/api/clients:import": "/v1/api/clients.import",
+	"/v1/api/clients:export": "/v1/api/clients.export",
+}
+
+// CustomMethodRouting makes the router match the custom methods of the API, e.g. /v1/api/clients:import.
+// Other paths are left as is.
+func CustomMethodRouting(r *http.Request) {
+	if path, ok := customMethods[r.URL.Path]; ok {
+		r.URL.Path = path
+		r.URL.RawPath = ""
+	}
+}
+
+// clientRecord is a client in an imported or exported file. Its limits are the ones set for the client itself,
+// zero limits of a client with a plan are taken from the plan.
+type clientRecord struct {
+	IPAddress       string                           `json:"ip_address"`
+	Capacity        int32                            `json:"capacity"`
+	RatePerSecond   int32                            `json:"rate_per_second"`
+	LimitMode       entity.LimitMode                 `json:"limit_mode,omitempty"`
+	MaxConcurrent   int32                            `json:"max_concurrent,omitempty"`
+	QuotaPeriod     entity.QuotaPeriod               `json:"quota_period,omitempty"`
+	QuotaLimit      int64                            `json:"quota_limit,omitempty"`
+	Plan            string                           `json:"plan,omitempty"`
+	Pinned          bool                             `json:"pinned,omitempty"`
+	PolicyOverrides map[string]entity.PolicyOverride `json:"policy_overrides,omitempty"`
+}
+
+func newClientRecord(client *entity.Client) clientRecord {
+	return clientRecord{
+		IPAddress:       client.IPAddress,
+		Capacity:        client.Capacity,
+		RatePerSecond:   client.RatePerSecond,
+		LimitMode:       client.LimitMode,
+		MaxConcurrent:   client.MaxConcurrent,
+		QuotaPeriod:     client.QuotaPeriod,
+		QuotaLimit:      client.QuotaLimit,
+		Plan:            client.Plan,
+		Pinned:          client.Pinned,
+		PolicyOverrides: client.PolicyOverrides,
+	}
+}
+
+func (c clientRecord) client() *entity.Client {
+	return &entity.Client{
+		IPAddress:       c.IPAddress,
+		Capacity:        c.Capacity,
+		RatePerSecond:   c.RatePerSecond,
+		LimitMode:       c.LimitMode,
+		MaxConcurrent:   c.MaxConcurrent,
+		QuotaPeriod:     c.QuotaPeriod,
+		QuotaLimit:      c.QuotaLimit,
+		Plan:            c.Plan,
+		Pinned:          c.Pinned,
+		PolicyOverrides: c.PolicyOverrides,
+	}
+}
+
+// importClients creates or replaces clients from a JSON Lines or CSV file, all of them or none.
+//
+// Query parameters: format ("jsonl" or "csv", by default taken from the Content-Type),
+// mode ("insert" fails on existing clients, "upsert" replaces them) and dry_run.
+// Invalid rows are reported with their lines and 422.
+func (h *ClientsHandler) importClients(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	values := r.URL.Query()
+
+	format, err := importFormat(values, r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	opts, err := parseImportOptions(values)
+	if err != nil {
+		return err
+	}
+
+	body := http.MaxBytesReader(w, r.Body, importBytesLimit)
+
+	var rows []entity.ImportRow
+	if format == formatCSV {
+		rows, err = readCSV(body)
+	} else {
+		rows, err = readJSONLines(body)
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return httperror.New(err, "request body is too large", http.StatusRequestEntityTooLarge)
+		}
+		return httperror.ErrDeserialize(err)
+	}
+	if len(rows) == 0 {
+		return httperror.BadRequest(nil, "there are no clients to import")
+	}
+
+	for i, row := range rows {
+		if row.Err != nil {
+			continue
+		}
+		if errs := validateClient(row.Client); len(errs) > 0 {
+			rows[i].Err = joinFieldErrors(errs)
+		}
+	}
+
+	result, err := h.clientsUseCase.ImportClients(r.Context(), rows, opts)
+	if err != nil {
+		if errors.Is(err, usecase.ErrTooManyClients) {
+			return httperror.New(nil, fmt.Sprintf("at most %d clients can be imported at once", usecase.MaxImportClients), http.StatusRequestEntityTooLarge)
+		}
+		if errors.Is(err, usecase.ErrClientExists) || errors.Is(err, usecase.ErrPlanNotFound) {
+			return httperror.Conflict(err, "clients or plans were changed during the import, try again")
+		}
+
+		return httperror.InternalServerError(err, "failed to import clients")
+	}
+
+	if len(result.Errors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		return httperror.ErrSerialize(err)
+	}
+
+	return nil
+}
+
+// joinFieldErrors returns a single error of a row describing all its invalid fields.
+func joinFieldErrors(errs []httperror.FieldError) error {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Field + " " + err.Message
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// importFormat returns the format of the imported file, JSON Lines unless the format parameter
+// or the Content-Type says otherwise.
+func importFormat(values url.Values, contentType string) (string, error) {
+	if values.Has("format") {
+		return formatParam(values)
+	}
+	if strings.HasPrefix(contentType, contentTypes[formatCSV]) {
+		return formatCSV, nil
+	}
+	return formatJSONLines, nil
+}
+
+// formatParam returns the format parameter, JSON Lines if it is missing.
+func formatParam(values url.Values) (string, error) {
+	format := values.Get("format")
+	switch format {
+	case "":
+		return formatJSONLines, nil
+	case formatJSONLines, formatCSV:
+		return format, nil
+	default:
+		return "", httperror.BadRequest(nil, "format must be either \"jsonl\" or \"csv\"")
+	}
+}
+
+func parseImportOptions(values url.Values) (entity.ImportOptions, error) {
+	var opts entity.ImportOptions
+
+	switch values.Get("mode") {
+	case "", "insert":
+	case "upsert":
+		opts.Upsert = true
+	default:
+		return opts, httperror.BadRequest(nil, "mode must be either \"insert\" or \"upsert\"")
+	}
+
+	if dryRun := values.Get("dry_run"); dryRun != "" {
+		var err error
+		opts.DryRun, err = strconv.ParseBool(dryRun)
+		if err != nil {
+			return opts, httperror.BadRequest(err, "dry_run must be a boolean")
+		}
+	}
+
+	return opts, nil
+}
+
+// readJSONLines reads a client per line, empty lines are skipped. A line that is not a valid client
+// is returned as a row with an error, an error is returned only if the file can not be read.
+func readJSONLines(r io.Reader) ([]entity.ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineLength)
+
+	rows := make([]entity.ImportRow, 0)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		row := entity.ImportRow{Line: line}
+
+		var record clientRecord
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&record); err != nil {
+			row.Err = err
+		} else if decoder.More() {
+			row.Err = errors.New("a line must hold a single client")
+		} else {
+			row.Client = record.client()
+		}
+
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// readCSV reads a client per record. The first record is the header, which must contain ip_address
+// and may contain the other csvColumns in any order. A record that is not a valid client
+// is returned as a row with an error, an error is returned only if the file can not be read.
+func readCSV(r io.Reader) ([]entity.ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, column := range header {
+		if !slices.Contains(csvColumns, column) {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+	}
+	if !slices.Contains(header, "ip_address") {
+		return nil, errors.New("ip_address column is required")
+	}
+
+	rows := make([]entity.ImportRow, 0)
+	for {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := entity.ImportRow{Line: line}
+		if err != nil {
+			row.Err = fmt.Errorf("expected %d fields, got %d", len(header), len(fields))
+		} else {
+			row.Client, row.Err = parseCSVClient(header, fields)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func parseCSVClient(header, fields []string) (*entity.Client, error) {
+	var (
+		client = new(entity.Client)
+		err    error
+	)
+
+	for i, value := range fields {
+		if value == "" {
+			continue
+		}
+
+		switch column := header[i]; column {
+		case "ip_address":
+			client.IPAddress = value
+		case "capacity":
+			client.Capacity, err = parseInt32(column, value)
+		case "rate_per_second":
+			client.RatePerSecond, err = parseInt32(column, value)
+		case "limit_mode":
+			client.LimitMode = entity.LimitMode(value)
+		case "max_concurrent":
+			client.MaxConcurrent, err = parseInt32(column, value)
+		case "quota_period":
+			client.QuotaPeriod = entity.QuotaPeriod(value)
+		case "quota_limit":
+			client.QuotaLimit, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				err = fmt.Errorf("%s must be an integer", column)
+			}
+		case "plan":
+			client.Plan = value
+		case "pinned":
+			client.Pinned, err = strconv.ParseBool(value)
+			if err != nil {
+				err = fmt.Errorf("%s must be a boolean", column)
+			}
+		case "policy_overrides":
+			decoder := json.NewDecoder(strings.NewReader(value))
+			decoder.DisallowUnknownFields()
+			if err = decoder.Decode(&client.PolicyOverrides); err != nil {
+				err = fmt.Errorf("%s must be a JSON object: %w", column, err)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+func parseInt32(column, value string) (int32, error) {
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", column)
+	}
+	return int32(n), nil
+}
+
+// exportClients streams all clients in JSON Lines or CSV (the format parameter) as they are imported.
+//
+// The response is streamed page by page, so an error after the first page can not be reported
+// with a status code, then the response is aborted and the client sees it truncated.
+func (h *ClientsHandler) exportClients(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	format, err := formatParam(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"clients.%s\"", format))
+
+	write := writeJSONLines(w)
+	if format == formatCSV {
+		write = writeCSV(w)
+	}
+
+	controller := http.NewResponseController(w)
+	started := false
+
+	err = h.clientsUseCase.ExportClients(r.Context(), func(clients []*entity.Client) error {
+		started = true
+		if err := write(clients); err != nil {
+			return err
+		}
+		return controller.Flush()
+	})
+	if err != nil {
+		if started {
+			panic(http.ErrAbortHandler)
+		}
+		return httperror.InternalServerError(err, "failed to export clients")
+	}
+
+	// The header of an empty CSV file is not written yet.
+	if !started {
+		return write(nil)
+	}
+
+	return nil
+}
+
+func writeJSONLines(w io.Writer) func(clients []*entity.Client) error {
+	encoder := json.NewEncoder(w)
+
+	return func(clients []*entity.Client) error {
+		for _, client := range clients {
+			if err := encoder.Encode(newClientRecord(client)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// writeCSV returns a function that writes the header before the first clients.
+func writeCSV(w io.Writer) func(clients []*entity.Client) error {
+	writer := csv.NewWriter(w)
+	header := true
+
+	return func(clients []*entity.Client) error {
+		if header {
+			header = false
+			if err := writer.Write(csvColumns); err != nil {
+				return err
+			}
+		}
+
+		for _, client := range clients {
+			record, err := csvRecord(client)
+			if err != nil {
+				return err
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+		return writer.Error()
+	}
+}
+
+// csvRecord returns the fields of the client in the order of csvColumns.
+func csvRecord(client *entity.Client) ([]string, error) {
+	var policyOverrides string
+	if len(client.PolicyOverrides) > 0 {
+		data, err := json.Marshal(client.PolicyOverrides)
+		if err != nil {
+			return nil, err
+		}
+		policyOverrides = string(data)
+	}
+
+	return []string{
+		client.IPAddress,
+		strconv.FormatInt(int64(client.Capacity), 10),
+		strconv.FormatInt(int64(client.RatePerSecond), 10),
+		string(client.LimitMode),
+		strconv.FormatInt(int64(client.MaxConcurrent), 10),
+		string(client.QuotaPeriod),
+		strconv.FormatInt(client.QuotaLimit, 10),
+		client.Plan,
+		strconv.FormatBool(client.Pinned),
+		policyOverrides,
+	}, nil
+}
@@ -0,0 +1,288 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/middleware"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientsUseCaseMock records the clients it is called with and returns err.
+type clientsUseCaseMock struct {
+	created []*entity.Client
+	updated []*entity.Client
+	err     error
+}
+
+func (m *clientsUseCaseMock) ListClients(context.Context, entity.ClientsQuery) (*entity.ClientsPage, error) {
+	return &entity.ClientsPage{}, m.err
+}
+
+func (m *clientsUseCaseMock) ClientState(context.Context, string) (*entity.ClientState, error) {
+	return nil, m.err
+}
+
+func (m *clientsUseCaseMock) ResetBucket(context.Context, string) error {
+	return m.err
+}
+
+func (m *clientsUseCaseMock) CreateClient(_ context.Context, client *entity.Client) error {
+	m.created = append(m.created, client)
+	return m.err
+}
+
+func (m *clientsUseCaseMock) ImportClients(context.Context, []entity.ImportRow, entity.ImportOptions) (*entity.ImportResult, error) {
+	return &entity.ImportResult{}, m.err
+}
+
+func (m *clientsUseCaseMock) ExportClients(context.Context, func(clients []*entity.Client) error) error {
+	return m.err
+}
+
+func (m *clientsUseCaseMock) UpdateClient(_ context.Context, client *entity.Client) error {
+	m.updated = append(m.updated, client)
+	return m.err
+}
+
+func (m *clientsUseCaseMock) DeleteClient(context.Context, string) error {
+	return m.err
+}
+
+type activityMock struct{}
+
+func (activityMock) Activity(string) (entity.ClientActivity, bool) {
+	return entity.ClientActivity{}, false
+}
+
+// newTestServer serves the clients API to an admin, as the application does after the authentication.
+func newTestServer(clientsUseCase ClientsUseCase) http.Handler {
+	router := httprouter.New()
+	NewClientsHandler(clientsUseCase, activityMock{}, 1<<20).Register(router)
+
+	admin := &entity.Identity{Name: "admin", Role: entity.RoleAdmin}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RawPathRouting(r)
+		router.ServeHTTP(w, r.WithContext(middleware.ContextWithIdentity(r.Context(), admin)))
+	})
+}
+
+// serve sends the request and decodes the problem details of the response, if there are any.
+func serve(t *testing.T, handler http.Handler, method, target, body string) (*httptest.ResponseRecorder, *httperror.HTTPError) {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code < http.StatusBadRequest {
+		return w, nil
+	}
+
+	assert.Equal(t, httperror.ContentType, w.Header().Get("Content-Type"))
+	problem := new(httperror.HTTPError)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), problem))
+	assert.Equal(t, w.Code, problem.Code, "the status is repeated in the problem")
+	return w, problem
+}
+
+func TestClientsHandler_CreateClient_Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		errors []httperror.FieldError
+	}{
+		{
+			name: "Invalid IP address",
+			body: `{"ip_address": "10.0.0.256", "capacity": 10, "rate_per_second": 1}`,
+			errors: []httperror.FieldError{
+				{Field: "ip_address", Message: "must be an IP address or a CIDR range"},
+			},
+		},
+		{
+			name: "Invalid CIDR range",
+			body: `{"ip_address": "10.0.0.0/33", "capacity": 10, "rate_per_second": 1}`,
+			errors: []httperror.FieldError{
+				{Field: "ip_address", Message: "must be an IP address or a CIDR range"},
+			},
+		},
+		{
+			name: "Missing fields",
+			body: `{}`,
+			errors: []httperror.FieldError{
+				{Field: "ip_address", Message: "is required"},
+				{Field: "capacity", Message: "must be positive"},
+				{Field: "rate_per_second", Message: "must be positive"},
+			},
+		},
+		{
+			name: "Invalid limits",
+			body: `{"ip_address": "10.0.0.1", "capacity": 5, "rate_per_second": 10, "limit_mode": "global",
+				"max_concurrent": -1, "quota_period": "week", "quota_limit": -5}`,
+			errors: []httperror.FieldError{
+				{Field: "limit_mode", Message: `must be either "shared" or "per_ip"`},
+				{Field: "capacity", Message: "must not be less than rate_per_second"},
+				{Field: "max_concurrent", Message: "must not be negative"},
+				{Field: "quota_period", Message: `must be one of "hour", "day" or "month"`},
+				{Field: "quota_limit", Message: "must not be negative"},
+			},
+		},
+		{
+			name: "Quota limit without period",
+			body: `{"ip_address": "10.0.0.1", "capacity": 10, "rate_per_second": 1, "quota_limit": 100}`,
+			errors: []httperror.FieldError{
+				{Field: "quota_limit", Message: "must be set together with quota_period"},
+			},
+		},
+		{
+			name: "Negative limits of a client of a plan",
+			body: `{"ip_address": "10.0.0.0/8", "plan": "pro", "capacity": -1}`,
+			errors: []httperror.FieldError{
+				{Field: "capacity", Message: "must not be negative"},
+			},
+		},
+		{
+			name: "Invalid policy overrides",
+			body: `{"ip_address": "10.0.0.1", "capacity": 10, "rate_per_second": 1,
+				"policy_overrides": {"search": {"capacity": 1, "rate_per_second": 2, "cost": -1}}}`,
+			errors: []httperror.FieldError{
+				{Field: "policy_overrides.search.cost", Message: "must not be negative"},
+				{Field: "policy_overrides.search.capacity", Message: "must not be less than rate_per_second"},
+			},
+		},
+		{
+			name: "Wrong type",
+			body: `{"ip_address": "10.0.0.1", "capacity": "ten", "rate_per_second": 1}`,
+			errors: []httperror.FieldError{
+				{Field: "capacity", Message: "must be an integer"},
+			},
+		},
+		{
+			name: "Unknown field",
+			body: `{"ip_address": "10.0.0.1", "capacity": 10, "rate_per_second": 1, "burst": 5}`,
+			errors: []httperror.FieldError{
+				{Field: "burst", Message: "is unknown"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientsUseCase := &clientsUseCaseMock{}
+
+			w, problem := serve(t, newTestServer(clientsUseCase), http.MethodPost, "/v1/api/clients/", tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			require.NotNil(t, problem)
+			assert.Equal(t, httperror.TypeValidation, problem.Type)
+			assert.Equal(t, "/v1/api/clients/", problem.Instance)
+			assert.Equal(t, tt.errors, problem.Errors)
+			assert.Empty(t, clientsUseCase.created, "an invalid client is not created")
+		})
+	}
+}
+
+func TestClientsHandler_CreateClient(t *testing.T) {
+	t.Run("Valid client", func(t *testing.T) {
+		clientsUseCase := &clientsUseCaseMock{}
+
+		w, _ := serve(t, newTestServer(clientsUseCase), http.MethodPost, "/v1/api/clients/",
+			`{"ip_address": "2001:db8::/32", "capacity": 10, "rate_per_second": 1, "quota_period": "day", "quota_limit": 100}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		require.Len(t, clientsUseCase.created, 1)
+		assert.Equal(t, entity.LimitModeShared, clientsUseCase.created[0].LimitMode, "the limit mode defaults to shared")
+	})
+
+	t.Run("Limits are taken from the plan", func(t *testing.T) {
+		clientsUseCase := &clientsUseCaseMock{}
+
+		w, _ := serve(t, newTestServer(clientsUseCase), http.MethodPost, "/v1/api/clients/", `{"ip_address": "10.0.0.1", "plan": "pro"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Len(t, clientsUseCase.created, 1)
+	})
+
+	t.Run("Existing client", func(t *testing.T) {
+		clientsUseCase := &clientsUseCaseMock{err: usecase.ErrClientExists}
+
+		w, problem := serve(t, newTestServer(clientsUseCase), http.MethodPost, "/v1/api/clients/",
+			`{"ip_address": "10.0.0.1", "capacity": 10, "rate_per_second": 1}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		require.NotNil(t, problem)
+		assert.Equal(t, httperror.TypeDefault, problem.Type)
+		assert.Equal(t, "Conflict", problem.Title)
+		assert.Empty(t, problem.Errors)
+	})
+}
+
+func TestClientsHandler_UpdateClient(t *testing.T) {
+	t.Run("Invalid limits", func(t *testing.T) {
+		clientsUseCase := &clientsUseCaseMock{}
+
+		w, problem := serve(t, newTestServer(clientsUseCase), http.MethodPut, "/v1/api/clients/10.0.0.0%2F8",
+			`{"capacity": 0, "rate_per_second": -1}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		require.NotNil(t, problem)
+		assert.Equal(t, "/v1/api/clients/10.0.0.0%2F8", problem.Instance, "the instance is the path as it is sent")
+		assert.Equal(t, []httperror.FieldError{
+			{Field: "capacity", Message: "must be positive"},
+			{Field: "rate_per_second", Message: "must be positive"},
+		}, problem.Errors)
+		assert.Empty(t, clientsUseCase.updated)
+	})
+
+	t.Run("Invalid CIDR range", func(t *testing.T) {
+		clientsUseCase := &clientsUseCaseMock{}
+
+		w, problem := serve(t, newTestServer(clientsUseCase), http.MethodPut, "/v1/api/clients/10.0.0.0%2F40",
+			`{"capacity": 10, "rate_per_second": 1}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		require.NotNil(t, problem)
+		assert.Equal(t, []httperror.FieldError{
+			{Field: "ip_address", Message: "must be an IP address or a CIDR range"},
+		}, problem.Errors)
+	})
+
+	t.Run("Valid range", func(t *testing.T) {
+		clientsUseCase := &clientsUseCaseMock{}
+
+		w, _ := serve(t, newTestServer(clientsUseCase), http.MethodPut, "/v1/api/clients/10.0.0.0%2F8",
+			`{"capacity": 10, "rate_per_second": 1, "limit_mode": "per_ip"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		require.Len(t, clientsUseCase.updated, 1)
+		assert.Equal(t, "10.0.0.0/8", clientsUseCase.updated[0].IPAddress)
+	})
+
+	t.Run("Missing client", func(t *testing.T) {
+		clientsUseCase := &clientsUseCaseMock{err: usecase.ErrClientNotFound}
+
+		w, problem := serve(t, newTestServer(clientsUseCase), http.MethodPut, "/v1/api/clients/10.0.0.1",
+			`{"capacity": 10, "rate_per_second": 1}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		require.NotNil(t, problem)
+		assert.Equal(t, httperror.TypeDefault, problem.Type)
+	})
+}
+
+func TestClientsHandler_Clients_InvalidQuery(t *testing.T) {
+	for _, target := range []string{
+		"/v1/api/clients/?network=10.0.0.0/33",
+		"/v1/api/clients/?limit=0",
+		"/v1/api/clients/?min_capacity=ten",
+		"/v1/api/clients/?sort=tokens",
+	} {
+		t.Run(target, func(t *testing.T) {
+			w, problem := serve(t, newTestServer(&clientsUseCaseMock{}), http.MethodGet, target, "")
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			require.NotNil(t, problem)
+			assert.Equal(t, "/v1/api/clients/", problem.Instance, "the query is not a part of the instance")
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/middleware"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+type CountersReceiver interface {
+	Receive(counters *entity.PeerCounters)
+}
+
+// CountersHandler receives rate-limiting counters from other balancer instances in the peer-to-peer mode.
+// Only the peers (and admins) may send them.
+type CountersHandler struct {
+	receiver   CountersReceiver
+	path       string
+	bytesLimit int64
+}
+
+func NewCountersHandler(receiver CountersReceiver, path string, bytesLimit int64) *CountersHandler {
+	return &CountersHandler{
+		receiver:   receiver,
+		path:       path,
+		bytesLimit: bytesLimit,
+	}
+}
+
+func (h *CountersHandler) Register(router *httprouter.Router) {
+	router.POST(h.path, middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RolePeer, h.receive)))
+}
+
+func (h *CountersHandler) receive(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	var counters entity.PeerCounters
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.bytesLimit)).Decode(&counters)
+	if err != nil {
+		return httperror.ErrDeserialize(err)
+	}
+
+	if counters.InstanceID == "" {
+		return httperror.BadRequest(nil, "instance_id is required")
+	}
+
+	h.receiver.Receive(&counters)
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
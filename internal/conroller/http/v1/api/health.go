@@ -0,0 +1,84 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/middleware"
+)
+
+// StorageHealth reports whether the storage is reachable.
+type StorageHealth interface {
+	Healthy() bool
+}
+
+const (
+	healthStatusOK          = "ok"
+	healthStatusDegraded    = "degraded"
+	healthStatusUnavailable = "unavailable"
+)
+
+// HealthHandler reports the health of the balancer for probes and monitoring.
+type HealthHandler struct {
+	backendsUseCase BackendsUseCase
+	storage         StorageHealth
+}
+
+// NewHealthHandler creates a HealthHandler. StorageHealth can be nil, then the storage is considered always available.
+func NewHealthHandler(backendsUseCase BackendsUseCase, storage StorageHealth) *HealthHandler {
+	return &HealthHandler{
+		backendsUseCase: backendsUseCase,
+		storage:         storage,
+	}
+}
+
+func (h *HealthHandler) Register(router *httprouter.Router) {
+	router.GET("/v1/internal/health", middleware.ErrorMiddlewareParams(h.health))
+}
+
+type healthResponse struct {
+	// Status is "ok", "degraded" if the storage or some backends are unavailable,
+	// or "unavailable" if no backend can serve requests.
+	Status   string         `json:"status"`
+	Storage  bool           `json:"storage"`
+	Backends backendsHealth `json:"backends"`
+}
+
+type backendsHealth struct {
+	Serving int `json:"serving"`
+	Total   int `json:"total"`
+}
+
+func (h *HealthHandler) health(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	backends := h.backendsUseCase.Backends()
+
+	resp := &healthResponse{
+		Status:   healthStatusOK,
+		Storage:  h.storage == nil || h.storage.Healthy(),
+		Backends: backendsHealth{Total: len(backends)},
+	}
+	for _, backend := range backends {
+		if backend.IsServing() {
+			resp.Backends.Serving++
+		}
+	}
+
+	status := http.StatusOK
+	switch {
+	case resp.Backends.Serving == 0:
+		resp.Status = healthStatusUnavailable
+		status = http.StatusServiceUnavailable
+	case !resp.Storage || resp.Backends.Serving < resp.Backends.Total:
+		resp.Status = healthStatusDegraded
+	}
+
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		return httperror.ErrSerialize(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package v1
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/middleware"
+)
+
+type MetricsWriter interface {
+	WriteTo(w io.Writer) (int64, error)
+}
+
+// MetricsHandler exposes the metrics of the balancer in the Prometheus text format.
+type MetricsHandler struct {
+	metrics MetricsWriter
+}
+
+func NewMetricsHandler(metrics MetricsWriter) *MetricsHandler {
+	return &MetricsHandler{
+		metrics: metrics,
+	}
+}
+
+func (h *MetricsHandler) Register(router *httprouter.Router) {
+	router.GET("/v1/internal/metrics", middleware.ErrorMiddlewareParams(h.metricsText))
+}
+
+func (h *MetricsHandler) metricsText(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	_, err := h.metrics.WriteTo(w)
+	if err != nil {
+		return httperror.InternalServerError(err, "failed to write metrics")
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/middleware"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase"
+)
+
+type PlansUseCase interface {
+	Plans(ctx context.Context) ([]*entity.Plan, error)
+	CreatePlan(ctx context.Context, plan *entity.Plan) error
+	UpdatePlan(ctx context.Context, plan *entity.Plan) error
+	DeletePlan(ctx context.Context, name string) error
+}
+
+type PlansHandler struct {
+	plansUseCase PlansUseCase
+	bytesLimit   int64
+}
+
+func NewPlansHandler(plansUseCase PlansUseCase, bytesLimit int64) *PlansHandler {
+	return &PlansHandler{
+		plansUseCase: plansUseCase,
+		bytesLimit:   bytesLimit,
+	}
+}
+
+func (h *PlansHandler) Register(router *httprouter.Router) {
+	router.GET("/v1/api/plans/", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleReadOnly, h.plans)))
+	router.POST("/v1/api/plans/", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleAdmin, h.createPlan)))
+	router.PUT("/v1/api/plans/:name", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleAdmin, h.updatePlan)))
+	router.DELETE("/v1/api/plans/:name", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleAdmin, h.deletePlan)))
+}
+
+func (h *PlansHandler) plans(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	plans, err := h.plansUseCase.Plans(r.Context())
+	if err != nil {
+		return httperror.InternalServerError(err, "failed to get all plans")
+	}
+
+	err = json.NewEncoder(w).Encode(plans)
+	if err != nil {
+		return httperror.ErrSerialize(err)
+	}
+
+	return nil
+}
+
+type planRequest struct {
+	Name          string             `json:"name"`
+	Capacity      int32              `json:"capacity"`
+	RatePerSecond int32              `json:"rate_per_second"`
+	MaxConcurrent int32              `json:"max_concurrent"`
+	QuotaPeriod   entity.QuotaPeriod `json:"quota_period"`
+	QuotaLimit    int64              `json:"quota_limit"`
+}
+
+func (h *PlansHandler) createPlan(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	plan, err := h.decodePlan(w, r)
+	if err != nil {
+		return err
+	}
+	if plan.Name == "" {
+		return httperror.BadRequest(nil, "name is required")
+	}
+
+	err = h.plansUseCase.CreatePlan(r.Context(), plan)
+	if err != nil {
+		if errors.Is(err, usecase.ErrPlanExists) {
+			return httperror.Conflict(err, "plan already exists")
+		}
+
+		return httperror.InternalServerError(err, "failed to create plan")
+	}
+
+	w.WriteHeader(http.StatusCreated)
+
+	return nil
+}
+
+func (h *PlansHandler) updatePlan(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	plan, err := h.decodePlan(w, r)
+	if err != nil {
+		return err
+	}
+	plan.Name = params.ByName("name")
+
+	err = h.plansUseCase.UpdatePlan(r.Context(), plan)
+	if err != nil {
+		if errors.Is(err, usecase.ErrPlanNotFound) {
+			return httperror.NotFound(err, "plan not found")
+		}
+
+		return httperror.InternalServerError(err, "failed to update plan")
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+func (h *PlansHandler) deletePlan(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	err := h.plansUseCase.DeletePlan(r.Context(), params.ByName("name"))
+	if err != nil {
+		if errors.Is(err, usecase.ErrPlanNotFound) {
+			return httperror.NotFound(err, "plan not found")
+		}
+		if errors.Is(err, usecase.ErrPlanInUse) {
+			return httperror.Conflict(err, "plan is referenced by clients")
+		}
+
+		return httperror.InternalServerError(err, "failed to delete plan")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// decodePlan decodes and validates the plan from the request body.
+// The name of the plan is not validated, since it is taken from the path on update.
+func (h *PlansHandler) decodePlan(w http.ResponseWriter, r *http.Request) (*entity.Plan, error) {
+	var req planRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.bytesLimit)).Decode(&req)
+	if err != nil {
+		return nil, httperror.ErrDeserialize(err)
+	}
+
+	if req.Capacity <= 0 {
+		return nil, httperror.BadRequest(nil, "capacity must be positive")
+	}
+	if req.RatePerSecond < 0 || req.MaxConcurrent < 0 || req.QuotaLimit < 0 {
+		return nil, httperror.BadRequest(nil, "limits must not be negative")
+	}
+	if !req.QuotaPeriod.IsValid() {
+		return nil, httperror.BadRequest(nil, "quota_period must be one of \"hour\", \"day\" or \"month\"")
+	}
+
+	return &entity.Plan{
+		Name:          req.Name,
+		Capacity:      req.Capacity,
+		RatePerSecond: req.RatePerSecond,
+		MaxConcurrent: req.MaxConcurrent,
+		QuotaPeriod:   req.QuotaPeriod,
+		QuotaLimit:    req.QuotaLimit,
+	}, nil
+}
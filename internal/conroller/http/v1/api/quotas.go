@@ -0,0 +1,61 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/middleware"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase"
+)
+
+type QuotasUseCase interface {
+	Usage(ctx context.Context, ipAddress string) (*entity.QuotaUsage, error)
+}
+
+type QuotasHandler struct {
+	quotasUseCase QuotasUseCase
+}
+
+func NewQuotasHandler(quotasUseCase QuotasUseCase) *QuotasHandler {
+	return &QuotasHandler{
+		quotasUseCase: quotasUseCase,
+	}
+}
+
+func (h *QuotasHandler) Register(router *httprouter.Router) {
+	router.GET("/v1/api/clients/:ip_address/quota", middleware.ErrorMiddlewareParams(middleware.RequireRole(entity.RoleReadOnly, h.usage)))
+}
+
+func (h *QuotasHandler) usage(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+	ipAdress, err := ipAddressParam(params)
+	if err != nil {
+		return err
+	}
+
+	usage, err := h.quotasUseCase.Usage(r.Context(), ipAdress)
+	if err != nil {
+		if errors.Is(err, usecase.ErrClientNotFound) {
+			return httperror.NotFound(err, "client not found")
+		}
+		if errors.Is(err, usecase.ErrNoQuota) {
+			return httperror.NotFound(err, "client has no quota")
+		}
+		if errors.Is(err, usecase.ErrStorageUnavailable) {
+			return httperror.ErrStorageUnavailable
+		}
+
+		return httperror.InternalServerError(err, "failed to get quota usage")
+	}
+
+	err = json.NewEncoder(w).Encode(usage)
+	if err != nil {
+		return httperror.ErrSerialize(err)
+	}
+
+	return nil
+}
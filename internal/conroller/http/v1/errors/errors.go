@@ -9,14 +9,47 @@ var (
 	// ErrRateLimitExceeded is returned when the client has exceeded the allowed rate limit.
 	ErrRateLimitExceeded = New(nil, "rate limit exceeded", http.StatusTooManyRequests)
 
+	// ErrQuotaExceeded is returned when the client has exceeded its quota for the current period.
+	ErrQuotaExceeded = New(nil, "quota exceeded", http.StatusTooManyRequests)
+
+	// ErrQuotaUnavailable is returned when the usage of the client's quota can not be loaded from the database.
+	ErrQuotaUnavailable = New(nil, "the quota usage is temporarily unavailable, try again later", http.StatusServiceUnavailable)
+
 	// ErrUnknownClient is returned when the client is unknown.
 	ErrUnknownClient = New(nil, "unknown client", http.StatusForbidden)
+
+	// ErrStorageUnavailable is returned when the client can not be resolved because the database is unavailable.
+	ErrStorageUnavailable = New(nil, "the client configuration is temporarily unavailable, try again later", http.StatusServiceUnavailable)
+)
+
+// Access errors
+var (
+	// ErrAccessDenied is returned when the client is in the deny list.
+	ErrAccessDenied = New(nil, "access denied", http.StatusForbidden)
+
+	// ErrBanned is returned when the client is temporarily banned for exceeding the limits too often.
+	ErrBanned = New(nil, "temporarily banned for exceeding the rate limits", http.StatusForbidden)
+)
+
+// Authentication errors
+var (
+	// ErrUnauthenticated is returned when the management API is called without valid credentials.
+	ErrUnauthenticated = New(nil, "valid bearer token or client certificate is required", http.StatusUnauthorized)
+
+	// ErrForbidden is returned when the role of the caller does not allow the endpoint.
+	ErrForbidden = New(nil, "the role does not allow this operation", http.StatusForbidden)
+
+	// ErrTooManyAuthFailures is returned when the client has failed to authenticate too often.
+	ErrTooManyAuthFailures = New(nil, "too many authentication failures, try again later", http.StatusTooManyRequests)
 )
 
 // Proxy errors
 var (
 	// ErrNoBackendsAvailable is returned when there are no servers to process the request.
 	ErrNoBackendsAvailable = New(nil, "there are no servers to process the request, try again later", http.StatusServiceUnavailable)
+
+	// ErrOverloaded is returned when the request is shed because the backends are overloaded.
+	ErrOverloaded = New(nil, "the servers are overloaded, try again later", http.StatusServiceUnavailable)
 )
 
 // ErrDeserialize returns an HTTP error for deserialization errors.
@@ -29,6 +62,15 @@ func ErrSerialize(err error) *HTTPError {
 	return InternalServerError(err, "failed to serialize data")
 }
 
+// Validation returns an HTTP error for a request with invalid fields.
+func Validation(errs ...FieldError) *HTTPError {
+	httpError := New(nil, "the request has invalid fields", http.StatusBadRequest)
+	httpError.Type = TypeValidation
+	httpError.Title = "Validation failed"
+	httpError.Errors = errs
+	return httpError
+}
+
 // BadRequest returns an HTTP error for bad requests.
 func BadRequest(err error, message string) *HTTPError {
 	return New(err, message, http.StatusBadRequest)
@@ -2,17 +2,42 @@
 //
 // HTTPError type is used to represent errors with HTTP status codes. It
 // implements the error interface and provides methods to get the status code and
-// the error message.
+// the error message. Errors are written as problem details (RFC 7807).
 package httperror
 
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ContentType is the media type of the written errors.
+const ContentType = "application/problem+json"
+
+const (
+	// TypeDefault is the type of problems that have no other semantics than the status code.
+	TypeDefault = "about:blank"
+	// TypeValidation is the type of problems with invalid fields of a request, listed in the errors.
+	TypeValidation = "urn:load-balancer:problem:validation"
 )
 
 // HTTPError represents an error with HTTP status code.
 type HTTPError struct {
-	Code    int    `json:"code"`
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	Code  int    `json:"status"`
+	// Message is the explanation specific to this occurrence of the problem.
+	Message string `json:"detail"`
+	// Instance is the path of the request, it is set when the error is written unless it is set already.
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is an invalid field of a request.
+type FieldError struct {
+	// Field is the name of the field, nested fields are joined with dots, e.g. "policy_overrides.search.capacity".
+	Field   string `json:"field"`
 	Message string `json:"message"`
 }
 
@@ -24,6 +49,8 @@ func New(err error, message string, status int) *HTTPError {
 	}
 
 	return &HTTPError{
+		Type:    TypeDefault,
+		Title:   http.StatusText(status),
 		Message: message,
 		Code:    status,
 	}
@@ -45,3 +72,18 @@ func (e *HTTPError) Marshal() []byte {
 	return data
 }
 
+// Write writes the error with its status code and content type. The instance defaults to the path
+// the request was sent to, before it was rewritten for routing.
+func (e *HTTPError) Write(w http.ResponseWriter, r *http.Request) {
+	problem := *e
+	if problem.Instance == "" {
+		problem.Instance = r.URL.Path
+		if r.RequestURI != "" {
+			problem.Instance, _, _ = strings.Cut(r.RequestURI, "?")
+		}
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(problem.Code)
+	w.Write(problem.Marshal())
+}
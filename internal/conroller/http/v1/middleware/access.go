@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/iputil"
+)
+
+// AccessChecker is an interface that defines the method to check an address against the allow and deny lists.
+type AccessChecker interface {
+	Check(addr netip.Addr) (entity.AccessAction, bool)
+}
+
+// BanList is an interface that defines the methods to check and record automatic bans of clients.
+type BanList interface {
+	Banned(key string) (*entity.Ban, bool)
+	RecordRejection(key string) (*entity.Ban, bool)
+}
+
+// AccessMiddleware is an HTTP middleware that applies the allow and deny lists and automatic bans
+// before rate limiting.
+//
+// Requests from denied or banned clients are rejected. Requests matching an allow rule are marked
+// as exempt from the limits (see IsExempt). Every rejection by the rate limit (httperror.ErrRateLimitExceeded)
+// counts as a rejection of the client and may get it banned. Other 429 responses, e.g. of an exhausted quota
+// or of the concurrency limit, are not counted.
+func AccessMiddleware(log *slog.Logger, access AccessChecker, bans BanList, ipv6PrefixLen int, next AppHandler) AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		addr, err := remoteAddr(log, r)
+		if err != nil {
+			return err
+		}
+
+		if action, ok := access.Check(addr); ok {
+			switch action {
+			case entity.AccessDeny:
+				log.Info("access denied", slog.String("ip_address", addr.String()))
+				return httperror.ErrAccessDenied
+			case entity.AccessAllow:
+				return next(w, r.WithContext(ContextWithExempt(r.Context())))
+			}
+		}
+
+		key := iputil.ClientKey(addr, ipv6PrefixLen)
+
+		if ban, ok := bans.Banned(key); ok {
+			retryAfter := math.Ceil(time.Until(ban.ExpiresAt).Seconds())
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(retryAfter), 1)))
+			return httperror.ErrBanned
+		}
+
+		err = next(w, r)
+
+		if errors.Is(err, httperror.ErrRateLimitExceeded) {
+			if ban, banned := bans.RecordRejection(key); banned {
+				log.Warn("client is banned",
+					slog.String("ip_address", key),
+					slog.Int("offences", ban.Offences),
+					slog.Time("expires_at", ban.ExpiresAt),
+				)
+			}
+		}
+
+		return err
+	}
+}
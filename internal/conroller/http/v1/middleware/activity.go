@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/lib/iputil"
+)
+
+// ActivityRecorder is an interface that defines the method to record the requests of clients.
+type ActivityRecorder interface {
+	RecordRequest(key string, rejected bool)
+}
+
+// ActivityMiddleware is an HTTP middleware that records every request to the backends together with
+// whether the next handler rejected it with 429. Clients are identified as by RateLimitingMiddleware.
+func ActivityMiddleware(log *slog.Logger, activity ActivityRecorder, ipv6PrefixLen int, next AppHandler) AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		addr, err := remoteAddr(log, r)
+		if err != nil {
+			return err
+		}
+
+		err = next(w, r)
+
+		var httpError *httperror.HTTPError
+		rejected := errors.As(err, &httpError) && httpError.Code == http.StatusTooManyRequests
+		activity.RecordRequest(iputil.ClientKey(addr, ipv6PrefixLen), rejected)
+
+		return err
+	}
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/iputil"
+	"github.com/kurochkinivan/load_balancer/internal/usecase"
+)
+
+// Authenticator is an interface that defines the methods to authenticate the callers of the management API.
+// They return usecase.ErrUnauthenticated for invalid credentials and usecase.ErrTooManyAuthFailures
+// if the client has failed too often.
+type Authenticator interface {
+	AuthenticateToken(key, token string) (*entity.Identity, error)
+	AuthenticateCertificate(key string, cert *x509.Certificate) (*entity.Identity, error)
+}
+
+// unixSocketKey is the key the failures of the requests over a Unix socket are counted by.
+const unixSocketKey = "unix"
+
+// anonymous is the identity of every caller when the authentication is disabled.
+var anonymous = &entity.Identity{Name: "anonymous", Role: entity.RoleAdmin}
+
+// AuthMiddleware is an HTTP middleware that authenticates the requests to the management API
+// and the rate-limiting counters sent by the other instances. The identity is passed to the next handler in the request context (see IdentityFromContext),
+// the roles are enforced per endpoint by RequireRole. Other requests are passed through as is.
+//
+// A bearer token in the Authorization header is checked first, then a client certificate verified
+// by the TLS server. Requests without credentials are rejected with 401 and are not counted as failures.
+//
+// IPv6 addresses are aggregated to networks of ipv6PrefixLen bits to count the failures (see iputil.ClientKey).
+// Requests over a Unix socket have no address, their failures are counted together.
+//
+// Authenticator can be nil. If it is nil, the authentication is disabled and every caller is an admin.
+func AuthMiddleware(log *slog.Logger, auth Authenticator, ipv6PrefixLen int, next AppHandler) AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if !requiresAuth(r) {
+			return next(w, r)
+		}
+
+		if auth == nil {
+			return next(w, r.WithContext(ContextWithIdentity(r.Context(), anonymous)))
+		}
+
+		key := unixSocketKey
+		if !viaUnixSocket(r) {
+			addr, err := remoteAddr(log, r)
+			if err != nil {
+				return err
+			}
+			key = iputil.ClientKey(addr, ipv6PrefixLen)
+		}
+
+		var (
+			identity *entity.Identity
+			err      error
+		)
+		token, hasToken := bearerToken(r)
+		switch {
+		case hasToken:
+			identity, err = auth.AuthenticateToken(key, token)
+		case r.TLS != nil && len(r.TLS.VerifiedChains) > 0:
+			identity, err = auth.AuthenticateCertificate(key, r.TLS.PeerCertificates[0])
+		default:
+			log.Debug("no credentials", slog.String("ip_address", key), slog.String("path", r.URL.Path))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="load-balancer"`)
+			return httperror.ErrUnauthenticated
+		}
+
+		switch {
+		case errors.Is(err, usecase.ErrTooManyAuthFailures):
+			log.Info("authentication is rejected, too many failures", slog.String("ip_address", key))
+			return httperror.ErrTooManyAuthFailures
+		case errors.Is(err, usecase.ErrUnauthenticated):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="load-balancer", error="invalid_token"`)
+			return httperror.ErrUnauthenticated
+		case err != nil:
+			return httperror.InternalServerError(err, "failed to authenticate")
+		}
+
+		return next(w, r.WithContext(ContextWithIdentity(r.Context(), identity)))
+	}
+}
+
+// RequireRole is a handler wrapper that rejects the callers whose role does not allow the endpoint.
+// The identity must be set by AuthMiddleware, requests without it are rejected as unauthenticated.
+func RequireRole(role entity.Role, next ParamsAppHandler) ParamsAppHandler {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+		identity, ok := IdentityFromContext(r.Context())
+		if !ok {
+			return httperror.ErrUnauthenticated
+		}
+		if !identity.Role.Allows(role) {
+			return httperror.ErrForbidden
+		}
+		return next(w, r, p)
+	}
+}
+
+// bearerToken returns the token of the Authorization header with the Bearer scheme.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// requiresAuth reports whether the request goes to the management API or to the internal rate-limiting endpoints.
+func requiresAuth(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/v1/api/") || strings.HasPrefix(r.URL.Path, "/v1/internal/ratelimit/")
+}
+
+// viaUnixSocket reports whether the request is received over a Unix socket.
+func viaUnixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/lib/semaphore"
+)
+
+// ConcurrencyOptions configures how requests over the concurrency limit are handled.
+type ConcurrencyOptions struct {
+	// RejectStatus is the status code of rejected requests, either 429 or 503.
+	RejectStatus int
+	// QueueSize is the number of requests that may wait for a free slot, 0 rejects them immediately.
+	QueueSize int
+	// QueueTimeout is the maximum time a request waits for a free slot.
+	QueueTimeout time.Duration
+}
+
+// ConcurrencyLimitingMiddleware is an HTTP middleware that limits the number of requests processed at the same time,
+// both per client and globally.
+//
+// The client is taken from the request context (see ClientFromContext), so the middleware has to run after
+// RateLimitingMiddleware. The global semaphore can be nil. Requests over a limit either wait in a bounded queue
+// or are rejected with the configured status code.
+func ConcurrencyLimitingMiddleware(
+	log *slog.Logger,
+	global *semaphore.Semaphore,
+	opts ConcurrencyOptions,
+	next AppHandler,
+) AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		waitCtx, cancel := r.Context(), context.CancelFunc(func() {})
+		if opts.QueueTimeout > 0 {
+			waitCtx, cancel = context.WithTimeout(r.Context(), opts.QueueTimeout)
+		}
+		defer cancel()
+
+		// The client's own limit goes first, so a client over its limit does not take a global slot.
+		if client, ok := ClientFromContext(r.Context()); ok {
+			if sem := client.Concurrency(opts.QueueSize); sem != nil {
+				if err := sem.Acquire(waitCtx); err != nil {
+					log.Info("client concurrency limit exceeded",
+						slog.String("ip_address", client.IPAddress),
+						slog.String("reason", err.Error()),
+					)
+					return concurrencyError(err, opts.RejectStatus)
+				}
+				defer sem.Release()
+			}
+		}
+
+		if global != nil {
+			if err := global.Acquire(waitCtx); err != nil {
+				log.Warn("global concurrency limit exceeded", slog.String("reason", err.Error()))
+				return concurrencyError(err, opts.RejectStatus)
+			}
+			defer global.Release()
+		}
+
+		cancel()
+
+		return next(w, r)
+	}
+}
+
+func concurrencyError(err error, status int) *httperror.HTTPError {
+	if status != http.StatusServiceUnavailable {
+		status = http.StatusTooManyRequests
+	}
+
+	if errors.Is(err, semaphore.ErrTimeout) {
+		return httperror.New(nil, "timed out waiting for a free slot", status)
+	}
+	return httperror.New(nil, "too many concurrent requests", status)
+}
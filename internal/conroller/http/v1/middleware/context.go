@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+type clientContextKey struct{}
+
+type exemptContextKey struct{}
+
+type priorityContextKey struct{}
+
+type identityContextKey struct{}
+
+// ContextWithClient returns a copy of ctx carrying the client resolved for the request.
+func ContextWithClient(ctx context.Context, client *entity.Client) context.Context {
+	return context.WithValue(ctx, clientContextKey{}, client)
+}
+
+// ClientFromContext returns the client resolved for the request by RateLimitingMiddleware.
+func ClientFromContext(ctx context.Context) (*entity.Client, bool) {
+	client, ok := ctx.Value(clientContextKey{}).(*entity.Client)
+	return client, ok && client != nil
+}
+
+// ContextWithExempt returns a copy of ctx marking the request as exempt from the limits.
+func ContextWithExempt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exemptContextKey{}, true)
+}
+
+// IsExempt reports whether the request is exempt from the limits by an allow rule (see AccessMiddleware).
+func IsExempt(ctx context.Context) bool {
+	exempt, _ := ctx.Value(exemptContextKey{}).(bool)
+	return exempt
+}
+
+// ContextWithPriority returns a copy of ctx carrying the priority class of the request.
+func ContextWithPriority(ctx context.Context, priority entity.Priority) context.Context {
+	return context.WithValue(ctx, priorityContextKey{}, priority)
+}
+
+// PriorityFromContext returns the priority class of the request, entity.PriorityNormal if it is not classified.
+func PriorityFromContext(ctx context.Context) entity.Priority {
+	priority, ok := ctx.Value(priorityContextKey{}).(entity.Priority)
+	if !ok {
+		return entity.PriorityNormal
+	}
+	return priority
+}
+
+// ContextWithIdentity returns a copy of ctx carrying the authenticated caller of the management API.
+func ContextWithIdentity(ctx context.Context, identity *entity.Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// IdentityFromContext returns the caller of the management API authenticated by AuthMiddleware.
+func IdentityFromContext(ctx context.Context) (*entity.Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(*entity.Identity)
+	return identity, ok && identity != nil
+}
@@ -9,8 +9,8 @@ import (
 )
 
 // ErrorMiddleware catches errors from the next handler and returns an appropriate HTTP response.
-// If the error is an instance of httperror.HTTPError, it writes the error as JSON and returns the status code.
-// If the error is not an instance of httperror.HTTPError, it wraps it in an InternalServerError and writes the error as JSON.
+// If the error is an instance of httperror.HTTPError, it writes the error as problem details (application/problem+json)
+// with its status code. If the error is not an instance of httperror.HTTPError, it wraps it in an InternalServerError.
 func ErrorMiddleware(next AppHandler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		err := next(w, r)
@@ -19,15 +19,12 @@ func ErrorMiddleware(next AppHandler) http.Handler {
 			var httpError *httperror.HTTPError
 			if errors.As(err, &httpError) {
 				// If the error is an instance of httperror.HTTPError, write the error as JSON and return the status code.
-				w.WriteHeader(httpError.Code)
-				w.Write(httpError.Marshal())
+				httpError.Write(w, r)
 				return
 			}
 
 			// If the error is not an instance of httperror.HTTPError, wrap it in an InternalServerError and write the error as JSON.
-			httpError = httperror.InternalServerError(err, "")
-			w.WriteHeader(httpError.Code)
-			w.Write(httpError.Marshal())
+			httperror.InternalServerError(err, "").Write(w, r)
 		}
 	})
 }
@@ -41,16 +38,12 @@ func ErrorMiddlewareParams(next ParamsAppHandler) httprouter.Handle {
 			var httpError *httperror.HTTPError
 			if errors.As(err, &httpError) {
 				// If the error is an instance of httperror.HTTPError, write the error as JSON and return the status code.
-				w.WriteHeader(httpError.Code)
-				w.Write(httpError.Marshal())
+				httpError.Write(w, r)
 				return
 			}
 
 			// If the error is not an instance of httperror.HTTPError, wrap it in an InternalServerError and write the error as JSON.
-			httpError = httperror.InternalServerError(err, "")
-			w.WriteHeader(httpError.Code)
-			w.Write(httpError.Marshal())
+			httperror.InternalServerError(err, "").Write(w, r)
 		}
 	}
 }
-
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/wfq"
+)
+
+// FairQueueMiddleware is an HTTP middleware that admits requests to the backends through a weighted fair queue,
+// so under overload the important priority classes keep flowing while the others wait or are dropped.
+//
+// The class of a request is taken from the request context (see PriorityFromContext). A request waits in the queue
+// of its class no longer than the timeout of the class, a zero timeout means waiting until the request is cancelled.
+// Requests that cannot be admitted are rejected with 503.
+func FairQueueMiddleware(log *slog.Logger, queue *wfq.Queue, timeouts map[entity.Priority]time.Duration, next AppHandler) AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		priority := PriorityFromContext(r.Context())
+
+		waitCtx, cancel := r.Context(), context.CancelFunc(func() {})
+		if timeout := timeouts[priority]; timeout > 0 {
+			waitCtx, cancel = context.WithTimeout(r.Context(), timeout)
+		}
+		err := queue.Acquire(waitCtx, int(priority))
+		cancel()
+
+		if err != nil {
+			log.Info("request is not admitted by the fair queue",
+				slog.String("priority", priority.String()),
+				slog.String("reason", err.Error()),
+			)
+			if errors.Is(err, wfq.ErrTimeout) {
+				return httperror.New(nil, "timed out waiting in the queue", http.StatusServiceUnavailable)
+			}
+			return httperror.New(nil, "the queue is full, try again later", http.StatusServiceUnavailable)
+		}
+		defer queue.Release()
+
+		return next(w, r)
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/adaptive"
+)
+
+// LoadSheddingOptions configures how requests are shed when the backends are overloaded.
+type LoadSheddingOptions struct {
+	// RetryAfter is sent to the clients of shed requests.
+	RetryAfter time.Duration
+	// Shares are the shares of the adaptive limit available to the priority classes.
+	// Less important classes get smaller shares, so they are shed first. A missing class gets the whole limit.
+	Shares map[entity.Priority]float64
+}
+
+// LoadSheddingMiddleware is an HTTP middleware that limits the number of requests in flight to the backends
+// with an adaptive limiter, which lowers the limit when the latency of the backends rises.
+//
+// Requests over the limit of their priority class (see PriorityFromContext) are rejected with 503 and Retry-After.
+// Responses with 5xx status codes are treated as dropped requests and lower the limit as well.
+func LoadSheddingMiddleware(log *slog.Logger, limiter *adaptive.Limiter, opts LoadSheddingOptions, next AppHandler) AppHandler {
+	retryAfter := strconv.Itoa(max(int(math.Ceil(opts.RetryAfter.Seconds())), 1))
+
+	return func(w http.ResponseWriter, r *http.Request) error {
+		priority := PriorityFromContext(r.Context())
+
+		share, ok := opts.Shares[priority]
+		if !ok {
+			share = 1
+		}
+
+		token, ok := limiter.TryAcquire(share)
+		if !ok {
+			log.Warn("request is shed",
+				slog.String("priority", priority.String()),
+				slog.Int("limit", limiter.Limit()),
+			)
+			w.Header().Set("Retry-After", retryAfter)
+			return httperror.ErrOverloaded
+		}
+
+		rw := &responseWriterWrapper{ResponseWriter: w}
+
+		err := next(rw, r)
+
+		token.Release(err != nil || rw.status >= http.StatusInternalServerError)
+
+		return err
+	}
+}
@@ -44,3 +44,8 @@ func (rw *responseWriterWrapper) WriteHeader(statusCode int) {
 	rw.status = statusCode
 	rw.ResponseWriter.WriteHeader(statusCode)
 }
+
+// Unwrap returns the wrapped writer, so http.ResponseController can flush a streamed response.
+func (rw *responseWriterWrapper) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
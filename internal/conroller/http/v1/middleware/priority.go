@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+// PriorityMiddleware is an HTTP middleware that classifies requests into priority classes
+// and passes the class to the next handler in the request context (see PriorityFromContext).
+//
+// The rules are evaluated in order and the first matching one wins. Requests not matched by any rule
+// are classified by the value of the header, e.g. "X-Priority: low", if the header is not empty.
+// The rest of the requests get the normal priority.
+//
+// The plan of the client is taken from the request context (see ClientFromContext), so the middleware
+// has to run after RateLimitingMiddleware.
+func PriorityMiddleware(rules []*entity.PriorityRule, header string, next AppHandler) AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		priority, ok := classify(r, rules, header)
+		if ok {
+			r = r.WithContext(ContextWithPriority(r.Context(), priority))
+		}
+		return next(w, r)
+	}
+}
+
+func classify(r *http.Request, rules []*entity.PriorityRule, header string) (entity.Priority, bool) {
+	var plan string
+	if client, ok := ClientFromContext(r.Context()); ok {
+		plan = client.Plan
+	}
+
+	for _, rule := range rules {
+		if rule.Matches(plan, r.Method, r.URL.Path, r.Header.Get) {
+			return rule.Priority, true
+		}
+	}
+
+	if header != "" {
+		return entity.ParsePriority(r.Header.Get(header))
+	}
+
+	return entity.PriorityNormal, false
+}
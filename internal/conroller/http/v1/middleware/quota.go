@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+// QuotaConsumer is an interface that defines the methods to count a request against the client's quota
+// and to take it back.
+type QuotaConsumer interface {
+	Consume(ctx context.Context, client *entity.Client) (*entity.QuotaUsage, bool, error)
+	Refund(client *entity.Client, usage *entity.QuotaUsage)
+}
+
+// QuotaMiddleware is an HTTP middleware that limits the number of requests of a client within a calendar period.
+//
+// The client is taken from the request context (see ClientFromContext), so the middleware has to run after
+// RateLimitingMiddleware. Clients without a quota are not limited. The usage of the quota is reported
+// in the X-Quota-Limit, X-Quota-Remaining and X-Quota-Reset (unix time) headers.
+//
+// Requests rejected by the next handlers (concurrency limits, load shedding, the fair queue) are refunded,
+// so only the admitted requests are counted. If the usage can not be loaded, requests are rejected with 503.
+func QuotaMiddleware(log *slog.Logger, quotas QuotaConsumer, next AppHandler) AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		client, ok := ClientFromContext(r.Context())
+		if !ok || !client.HasQuota() {
+			return next(w, r)
+		}
+
+		usage, allowed, err := quotas.Consume(r.Context(), client)
+		if err != nil {
+			log.Warn("request is rejected, quota usage is unavailable", slog.String("ip_address", client.IPAddress))
+			return httperror.ErrQuotaUnavailable
+		}
+
+		header := w.Header()
+		header.Set("X-Quota-Limit", strconv.FormatInt(usage.Limit, 10))
+		header.Set("X-Quota-Remaining", strconv.FormatInt(usage.Remaining, 10))
+		header.Set("X-Quota-Reset", strconv.FormatInt(usage.ResetsAt.Unix(), 10))
+
+		if !allowed {
+			log.Info("quota exceeded",
+				slog.String("ip_address", client.IPAddress),
+				slog.String("period", string(usage.Period)),
+			)
+			return httperror.ErrQuotaExceeded
+		}
+
+		err = next(w, r)
+		if err != nil {
+			quotas.Refund(client, usage)
+			header.Set("X-Quota-Remaining", strconv.FormatInt(min(usage.Remaining+1, usage.Limit), 10))
+		}
+		return err
+	}
+}
@@ -2,75 +2,125 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net"
 	"net/http"
-	"strings"
+	"net/netip"
 
 	httperror "github.com/kurochkinivan/load_balancer/internal/conroller/http/v1/errors"
 	"github.com/kurochkinivan/load_balancer/internal/entity"
-	"github.com/kurochkinivan/load_balancer/internal/lib/sl"
+	"github.com/kurochkinivan/load_balancer/internal/lib/iputil"
+	"github.com/kurochkinivan/load_balancer/internal/usecase"
 )
 
 // ClientProvider is an interface that defines the method to retrieve a client based on IP address.
+// It returns usecase.ErrClientNotFound for unknown clients and usecase.ErrStorageUnavailable
+// if the client can not be resolved because the storage is unavailable.
 type ClientProvider interface {
+	Client(ctx context.Context, ipAdress string) (*entity.Client, error)
+}
+
+// UnknownClients is an interface that defines the methods to get a client to limit the requests
+// of an unknown client, or of a client that can not be resolved because the storage is unavailable, with.
+// They return false if such requests are rejected.
+type UnknownClients interface {
 	Client(ctx context.Context, ipAdress string) (*entity.Client, bool)
+	Unavailable(ctx context.Context, ipAdress string) (*entity.Client, bool)
 }
 
-// ClientProvider is an interface that defines the method to create a client
-type ClientCreator interface {
-	CreateClient(ctx context.Context, client *entity.Client) error
+// RateLimitPolicies is an interface that defines the method to apply route- and method-scoped
+// rate-limit policies together with the client's own limit.
+type RateLimitPolicies interface {
+	Allow(client *entity.Client, method, path string) bool
 }
 
 // RateLimitingMiddleware is an HTTP middleware that applies rate limiting based on client IP address.
+// Requests exempt by an allow rule (see IsExempt) are not limited.
 // It uses a ClientProvider to retrieve client information and check if the client is allowed to proceed.
+// The resolved client is passed to the next handler in the request context (see ClientFromContext).
+// Its limits are the effective ones: limits that the client does not override are taken from its plan.
+//
+// IPv6 addresses are aggregated to networks of ipv6PrefixLen bits before the lookup (see iputil.ClientKey).
+//
+// RateLimitPolicies can be nil. If it is nil, every request costs a single token of the client's bucket.
 //
-// ClientCreator can be nil. If it is not nil, it will be used to create a default client if the client is not found in the database.
+// UnknownClients can be nil. If it is nil, the requests of clients that are not found are rejected,
+// and so are the requests of clients that can not be resolved while the storage is unavailable.
 func RateLimitingMiddleware(
 	log *slog.Logger,
 	clientProvider ClientProvider,
-	clientCreator ClientCreator,
-	defaultCapacity, defaultRatePerSecond int32,
+	unknownClients UnknownClients,
+	policies RateLimitPolicies,
+	ipv6PrefixLen int,
 	next AppHandler,
 ) AppHandler {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		// Bypass rate limiting for client-related API paths.
-		if strings.HasPrefix(r.URL.String(), "/v1/api/clients") {
-			log.Debug("skipping rate limiting", slog.String("path", r.URL.Path))
+		if IsExempt(r.Context()) {
 			return next(w, r)
 		}
 
 		// Extract IP address from the request's remote address.
-		ipAddress, _, err := net.SplitHostPort(r.RemoteAddr)
+		addr, err := remoteAddr(log, r)
 		if err != nil {
-			log.Error("failed to split host and port", slog.String("remote_addr", r.RemoteAddr))
-			return httperror.BadRequest(err, "failed to split host and port")
+			return err
 		}
+		ipAddress := iputil.ClientKey(addr, ipv6PrefixLen)
 
 		// Retrieve the client information using the ClientProvider.
-		client, ok := clientProvider.Client(r.Context(), ipAddress)
-		if !ok {
-			log.Warn("unknown client", slog.String("ip_address", ipAddress))
-			if clientCreator != nil {
-				err = clientCreator.CreateClient(r.Context(), &entity.Client{
-					IPAddress:     ipAddress,
-					Capacity:      defaultCapacity,
-					RatePerSecond: defaultRatePerSecond,
-				})
-				if err != nil {
-					log.Error("failed to create client", sl.Error(err))
-				}
-			} else {
+		client, err := clientProvider.Client(r.Context(), ipAddress)
+		switch {
+		case errors.Is(err, usecase.ErrStorageUnavailable):
+			var ok bool
+			if unknownClients != nil {
+				client, ok = unknownClients.Unavailable(r.Context(), ipAddress)
+			}
+			if !ok {
+				log.Warn("client is rejected, storage is unavailable", slog.String("ip_address", ipAddress))
+				return httperror.ErrStorageUnavailable
+			}
+			log.Debug("client is limited by default limits, storage is unavailable", slog.String("ip_address", ipAddress))
+		case err != nil:
+			var ok bool
+			if unknownClients != nil {
+				log.Debug("unknown client", slog.String("ip_address", ipAddress))
+				client, ok = unknownClients.Client(r.Context(), ipAddress)
+			}
+			if !ok {
+				log.Info("unknown client is rejected", slog.String("ip_address", ipAddress))
 				return httperror.ErrUnknownClient
 			}
 		}
 
 		// Check if the client is allowed to proceed based on rate limiting.
-		if !client.Allow() {
+		var allowed bool
+		if policies != nil {
+			allowed = policies.Allow(client, r.Method, r.URL.Path)
+		} else {
+			allowed = client.Allow()
+		}
+		if !allowed {
 			log.Info("rate limit exceeded", slog.String("ip_address", ipAddress))
 			return httperror.ErrRateLimitExceeded
 		}
 
-		return next(w, r)
+		return next(w, r.WithContext(ContextWithClient(r.Context(), client)))
+	}
+}
+
+// remoteAddr extracts the IP address from the request's remote address.
+func remoteAddr(log *slog.Logger, r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		log.Error("failed to split host and port", slog.String("remote_addr", r.RemoteAddr))
+		return netip.Addr{}, httperror.BadRequest(err, "failed to split host and port")
 	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		log.Error("failed to parse ip address", slog.String("remote_addr", r.RemoteAddr))
+		return netip.Addr{}, httperror.BadRequest(err, "failed to parse ip address")
+	}
+
+	return addr, nil
 }
@@ -40,10 +40,8 @@ func New(log *slog.Logger, backends []*entity.Backend, balancer LoadBalanceAlgor
 func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	next, ok := p.balancer.Next()
 	if !ok {
-		httpError := httperror.ErrNoBackendsAvailable
-		w.WriteHeader(httpError.Code)
-		w.Write(httpError.Marshal())
-		return 
+		httperror.ErrNoBackendsAvailable.Write(w, r)
+		return
 	}
 
 	backend := p.backends[next]
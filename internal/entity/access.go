@@ -0,0 +1,37 @@
+package entity
+
+import "time"
+
+// AccessAction defines what happens to the requests from the network of an access rule.
+type AccessAction string
+
+const (
+	// AccessAllow exempts the requests from all limits.
+	AccessAllow AccessAction = "allow"
+	// AccessDeny rejects the requests before they are rate limited.
+	AccessDeny AccessAction = "deny"
+)
+
+// IsValid reports whether the access action is known.
+func (a AccessAction) IsValid() bool {
+	return a == AccessAllow || a == AccessDeny
+}
+
+// AccessRule allows or denies the requests from a single IP address or a CIDR range.
+// When the rules overlap, the most specific one wins.
+type AccessRule struct {
+	ID        int64        `json:"id"`
+	Network   string       `json:"network"`
+	Action    AccessAction `json:"action"`
+	Comment   string       `json:"comment,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// Ban is a temporary ban of a client that has been rejected by the rate limits too often.
+type Ban struct {
+	IPAddress string `json:"ip_address"`
+	// Offences is the number of times the client has been banned, it makes every next ban longer.
+	Offences  int       `json:"offences"`
+	BannedAt  time.Time `json:"banned_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,20 @@
+package entity
+
+import "time"
+
+// ClientActivity is the recent activity of a client seen by an instance of the balancer.
+type ClientActivity struct {
+	LastSeen time.Time
+	// Rejections is the number of the client's requests rejected with 429 within the current window.
+	Rejections int
+}
+
+// ClientState is a stored client together with the runtime state of its bucket on an instance of the balancer.
+type ClientState struct {
+	Client *Client
+	// Cached reports whether the bucket is in memory. A client that is not cached gets a full bucket when it is loaded.
+	// CIDR ranges are always in memory.
+	Cached     bool
+	Tokens     int32
+	LastRefill time.Time
+}
@@ -0,0 +1,54 @@
+package entity
+
+// Role defines which endpoints of the management API an identity may call.
+// Every role may call the endpoints of the roles below it. RolePeer is outside of the hierarchy.
+type Role string
+
+const (
+	// RoleReadOnly may only read clients, plans, access rules, bans and quotas.
+	RoleReadOnly Role = "read-only"
+	// RoleOperator may also manage clients, reset their buckets and lift bans.
+	RoleOperator Role = "operator"
+	// RoleAdmin may also manage plans and access rules.
+	RoleAdmin Role = "admin"
+	// RolePeer may only send rate-limiting counters to the instance, it is the role of the other instances.
+	// Admins may send them too.
+	RolePeer Role = "peer"
+)
+
+var roleLevels = map[Role]int{
+	RoleReadOnly: 1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// IsValid reports whether the role is known.
+func (r Role) IsValid() bool {
+	_, ok := roleLevels[r]
+	return ok || r == RolePeer
+}
+
+// Allows reports whether the role may call the endpoints that require the given role.
+func (r Role) Allows(required Role) bool {
+	switch {
+	case required == RolePeer:
+		return r == RolePeer || r == RoleAdmin
+	case r == RolePeer:
+		return false
+	}
+	return r.IsValid() && roleLevels[r] >= roleLevels[required]
+}
+
+// APIToken is a static token of the management API. Only the SHA-256 hash of the token is kept.
+type APIToken struct {
+	Name string
+	Hash []byte
+	Role Role
+}
+
+// Identity is the authenticated caller of the management API.
+type Identity struct {
+	// Name is the name of the token or the common name of the client certificate.
+	Name string
+	Role Role
+}
@@ -9,6 +9,8 @@ import (
 type Backend struct {
 	URL       *url.URL
 	available atomic.Bool
+	// disabled is set by an operator to take the backend out of rotation regardless of its health.
+	disabled atomic.Bool
 }
 
 // NewBackend creates a new Backend instance.
@@ -35,3 +37,24 @@ func (b *Backend) SetAvailable(available bool) {
 func (b *Backend) IsAvailable() bool {
 	return b.available.Load()
 }
+
+// SetEnabled puts the backend back into rotation or takes it out. Health checks do not change it.
+//
+// This method is concurrently safe.
+func (b *Backend) SetEnabled(enabled bool) {
+	b.disabled.Store(!enabled)
+}
+
+// IsEnabled returns false if the backend is taken out of rotation by an operator.
+//
+// This method is concurrently safe.
+func (b *Backend) IsEnabled() bool {
+	return !b.disabled.Load()
+}
+
+// IsServing reports whether requests can be sent to the backend: it is both enabled and available.
+//
+// This method is concurrently safe.
+func (b *Backend) IsServing() bool {
+	return b.IsEnabled() && b.IsAvailable()
+}
@@ -0,0 +1,79 @@
+package entity
+
+import "sync/atomic"
+
+// TokenBucket is a token bucket that is refilled once per second.
+type TokenBucket struct {
+	capacity      int32
+	ratePerSecond int32
+	tokens        atomic.Int32
+}
+
+// NewTokenBucket creates a new full TokenBucket.
+func NewTokenBucket(capacity, ratePerSecond int32) *TokenBucket {
+	b := &TokenBucket{
+		capacity:      capacity,
+		ratePerSecond: ratePerSecond,
+	}
+	b.tokens.Store(capacity)
+
+	return b
+}
+
+// AllowN takes n tokens from the bucket if it has enough of them.
+//
+// This method is concurrently safe.
+func (b *TokenBucket) AllowN(n int32) bool {
+	return takeTokens(&b.tokens, n)
+}
+
+// Refund returns n previously taken tokens to the bucket.
+//
+// This method is concurrently safe.
+func (b *TokenBucket) Refund(n int32) {
+	refillTokens(&b.tokens, n, b.capacity)
+}
+
+// RefillOncePerSecond refills the bucket by its rate. It should be called once per second.
+//
+// This method is concurrently safe.
+func (b *TokenBucket) RefillOncePerSecond() {
+	refillTokens(&b.tokens, b.ratePerSecond, b.capacity)
+}
+
+// RefillFull fills the bucket up to its capacity.
+//
+// This method is concurrently safe.
+func (b *TokenBucket) RefillFull() {
+	b.tokens.Store(b.capacity)
+}
+
+// Tokens returns the current amount of tokens in the bucket.
+func (b *TokenBucket) Tokens() int32 {
+	return b.tokens.Load()
+}
+
+// takeTokens atomically takes n tokens if there are enough of them.
+func takeTokens(tokens *atomic.Int32, n int32) bool {
+	for {
+		current := tokens.Load()
+		if current < n || current <= 0 {
+			return false
+		}
+		if tokens.CompareAndSwap(current, current-n) {
+			return true
+		}
+	}
+}
+
+// refillTokens atomically adds n tokens without exceeding the capacity.
+func refillTokens(tokens *atomic.Int32, n, capacity int32) {
+	for {
+		current := tokens.Load()
+		newTokens := min(current+n, capacity)
+
+		if tokens.CompareAndSwap(current, newTokens) {
+			return
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package entity
+
+// ClientChangeOp is the kind of a change of a client in the storage.
+type ClientChangeOp string
+
+const (
+	ClientInserted ClientChangeOp = "insert"
+	ClientUpdated  ClientChangeOp = "update"
+	ClientDeleted  ClientChangeOp = "delete"
+)
+
+// ClientChange notifies about a change of a client made by any balancer instance.
+// A change of a plan changes the limits of all its clients, then Plan is set instead of IPAddress.
+type ClientChange struct {
+	Op        ClientChangeOp `json:"op"`
+	IPAddress string         `json:"ip_address,omitempty"`
+	Plan      string         `json:"plan,omitempty"`
+}
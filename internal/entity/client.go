@@ -1,41 +1,311 @@
 package entity
 
 import (
+	"maps"
 	"sync/atomic"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/lib/semaphore"
+)
+
+// LimitMode defines how the limits of a client defined by a CIDR range are applied.
+type LimitMode string
+
+const (
+	// LimitModeShared means that all addresses of the range share a single token bucket.
+	LimitModeShared LimitMode = "shared"
+	// LimitModePerIP means that every address of the range gets its own token bucket.
+	LimitModePerIP LimitMode = "per_ip"
 )
 
+// IsValid reports whether the limit mode is known.
+func (m LimitMode) IsValid() bool {
+	return m == LimitModeShared || m == LimitModePerIP
+}
+
+// Client is a rate limited client identified either by a single IP address or by a CIDR range.
 type Client struct {
-	ID            int64  `json:"id"`
-	IPAddress     string `json:"ip_address"`
-	Capacity      int32  `json:"capacity"`
-	RatePerSecond int32  `json:"rate_per_second"`
-	Tokens        atomic.Int32
+	ID            int64     `json:"id"`
+	IPAddress     string    `json:"ip_address"`
+	Capacity      int32     `json:"capacity"`
+	RatePerSecond int32     `json:"rate_per_second"`
+	LimitMode     LimitMode `json:"limit_mode"`
+	// MaxConcurrent limits the number of requests of the client processed at the same time, 0 means no limit.
+	MaxConcurrent int32 `json:"max_concurrent"`
+	// QuotaPeriod and QuotaLimit limit the number of requests of the client within a calendar period.
+	QuotaPeriod QuotaPeriod `json:"quota_period,omitempty"`
+	QuotaLimit  int64       `json:"quota_limit,omitempty"`
+	// Plan is the name of the plan the client belongs to. Limits of the client that are zero
+	// in the storage are taken from the plan, so the fields above always hold the effective limits.
+	Plan string `json:"plan,omitempty"`
+	// PolicyOverrides overrides the rate-limit policies for this client, the key is the policy name.
+	PolicyOverrides map[string]PolicyOverride `json:"policy_overrides,omitempty"`
+	// Pinned clients are preloaded into the cache on startup before the others.
+	Pinned bool         `json:"pinned,omitempty"`
+	Tokens atomic.Int32 `json:"-"`
+
+	// lastRefill is the time of the last refill of the bucket in unix nanoseconds, 0 if it has never been refilled.
+	lastRefill atomic.Int64
+
+	// parent is set for clients resolved through a CIDR range in shared mode.
+	// Such clients spend tokens from the bucket of the range.
+	parent *Client
+
+	// limiters holds the buckets of the client-scoped policies and the concurrency semaphore,
+	// it is created on the first use or inherited from the client this one replaces.
+	limiters atomic.Pointer[Limiters]
+}
+
+// ForAddress returns a client for a single address (or an aggregated IPv6 network) that belongs to the range c.
+//
+// In shared mode the returned client spends tokens of c, in per-IP mode it gets its own full bucket.
+func (c *Client) ForAddress(key string) *Client {
+	client := &Client{
+		ID:            c.ID,
+		IPAddress:     key,
+		Capacity:      c.Capacity,
+		RatePerSecond: c.RatePerSecond,
+		LimitMode:     c.LimitMode,
+		MaxConcurrent: c.MaxConcurrent,
+		QuotaPeriod:   c.QuotaPeriod,
+		QuotaLimit:    c.QuotaLimit,
+		Plan:          c.Plan,
+		// Overrides are never modified after the client is created, so they can be shared.
+		PolicyOverrides: c.PolicyOverrides,
+	}
+
+	if c.LimitMode == LimitModePerIP {
+		client.Tokens.Store(c.Capacity)
+	} else {
+		client.parent = c
+	}
+
+	return client
 }
 
 // Allow checks if client has available tokens.
 func (c *Client) Allow() bool {
+	return c.AllowN(1)
+}
+
+// AllowN takes n tokens from the client's bucket if it has enough of them.
+//
+// This method is concurrently safe.
+func (c *Client) AllowN(n int32) bool {
+	if c.parent != nil {
+		return c.parent.AllowN(n)
+	}
+
+	return takeTokens(&c.Tokens, n)
+}
+
+// Refund returns n previously taken tokens to the client's bucket.
+//
+// This method is concurrently safe.
+func (c *Client) Refund(n int32) {
+	if c.parent != nil {
+		c.parent.Refund(n)
+		return
+	}
+
+	refillTokens(&c.Tokens, n, c.Capacity)
+}
+
+// Drain takes n tokens spent by other instances of the balancer from the client's bucket.
+// Unlike AllowN it never fails: the bucket may go into debt of up to its capacity,
+// which is paid off by the following refills.
+//
+// This method is concurrently safe.
+func (c *Client) Drain(n int32) {
+	if c.parent != nil {
+		c.parent.Drain(n)
+		return
+	}
+
 	for {
-		tokens := c.Tokens.Load()
-		if tokens <= 0 {
-			return false
+		current := c.Tokens.Load()
+		newTokens := max(current-n, -c.Capacity)
+
+		if c.Tokens.CompareAndSwap(current, newTokens) {
+			return
 		}
-		if c.Tokens.CompareAndSwap(tokens, tokens-1) {
-			return true
+	}
+}
+
+// BucketKey returns the key of the bucket the client spends tokens from.
+// It differs from IPAddress for clients that share the bucket of a CIDR range.
+func (c *Client) BucketKey() string {
+	if c.parent != nil {
+		return c.parent.BucketKey()
+	}
+	return c.IPAddress
+}
+
+// SameConfig reports whether the clients have the same identity and limits, the buckets are not compared.
+func (c *Client) SameConfig(other *Client) bool {
+	return c.ID == other.ID &&
+		c.IPAddress == other.IPAddress &&
+		c.Capacity == other.Capacity &&
+		c.RatePerSecond == other.RatePerSecond &&
+		c.LimitMode == other.LimitMode &&
+		c.MaxConcurrent == other.MaxConcurrent &&
+		c.QuotaPeriod == other.QuotaPeriod &&
+		c.QuotaLimit == other.QuotaLimit &&
+		c.Plan == other.Plan &&
+		c.Pinned == other.Pinned &&
+		maps.Equal(c.PolicyOverrides, other.PolicyOverrides)
+}
+
+// BucketTokens returns the amount of tokens in the bucket the client spends tokens from.
+func (c *Client) BucketTokens() int32 {
+	if c.parent != nil {
+		return c.parent.BucketTokens()
+	}
+	return c.Tokens.Load()
+}
+
+// LastRefill returns the time of the last refill of the bucket the client spends tokens from,
+// zero if it has not been refilled since the client was loaded.
+func (c *Client) LastRefill() time.Time {
+	if c.parent != nil {
+		return c.parent.LastRefill()
+	}
+
+	nanos := c.lastRefill.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// RefillFull fills the client's bucket and the buckets of its policies up to their capacity.
+//
+// This method is concurrently safe.
+func (c *Client) RefillFull() {
+	if c.parent != nil {
+		c.parent.RefillFull()
+		return
+	}
+
+	c.Tokens.Store(c.Capacity)
+	c.lastRefill.Store(time.Now().UnixNano())
+
+	if limiters := c.limiters.Load(); limiters != nil {
+		limiters.refillFull()
+	}
+}
+
+// OwnsBucket reports whether the client spends tokens of its own bucket
+// rather than the bucket of the CIDR range it belongs to.
+func (c *Client) OwnsBucket() bool {
+	return c.parent == nil
+}
+
+// InheritTokens fills the client's bucket from a previous state of a bucket of the given capacity.
+// The tokens are scaled to the capacity of the client, so a changed capacity keeps the bucket as full as it was.
+func (c *Client) InheritTokens(tokens, capacity int32) {
+	c.Tokens.Store(scaleTokens(tokens, capacity, c.Capacity))
+}
+
+// Limiters returns the state of the policy buckets and the concurrency semaphore of the bucket the client
+// spends tokens from, nil if none of them has been used yet.
+func (c *Client) Limiters() *Limiters {
+	if c.parent != nil {
+		return c.parent.Limiters()
+	}
+	return c.limiters.Load()
+}
+
+// InheritLimiters makes the client continue with the limiters of the client it replaces
+// (see Limiters). Buckets of policies with changed limits are scaled on their next use
+// and the semaphore gets the concurrency limit of this client. Nil limiters are ignored.
+//
+// It must be called before the client is used.
+func (c *Client) InheritLimiters(limiters *Limiters) {
+	if limiters != nil && c.parent == nil {
+		c.limiters.Store(limiters)
+	}
+}
+
+// ownLimiters returns the limiters of the client, creating them on the first use.
+func (c *Client) ownLimiters() *Limiters {
+	if limiters := c.limiters.Load(); limiters != nil {
+		return limiters
+	}
+	c.limiters.CompareAndSwap(nil, new(Limiters))
+	return c.limiters.Load()
+}
+
+// PolicyCost returns the cost of a request matching the policy, taking the client's override into account.
+func (c *Client) PolicyCost(policy *Policy) int32 {
+	if override, ok := c.PolicyOverrides[policy.Name]; ok && override.Cost > 0 {
+		return override.Cost
+	}
+	return max(policy.Cost, 1)
+}
+
+// PolicyBucket returns the client's bucket of a client-scoped policy, creating it on the first use.
+//
+// This method is concurrently safe.
+func (c *Client) PolicyBucket(policy *Policy) *TokenBucket {
+	if c.parent != nil {
+		return c.parent.PolicyBucket(policy)
+	}
+
+	capacity, ratePerSecond := policy.Capacity, policy.RatePerSecond
+	if override, ok := c.PolicyOverrides[policy.Name]; ok {
+		if override.Capacity > 0 {
+			capacity = override.Capacity
+		}
+		if override.RatePerSecond > 0 {
+			ratePerSecond = override.RatePerSecond
 		}
 	}
+
+	return c.ownLimiters().policyBucket(policy.Name, capacity, ratePerSecond)
+}
+
+// HasQuota reports whether the number of requests of the client is limited within a calendar period.
+func (c *Client) HasQuota() bool {
+	return c.QuotaPeriod != QuotaPeriodNone && c.QuotaLimit > 0
+}
+
+// Concurrency returns the semaphore limiting concurrent requests of the client, creating it on the first use.
+// The queueSize is only used when the semaphore is created. It returns nil if the client has no concurrency limit.
+//
+// The semaphore is kept by the limiters of the client, so the requests of the client that replaces it
+// share the slots with the requests that are still in flight.
+//
+// Clients that share the bucket of a CIDR range share its concurrency limit as well.
+//
+// This method is concurrently safe.
+func (c *Client) Concurrency(queueSize int) *semaphore.Semaphore {
+	if c.parent != nil {
+		return c.parent.Concurrency(queueSize)
+	}
+
+	if c.MaxConcurrent <= 0 {
+		return nil
+	}
+
+	return c.ownLimiters().semaphore(int(c.MaxConcurrent), queueSize)
 }
 
 // RefillTokensOncePerSecond refills client tokens by RatePerSecond.
 // It should be called once per second
 //
+// Clients that share the bucket of a CIDR range are not refilled, the range itself is.
+//
 // This method is concurrently safe.
 func (c *Client) RefillTokensOncePerSecond() {
-	for {
-		current := c.Tokens.Load()
-		newTokens := min(current+c.RatePerSecond, c.Capacity)
+	if c.parent != nil {
+		return
+	}
 
-		if c.Tokens.CompareAndSwap(current, newTokens) {
-			break
-		}
+	refillTokens(&c.Tokens, c.RatePerSecond, c.Capacity)
+	c.lastRefill.Store(time.Now().UnixNano())
+
+	if limiters := c.limiters.Load(); limiters != nil {
+		limiters.refillOncePerSecond()
 	}
 }
@@ -0,0 +1,9 @@
+package entity
+
+// PeerCounters is a snapshot of tokens consumed by a single balancer instance,
+// exchanged between instances in the peer-to-peer rate-limiting mode.
+type PeerCounters struct {
+	InstanceID string `json:"instance_id"`
+	// Totals holds the total amount of tokens consumed by the instance, the key is the bucket key.
+	Totals map[string]int64 `json:"totals"`
+}
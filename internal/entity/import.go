@@ -0,0 +1,34 @@
+package entity
+
+// ImportRow is a row of a bulk import of clients.
+type ImportRow struct {
+	// Line is the line of the row in the imported file.
+	Line   int
+	Client *Client
+	// Err is set if the row could not be parsed, then Client is nil.
+	Err error
+}
+
+// ImportOptions configures a bulk import of clients.
+type ImportOptions struct {
+	// Upsert replaces the existing clients, otherwise an existing client is an error of its row.
+	Upsert bool
+	// DryRun validates the rows without applying them.
+	DryRun bool
+}
+
+// ImportResult is the result of a bulk import of clients. The rows are applied only if there are no errors.
+type ImportResult struct {
+	Rows    int           `json:"rows"`
+	Created int64         `json:"created"`
+	Updated int64         `json:"updated"`
+	DryRun  bool          `json:"dry_run"`
+	Errors  []ImportError `json:"errors,omitempty"`
+}
+
+// ImportError is an error of a row of a bulk import.
+type ImportError struct {
+	Line      int    `json:"line"`
+	IPAddress string `json:"ip_address,omitempty"`
+	Message   string `json:"message"`
+}
@@ -0,0 +1,107 @@
+package entity
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/lib/semaphore"
+)
+
+// Limiters is the state of the limiters of a client other than its main bucket:
+// the buckets of the client-scoped policies and the semaphore of its concurrent requests.
+//
+// The state is decoupled from the configuration of the client. It is handed over from a client to the one
+// that replaces it (see Client.InheritLimiters), so neither an update nor an eviction resets it,
+// and the requests in flight keep holding the slots of the same semaphore.
+//
+// This type is concurrently safe.
+type Limiters struct {
+	policyBuckets sync.Map // string (policy name) -> *TokenBucket
+
+	mu               sync.Mutex
+	concurrency      *semaphore.Semaphore
+	concurrencyLimit int
+}
+
+// policyBucket returns the bucket of the policy with the given limits. A bucket created with other limits
+// is replaced by a bucket with the new ones, as full as the replaced one was.
+func (l *Limiters) policyBucket(name string, capacity, ratePerSecond int32) *TokenBucket {
+	for {
+		v, ok := l.policyBuckets.Load(name)
+		if !ok {
+			v, _ = l.policyBuckets.LoadOrStore(name, NewTokenBucket(capacity, ratePerSecond))
+		}
+
+		bucket := v.(*TokenBucket)
+		if bucket.capacity == capacity && bucket.ratePerSecond == ratePerSecond {
+			return bucket
+		}
+
+		resized := NewTokenBucket(capacity, ratePerSecond)
+		resized.tokens.Store(scaleTokens(bucket.Tokens(), bucket.capacity, capacity))
+		if l.policyBuckets.CompareAndSwap(name, bucket, resized) {
+			return resized
+		}
+	}
+}
+
+// semaphore returns the semaphore of the client with the given limit, creating it on the first use.
+// The queueSize is only used when the semaphore is created.
+func (l *Limiters) semaphore(limit, queueSize int) *semaphore.Semaphore {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.concurrency == nil:
+		l.concurrency = semaphore.New(limit, queueSize)
+	case l.concurrencyLimit != limit:
+		l.concurrency.SetLimit(limit)
+	}
+	l.concurrencyLimit = limit
+
+	return l.concurrency
+}
+
+// refillOncePerSecond refills the buckets of the policies by their rates.
+func (l *Limiters) refillOncePerSecond() {
+	l.policyBuckets.Range(func(_, bucket any) bool {
+		bucket.(*TokenBucket).RefillOncePerSecond()
+		return true
+	})
+}
+
+// refillFull fills the buckets of the policies up to their capacity.
+func (l *Limiters) refillFull() {
+	l.policyBuckets.Range(func(_, bucket any) bool {
+		bucket.(*TokenBucket).RefillFull()
+		return true
+	})
+}
+
+// RefillFor refills the buckets of the policies by the tokens of the elapsed whole seconds,
+// e.g. of the time the state was kept for a client that left the cache.
+//
+// This method is concurrently safe.
+func (l *Limiters) RefillFor(elapsed time.Duration) {
+	seconds := int64(elapsed / time.Second)
+	if seconds <= 0 {
+		return
+	}
+
+	l.policyBuckets.Range(func(_, v any) bool {
+		bucket := v.(*TokenBucket)
+		refillTokens(&bucket.tokens, int32(min(seconds*int64(bucket.ratePerSecond), int64(bucket.capacity))), bucket.capacity)
+		return true
+	})
+}
+
+// scaleTokens scales the tokens of a bucket of the given capacity to another capacity,
+// so a changed capacity keeps the bucket as full as it was. A debt is kept down to the new capacity.
+func scaleTokens(tokens, from, to int32) int32 {
+	if from <= 0 {
+		return to
+	}
+
+	scaled := int64(tokens) * int64(to) / int64(from)
+	return int32(min(max(scaled, -int64(to)), int64(to)))
+}
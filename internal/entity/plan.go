@@ -0,0 +1,13 @@
+package entity
+
+// Plan is a tier of clients that defines their limits.
+// Clients reference a plan by its name and may override any of its limits.
+type Plan struct {
+	ID            int64       `json:"id"`
+	Name          string      `json:"name"`
+	Capacity      int32       `json:"capacity"`
+	RatePerSecond int32       `json:"rate_per_second"`
+	MaxConcurrent int32       `json:"max_concurrent"`
+	QuotaPeriod   QuotaPeriod `json:"quota_period,omitempty"`
+	QuotaLimit    int64       `json:"quota_limit,omitempty"`
+}
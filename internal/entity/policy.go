@@ -0,0 +1,73 @@
+package entity
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// PolicyScope defines who shares the bucket of a rate-limit policy.
+type PolicyScope string
+
+const (
+	// PolicyScopeGlobal means that all clients share a single bucket of the policy.
+	PolicyScopeGlobal PolicyScope = "global"
+	// PolicyScopeClient means that every client gets its own bucket of the policy.
+	PolicyScopeClient PolicyScope = "client"
+)
+
+// Policy is a rate-limit policy attached to the requests matching its methods and path pattern.
+//
+// A matching request costs Cost tokens of the client's bucket. If the policy has a positive Capacity,
+// the request also has to pass through the bucket of the policy.
+type Policy struct {
+	Name          string
+	Methods       []string
+	Path          string
+	Cost          int32
+	Scope         PolicyScope
+	Capacity      int32
+	RatePerSecond int32
+}
+
+// PolicyOverride overrides the parameters of a policy for a single client.
+// Zero fields are not overridden.
+type PolicyOverride struct {
+	Capacity      int32 `json:"capacity,omitempty"`
+	RatePerSecond int32 `json:"rate_per_second,omitempty"`
+	Cost          int32 `json:"cost,omitempty"`
+}
+
+// Matches reports whether the request with the given method and path is covered by the policy.
+//
+// The path pattern uses the path.Match syntax, additionally a pattern ending with "/**"
+// matches the prefix itself and every path below it. An empty pattern matches every path,
+// empty methods match every method.
+func (p *Policy) Matches(method, urlPath string) bool {
+	return matchRoute(p.Methods, p.Path, method, urlPath)
+}
+
+// matchRoute reports whether the method and the path match the methods and the path pattern (see Policy.Matches).
+func matchRoute(methods []string, pattern, method, urlPath string) bool {
+	if len(methods) > 0 && !slices.ContainsFunc(methods, func(m string) bool {
+		return strings.EqualFold(m, method)
+	}) {
+		return false
+	}
+
+	if pattern == "" {
+		return true
+	}
+
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+	}
+
+	ok, err := path.Match(pattern, urlPath)
+	return err == nil && ok
+}
+
+// HasBucket reports whether the policy limits requests by its own bucket rather than only setting their cost.
+func (p *Policy) HasBucket() bool {
+	return p.Capacity > 0
+}
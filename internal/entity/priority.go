@@ -0,0 +1,70 @@
+package entity
+
+import "slices"
+
+// Priority is the priority class of a request. Lower values are more important.
+type Priority int
+
+const (
+	// PriorityCritical is for requests that must pass even under overload, e.g. health checks.
+	PriorityCritical Priority = iota
+	// PriorityNormal is the priority of requests that are not classified.
+	PriorityNormal
+	// PriorityLow is for best-effort requests that are shed first, e.g. background batch jobs.
+	PriorityLow
+)
+
+var priorityNames = map[Priority]string{
+	PriorityCritical: "critical",
+	PriorityNormal:   "normal",
+	PriorityLow:      "low",
+}
+
+// ParsePriority returns the priority with the given name.
+func ParsePriority(name string) (Priority, bool) {
+	for priority, n := range priorityNames {
+		if n == name {
+			return priority, true
+		}
+	}
+	return PriorityNormal, false
+}
+
+// String returns the name of the priority.
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// PriorityRule classifies the requests matching all of its conditions into a priority class.
+// Empty conditions match every request.
+type PriorityRule struct {
+	Priority Priority
+	// Plans are the plans of the clients, see Client.Plan.
+	Plans []string
+	// Methods and Path match the route of the request, see Policy.Matches.
+	Methods []string
+	Path    string
+	// Header and HeaderValue match a header of the request. An empty HeaderValue matches any non-empty value.
+	Header      string
+	HeaderValue string
+}
+
+// Matches reports whether the request is covered by the rule. The plan is empty for requests without a client,
+// header returns the value of a header of the request.
+func (r *PriorityRule) Matches(plan, method, urlPath string, header func(name string) string) bool {
+	if len(r.Plans) > 0 && !slices.Contains(r.Plans, plan) {
+		return false
+	}
+
+	if r.Header != "" {
+		value := header(r.Header)
+		if value == "" || (r.HeaderValue != "" && value != r.HeaderValue) {
+			return false
+		}
+	}
+
+	return matchRoute(r.Methods, r.Path, method, urlPath)
+}
@@ -0,0 +1,71 @@
+package entity
+
+import "net/netip"
+
+// ClientsSort is the field the clients are ordered by. Clients with equal values are ordered by their ID.
+type ClientsSort string
+
+const (
+	ClientsSortID            ClientsSort = "id"
+	ClientsSortIPAddress     ClientsSort = "ip_address"
+	ClientsSortCapacity      ClientsSort = "capacity"
+	ClientsSortRatePerSecond ClientsSort = "rate_per_second"
+)
+
+// IsValid reports whether the clients can be ordered by the field.
+func (s ClientsSort) IsValid() bool {
+	switch s {
+	case ClientsSortID, ClientsSortIPAddress, ClientsSortCapacity, ClientsSortRatePerSecond:
+		return true
+	default:
+		return false
+	}
+}
+
+// ClientsQuery selects a page of clients. Zero filters select all clients.
+// Capacities are the effective ones, i.e. taken from the plan unless overridden.
+type ClientsQuery struct {
+	// IPPrefix selects the addresses and ranges that start with it, e.g. "10.0.".
+	IPPrefix string
+	// Network selects the addresses and ranges within it.
+	Network     netip.Prefix
+	Plan        string
+	MinCapacity int32
+	MaxCapacity int32
+	// Raw returns the limits of the clients as they are stored: limits taken from the plan are zero.
+	// Filters still apply to the effective limits, and the raw clients can be paged only in the order of ID.
+	Raw bool
+
+	Sort ClientsSort
+	Desc bool
+	// Limit is the maximum number of clients in the page.
+	Limit int
+	// After is the position of the last client of the previous page, nil for the first page.
+	After *ClientsCursor
+}
+
+// ClientsCursor is the position of a client in the ordered clients, it holds the values of all sortable fields,
+// so the next page starts right after the client whatever it was ordered by.
+type ClientsCursor struct {
+	ID            int64  `json:"id"`
+	IPAddress     string `json:"ip,omitempty"`
+	Capacity      int32  `json:"c,omitempty"`
+	RatePerSecond int32  `json:"r,omitempty"`
+}
+
+// CursorOf returns the position of the client.
+func CursorOf(client *Client) *ClientsCursor {
+	return &ClientsCursor{
+		ID:            client.ID,
+		IPAddress:     client.IPAddress,
+		Capacity:      client.Capacity,
+		RatePerSecond: client.RatePerSecond,
+	}
+}
+
+// ClientsPage is a page of clients.
+type ClientsPage struct {
+	Clients []*Client
+	// Next is the position to request the next page from, nil if this page is the last one.
+	Next *ClientsCursor
+}
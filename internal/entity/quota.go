@@ -0,0 +1,73 @@
+package entity
+
+import "time"
+
+// QuotaPeriod is a calendar period over which the requests of a client are counted.
+type QuotaPeriod string
+
+const (
+	QuotaPeriodNone  QuotaPeriod = ""
+	QuotaPeriodHour  QuotaPeriod = "hour"
+	QuotaPeriodDay   QuotaPeriod = "day"
+	QuotaPeriodMonth QuotaPeriod = "month"
+)
+
+// IsValid reports whether the quota period is known.
+func (p QuotaPeriod) IsValid() bool {
+	switch p {
+	case QuotaPeriodNone, QuotaPeriodHour, QuotaPeriodDay, QuotaPeriodMonth:
+		return true
+	}
+	return false
+}
+
+// Start returns the beginning of the period containing t in the given location.
+func (p QuotaPeriod) Start(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch p {
+	case QuotaPeriodHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	case QuotaPeriodDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	case QuotaPeriodMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	return time.Time{}
+}
+
+// End returns the end of the period that begins at start.
+func (p QuotaPeriod) End(start time.Time) time.Time {
+	switch p {
+	case QuotaPeriodHour:
+		return start.Add(time.Hour)
+	case QuotaPeriodDay:
+		return start.AddDate(0, 0, 1)
+	case QuotaPeriodMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start
+}
+
+// QuotaUsage is the usage of a client's quota in the current period.
+type QuotaUsage struct {
+	IPAddress   string      `json:"ip_address"`
+	Period      QuotaPeriod `json:"period"`
+	Limit       int64       `json:"limit"`
+	Used        int64       `json:"used"`
+	Remaining   int64       `json:"remaining"`
+	PeriodStart time.Time   `json:"period_start"`
+	ResetsAt    time.Time   `json:"resets_at"`
+}
+
+// QuotaUsageKey identifies the usage of a client's quota within a period.
+type QuotaUsageKey struct {
+	Key         string
+	PeriodStart time.Time
+}
+
+// QuotaUsageDelta is the amount of requests made by a client within a period that is not persisted yet.
+type QuotaUsageDelta struct {
+	Key         string
+	PeriodStart time.Time
+	Used        int64
+}
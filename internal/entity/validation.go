@@ -0,0 +1,97 @@
+package entity
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/kurochkinivan/load_balancer/internal/lib/iputil"
+)
+
+// FieldError is an invalid field of a client.
+type FieldError struct {
+	// Field is the name of the field, nested fields are joined with dots, e.g. "policy_overrides.search.capacity".
+	Field   string
+	Message string
+}
+
+// FieldErrors is the list of the invalid fields of a client.
+type FieldErrors []FieldError
+
+// Error describes all invalid fields in one line.
+func (e FieldErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Field + " " + err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *FieldErrors) add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+func (e *FieldErrors) positive(field string, v int64) {
+	if v <= 0 {
+		e.add(field, "must be positive")
+	}
+}
+
+func (e *FieldErrors) nonNegative(field string, v int64) {
+	if v < 0 {
+		e.add(field, "must not be negative")
+	}
+}
+
+// Validate checks the fields of the client and returns the invalid ones, nil if the client is valid.
+//
+// Limits of a client with a plan may be zero, then they are taken from the plan, other clients must have
+// a positive capacity and rate_per_second. A bucket must hold at least the tokens it is refilled by per second.
+// An empty limit mode is invalid, it has to be defaulted before.
+func (c *Client) Validate() FieldErrors {
+	var errs FieldErrors
+
+	if c.IPAddress == "" {
+		errs.add("ip_address", "is required")
+	} else if _, err := iputil.Canonical(c.IPAddress); err != nil {
+		errs.add("ip_address", "must be an IP address or a CIDR range")
+	}
+
+	if !c.LimitMode.IsValid() {
+		errs.add("limit_mode", "must be either \"shared\" or \"per_ip\"")
+	}
+
+	if c.Plan == "" {
+		errs.positive("capacity", int64(c.Capacity))
+		errs.positive("rate_per_second", int64(c.RatePerSecond))
+	} else {
+		errs.nonNegative("capacity", int64(c.Capacity))
+		errs.nonNegative("rate_per_second", int64(c.RatePerSecond))
+	}
+	if c.Capacity > 0 && c.RatePerSecond > c.Capacity {
+		errs.add("capacity", "must not be less than rate_per_second")
+	}
+
+	errs.nonNegative("max_concurrent", int64(c.MaxConcurrent))
+	if !c.QuotaPeriod.IsValid() {
+		errs.add("quota_period", "must be one of \"hour\", \"day\" or \"month\"")
+	}
+	errs.nonNegative("quota_limit", c.QuotaLimit)
+	if c.Plan == "" && (c.QuotaPeriod == QuotaPeriodNone) != (c.QuotaLimit == 0) {
+		errs.add("quota_limit", "must be set together with quota_period")
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.PolicyOverrides)) {
+		override := c.PolicyOverrides[name]
+		field := "policy_overrides." + name + "."
+
+		errs.nonNegative(field+"capacity", int64(override.Capacity))
+		errs.nonNegative(field+"rate_per_second", int64(override.RatePerSecond))
+		errs.nonNegative(field+"cost", int64(override.Cost))
+		if override.Capacity > 0 && override.RatePerSecond > override.Capacity {
+			errs.add(field+"capacity", "must not be less than rate_per_second")
+		}
+	}
+
+	return errs
+}
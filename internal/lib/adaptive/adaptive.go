@@ -0,0 +1,139 @@
+// Package adaptive provides a concurrency limiter that adapts its limit to the latency of the protected service.
+package adaptive
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// baselineDrift is how fast the baseline latency follows latencies above it.
+	// It lets the limiter adapt to a permanent change of the latency of the service.
+	baselineDrift = 0.001
+)
+
+// Options configures the Limiter.
+type Options struct {
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// Backoff is the factor the limit is multiplied by when the service is overloaded, e.g. 0.9.
+	Backoff float64
+	// Tolerance is how many times the latency may exceed the baseline latency
+	// before the service is considered overloaded, e.g. 2.
+	Tolerance float64
+}
+
+// Limiter limits the number of concurrent requests to a service using the AIMD algorithm.
+//
+// The baseline latency is the latency of the service without load. While latencies stay within
+// Tolerance of the baseline, the limit grows by one per limit of completed requests (additive increase).
+// When a request is slow or dropped, the limit is multiplied by Backoff (multiplicative decrease).
+// The limit is decreased at most once per round of requests started before the previous decrease,
+// so a single burst of slow responses does not collapse it.
+type Limiter struct {
+	opts Options
+	now  func() time.Time
+
+	mu           sync.Mutex
+	limit        float64
+	inFlight     int
+	baseline     time.Duration
+	lastDecrease time.Time
+}
+
+// Token is an acquired slot of the limiter. It has to be released exactly once.
+type Token struct {
+	limiter *Limiter
+	start   time.Time
+}
+
+func New(opts Options) *Limiter {
+	opts.MinLimit = max(opts.MinLimit, 1)
+	opts.MaxLimit = max(opts.MaxLimit, opts.MinLimit)
+	if opts.Backoff <= 0 || opts.Backoff >= 1 {
+		opts.Backoff = 0.9
+	}
+	if opts.Tolerance <= 1 {
+		opts.Tolerance = 2
+	}
+
+	return &Limiter{
+		opts:  opts,
+		now:   time.Now,
+		limit: float64(min(max(opts.InitialLimit, opts.MinLimit), opts.MaxLimit)),
+	}
+}
+
+// TryAcquire acquires a slot if the number of requests in flight is below the given share of the limit.
+// Shares below 1 reserve the rest of the limit for more important requests.
+//
+// This method is concurrently safe.
+func (l *Limiter) TryAcquire(share float64) (*Token, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight >= max(int(l.limit*share), 1) {
+		return nil, false
+	}
+	l.inFlight++
+
+	return &Token{limiter: l, start: l.now()}, true
+}
+
+// Release releases the slot and adjusts the limit by the latency of the request.
+// A dropped request, e.g. one that has failed or timed out, is treated as a sign of overload.
+//
+// This method is concurrently safe.
+func (t *Token) Release(dropped bool) {
+	t.limiter.release(t.start, dropped)
+}
+
+func (l *Limiter) release(start time.Time, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	latency := now.Sub(start)
+	inFlight := l.inFlight
+	l.inFlight--
+
+	if !dropped {
+		switch {
+		case l.baseline == 0 || latency < l.baseline:
+			l.baseline = latency
+		default:
+			l.baseline += time.Duration(float64(latency-l.baseline) * baselineDrift)
+		}
+	}
+
+	if dropped || float64(latency) > float64(l.baseline)*l.opts.Tolerance {
+		if start.Before(l.lastDecrease) {
+			return
+		}
+		l.limit = max(l.limit*l.opts.Backoff, float64(l.opts.MinLimit))
+		l.lastDecrease = now
+		return
+	}
+
+	// The limit is not increased while it is not used, otherwise it would grow without bound.
+	if float64(inFlight) >= l.limit/2 {
+		l.limit = min(l.limit+1/l.limit, float64(l.opts.MaxLimit))
+	}
+}
+
+// Limit returns the current limit.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
+
+// InFlight returns the number of acquired slots.
+func (l *Limiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.inFlight
+}
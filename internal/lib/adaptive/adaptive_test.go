@@ -0,0 +1,89 @@
+package adaptive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestLimiter(opts Options) (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New(opts)
+	l.now = clock.Now
+	return l, clock
+}
+
+// serve acquires n slots and releases them after the latency.
+func serve(t *testing.T, l *Limiter, clock *fakeClock, n int, latency time.Duration) {
+	t.Helper()
+
+	tokens := make([]*Token, 0, n)
+	for range n {
+		token, ok := l.TryAcquire(1)
+		require.True(t, ok)
+		tokens = append(tokens, token)
+	}
+
+	clock.now = clock.now.Add(latency)
+	for _, token := range tokens {
+		token.Release(false)
+	}
+}
+
+func TestLimiter_TryAcquire(t *testing.T) {
+	l, _ := newTestLimiter(Options{InitialLimit: 10, MinLimit: 1, MaxLimit: 100})
+
+	for range 9 {
+		_, ok := l.TryAcquire(1)
+		require.True(t, ok)
+	}
+
+	// The last slot is reserved for requests that may use the whole limit.
+	_, ok := l.TryAcquire(0.9)
+	assert.False(t, ok)
+
+	token, ok := l.TryAcquire(1)
+	require.True(t, ok)
+	_, ok = l.TryAcquire(1)
+	assert.False(t, ok)
+
+	token.Release(false)
+	assert.Equal(t, 9, l.InFlight())
+}
+
+func TestLimiter_AIMD(t *testing.T) {
+	l, clock := newTestLimiter(Options{InitialLimit: 10, MinLimit: 2, MaxLimit: 20, Backoff: 0.5, Tolerance: 2})
+
+	t.Run("Increases while latency is normal", func(t *testing.T) {
+		for range 50 {
+			serve(t, l, clock, l.Limit(), 10*time.Millisecond)
+		}
+		assert.Equal(t, 20, l.Limit())
+	})
+
+	t.Run("Decreases once per round of slow requests", func(t *testing.T) {
+		serve(t, l, clock, 10, 50*time.Millisecond)
+		assert.Equal(t, 10, l.Limit())
+
+		serve(t, l, clock, 5, 50*time.Millisecond)
+		assert.Equal(t, 5, l.Limit())
+	})
+
+	t.Run("Decreases on dropped requests down to the minimum", func(t *testing.T) {
+		for range 10 {
+			token, ok := l.TryAcquire(1)
+			require.True(t, ok)
+			clock.now = clock.now.Add(time.Millisecond)
+			token.Release(true)
+		}
+		assert.Equal(t, 2, l.Limit())
+	})
+}
@@ -0,0 +1,93 @@
+// Package iputil contains helpers for turning IP addresses and CIDR ranges into client keys.
+package iputil
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ClientKey returns the key under which the rate limits of the given address are tracked.
+//
+// IPv4 addresses are used as is. IPv6 addresses are aggregated to the network of ipv6PrefixLen bits,
+// so a host cannot evade limits by rotating addresses inside its network. If ipv6PrefixLen is
+// not in (0, 128), IPv6 addresses are not aggregated.
+func ClientKey(addr netip.Addr, ipv6PrefixLen int) string {
+	addr = addr.Unmap()
+	if addr.Is4() || ipv6PrefixLen <= 0 || ipv6PrefixLen >= 128 {
+		return addr.WithZone("").String()
+	}
+
+	return netip.PrefixFrom(addr.WithZone(""), ipv6PrefixLen).Masked().String()
+}
+
+// Canonical parses an IP address or a CIDR range and returns its canonical string representation.
+// CIDR ranges are masked and a range covering exactly one address is turned into that address.
+func Canonical(s string) (string, error) {
+	if !IsRange(s) {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid ip address %q: %w", s, err)
+		}
+		return addr.Unmap().WithZone("").String(), nil
+	}
+
+	prefix, err := ParsePrefix(s)
+	if err != nil {
+		return "", err
+	}
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String(), nil
+	}
+	return prefix.String(), nil
+}
+
+// ParsePrefix parses a CIDR range and returns it masked.
+// IPv4-mapped IPv6 ranges are converted to IPv4 ranges.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid cidr %q: %w", s, err)
+	}
+
+	addr, bits := prefix.Addr(), prefix.Bits()
+	if addr.Is4In6() {
+		if bits < 96 {
+			return netip.Prefix{}, fmt.Errorf("invalid cidr %q: ipv4-mapped range is too wide", s)
+		}
+		addr, bits = addr.Unmap(), bits-96
+	}
+
+	return netip.PrefixFrom(addr, bits).Masked(), nil
+}
+
+// Prefix parses an IP address or a CIDR range and returns it as a masked prefix.
+// A single address is turned into a prefix covering exactly that address.
+func Prefix(s string) (netip.Prefix, error) {
+	if IsRange(s) {
+		return ParsePrefix(s)
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid ip address %q: %w", s, err)
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// IsRange reports whether s looks like a CIDR range rather than a single address.
+func IsRange(s string) bool {
+	return strings.Contains(s, "/")
+}
+
+// Contains reports whether the address or the range s is fully inside the prefix.
+func Contains(prefix netip.Prefix, s string) bool {
+	if IsRange(s) {
+		p, err := ParsePrefix(s)
+		return err == nil && p.Bits() >= prefix.Bits() && prefix.Contains(p.Addr())
+	}
+
+	addr, err := netip.ParseAddr(s)
+	return err == nil && prefix.Contains(addr.Unmap())
+}
@@ -0,0 +1,102 @@
+// Package metrics implements counters and gauges exposed in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a metric that only increases.
+type Counter struct {
+	v atomic.Int64
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add increments the counter by n, which must not be negative.
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+// Gauge is a metric that can go up and down.
+type Gauge struct {
+	v atomic.Int64
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v int64) {
+	g.v.Store(v)
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func() int64
+}
+
+// Registry holds the metrics of the application in the order of their registration.
+//
+// This type is concurrently safe.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter registers a new counter.
+func (r *Registry) Counter(name, help string) *Counter {
+	c := new(Counter)
+	r.register(metric{name: name, help: help, kind: "counter", value: c.Value})
+	return c
+}
+
+// Gauge registers a new gauge.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := new(Gauge)
+	r.register(metric{name: name, help: help, kind: "gauge", value: g.Value})
+	return g
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes all metrics to w in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := r.metrics
+	r.mu.Unlock()
+
+	var written int64
+	for _, m := range metrics {
+		n, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value())
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	up := r.Gauge("storage_up", "Whether the storage is reachable.")
+	requests := r.Counter("requests_total", "Total requests.")
+
+	up.Set(1)
+	requests.Inc()
+	requests.Add(2)
+
+	var sb strings.Builder
+	_, err := r.WriteTo(&sb)
+	require.NoError(t, err)
+
+	assert.Equal(t, "# HELP storage_up Whether the storage is reachable.\n"+
+		"# TYPE storage_up gauge\n"+
+		"storage_up 1\n"+
+		"# HELP requests_total Total requests.\n"+
+		"# TYPE requests_total counter\n"+
+		"requests_total 3\n", sb.String())
+}
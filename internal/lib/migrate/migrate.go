@@ -0,0 +1,433 @@
+// Package migrate applies versioned SQL migrations to a PostgreSQL database.
+//
+// Migrations are read from files named <version>_<name>.up.sql and <version>_<name>.down.sql,
+// the applied versions are tracked in the schema_migrations table. Every migration runs in its own
+// transaction together with the change of the table, and concurrent migrators, e.g. several balancer
+// instances starting at once, are serialized by an advisory lock.
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kurochkinivan/load_balancer/pkg/pgerr"
+)
+
+// lockKey is the key of the advisory lock held while migrations are applied.
+const lockKey int64 = 0x6c625f6d696772 // "lb_migr"
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var (
+	ErrNoDownMigration = errors.New("migration can not be rolled back")
+	// ErrUntrackedSchema is returned by Up when the database already has tables but no applied migrations,
+	// e.g. it was created by docker-entrypoint-initdb.d scripts, and it is not adopted (see Adopt).
+	// Its version has to be recorded by Baseline.
+	ErrUntrackedSchema = errors.New("database has tables but no applied migrations, record the version of its schema with baseline")
+	ErrUnknownVersion  = errors.New("unknown migration version")
+)
+
+// Migration is a version of the schema.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	// Down is empty if the migration can not be rolled back.
+	Down string
+}
+
+// Status is a migration and whether it is applied.
+type Status struct {
+	*Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []*Migration // ordered by version
+	adopted    *adoption
+}
+
+// adoption is the schema of the untracked databases that Up adopts.
+type adoption struct {
+	version int64
+	columns map[string][]string // table -> columns
+}
+
+// New reads the migrations from fsys. Files that do not look like migrations are ignored.
+func New(pool *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	migrations, err := parse(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		pool:       pool,
+		migrations: migrations,
+	}, nil
+}
+
+// Adopt makes Up adopt a database that has no applied migrations but has exactly the given tables and columns,
+// e.g. a database created from the migrations up to the version by docker-entrypoint-initdb.d before
+// the migrations were tracked. The migrations up to the version are recorded as applied without running them
+// (see Baseline) and the later ones are applied. Other untracked databases are still rejected.
+func (m *Migrator) Adopt(version int64, columns map[string][]string) {
+	m.adopted = &adoption{version: version, columns: columns}
+}
+
+func parse(fsys fs.FS) ([]*Migration, error) {
+	const op = "migrate.parse"
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to read migrations: %w", op, err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid version of %q: %w", op, entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("%s: version %d is used by %q and %q", op, version, migration.Name, match[2])
+		}
+
+		sql, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to read %q: %w", op, entry.Name(), err)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(sql)
+		} else {
+			migration.Down = string(sql)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("%s: migration %d_%s has no up file", op, migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+
+	slices.SortFunc(migrations, func(a, b *Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	return migrations, nil
+}
+
+// Up applies all pending migrations in the order of their versions and returns them.
+// A database with tables but no applied migrations is adopted if it has the schema given to Adopt,
+// otherwise Up returns ErrUntrackedSchema.
+// A failed migration is rolled back and stops the process, the migrations applied before it stay applied.
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	const op = "migrate.Up"
+
+	var applied []*Migration
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if len(versions) == 0 {
+			columns, err := tableColumns(ctx, conn)
+			if err != nil {
+				return err
+			}
+			if len(columns) > 0 {
+				if m.adopted == nil || !sameColumns(columns, m.adopted.columns) {
+					return ErrUntrackedSchema
+				}
+				if _, err := m.record(ctx, conn, m.adopted.version, versions); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			err := run(ctx, conn, migration.Up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("failed to apply %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return applied, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return applied, nil
+}
+
+// Down rolls back up to steps latest applied migrations and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	const op = "migrate.Down"
+
+	var rolledBack []*Migration
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range slices.Backward(m.migrations) {
+			if len(rolledBack) >= steps {
+				break
+			}
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("%d_%s: %w", migration.Version, migration.Name, ErrNoDownMigration)
+			}
+
+			err := run(ctx, conn, migration.Down,
+				"DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+			if err != nil {
+				return fmt.Errorf("failed to roll back %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			rolledBack = append(rolledBack, migration)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return rolledBack, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return rolledBack, nil
+}
+
+// Baseline records the migrations up to the version as applied without running them and returns them.
+// It adopts a database whose schema was created before the migrations were tracked.
+func (m *Migrator) Baseline(ctx context.Context, version int64) ([]*Migration, error) {
+	const op = "migrate.Baseline"
+
+	if !slices.ContainsFunc(m.migrations, func(migration *Migration) bool { return migration.Version == version }) {
+		return nil, fmt.Errorf("%s: %d: %w", op, version, ErrUnknownVersion)
+	}
+
+	var recorded []*Migration
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		recorded, err = m.record(ctx, conn, version, versions)
+		return err
+	})
+	if err != nil {
+		return recorded, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return recorded, nil
+}
+
+// record records the migrations up to the version that are not in versions as applied and adds them to versions.
+func (m *Migrator) record(ctx context.Context, conn *pgxpool.Conn, version int64, versions map[int64]time.Time) ([]*Migration, error) {
+	const op = "migrate.record"
+
+	var recorded []*Migration
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			break
+		}
+		if _, ok := versions[migration.Version]; ok {
+			continue
+		}
+
+		_, err := conn.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+		if err != nil {
+			return recorded, pgerr.ErrExec(op, err)
+		}
+		versions[migration.Version] = time.Now()
+		recorded = append(recorded, migration)
+	}
+
+	return recorded, nil
+}
+
+// Status returns all known migrations in the order of their versions.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	const op = "migrate.Status"
+
+	var statuses []Status
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		statuses = make([]Status, len(m.migrations))
+		for i, migration := range m.migrations {
+			appliedAt, ok := versions[migration.Version]
+			statuses[i] = Status{Migration: migration, Applied: ok, AppliedAt: appliedAt}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return statuses, nil
+}
+
+// locked calls fn on a connection holding the advisory lock, the table of versions exists by then.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	const op = "migrate.locked"
+
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: failed to acquire connection: %w", op, err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return pgerr.ErrExec(op, err)
+	}
+	// The lock is released even if the context is cancelled, otherwise it stays with the pooled connection.
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	if _, err := conn.Exec(ctx, createTable); err != nil {
+		return pgerr.ErrExec(op, err)
+	}
+
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int64]time.Time, error) {
+	const op = "migrate.appliedVersions"
+
+	rows, err := conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, pgerr.ErrDoQuery(op, err)
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, pgerr.ErrScan(op, err)
+		}
+		versions[version] = appliedAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, pgerr.ErrScan(op, err)
+	}
+
+	return versions, nil
+}
+
+// tableColumns returns the columns of the tables of the current schema other than the table of versions.
+func tableColumns(ctx context.Context, conn *pgxpool.Conn) (map[string][]string, error) {
+	const op = "migrate.tableColumns"
+
+	rows, err := conn.Query(ctx, `SELECT table_name, column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'`)
+	if err != nil {
+		return nil, pgerr.ErrDoQuery(op, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string][]string)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, pgerr.ErrScan(op, err)
+		}
+		columns[table] = append(columns[table], column)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, pgerr.ErrScan(op, err)
+	}
+
+	return columns, nil
+}
+
+// sameColumns reports whether the tables have the same columns in any order.
+func sameColumns(a, b map[string][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for table, columns := range a {
+		other, ok := b[table]
+		if !ok || len(columns) != len(other) {
+			return false
+		}
+		if !slices.Equal(slices.Sorted(slices.Values(columns)), slices.Sorted(slices.Values(other))) {
+			return false
+		}
+	}
+	return true
+}
+
+// run executes the script of a migration and records it with the query in a single transaction.
+func run(ctx context.Context, conn *pgxpool.Conn, script, record string, args ...any) error {
+	const op = "migrate.run"
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return pgerr.ErrCreateTx(op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Without arguments the script is sent with the simple protocol, so it may contain several statements.
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return pgerr.ErrExec(op, err)
+	}
+	if _, err := tx.Exec(ctx, record, args...); err != nil {
+		return pgerr.ErrExec(op, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return pgerr.ErrCommit(op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/kurochkinivan/load_balancer/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("Migrations are ordered by version", func(t *testing.T) {
+		parsed, err := parse(fstest.MapFS{
+			"10_pinned.up.sql":   {Data: []byte("up 10")},
+			"10_pinned.down.sql": {Data: []byte("down 10")},
+			"2_ranges.up.sql":    {Data: []byte("up 2")},
+			"1_init.up.sql":      {Data: []byte("up 1")},
+			"1_init.down.sql":    {Data: []byte("down 1")},
+			"migrations.go":      {Data: []byte("package migrations")},
+		})
+		require.NoError(t, err)
+
+		require.Len(t, parsed, 3)
+		assert.Equal(t, &Migration{Version: 1, Name: "init", Up: "up 1", Down: "down 1"}, parsed[0])
+		assert.Equal(t, &Migration{Version: 2, Name: "ranges", Up: "up 2"}, parsed[1])
+		assert.Equal(t, &Migration{Version: 10, Name: "pinned", Up: "up 10", Down: "down 10"}, parsed[2])
+	})
+
+	t.Run("Version used twice", func(t *testing.T) {
+		_, err := parse(fstest.MapFS{
+			"1_init.up.sql":  {Data: []byte("up")},
+			"1_other.up.sql": {Data: []byte("up")},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("Missing up file", func(t *testing.T) {
+		_, err := parse(fstest.MapFS{
+			"1_init.down.sql": {Data: []byte("down")},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("Embedded migrations", func(t *testing.T) {
+		parsed, err := parse(migrations.FS)
+		require.NoError(t, err)
+
+		for i, migration := range parsed {
+			assert.Equal(t, int64(i+1), migration.Version, "versions have no gaps")
+			assert.NotEmpty(t, migration.Down, "%d_%s can be rolled back", migration.Version, migration.Name)
+		}
+	})
+}
+
+func TestSameColumns(t *testing.T) {
+	schema := map[string][]string{
+		"clients": {"id", "ip_address", "capacity", "rate_per_second"},
+	}
+
+	assert.True(t, sameColumns(map[string][]string{
+		"clients": {"rate_per_second", "capacity", "ip_address", "id"},
+	}, schema), "the order of columns does not matter")
+	assert.False(t, sameColumns(map[string][]string{
+		"clients": {"id", "ip_address", "capacity", "rate_per_second", "limit_mode"},
+	}, schema), "a later migration is applied")
+	assert.False(t, sameColumns(map[string][]string{
+		"clients": {"id", "ip_address", "capacity", "rate_per_second"},
+		"plans":   {"id", "name"},
+	}, schema))
+	assert.False(t, sameColumns(map[string][]string{
+		"users": {"id", "ip_address", "capacity", "rate_per_second"},
+	}, schema))
+}
@@ -0,0 +1,211 @@
+// Package radix provides a binary radix tree over IP prefixes with longest-prefix matching.
+//
+// IPv4 and IPv6 prefixes are kept in separate trees, so an IPv4 rule never matches an IPv6 address
+// and vice versa. IPv4-mapped IPv6 addresses are unmapped before being inserted or looked up.
+package radix
+
+import (
+	"net/netip"
+)
+
+// Tree is a radix tree that maps IP prefixes to values of type V.
+//
+// Tree is not safe for concurrent use, callers have to provide their own synchronization.
+type Tree[V any] struct {
+	v4   *node[V]
+	v6   *node[V]
+	size int
+}
+
+type node[V any] struct {
+	children [2]*node[V]
+	prefix   netip.Prefix
+	value    V
+	set      bool
+}
+
+// New creates an empty Tree.
+func New[V any]() *Tree[V] {
+	return &Tree[V]{
+		v4: new(node[V]),
+		v6: new(node[V]),
+	}
+}
+
+// Len returns the number of prefixes stored in the tree.
+func (t *Tree[V]) Len() int {
+	return t.size
+}
+
+// Insert stores the value for the given prefix, replacing the previous one if it exists.
+// The prefix is masked before being inserted, so 10.1.2.3/8 and 10.0.0.0/8 are the same key.
+func (t *Tree[V]) Insert(prefix netip.Prefix, value V) {
+	prefix = normalize(prefix)
+	if !prefix.IsValid() {
+		return
+	}
+
+	n := t.root(prefix.Addr())
+	addr := prefix.Addr().AsSlice()
+	for i := range prefix.Bits() {
+		b := bit(addr, i)
+		if n.children[b] == nil {
+			n.children[b] = new(node[V])
+		}
+		n = n.children[b]
+	}
+
+	if !n.set {
+		t.size++
+	}
+	n.prefix = prefix
+	n.value = value
+	n.set = true
+}
+
+// Delete removes the value stored for exactly the given prefix.
+// It reports whether the prefix was present in the tree.
+func (t *Tree[V]) Delete(prefix netip.Prefix) bool {
+	prefix = normalize(prefix)
+	if !prefix.IsValid() {
+		return false
+	}
+
+	n := t.root(prefix.Addr())
+	addr := prefix.Addr().AsSlice()
+	path := make([]*node[V], 0, prefix.Bits()+1)
+	path = append(path, n)
+	for i := range prefix.Bits() {
+		n = n.children[bit(addr, i)]
+		if n == nil {
+			return false
+		}
+		path = append(path, n)
+	}
+
+	if !n.set {
+		return false
+	}
+
+	var zero V
+	n.value = zero
+	n.set = false
+	t.size--
+
+	// Prune the branches that do not lead to any value anymore.
+	for i := len(path) - 1; i > 0; i-- {
+		cur := path[i]
+		if cur.set || cur.children[0] != nil || cur.children[1] != nil {
+			break
+		}
+		path[i-1].children[bit(addr, i-1)] = nil
+	}
+
+	return true
+}
+
+// Get returns the value stored for exactly the given prefix.
+func (t *Tree[V]) Get(prefix netip.Prefix) (V, bool) {
+	prefix = normalize(prefix)
+
+	var zero V
+	if !prefix.IsValid() {
+		return zero, false
+	}
+
+	n := t.root(prefix.Addr())
+	addr := prefix.Addr().AsSlice()
+	for i := range prefix.Bits() {
+		n = n.children[bit(addr, i)]
+		if n == nil {
+			return zero, false
+		}
+	}
+
+	if !n.set {
+		return zero, false
+	}
+	return n.value, true
+}
+
+// Lookup returns the value of the longest prefix that contains the given address.
+func (t *Tree[V]) Lookup(addr netip.Addr) (V, netip.Prefix, bool) {
+	addr = addr.Unmap()
+	return t.LookupPrefix(netip.PrefixFrom(addr, addr.BitLen()))
+}
+
+// LookupPrefix returns the value of the longest stored prefix that fully contains the given prefix,
+// i.e. a stored prefix that is not longer than the given one and covers its address.
+func (t *Tree[V]) LookupPrefix(prefix netip.Prefix) (V, netip.Prefix, bool) {
+	prefix = normalize(prefix)
+
+	var (
+		zero  V
+		found *node[V]
+	)
+	if !prefix.IsValid() {
+		return zero, netip.Prefix{}, false
+	}
+
+	n := t.root(prefix.Addr())
+	addr := prefix.Addr().AsSlice()
+	for i := 0; n != nil; i++ {
+		if n.set {
+			found = n
+		}
+		if i == prefix.Bits() {
+			break
+		}
+		n = n.children[bit(addr, i)]
+	}
+
+	if found == nil {
+		return zero, netip.Prefix{}, false
+	}
+	return found.value, found.prefix, true
+}
+
+// Walk calls fn for every prefix stored in the tree, IPv4 prefixes first.
+// If fn returns false, the walk stops.
+func (t *Tree[V]) Walk(fn func(prefix netip.Prefix, value V) bool) {
+	if walk(t.v4, fn) {
+		walk(t.v6, fn)
+	}
+}
+
+func walk[V any](n *node[V], fn func(prefix netip.Prefix, value V) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.set && !fn(n.prefix, n.value) {
+		return false
+	}
+	return walk(n.children[0], fn) && walk(n.children[1], fn)
+}
+
+func (t *Tree[V]) root(addr netip.Addr) *node[V] {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+func normalize(prefix netip.Prefix) netip.Prefix {
+	if !prefix.IsValid() {
+		return netip.Prefix{}
+	}
+
+	addr := prefix.Addr()
+	bits := prefix.Bits()
+	if addr.Is4In6() {
+		addr = addr.Unmap()
+		bits = max(bits-96, 0)
+	}
+
+	return netip.PrefixFrom(addr, bits).Masked()
+}
+
+// bit returns the i-th most significant bit of the address.
+func bit(addr []byte, i int) int {
+	return int(addr[i/8]>>(7-i%8)) & 1
+}
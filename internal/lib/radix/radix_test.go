@@ -0,0 +1,127 @@
+package radix
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTree_Lookup(t *testing.T) {
+	tree := New[string]()
+	tree.Insert(netip.MustParsePrefix("10.0.0.0/8"), "ten")
+	tree.Insert(netip.MustParsePrefix("10.1.0.0/16"), "ten-one")
+	tree.Insert(netip.MustParsePrefix("10.1.2.3/32"), "host")
+	tree.Insert(netip.MustParsePrefix("2001:db8::/32"), "doc")
+	tree.Insert(netip.MustParsePrefix("2001:db8:1::/48"), "doc-one")
+
+	tests := []struct {
+		name       string
+		addr       string
+		wantValue  string
+		wantPrefix string
+		wantFound  bool
+	}{
+		{
+			name:       "Shortest IPv4 prefix",
+			addr:       "10.200.0.1",
+			wantValue:  "ten",
+			wantPrefix: "10.0.0.0/8",
+			wantFound:  true,
+		},
+		{
+			name:       "Longer IPv4 prefix wins",
+			addr:       "10.1.200.1",
+			wantValue:  "ten-one",
+			wantPrefix: "10.1.0.0/16",
+			wantFound:  true,
+		},
+		{
+			name:       "Exact host",
+			addr:       "10.1.2.3",
+			wantValue:  "host",
+			wantPrefix: "10.1.2.3/32",
+			wantFound:  true,
+		},
+		{
+			name:       "IPv4-mapped IPv6 address",
+			addr:       "::ffff:10.1.2.4",
+			wantValue:  "ten-one",
+			wantPrefix: "10.1.0.0/16",
+			wantFound:  true,
+		},
+		{
+			name:       "IPv6 prefix",
+			addr:       "2001:db8:1::42",
+			wantValue:  "doc-one",
+			wantPrefix: "2001:db8:1::/48",
+			wantFound:  true,
+		},
+		{
+			name:      "No matching prefix",
+			addr:      "192.168.1.1",
+			wantFound: false,
+		},
+		{
+			name:      "IPv4 rule does not match IPv6",
+			addr:      "a00::1",
+			wantFound: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, prefix, found := tree.Lookup(netip.MustParseAddr(tt.addr))
+
+			assert.Equal(t, tt.wantFound, found)
+			assert.Equal(t, tt.wantValue, value)
+			if tt.wantFound {
+				assert.Equal(t, tt.wantPrefix, prefix.String())
+			}
+		})
+	}
+}
+
+func TestTree_LookupPrefix(t *testing.T) {
+	tree := New[string]()
+	tree.Insert(netip.MustParsePrefix("2001:db8::/32"), "doc")
+	tree.Insert(netip.MustParsePrefix("2001:db8::/96"), "narrow")
+
+	// A /96 rule must not match a whole /64 network.
+	value, prefix, found := tree.LookupPrefix(netip.MustParsePrefix("2001:db8::/64"))
+	assert.True(t, found)
+	assert.Equal(t, "doc", value)
+	assert.Equal(t, "2001:db8::/32", prefix.String())
+}
+
+func TestTree_InsertAndDelete(t *testing.T) {
+	tree := New[int]()
+
+	tree.Insert(netip.MustParsePrefix("10.1.2.3/8"), 1)
+	tree.Insert(netip.MustParsePrefix("10.1.0.0/16"), 2)
+	assert.Equal(t, 2, tree.Len())
+
+	// The prefix is masked, so this replaces the first value.
+	tree.Insert(netip.MustParsePrefix("10.0.0.0/8"), 3)
+	assert.Equal(t, 2, tree.Len())
+
+	value, ok := tree.Get(netip.MustParsePrefix("10.0.0.0/8"))
+	assert.True(t, ok)
+	assert.Equal(t, 3, value)
+
+	assert.True(t, tree.Delete(netip.MustParsePrefix("10.1.0.0/16")))
+	assert.False(t, tree.Delete(netip.MustParsePrefix("10.1.0.0/16")))
+	assert.Equal(t, 1, tree.Len())
+
+	value, prefix, found := tree.Lookup(netip.MustParseAddr("10.1.2.3"))
+	assert.True(t, found)
+	assert.Equal(t, 3, value)
+	assert.Equal(t, "10.0.0.0/8", prefix.String())
+
+	var walked []string
+	tree.Walk(func(prefix netip.Prefix, _ int) bool {
+		walked = append(walked, prefix.String())
+		return true
+	})
+	assert.Equal(t, []string{"10.0.0.0/8"}, walked)
+}
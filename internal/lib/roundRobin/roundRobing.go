@@ -30,7 +30,7 @@ func (r *RobinRound) Next() (int32, bool) {
 		current := r.current.Add(1)
 		idx := (current - 1) % r.n
 
-		if r.backends[idx].IsAvailable() {
+		if r.backends[idx].IsServing() {
 			return idx, true
 		}
 	}
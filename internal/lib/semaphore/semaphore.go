@@ -0,0 +1,152 @@
+// Package semaphore provides a counting semaphore with a bounded FIFO queue of waiters.
+package semaphore
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrLimitExceeded is returned when there are no free slots and the queue is full.
+	ErrLimitExceeded = errors.New("concurrency limit exceeded")
+	// ErrTimeout is returned when the context is done before a slot was acquired.
+	ErrTimeout = errors.New("timed out waiting for a free slot")
+)
+
+// Semaphore limits the number of concurrently held slots.
+//
+// If there are no free slots, up to queueSize callers wait for a slot in FIFO order.
+// A zero or negative limit means that the number of slots is unlimited.
+type Semaphore struct {
+	mu        sync.Mutex
+	limit     int
+	queueSize int
+	inFlight  int
+	waiters   *list.List // *waiter
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func New(limit, queueSize int) *Semaphore {
+	return &Semaphore{
+		limit:     limit,
+		queueSize: max(queueSize, 0),
+		waiters:   list.New(),
+	}
+}
+
+// TryAcquire acquires a slot if there is a free one, without waiting.
+func (s *Semaphore) TryAcquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.limit > 0 && s.inFlight >= s.limit {
+		return false
+	}
+	s.inFlight++
+	return true
+}
+
+// Acquire acquires a slot, waiting in the queue until the context is done if there are no free slots.
+//
+// Every successful call must be followed by a call to Release.
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	s.mu.Lock()
+	if s.limit <= 0 || s.inFlight < s.limit {
+		s.inFlight++
+		s.mu.Unlock()
+		return nil
+	}
+
+	if s.waiters.Len() >= s.queueSize {
+		s.mu.Unlock()
+		return ErrLimitExceeded
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	el := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if w.granted {
+			// The slot was handed over right before the context was done, give it back.
+			s.releaseLocked()
+		} else {
+			s.waiters.Remove(el)
+		}
+		return ErrTimeout
+	}
+}
+
+// Release releases a slot, handing it over to the first waiter if there is one.
+func (s *Semaphore) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.releaseLocked()
+}
+
+func (s *Semaphore) releaseLocked() {
+	// After the limit is lowered, slots are not handed over until the held ones fit into it.
+	if s.waiters.Len() > 0 && (s.limit <= 0 || s.inFlight <= s.limit) {
+		s.grantLocked()
+		return
+	}
+
+	s.inFlight--
+}
+
+// grantLocked hands a slot over to the first waiter, the slot is counted as held by the caller.
+func (s *Semaphore) grantLocked() {
+	w := s.waiters.Remove(s.waiters.Front()).(*waiter)
+	w.granted = true
+	close(w.ready)
+}
+
+// SetLimit changes the number of slots. Held slots are kept: if the limit is lowered below their number,
+// new slots are granted only after enough of them are released. Added slots are granted to the waiters at once.
+func (s *Semaphore) SetLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limit = limit
+	for s.waiters.Len() > 0 && (s.limit <= 0 || s.inFlight < s.limit) {
+		s.inFlight++
+		s.grantLocked()
+	}
+}
+
+// Limit returns the number of slots.
+func (s *Semaphore) Limit() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.limit
+}
+
+// InFlight returns the number of held slots.
+func (s *Semaphore) InFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.inFlight
+}
+
+// Queued returns the number of callers waiting for a slot.
+func (s *Semaphore) Queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.waiters.Len()
+}
@@ -0,0 +1,89 @@
+package semaphore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemaphore_Acquire(t *testing.T) {
+	t.Run("Reject without queue", func(t *testing.T) {
+		s := New(1, 0)
+
+		assert.NoError(t, s.Acquire(context.Background()))
+		assert.ErrorIs(t, s.Acquire(context.Background()), ErrLimitExceeded)
+
+		s.Release()
+		assert.NoError(t, s.Acquire(context.Background()))
+		assert.Equal(t, 1, s.InFlight())
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		s := New(0, 0)
+
+		for range 100 {
+			assert.True(t, s.TryAcquire())
+		}
+		assert.Equal(t, 100, s.InFlight())
+	})
+
+	t.Run("Waiter gets the released slot", func(t *testing.T) {
+		s := New(1, 1)
+		assert.NoError(t, s.Acquire(context.Background()))
+
+		acquired := make(chan error)
+		go func() {
+			acquired <- s.Acquire(context.Background())
+		}()
+
+		assert.Eventually(t, func() bool { return s.Queued() == 1 }, time.Second, time.Millisecond)
+		assert.ErrorIs(t, s.Acquire(context.Background()), ErrLimitExceeded)
+
+		s.Release()
+		assert.NoError(t, <-acquired)
+		assert.Equal(t, 1, s.InFlight())
+		assert.Equal(t, 0, s.Queued())
+	})
+
+	t.Run("Waiter times out", func(t *testing.T) {
+		s := New(1, 1)
+		assert.NoError(t, s.Acquire(context.Background()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, s.Acquire(ctx), ErrTimeout)
+		assert.Equal(t, 0, s.Queued())
+
+		s.Release()
+		assert.Equal(t, 0, s.InFlight())
+	})
+
+	t.Run("Limit is changed", func(t *testing.T) {
+		s := New(2, 1)
+		assert.NoError(t, s.Acquire(context.Background()))
+		assert.NoError(t, s.Acquire(context.Background()))
+
+		// Held slots are kept, the next ones wait until they fit into the lowered limit.
+		s.SetLimit(1)
+		assert.Equal(t, 2, s.InFlight())
+		assert.False(t, s.TryAcquire())
+
+		acquired := make(chan error)
+		go func() {
+			acquired <- s.Acquire(context.Background())
+		}()
+		assert.Eventually(t, func() bool { return s.Queued() == 1 }, time.Second, time.Millisecond)
+
+		s.Release()
+		assert.Equal(t, 1, s.InFlight())
+		assert.Equal(t, 1, s.Queued(), "the released slot does not fit into the limit")
+
+		// The raised limit is granted to the waiter at once.
+		s.SetLimit(2)
+		assert.NoError(t, <-acquired)
+		assert.Equal(t, 2, s.InFlight())
+	})
+}
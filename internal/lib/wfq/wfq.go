@@ -0,0 +1,181 @@
+// Package wfq provides a weighted fair queue that admits callers of several classes to a limited number of slots.
+package wfq
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrQueueFull is returned when there are no free slots and the queue of the class is full.
+	ErrQueueFull = errors.New("queue is full")
+	// ErrTimeout is returned when the context is done before a slot was acquired.
+	ErrTimeout = errors.New("timed out waiting in the queue")
+)
+
+// Class configures a class of callers.
+type Class struct {
+	// Weight is the relative share of the slots the class gets when all classes are waiting.
+	Weight float64
+	// QueueSize is the number of callers of the class that may wait for a slot, 0 rejects them immediately.
+	QueueSize int
+}
+
+// Queue limits the number of concurrently held slots and hands the released slots over
+// to the waiting callers in weighted fair order.
+//
+// Every waiter gets a virtual finish time: the later of the current virtual time and the finish
+// time of the previous waiter of its class, plus the inverse of the class weight. Released slots go
+// to the waiter with the smallest finish time, so under contention a class with weight 4 is admitted
+// four times as often as a class with weight 1, and no class starves.
+type Queue struct {
+	mu          sync.Mutex
+	limit       int
+	inFlight    int
+	virtualTime float64
+	classes     []*class
+}
+
+type class struct {
+	weight     float64
+	queueSize  int
+	lastFinish float64
+	waiters    *list.List // *waiter
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+	finish  float64
+}
+
+// New creates a queue with the given number of slots. Classes are identified by their index.
+// A queue without classes has a single class that does not queue callers.
+func New(limit int, classes []Class) *Queue {
+	if len(classes) == 0 {
+		classes = []Class{{Weight: 1}}
+	}
+
+	q := &Queue{
+		limit:   max(limit, 1),
+		classes: make([]*class, len(classes)),
+	}
+	for i, c := range classes {
+		weight := c.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		q.classes[i] = &class{
+			weight:    weight,
+			queueSize: max(c.QueueSize, 0),
+			waiters:   list.New(),
+		}
+	}
+	return q
+}
+
+// Acquire acquires a slot for a caller of the class, waiting in the queue of the class
+// until the context is done if there are no free slots. Unknown classes are treated as the last one.
+//
+// Every successful call must be followed by a call to Release.
+func (q *Queue) Acquire(ctx context.Context, classIndex int) error {
+	q.mu.Lock()
+	if q.inFlight < q.limit && q.queuedLocked() == 0 {
+		q.inFlight++
+		q.mu.Unlock()
+		return nil
+	}
+
+	c := q.class(classIndex)
+	if c.waiters.Len() >= c.queueSize {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+
+	finish := max(q.virtualTime, c.lastFinish) + 1/c.weight
+	c.lastFinish = finish
+
+	w := &waiter{ready: make(chan struct{}), finish: finish}
+	el := c.waiters.PushBack(w)
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if w.granted {
+			// The slot was handed over right before the context was done, give it back.
+			q.releaseLocked()
+		} else {
+			c.waiters.Remove(el)
+		}
+		return ErrTimeout
+	}
+}
+
+// Release releases a slot, handing it over to the waiter with the smallest virtual finish time.
+func (q *Queue) Release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.releaseLocked()
+}
+
+func (q *Queue) releaseLocked() {
+	var next *class
+	for _, c := range q.classes {
+		front := c.waiters.Front()
+		if front == nil {
+			continue
+		}
+		if next == nil || front.Value.(*waiter).finish < next.waiters.Front().Value.(*waiter).finish {
+			next = c
+		}
+	}
+
+	if next == nil {
+		q.inFlight--
+		return
+	}
+
+	w := next.waiters.Remove(next.waiters.Front()).(*waiter)
+	q.virtualTime = w.finish
+	w.granted = true
+	close(w.ready)
+}
+
+func (q *Queue) class(index int) *class {
+	if index < 0 || index >= len(q.classes) {
+		return q.classes[len(q.classes)-1]
+	}
+	return q.classes[index]
+}
+
+func (q *Queue) queuedLocked() int {
+	n := 0
+	for _, c := range q.classes {
+		n += c.waiters.Len()
+	}
+	return n
+}
+
+// InFlight returns the number of held slots.
+func (q *Queue) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.inFlight
+}
+
+// Queued returns the number of callers of the class waiting for a slot.
+func (q *Queue) Queued(classIndex int) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.class(classIndex).waiters.Len()
+}
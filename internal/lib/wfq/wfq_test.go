@@ -0,0 +1,62 @@
+package wfq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue_Acquire(t *testing.T) {
+	t.Run("Reject when the class queue is full", func(t *testing.T) {
+		q := New(1, []Class{{Weight: 1, QueueSize: 0}})
+
+		require.NoError(t, q.Acquire(context.Background(), 0))
+		assert.ErrorIs(t, q.Acquire(context.Background(), 0), ErrQueueFull)
+
+		q.Release()
+		assert.NoError(t, q.Acquire(context.Background(), 0))
+		assert.Equal(t, 1, q.InFlight())
+	})
+
+	t.Run("Waiter times out", func(t *testing.T) {
+		q := New(1, []Class{{Weight: 1, QueueSize: 1}})
+		require.NoError(t, q.Acquire(context.Background(), 0))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, q.Acquire(ctx, 0), ErrTimeout)
+		assert.Equal(t, 0, q.Queued(0))
+
+		q.Release()
+		assert.Equal(t, 0, q.InFlight())
+	})
+
+	t.Run("Slots are shared by weight", func(t *testing.T) {
+		q := New(1, []Class{{Weight: 3, QueueSize: 10}, {Weight: 1, QueueSize: 10}})
+		require.NoError(t, q.Acquire(context.Background(), 0))
+
+		admitted := make(chan int)
+		for i := range 8 {
+			classIndex := i % 2
+			go func() {
+				if err := q.Acquire(context.Background(), classIndex); err == nil {
+					admitted <- classIndex
+				}
+			}()
+			// Waiters are enqueued one by one, so their order is known.
+			require.Eventually(t, func() bool { return q.Queued(0)+q.Queued(1) == i+1 }, time.Second, time.Millisecond)
+		}
+
+		order := make([]int, 0, 8)
+		for range 8 {
+			q.Release()
+			order = append(order, <-admitted)
+		}
+
+		assert.Equal(t, []int{0, 0, 0, 1, 0, 1, 1, 1}, order)
+	})
+}
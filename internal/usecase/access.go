@@ -0,0 +1,156 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/iputil"
+	"github.com/kurochkinivan/load_balancer/internal/lib/radix"
+	"github.com/kurochkinivan/load_balancer/internal/lib/sl"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
+)
+
+type AccessRuleStorage interface {
+	AccessRules(ctx context.Context) ([]*entity.AccessRule, error)
+	CreateAccessRule(ctx context.Context, rule *entity.AccessRule) error
+	DeleteAccessRule(ctx context.Context, network string) error
+}
+
+// AccessUseCase manages the allow and deny lists and checks addresses against them.
+// The rules are kept in memory, so the check does not go to the storage.
+type AccessUseCase struct {
+	log     *slog.Logger
+	storage AccessRuleStorage
+
+	mu    sync.RWMutex
+	rules *radix.Tree[*entity.AccessRule]
+}
+
+func NewAccess(log *slog.Logger, storage AccessRuleStorage) *AccessUseCase {
+	return &AccessUseCase{
+		log:     log,
+		storage: storage,
+		rules:   radix.New[*entity.AccessRule](),
+	}
+}
+
+// Check returns the action of the most specific rule that contains the address.
+// It returns false if there is no such rule.
+//
+// This method is concurrently safe.
+func (a *AccessUseCase) Check(addr netip.Addr) (entity.AccessAction, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	rule, _, ok := a.rules.Lookup(addr)
+	if !ok {
+		return "", false
+	}
+	return rule.Action, true
+}
+
+// LoadRules loads all access rules from the storage.
+func (a *AccessUseCase) LoadRules(ctx context.Context) error {
+	const op = "AccessUseCase.LoadRules"
+
+	rules, err := a.storage.AccessRules(ctx)
+	if err != nil {
+		a.log.Error("failed to get access rules", sl.Error(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tree := radix.New[*entity.AccessRule]()
+	for _, rule := range rules {
+		prefix, err := iputil.Prefix(rule.Network)
+		if err != nil {
+			a.log.Warn("skipping access rule with invalid network", slog.String("network", rule.Network))
+			continue
+		}
+		tree.Insert(prefix, rule)
+	}
+
+	a.mu.Lock()
+	a.rules = tree
+	a.mu.Unlock()
+
+	a.log.Info("access rules are loaded", slog.Int("count", tree.Len()))
+
+	return nil
+}
+
+func (a *AccessUseCase) Rules(ctx context.Context) ([]*entity.AccessRule, error) {
+	const op = "AccessUseCase.Rules"
+
+	rules, err := a.storage.AccessRules(ctx)
+	if err != nil {
+		a.log.Error("failed to get access rules", sl.Error(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return rules, nil
+}
+
+func (a *AccessUseCase) CreateRule(ctx context.Context, rule *entity.AccessRule) error {
+	const op = "AccessUseCase.CreateRule"
+
+	network, err := iputil.Canonical(rule.Network)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", op, ErrInvalidIPAddress, err)
+	}
+	rule.Network = network
+
+	prefix, err := iputil.Prefix(network)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", op, ErrInvalidIPAddress, err)
+	}
+
+	err = a.storage.CreateAccessRule(ctx, rule)
+	if err != nil {
+		if errors.Is(err, storage.ErrRuleExists) {
+			a.log.Error("access rule already exists", slog.String("network", network))
+			return fmt.Errorf("%s: %w", op, ErrRuleExists)
+		}
+
+		a.log.Error("failed to create access rule", sl.Error(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.mu.Lock()
+	a.rules.Insert(prefix, rule)
+	a.mu.Unlock()
+
+	return nil
+}
+
+func (a *AccessUseCase) DeleteRule(ctx context.Context, network string) error {
+	const op = "AccessUseCase.DeleteRule"
+
+	network, err := iputil.Canonical(network)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", op, ErrInvalidIPAddress, err)
+	}
+
+	err = a.storage.DeleteAccessRule(ctx, network)
+	if err != nil {
+		if errors.Is(err, storage.ErrRuleNotFound) {
+			a.log.Warn("access rule was not found", slog.String("network", network))
+			return fmt.Errorf("%s: %w", op, ErrRuleNotFound)
+		}
+
+		a.log.Error("failed to delete access rule", sl.Error(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if prefix, err := iputil.Prefix(network); err == nil {
+		a.mu.Lock()
+		a.rules.Delete(prefix)
+		a.mu.Unlock()
+	}
+
+	return nil
+}
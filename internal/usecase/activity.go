@@ -0,0 +1,126 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+// ActivityOptions configures the tracking of the activity of clients.
+type ActivityOptions struct {
+	// Window is the duration rejections are counted within.
+	Window time.Duration
+	// Retention is how long a client that sends no requests is remembered.
+	Retention time.Duration
+	// MaxClients caps the number of tracked clients, new clients are not tracked above it.
+	MaxClients int
+}
+
+// ActivityUseCase tracks when clients were last seen and how often their requests were rejected with 429.
+// The activity is kept in memory of the instance.
+type ActivityUseCase struct {
+	log  *slog.Logger
+	opts ActivityOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	activity map[string]*clientActivity
+}
+
+type clientActivity struct {
+	lastSeen time.Time
+	window   rejectionWindow
+}
+
+func NewActivity(log *slog.Logger, opts ActivityOptions) *ActivityUseCase {
+	return &ActivityUseCase{
+		log:      log,
+		opts:     opts,
+		now:      time.Now,
+		activity: make(map[string]*clientActivity),
+	}
+}
+
+// RecordRequest records a request of the client, rejected tells whether it was rejected with 429.
+//
+// This method is concurrently safe.
+func (a *ActivityUseCase) RecordRequest(key string, rejected bool) {
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	activity, ok := a.activity[key]
+	if !ok {
+		if len(a.activity) >= a.opts.MaxClients {
+			return
+		}
+		activity = new(clientActivity)
+		a.activity[key] = activity
+	}
+
+	activity.lastSeen = now
+	if rejected {
+		if now.Sub(activity.window.start) > a.opts.Window {
+			activity.window = rejectionWindow{start: now}
+		}
+		activity.window.count++
+	}
+}
+
+// Activity returns the activity of the client, false if the client has not been seen within the retention.
+//
+// This method is concurrently safe.
+func (a *ActivityUseCase) Activity(key string) (entity.ClientActivity, bool) {
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	activity, ok := a.activity[key]
+	if !ok {
+		return entity.ClientActivity{}, false
+	}
+
+	var rejections int
+	if now.Sub(activity.window.start) <= a.opts.Window {
+		rejections = activity.window.count
+	}
+
+	return entity.ClientActivity{
+		LastSeen:   activity.lastSeen,
+		Rejections: rejections,
+	}, true
+}
+
+// StartJanitor periodically forgets the clients that have not been seen within the retention.
+func (a *ActivityUseCase) StartJanitor(ctx context.Context) {
+	ticker := time.NewTicker(max(a.opts.Retention/10, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.cleanup()
+		case <-ctx.Done():
+			a.log.Info("activity janitor is terminated due to context cancellation")
+			return
+		}
+	}
+}
+
+func (a *ActivityUseCase) cleanup() {
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, activity := range a.activity {
+		if now.Sub(activity.lastSeen) > a.opts.Retention {
+			delete(a.activity, key)
+		}
+	}
+}
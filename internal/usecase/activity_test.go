@@ -0,0 +1,52 @@
+package usecase
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivityUseCase_RecordRequest(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	activity := NewActivity(slog.New(slog.NewTextHandler(io.Discard, nil)), ActivityOptions{
+		Window:     time.Minute,
+		Retention:  time.Hour,
+		MaxClients: 2,
+	})
+	activity.now = func() time.Time { return now }
+
+	_, ok := activity.Activity("10.0.0.1")
+	assert.False(t, ok)
+
+	activity.RecordRequest("10.0.0.1", false)
+	activity.RecordRequest("10.0.0.1", true)
+	activity.RecordRequest("10.0.0.1", true)
+
+	got, ok := activity.Activity("10.0.0.1")
+	require.True(t, ok)
+	assert.Equal(t, now, got.LastSeen)
+	assert.Equal(t, 2, got.Rejections)
+
+	now = now.Add(2 * time.Minute)
+	got, _ = activity.Activity("10.0.0.1")
+	assert.Zero(t, got.Rejections, "rejections of a past window are not recent")
+
+	activity.RecordRequest("10.0.0.1", true)
+	got, _ = activity.Activity("10.0.0.1")
+	assert.Equal(t, 1, got.Rejections)
+	assert.Equal(t, now, got.LastSeen)
+
+	activity.RecordRequest("10.0.0.2", false)
+	activity.RecordRequest("10.0.0.3", false)
+	_, ok = activity.Activity("10.0.0.3")
+	assert.False(t, ok, "clients above the limit are not tracked")
+
+	now = now.Add(2 * time.Hour)
+	activity.cleanup()
+	_, ok = activity.Activity("10.0.0.1")
+	assert.False(t, ok, "clients not seen within the retention are forgotten")
+}
@@ -0,0 +1,171 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+// AuthOptions configures the authentication of the management API.
+type AuthOptions struct {
+	Tokens []*entity.APIToken
+	// Certificates maps the common names of the client certificates to their roles.
+	// The certificates are verified by the TLS server, only their common names are checked here.
+	Certificates map[string]entity.Role
+	// MaxFailures is the number of failed attempts within FailureWindow after which the attempts of a client
+	// are rejected until the window is over, 0 disables the limit.
+	MaxFailures   int
+	FailureWindow time.Duration
+}
+
+// AuthUseCase authenticates the callers of the management API by static tokens or client certificates.
+//
+// Failed attempts are counted per client separately from the rate limits of the proxied requests,
+// a client that fails too often is locked out until its window is over. Failures are kept in memory of the instance.
+type AuthUseCase struct {
+	log  *slog.Logger
+	opts AuthOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]*rejectionWindow
+}
+
+func NewAuth(log *slog.Logger, opts AuthOptions) *AuthUseCase {
+	return &AuthUseCase{
+		log:      log,
+		opts:     opts,
+		now:      time.Now,
+		failures: make(map[string]*rejectionWindow),
+	}
+}
+
+// AuthenticateToken returns the identity of the token. Tokens are compared by their hashes in constant time.
+// It returns ErrUnauthenticated if the token is unknown and ErrTooManyAuthFailures if the client is locked out.
+//
+// This method is concurrently safe.
+func (a *AuthUseCase) AuthenticateToken(key, token string) (*entity.Identity, error) {
+	const op = "AuthUseCase.AuthenticateToken"
+
+	if a.lockedOut(key) {
+		return nil, fmt.Errorf("%s: %w", op, ErrTooManyAuthFailures)
+	}
+
+	hash := sha256.Sum256([]byte(token))
+
+	var found *entity.APIToken
+	for _, apiToken := range a.opts.Tokens {
+		// Every token is compared, so the time does not tell which one matched.
+		if subtle.ConstantTimeCompare(hash[:], apiToken.Hash) == 1 {
+			found = apiToken
+		}
+	}
+	if found == nil {
+		a.recordFailure(key, "unknown token")
+		return nil, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	return &entity.Identity{Name: found.Name, Role: found.Role}, nil
+}
+
+// AuthenticateCertificate returns the identity of the verified client certificate by its common name.
+// It returns ErrUnauthenticated if the common name is unknown and ErrTooManyAuthFailures if the client is locked out.
+//
+// This method is concurrently safe.
+func (a *AuthUseCase) AuthenticateCertificate(key string, cert *x509.Certificate) (*entity.Identity, error) {
+	const op = "AuthUseCase.AuthenticateCertificate"
+
+	if a.lockedOut(key) {
+		return nil, fmt.Errorf("%s: %w", op, ErrTooManyAuthFailures)
+	}
+
+	role, ok := a.opts.Certificates[cert.Subject.CommonName]
+	if !ok {
+		a.recordFailure(key, fmt.Sprintf("unknown certificate %q", cert.Subject.CommonName))
+		return nil, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	return &entity.Identity{Name: cert.Subject.CommonName, Role: role}, nil
+}
+
+// lockedOut reports whether the client has failed too often within its window.
+func (a *AuthUseCase) lockedOut(key string) bool {
+	if a.opts.MaxFailures <= 0 {
+		return false
+	}
+
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	window, ok := a.failures[key]
+	return ok && now.Sub(window.start) <= a.opts.FailureWindow && window.count >= a.opts.MaxFailures
+}
+
+func (a *AuthUseCase) recordFailure(key, reason string) {
+	a.log.Warn("authentication failed", slog.String("ip_address", key), slog.String("reason", reason))
+
+	if a.opts.MaxFailures <= 0 {
+		return
+	}
+
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	window, ok := a.failures[key]
+	if !ok || now.Sub(window.start) > a.opts.FailureWindow {
+		window = &rejectionWindow{start: now}
+		a.failures[key] = window
+	}
+	window.count++
+
+	if window.count == a.opts.MaxFailures {
+		a.log.Warn("client is locked out after too many authentication failures",
+			slog.String("ip_address", key),
+			slog.Time("until", window.start.Add(a.opts.FailureWindow)),
+		)
+	}
+}
+
+// StartJanitor removes outdated failure windows every window until the context is cancelled.
+func (a *AuthUseCase) StartJanitor(ctx context.Context) {
+	if a.opts.MaxFailures <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(max(a.opts.FailureWindow, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.cleanup()
+		case <-ctx.Done():
+			a.log.Info("auth janitor is terminated due to context cancellation")
+			return
+		}
+	}
+}
+
+func (a *AuthUseCase) cleanup() {
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, window := range a.failures {
+		if now.Sub(window.start) > a.opts.FailureWindow {
+			delete(a.failures, key)
+		}
+	}
+}
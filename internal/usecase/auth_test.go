@@ -0,0 +1,75 @@
+package usecase
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAuth(now *time.Time) *AuthUseCase {
+	hash := sha256.Sum256([]byte("secret"))
+	auth := NewAuth(slog.New(slog.NewTextHandler(io.Discard, nil)), AuthOptions{
+		Tokens:        []*entity.APIToken{{Name: "ci", Hash: hash[:], Role: entity.RoleOperator}},
+		Certificates:  map[string]entity.Role{"admin": entity.RoleAdmin},
+		MaxFailures:   3,
+		FailureWindow: time.Minute,
+	})
+	auth.now = func() time.Time { return *now }
+	return auth
+}
+
+func TestAuthUseCase_AuthenticateToken(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	auth := newTestAuth(&now)
+
+	identity, err := auth.AuthenticateToken("10.0.0.1", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, &entity.Identity{Name: "ci", Role: entity.RoleOperator}, identity)
+
+	for range 3 {
+		_, err := auth.AuthenticateToken("10.0.0.1", "guess")
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	}
+
+	// The client is locked out even with a valid token, the others are not.
+	_, err = auth.AuthenticateToken("10.0.0.1", "secret")
+	assert.ErrorIs(t, err, ErrTooManyAuthFailures)
+	_, err = auth.AuthenticateToken("10.0.0.2", "secret")
+	assert.NoError(t, err)
+
+	now = now.Add(2 * time.Minute)
+	_, err = auth.AuthenticateToken("10.0.0.1", "secret")
+	assert.NoError(t, err)
+}
+
+func TestAuthUseCase_AuthenticateCertificate(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	auth := newTestAuth(&now)
+
+	identity, err := auth.AuthenticateCertificate("10.0.0.1", &x509.Certificate{Subject: pkix.Name{CommonName: "admin"}})
+	require.NoError(t, err)
+	assert.Equal(t, entity.RoleAdmin, identity.Role)
+
+	_, err = auth.AuthenticateCertificate("10.0.0.1", &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}})
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
+
+func TestRole_Allows(t *testing.T) {
+	assert.True(t, entity.RoleAdmin.Allows(entity.RoleOperator))
+	assert.True(t, entity.RoleOperator.Allows(entity.RoleOperator))
+	assert.False(t, entity.RoleReadOnly.Allows(entity.RoleOperator))
+	assert.False(t, entity.Role("root").Allows(entity.RoleReadOnly))
+
+	assert.True(t, entity.RolePeer.Allows(entity.RolePeer))
+	assert.True(t, entity.RoleAdmin.Allows(entity.RolePeer))
+	assert.False(t, entity.RoleOperator.Allows(entity.RolePeer))
+	assert.False(t, entity.RolePeer.Allows(entity.RoleReadOnly), "peers may not read the management API")
+}
@@ -0,0 +1,47 @@
+package usecase
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+// BackendsUseCase manages the backends the requests are proxied to. The backends are set by the config,
+// they can only be taken out of rotation and put back, which is kept in memory of the instance.
+type BackendsUseCase struct {
+	log      *slog.Logger
+	backends []*entity.Backend
+}
+
+func NewBackends(log *slog.Logger, backends []*entity.Backend) *BackendsUseCase {
+	return &BackendsUseCase{
+		log:      log,
+		backends: backends,
+	}
+}
+
+// Backends returns the backends in the order of the config.
+func (b *BackendsUseCase) Backends() []*entity.Backend {
+	return b.backends
+}
+
+// SetEnabled puts the backend with the given host (host:port of its URL) back into rotation or takes it out.
+//
+// This method is concurrently safe.
+func (b *BackendsUseCase) SetEnabled(host string, enabled bool) (*entity.Backend, error) {
+	const op = "BackendsUseCase.SetEnabled"
+
+	for _, backend := range b.backends {
+		if backend.URL.Host != host {
+			continue
+		}
+
+		backend.SetEnabled(enabled)
+		b.log.Info("backend rotation is changed", slog.String("backend", host), slog.Bool("enabled", enabled))
+
+		return backend, nil
+	}
+
+	return nil, fmt.Errorf("%s: %w", op, ErrBackendNotFound)
+}
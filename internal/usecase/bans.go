@@ -0,0 +1,208 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+// BanOptions configures automatic bans.
+type BanOptions struct {
+	// Threshold is the number of rejections within Window after which a client is banned, 0 disables bans.
+	Threshold int
+	Window    time.Duration
+	// Duration is the duration of the first ban, every next one is twice as long up to MaxDuration.
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+// BansUseCase bans clients that are rejected by the rate limits too often, fail2ban-style.
+//
+// A client rejected more than Threshold times within Window is banned for Duration. Every repeated
+// offence doubles the duration of the ban up to MaxDuration. Offences are forgotten once a client
+// behaves for MaxDuration after its last ban. Bans are kept in memory of the instance.
+type BansUseCase struct {
+	log  *slog.Logger
+	opts BanOptions
+	now  func() time.Time
+
+	mu         sync.Mutex
+	rejections map[string]*rejectionWindow
+	bans       map[string]*entity.Ban // including expired ones, to remember offences
+}
+
+type rejectionWindow struct {
+	start time.Time
+	count int
+}
+
+func NewBans(log *slog.Logger, opts BanOptions) *BansUseCase {
+	return &BansUseCase{
+		log:        log,
+		opts:       opts,
+		now:        time.Now,
+		rejections: make(map[string]*rejectionWindow),
+		bans:       make(map[string]*entity.Ban),
+	}
+}
+
+// Banned returns the ban of the client if it is banned at the moment.
+//
+// This method is concurrently safe.
+func (b *BansUseCase) Banned(key string) (*entity.Ban, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ban, ok := b.bans[key]
+	if !ok || !ban.ExpiresAt.After(b.now()) {
+		return nil, false
+	}
+	return ban, true
+}
+
+// RecordRejection counts a rejection of the client and bans it if it exceeds the threshold.
+// It returns the ban if the client has been banned by this rejection.
+//
+// This method is concurrently safe.
+func (b *BansUseCase) RecordRejection(key string) (*entity.Ban, bool) {
+	if b.opts.Threshold <= 0 {
+		return nil, false
+	}
+
+	now := b.now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	window, ok := b.rejections[key]
+	if !ok || now.Sub(window.start) > b.opts.Window {
+		window = &rejectionWindow{start: now}
+		b.rejections[key] = window
+	}
+	window.count++
+
+	if window.count <= b.opts.Threshold {
+		return nil, false
+	}
+	delete(b.rejections, key)
+
+	offences := 1
+	if prev, ok := b.bans[key]; ok {
+		offences = prev.Offences + 1
+	}
+
+	ban := &entity.Ban{
+		IPAddress: key,
+		Offences:  offences,
+		BannedAt:  now,
+		ExpiresAt: now.Add(b.duration(offences)),
+	}
+	b.bans[key] = ban
+
+	return ban, true
+}
+
+// Bans returns the active bans, the ones expiring first go first.
+func (b *BansUseCase) Bans() []*entity.Ban {
+	now := b.now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bans := make([]*entity.Ban, 0)
+	for _, ban := range b.bans {
+		if ban.ExpiresAt.After(now) {
+			bans = append(bans, ban)
+		}
+	}
+
+	slices.SortFunc(bans, func(a, b *entity.Ban) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+
+	return bans
+}
+
+// Unban lifts the ban of the client and forgets its offences.
+func (b *BansUseCase) Unban(key string) error {
+	const op = "BansUseCase.Unban"
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ban, ok := b.bans[key]
+	if !ok || !ban.ExpiresAt.After(b.now()) {
+		return fmt.Errorf("%s: %w", op, ErrBanNotFound)
+	}
+
+	delete(b.bans, key)
+	delete(b.rejections, key)
+
+	b.log.Info("client is unbanned", slog.String("ip_address", key))
+
+	return nil
+}
+
+// Clear lifts all bans and forgets all offences.
+func (b *BansUseCase) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	clear(b.bans)
+	clear(b.rejections)
+
+	b.log.Info("all bans are cleared")
+}
+
+// StartJanitor removes outdated rejection windows and forgotten offences every window until the context is cancelled.
+func (b *BansUseCase) StartJanitor(ctx context.Context) {
+	if b.opts.Threshold <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(max(b.opts.Window, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.cleanup()
+		case <-ctx.Done():
+			b.log.Info("ban janitor is terminated due to context cancellation")
+			return
+		}
+	}
+}
+
+func (b *BansUseCase) cleanup() {
+	now := b.now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key, window := range b.rejections {
+		if now.Sub(window.start) > b.opts.Window {
+			delete(b.rejections, key)
+		}
+	}
+
+	for key, ban := range b.bans {
+		if now.Sub(ban.ExpiresAt) > b.opts.MaxDuration {
+			delete(b.bans, key)
+		}
+	}
+}
+
+// duration returns the duration of the ban for the given number of offences.
+func (b *BansUseCase) duration(offences int) time.Duration {
+	d := b.opts.Duration
+	for i := 1; i < offences && d < b.opts.MaxDuration; i++ {
+		d *= 2
+	}
+	return min(d, b.opts.MaxDuration)
+}
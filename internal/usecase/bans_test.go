@@ -0,0 +1,103 @@
+package usecase
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBans(now *time.Time) *BansUseCase {
+	bans := NewBans(slog.New(slog.NewTextHandler(io.Discard, nil)), BanOptions{
+		Threshold:   3,
+		Window:      time.Minute,
+		Duration:    time.Minute,
+		MaxDuration: 5 * time.Minute,
+	})
+	bans.now = func() time.Time { return *now }
+	return bans
+}
+
+func TestBansUseCase_RecordRejection(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	bans := newTestBans(&now)
+
+	for range 3 {
+		_, banned := bans.RecordRejection("10.0.0.1")
+		assert.False(t, banned)
+	}
+
+	// The window is over, so the rejections are counted from scratch.
+	now = now.Add(2 * time.Minute)
+	for range 3 {
+		_, banned := bans.RecordRejection("10.0.0.1")
+		assert.False(t, banned)
+	}
+
+	ban, banned := bans.RecordRejection("10.0.0.1")
+	require.True(t, banned)
+	assert.Equal(t, now.Add(time.Minute), ban.ExpiresAt)
+
+	_, ok := bans.Banned("10.0.0.1")
+	assert.True(t, ok)
+	_, ok = bans.Banned("10.0.0.2")
+	assert.False(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = bans.Banned("10.0.0.1")
+	assert.False(t, ok)
+}
+
+func TestBansUseCase_GrowingDuration(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	bans := newTestBans(&now)
+
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, duration := range expected {
+		var (
+			ban    *entity.Ban
+			banned bool
+		)
+		for range 4 {
+			ban, banned = bans.RecordRejection("10.0.0.1")
+		}
+		require.True(t, banned, "offence %d", i+1)
+		assert.Equal(t, i+1, ban.Offences)
+		assert.Equal(t, now.Add(duration), ban.ExpiresAt, "offence %d", i+1)
+
+		now = ban.ExpiresAt
+	}
+
+	// Offences are forgotten after a clean period of MaxDuration.
+	now = now.Add(6 * time.Minute)
+	bans.cleanup()
+
+	var ban *entity.Ban
+	for range 4 {
+		ban, _ = bans.RecordRejection("10.0.0.1")
+	}
+	assert.Equal(t, 1, ban.Offences)
+}
+
+func TestBansUseCase_Unban(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	bans := newTestBans(&now)
+
+	for _, key := range []string{"10.0.0.1", "10.0.0.2"} {
+		for range 4 {
+			bans.RecordRejection(key)
+		}
+	}
+	assert.Len(t, bans.Bans(), 2)
+
+	require.NoError(t, bans.Unban("10.0.0.1"))
+	assert.ErrorIs(t, bans.Unban("10.0.0.1"), ErrBanNotFound)
+	assert.Len(t, bans.Bans(), 1)
+
+	bans.Clear()
+	assert.Empty(t, bans.Bans())
+}
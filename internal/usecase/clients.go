@@ -1,78 +1,690 @@
 package usecase
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/netip"
+	"slices"
+	"time"
 
 	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/iputil"
 	"github.com/kurochkinivan/load_balancer/internal/lib/sl"
 	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
 )
 
+const (
+	// DefaultClientsLimit is the number of clients in a page if the query does not set it.
+	DefaultClientsLimit = 100
+	MaxClientsLimit     = 1000
+	// MaxImportClients is the maximum number of rows in a bulk import of clients.
+	MaxImportClients = 10000
+
+	// sharedLoadTimeout bounds a load shared by concurrent requests. Such a load is detached from
+	// the request that has started it, so a cancelled request does not fail the others waiting for it.
+	sharedLoadTimeout = 5 * time.Second
+)
+
 type ClientsUseCase struct {
 	log     *slog.Logger
 	storage ClientStorage
+	health  StorageHealth
 	cache   ClientCache
+	ranges  *clientRanges
 }
 
-func New(log *slog.Logger, clientStorage ClientStorage, cache ClientCache) *ClientsUseCase {
+// New creates a ClientsUseCase. StorageHealth can be nil, then the storage is considered always available.
+func New(log *slog.Logger, clientStorage ClientStorage, health StorageHealth, cache ClientCache) *ClientsUseCase {
 	return &ClientsUseCase{
 		log:     log,
 		storage: clientStorage,
+		health:  health,
 		cache:   cache,
+		ranges:  newClientRanges(),
 	}
 }
 
+// StorageHealth reports whether the storage is reachable.
+type StorageHealth interface {
+	Healthy() bool
+}
+
 type ClientStorage interface {
-	Clients(ctx context.Context) ([]*entity.Client, error)
+	ListClients(ctx context.Context, query entity.ClientsQuery) ([]*entity.Client, error)
+	RangeClients(ctx context.Context) ([]*entity.Client, error)
+	WarmUpClients(ctx context.Context, limit int) ([]*entity.Client, error)
+	ClientsByAddresses(ctx context.Context, ipAddresses []string) ([]*entity.Client, error)
 	Client(ctx context.Context, ipAdress string) (*entity.Client, error)
 	CreateClient(ctx context.Context, client *entity.Client) error
+	ImportClients(ctx context.Context, clients []*entity.Client, upsert bool) (created, updated int64, err error)
+	Plans(ctx context.Context) ([]*entity.Plan, error)
 	UpdateClient(ctx context.Context, client *entity.Client) error
 	DeleteClient(ctx context.Context, ipAdress string) error
 }
 
+type ClientChangeListener interface {
+	ListenClientChanges(ctx context.Context, onListen func(), onChange func(change *entity.ClientChange)) error
+}
+
 type ClientCache interface {
 	Client(ip_address string) (*entity.Client, bool)
+	Peek(ip_address string) (*entity.Client, bool)
+	Load(ip_address string, load func() (*entity.Client, error)) (*entity.Client, error)
 	UpdateClient(client *entity.Client)
 	DeleteClient(ip_address string)
+	Clients() []*entity.Client
+	DeleteFunc(fn func(client *entity.Client) bool)
+	DeleteMissingFunc(fn func(ip_address string) bool)
+	Purge()
+}
+
+// Client returns the client for the given key. The key is either an IP address
+// or an aggregated IPv6 network (see iputil.ClientKey).
+//
+// Clients are resolved in the following order: the cache, the exact match in the storage
+// and the longest CIDR range that contains the key. While the storage is unavailable, only the cache
+// and the CIDR ranges loaded into memory are used.
+//
+// It returns ErrClientNotFound if there is no such client and ErrStorageUnavailable if the client
+// can not be resolved because the storage is unavailable.
+func (c *ClientsUseCase) Client(ctx context.Context, ipAdress string) (*entity.Client, error) {
+	const op = "ClientsUseCase.Client"
+
+	// Concurrent misses of the same key are resolved once, and keys that are not found are cached as missing.
+	client, err := c.cache.Load(ipAdress, func() (*entity.Client, error) {
+		c.log.Info("cache miss, going to db...", slog.String("ipAdress", ipAdress))
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedLoadTimeout)
+		defer cancel()
+
+		return c.resolve(ctx, ipAdress)
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrClientNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return client, nil
 }
 
-func (c *ClientsUseCase) Client(ctx context.Context, ipAdress string) (*entity.Client, bool) {
-	client, ok := c.cache.Client(ipAdress)
-	if ok {
-		c.log.Info("cache hit!", slog.String("ipAdress", ipAdress))
-		return client, true
+// resolve returns storage.ErrClientNotFound if there is no such client, so the cache remembers the key as missing.
+func (c *ClientsUseCase) resolve(ctx context.Context, key string) (*entity.Client, error) {
+	var (
+		prefix      netip.Prefix
+		storageDown bool
+	)
+	if iputil.IsRange(key) {
+		p, err := netip.ParsePrefix(key)
+		if err != nil {
+			return nil, storage.ErrClientNotFound
+		}
+		prefix = p
+	} else {
+		addr, err := netip.ParseAddr(key)
+		if err != nil {
+			return nil, storage.ErrClientNotFound
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+
+		if c.health != nil && !c.health.Healthy() {
+			storageDown = true
+		} else {
+			client, err := c.storage.Client(ctx, key)
+			if err == nil {
+				return client, nil
+			}
+			if !errors.Is(err, storage.ErrClientNotFound) {
+				c.log.Error("failed to get client", sl.Error(err))
+				storageDown = true
+			}
+		}
+	}
+
+	rangeClient, ok := c.ranges.lookup(prefix)
+	if !ok {
+		if storageDown {
+			return nil, ErrStorageUnavailable
+		}
+		return nil, storage.ErrClientNotFound
+	}
+
+	c.log.Debug("client is resolved by cidr range",
+		slog.String("key", key),
+		slog.String("range", rangeClient.IPAddress),
+		slog.String("limit_mode", string(rangeClient.LimitMode)),
+	)
+
+	return rangeClient.ForAddress(key), nil
+}
+
+// LoadRanges loads all clients defined by CIDR ranges from the storage.
+func (c *ClientsUseCase) LoadRanges(ctx context.Context) error {
+	const op = "ClientsUseCase.LoadRanges"
+
+	clients, err := c.storage.RangeClients(ctx)
+	if err != nil {
+		c.log.Error("failed to get range clients", sl.Error(err))
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	c.log.Info("cache miss, going to db...", slog.String("ipAdress", ipAdress))
+	c.ranges.replaceAll(c.byPrefix(clients))
+	c.cache.Purge()
+
+	c.log.Info("cidr ranges are loaded", slog.Int("count", c.ranges.len()))
+
+	return nil
+}
+
+func (c *ClientsUseCase) byPrefix(clients []*entity.Client) map[netip.Prefix]*entity.Client {
+	ranges := make(map[netip.Prefix]*entity.Client, len(clients))
+	for _, client := range clients {
+		prefix, err := iputil.ParsePrefix(client.IPAddress)
+		if err != nil {
+			c.log.Warn("skipping client with invalid cidr", slog.String("ip_address", client.IPAddress))
+			continue
+		}
+		ranges[prefix] = client
+	}
+	return ranges
+}
+
+// WarmUp preloads up to limit clients into the cache, so their first requests do not go to the storage.
+// Pinned clients are preloaded first, see ClientStorage.WarmUpClients.
+func (c *ClientsUseCase) WarmUp(ctx context.Context, limit int) error {
+	const op = "ClientsUseCase.WarmUp"
+
+	clients, err := c.storage.WarmUpClients(ctx, limit)
+	if err != nil {
+		c.log.Error("failed to get clients to warm up the cache", sl.Error(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, client := range clients {
+		c.cache.UpdateClient(client)
+	}
+
+	c.log.Info("cache is warmed up", slog.Int("count", len(clients)))
+
+	return nil
+}
+
+// Resync makes the CIDR ranges and the cached clients consistent with the storage, so changes
+// that were not delivered to this instance converge. Unlike LoadRanges, it does not purge the cache:
+// only the clients that differ from the storage are replaced or evicted, and the others keep their buckets.
+func (c *ClientsUseCase) Resync(ctx context.Context) error {
+	const op = "ClientsUseCase.Resync"
+
+	rangeClients, err := c.storage.RangeClients(ctx)
+	if err != nil {
+		c.log.Error("failed to get range clients", sl.Error(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	changedRanges := c.ranges.sync(c.byPrefix(rangeClients))
+	for _, prefix := range changedRanges {
+		c.evictRange(prefix)
+	}
+
+	cached := c.cache.Clients()
+	ipAddresses := make([]string, 0, len(cached))
+	for _, client := range cached {
+		// Aggregated IPv6 networks are resolved only by the ranges, which are synced above.
+		if !iputil.IsRange(client.IPAddress) {
+			ipAddresses = append(ipAddresses, client.IPAddress)
+		}
+	}
+
+	clients, err := c.storage.ClientsByAddresses(ctx, ipAddresses)
+	if err != nil {
+		c.log.Error("failed to get cached clients", sl.Error(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	stored := make(map[string]*entity.Client, len(clients))
+	for _, client := range clients {
+		stored[client.IPAddress] = client
+	}
+
+	var updated, evicted int
+	for _, client := range cached {
+		if storedClient, ok := stored[client.IPAddress]; ok {
+			if !client.SameConfig(storedClient) {
+				c.cache.UpdateClient(storedClient)
+				updated++
+			}
+			continue
+		}
+
+		if !c.resolvedByRange(client) {
+			c.cache.DeleteClient(client.IPAddress)
+			evicted++
+		}
+	}
+
+	c.log.Info("cache is resynced",
+		slog.Int("changed_ranges", len(changedRanges)),
+		slog.Int("updated", updated),
+		slog.Int("evicted", evicted),
+	)
+
+	return nil
+}
+
+// resolvedByRange reports whether a cached client that is not stored with its own address is still valid:
+// it is resolved by the range that contains it, or it has the default limits of an unknown client
+// and no range contains it.
+func (c *ClientsUseCase) resolvedByRange(client *entity.Client) bool {
+	prefix, err := iputil.ParsePrefix(client.IPAddress)
+	if err != nil {
+		addr, err := netip.ParseAddr(client.IPAddress)
+		if err != nil {
+			return false
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+
+	rangeClient, ok := c.ranges.lookup(prefix)
+	if !ok {
+		return client.ID == 0
+	}
+	return rangeClient.ID == client.ID
+}
+
+// StartResync resyncs the cache with the storage every interval until the context is cancelled.
+// The resync is skipped while the storage is unavailable.
+func (c *ClientsUseCase) StartResync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if c.health != nil && !c.health.Healthy() {
+				continue
+			}
+			if err := c.Resync(ctx); err != nil {
+				c.log.Error("failed to resync the cache", sl.Error(err))
+			}
+		case <-ctx.Done():
+			c.log.Info("cache resync is terminated due to context cancellation")
+			return
+		}
+	}
+}
+
+// StartTokenRefiller refills the buckets of the CIDR ranges in shared mode once per second.
+func (c *ClientsUseCase) StartTokenRefiller(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.ranges.refillShared()
+		case <-ctx.Done():
+			c.log.Info("range token refiller is terminated due to context cancellation")
+			return
+		}
+	}
+}
+
+// StartChangeListener keeps the cache consistent with the changes of clients made by any balancer instance
+// until the context is cancelled. Cached single addresses are evicted and resolved again on the next request,
+// CIDR ranges are read again from the storage.
+//
+// Changes made while the listener is disconnected are lost, so every time it starts listening the cache
+// and the ranges are resynced with the storage. A dropped connection is retried with a delay that doubles from retryDelay up to maxRetryDelay.
+func (c *ClientsUseCase) StartChangeListener(ctx context.Context, listener ClientChangeListener, retryDelay, maxRetryDelay time.Duration) {
+	delay := retryDelay
+
+	for {
+		err := listener.ListenClientChanges(ctx,
+			func() {
+				delay = retryDelay
+				c.log.Info("listening to client changes, resyncing the cache")
+				if err := c.Resync(ctx); err != nil {
+					c.log.Error("failed to resync the cache", sl.Error(err))
+				}
+			},
+			func(change *entity.ClientChange) {
+				c.applyChange(ctx, change)
+			},
+		)
+		if ctx.Err() != nil {
+			c.log.Info("client changes listener is terminated due to context cancellation")
+			return
+		}
+
+		c.log.Error("client changes listener is disconnected", slog.Duration("retry_in", delay), sl.Error(err))
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			c.log.Info("client changes listener is terminated due to context cancellation")
+			return
+		}
+
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// applyChange makes the cache consistent with a change of a client or a plan made by any balancer instance.
+func (c *ClientsUseCase) applyChange(ctx context.Context, change *entity.ClientChange) {
+	if change.Plan != "" {
+		c.log.Debug("plan is changed", slog.String("op", string(change.Op)), slog.String("plan", change.Plan))
+		if err := c.PlanChanged(ctx, change.Plan); err != nil {
+			c.log.Error("failed to apply plan change", slog.String("plan", change.Plan), sl.Error(err))
+		}
+		return
+	}
+
+	c.log.Debug("client is changed", slog.String("op", string(change.Op)), slog.String("ip_address", change.IPAddress))
+
+	prefix, err := iputil.ParsePrefix(change.IPAddress)
+	if err != nil {
+		c.cache.DeleteClient(change.IPAddress)
+		return
+	}
+
+	if change.Op != entity.ClientDeleted {
+		client, err := c.storage.Client(ctx, change.IPAddress)
+		if err == nil {
+			c.applyToCache(client)
+			return
+		}
+		if !errors.Is(err, storage.ErrClientNotFound) {
+			// The range stays as it is until the next resync.
+			c.log.Error("failed to get changed client", slog.String("ip_address", change.IPAddress), sl.Error(err))
+			return
+		}
+	}
+
+	c.ranges.delete(prefix)
+	c.evictRange(prefix)
+}
+
+// ClientState returns the stored client with the runtime state of its bucket on this instance.
+func (c *ClientsUseCase) ClientState(ctx context.Context, ipAdress string) (*entity.ClientState, error) {
+	const op = "ClientsUseCase.ClientState"
+
+	ipAdress, err := iputil.Canonical(ipAdress)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", op, ErrInvalidIPAddress, err)
+	}
 
 	client, err := c.storage.Client(ctx, ipAdress)
 	if err != nil {
-		return nil, false
+		if errors.Is(err, storage.ErrClientNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrClientNotFound)
+		}
+
+		c.log.Error("failed to get client", sl.Error(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	c.cache.UpdateClient(client)
+	state := &entity.ClientState{
+		Client: client,
+		Tokens: client.Capacity,
+	}
+	if bucket, ok := c.bucket(ipAdress); ok {
+		state.Cached = true
+		state.Tokens = bucket.BucketTokens()
+		state.LastRefill = bucket.LastRefill()
+	}
 
-	return client, true
+	return state, nil
 }
 
-func (c *ClientsUseCase) Clients(ctx context.Context) ([]*entity.Client, error) {
-	const op = "ClientsUseCase.GetClients"
+// ResetBucket fills the bucket of the client on this instance up to its capacity.
+// A client that is not cached is loaded into the cache first, so it does not continue with the bucket
+// saved when it left the cache.
+func (c *ClientsUseCase) ResetBucket(ctx context.Context, ipAdress string) error {
+	const op = "ClientsUseCase.ResetBucket"
 
-	clients, err := c.storage.Clients(ctx)
+	ipAdress, err := iputil.Canonical(ipAdress)
 	if err != nil {
-		c.log.Error("failed to get clients", sl.Error(err))
+		return fmt.Errorf("%s: %w: %w", op, ErrInvalidIPAddress, err)
+	}
+
+	if _, err := c.storage.Client(ctx, ipAdress); err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrClientNotFound)
+		}
+
+		c.log.Error("failed to get client", sl.Error(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	bucket, ok := c.bucket(ipAdress)
+	if !ok {
+		if iputil.IsRange(ipAdress) {
+			// The range is not loaded yet, it gets a full bucket when it is.
+			return nil
+		}
+
+		bucket, err = c.Client(ctx, ipAdress)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	bucket.RefillFull()
+	c.log.Info("bucket is reset", slog.String("ip_address", ipAdress))
+
+	return nil
+}
+
+// bucket returns the client holding the bucket of the address or the range in memory.
+func (c *ClientsUseCase) bucket(ipAdress string) (*entity.Client, bool) {
+	if prefix, err := iputil.ParsePrefix(ipAdress); err == nil {
+		return c.ranges.get(prefix)
+	}
+	return c.cache.Peek(ipAdress)
+}
+
+// ListClients returns a page of clients. The limit of the query defaults to DefaultClientsLimit
+// and is capped at MaxClientsLimit, the clients are ordered by their ID unless the query sets the order.
+func (c *ClientsUseCase) ListClients(ctx context.Context, query entity.ClientsQuery) (*entity.ClientsPage, error) {
+	const op = "ClientsUseCase.ListClients"
+
+	if query.Limit <= 0 {
+		query.Limit = DefaultClientsLimit
+	}
+	query.Limit = min(query.Limit, MaxClientsLimit)
+	if query.Sort == "" {
+		query.Sort = entity.ClientsSortID
+	}
+	query.Network = query.Network.Masked()
+
+	// One more client tells whether there is a next page.
+	limit := query.Limit
+	query.Limit++
+
+	clients, err := c.storage.ListClients(ctx, query)
+	if err != nil {
+		c.log.Error("failed to list clients", sl.Error(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	page := &entity.ClientsPage{Clients: clients}
+	if len(clients) > limit {
+		page.Clients = clients[:limit]
+		page.Next = entity.CursorOf(clients[limit-1])
+	}
+
+	return page, nil
+}
+
+// ExportClients passes all clients with the limits set for them, as they are imported, to fn page by page
+// in the order of ID. Clients changed during the export may be missed or seen twice.
+func (c *ClientsUseCase) ExportClients(ctx context.Context, fn func(clients []*entity.Client) error) error {
+	const op = "ClientsUseCase.ExportClients"
+
+	query := entity.ClientsQuery{
+		Raw:   true,
+		Sort:  entity.ClientsSortID,
+		Limit: MaxClientsLimit,
+	}
+
+	for {
+		clients, err := c.storage.ListClients(ctx, query)
+		if err != nil {
+			c.log.Error("failed to list clients", sl.Error(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if len(clients) == 0 {
+			return nil
+		}
+
+		if err := fn(clients); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		if len(clients) < query.Limit {
+			return nil
+		}
+		query.After = entity.CursorOf(clients[len(clients)-1])
+	}
+}
+
+// ImportClients validates all rows and applies them at once, or none of them if any row is invalid.
+// Every invalid row is reported in the result with its line. A row is invalid if it could not be parsed,
+// has an invalid address, limit mode or quota period, negative limits, no capacity or rate without a plan,
+// an unknown plan, the address of another row or, unless the import is an upsert, the address of an existing client.
+//
+// It returns ErrTooManyClients if there are more than MaxImportClients rows.
+func (c *ClientsUseCase) ImportClients(ctx context.Context, rows []entity.ImportRow, opts entity.ImportOptions) (*entity.ImportResult, error) {
+	const op = "ClientsUseCase.ImportClients"
+
+	if len(rows) > MaxImportClients {
+		return nil, fmt.Errorf("%s: %w", op, ErrTooManyClients)
+	}
+
+	plans, err := c.storage.Plans(ctx)
+	if err != nil {
+		c.log.Error("failed to get plans", sl.Error(err))
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return clients, nil
+	known := make(map[string]bool, len(plans))
+	for _, plan := range plans {
+		known[plan.Name] = true
+	}
+
+	result := &entity.ImportResult{
+		Rows:   len(rows),
+		DryRun: opts.DryRun,
+	}
+	reject := func(row entity.ImportRow, err error) {
+		importError := entity.ImportError{Line: row.Line, Message: err.Error()}
+		if row.Client != nil {
+			importError.IPAddress = row.Client.IPAddress
+		}
+		result.Errors = append(result.Errors, importError)
+	}
+
+	clients := make([]*entity.Client, 0, len(rows))
+	lines := make(map[string]int, len(rows))
+	for _, row := range rows {
+		if row.Err != nil {
+			reject(row, row.Err)
+			continue
+		}
+
+		if err := validateImported(row.Client, known); err != nil {
+			reject(row, err)
+			continue
+		}
+
+		if line, ok := lines[row.Client.IPAddress]; ok {
+			reject(row, fmt.Errorf("duplicates the client of line %d", line))
+			continue
+		}
+		lines[row.Client.IPAddress] = row.Line
+		clients = append(clients, row.Client)
+	}
+
+	existing, err := c.storage.ClientsByAddresses(ctx, slices.Collect(maps.Keys(lines)))
+	if err != nil {
+		c.log.Error("failed to get existing clients", sl.Error(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !opts.Upsert {
+		for _, client := range existing {
+			reject(entity.ImportRow{Line: lines[client.IPAddress], Client: client}, ErrClientExists)
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		slices.SortStableFunc(result.Errors, func(a, b entity.ImportError) int { return cmp.Compare(a.Line, b.Line) })
+		return result, nil
+	}
+
+	if opts.DryRun {
+		result.Updated = int64(len(existing))
+		result.Created = int64(len(clients) - len(existing))
+		return result, nil
+	}
+
+	result.Created, result.Updated, err = c.storage.ImportClients(ctx, clients, opts.Upsert)
+	if err != nil {
+		// The clients or the plans were changed concurrently after the validation.
+		if errors.Is(err, storage.ErrClientExists) {
+			return nil, fmt.Errorf("%s: %w", op, ErrClientExists)
+		}
+		if errors.Is(err, storage.ErrPlanNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrPlanNotFound)
+		}
+
+		c.log.Error("failed to import clients", sl.Error(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	c.log.Info("clients are imported",
+		slog.Int64("created", result.Created),
+		slog.Int64("updated", result.Updated),
+	)
+
+	// Addresses remembered as missing are now stored, and the cached clients and ranges
+	// are brought up to date by the resync.
+	c.cache.DeleteMissingFunc(func(ipAddress string) bool {
+		_, ok := lines[ipAddress]
+		return ok
+	})
+	if err := c.Resync(ctx); err != nil {
+		c.log.Error("failed to resync the cache after the import", sl.Error(err))
+	}
+
+	return result, nil
+}
+
+// validateImported canonicalizes an imported client and checks its fields. known holds the names of the plans.
+func validateImported(client *entity.Client, known map[string]bool) error {
+	if err := validate(client); err != nil {
+		return err
+	}
+
+	if client.Plan != "" && !known[client.Plan] {
+		return ErrPlanNotFound
+	}
+
+	return nil
 }
 
 func (c *ClientsUseCase) CreateClient(ctx context.Context, client *entity.Client) error {
 	const op = "ClientsUseCase.CreateClient"
 
+	if err := validate(client); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
 	err := c.storage.CreateClient(ctx, client)
 	if err != nil {
 		if errors.Is(err, storage.ErrClientExists) {
@@ -80,11 +692,21 @@ func (c *ClientsUseCase) CreateClient(ctx context.Context, client *entity.Client
 			return fmt.Errorf("%s: %w", op, ErrClientExists)
 		}
 
+		if errors.Is(err, storage.ErrPlanNotFound) {
+			c.log.Warn("plan was not found", slog.String("plan", client.Plan))
+			return fmt.Errorf("%s: %w", op, ErrPlanNotFound)
+		}
+
 		c.log.Error("failed to create client", sl.Error(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	c.cache.UpdateClient(client)
+	client, err = c.effective(ctx, client)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	c.applyToCache(client)
 
 	return nil
 }
@@ -92,6 +714,10 @@ func (c *ClientsUseCase) CreateClient(ctx context.Context, client *entity.Client
 func (c *ClientsUseCase) UpdateClient(ctx context.Context, client *entity.Client) error {
 	const op = "ClientsUseCase.UpdateClient"
 
+	if err := validate(client); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
 	err := c.storage.UpdateClient(ctx, client)
 	if err != nil {
 		if errors.Is(err, storage.ErrClientNotFound) {
@@ -99,11 +725,21 @@ func (c *ClientsUseCase) UpdateClient(ctx context.Context, client *entity.Client
 			return fmt.Errorf("%s: %w", op, ErrClientNotFound)
 		}
 
+		if errors.Is(err, storage.ErrPlanNotFound) {
+			c.log.Warn("plan was not found", slog.String("plan", client.Plan))
+			return fmt.Errorf("%s: %w", op, ErrPlanNotFound)
+		}
+
 		c.log.Error("failed to update client", sl.Error(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	c.cache.UpdateClient(client)
+	client, err = c.effective(ctx, client)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	c.applyToCache(client)
 
 	return nil
 }
@@ -111,7 +747,12 @@ func (c *ClientsUseCase) UpdateClient(ctx context.Context, client *entity.Client
 func (c *ClientsUseCase) DeleteClient(ctx context.Context, ipAdress string) error {
 	const op = "ClientsUseCase.DeleteClient"
 
-	err := c.storage.DeleteClient(ctx, ipAdress)
+	ipAdress, err := iputil.Canonical(ipAdress)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", op, ErrInvalidIPAddress, err)
+	}
+
+	err = c.storage.DeleteClient(ctx, ipAdress)
 	if err != nil {
 		if errors.Is(err, storage.ErrClientNotFound) {
 			c.log.Warn("client was not found")
@@ -122,7 +763,106 @@ func (c *ClientsUseCase) DeleteClient(ctx context.Context, ipAdress string) erro
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
+	if prefix, err := iputil.ParsePrefix(ipAdress); err == nil {
+		c.ranges.delete(prefix)
+		c.evictRange(prefix)
+		return nil
+	}
+
 	c.cache.DeleteClient(ipAdress)
 
 	return nil
 }
+
+// PlanChanged makes the cache consistent with the changed plan: clients of the plan
+// are evicted and resolved again with the new limits on the next request.
+func (c *ClientsUseCase) PlanChanged(ctx context.Context, plan string) error {
+	const op = "ClientsUseCase.PlanChanged"
+
+	c.cache.DeleteFunc(func(client *entity.Client) bool {
+		return client.Plan == plan
+	})
+
+	if !c.ranges.hasPlan(plan) {
+		return nil
+	}
+
+	if err := c.LoadRanges(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// effective returns the client with its effective limits. Limits of a client that references a plan
+// may be inherited from the plan, so such a client is read back from the storage.
+func (c *ClientsUseCase) effective(ctx context.Context, client *entity.Client) (*entity.Client, error) {
+	if client.Plan == "" {
+		return client, nil
+	}
+
+	effective, err := c.storage.Client(ctx, client.IPAddress)
+	if err != nil {
+		c.log.Error("failed to get client", sl.Error(err))
+		return nil, err
+	}
+
+	return effective, nil
+}
+
+// applyToCache makes the cache consistent with the created or updated client.
+//
+// A change of a CIDR range may affect any cached client inside of it,
+// so all of them are evicted and resolved again on the next request.
+func (c *ClientsUseCase) applyToCache(client *entity.Client) {
+	// A new bucket is full, the cache and the ranges carry the tokens of a replaced one over.
+	client.Tokens.Store(client.Capacity)
+
+	prefix, err := iputil.ParsePrefix(client.IPAddress)
+	if err != nil {
+		c.cache.UpdateClient(client)
+		return
+	}
+
+	c.ranges.set(prefix, client)
+	c.evictRange(prefix)
+}
+
+// evictRange evicts cached clients and missing keys that belong to the given prefix.
+func (c *ClientsUseCase) evictRange(prefix netip.Prefix) {
+	c.cache.DeleteFunc(func(client *entity.Client) bool {
+		return iputil.Contains(prefix, client.IPAddress)
+	})
+	c.cache.DeleteMissingFunc(func(ipAddress string) bool {
+		return iputil.Contains(prefix, ipAddress)
+	})
+}
+
+// validate canonicalizes the client and checks its fields (see entity.Client.Validate).
+// It returns ErrInvalidIPAddress or ErrInvalidClient with the invalid fields.
+func validate(client *entity.Client) error {
+	if err := canonicalize(client); err != nil {
+		return err
+	}
+
+	if errs := client.Validate(); len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidClient, errs)
+	}
+
+	return nil
+}
+
+// canonicalize brings the address of the client to its canonical form and sets the default limit mode.
+func canonicalize(client *entity.Client) error {
+	ipAddress, err := iputil.Canonical(client.IPAddress)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidIPAddress, err)
+	}
+	client.IPAddress = ipAddress
+
+	if client.LimitMode == "" {
+		client.LimitMode = entity.LimitModeShared
+	}
+
+	return nil
+}
@@ -0,0 +1,550 @@
+package usecase
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/iputil"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientStorageMock returns a new object with a full bucket on every read, as the database does.
+type clientStorageMock struct {
+	mu      sync.Mutex
+	clients map[string]*entity.Client
+}
+
+func newClientStorageMock(clients ...*entity.Client) *clientStorageMock {
+	s := &clientStorageMock{clients: make(map[string]*entity.Client)}
+	for _, client := range clients {
+		s.clients[client.IPAddress] = client
+	}
+	return s
+}
+
+func (s *clientStorageMock) set(client *entity.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clients[client.IPAddress] = client
+}
+
+func (s *clientStorageMock) read(fn func(client *entity.Client) bool) []*entity.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var clients []*entity.Client
+	for _, client := range s.clients {
+		if fn(client) {
+			read := &entity.Client{
+				ID:            client.ID,
+				IPAddress:     client.IPAddress,
+				Capacity:      client.Capacity,
+				RatePerSecond: client.RatePerSecond,
+				LimitMode:     client.LimitMode,
+				Plan:          client.Plan,
+				Pinned:        client.Pinned,
+			}
+			read.Tokens.Store(read.Capacity)
+			clients = append(clients, read)
+		}
+	}
+	return clients
+}
+
+// ListClients supports only the order by id.
+func (s *clientStorageMock) ListClients(_ context.Context, query entity.ClientsQuery) ([]*entity.Client, error) {
+	clients := s.read(func(client *entity.Client) bool { return query.After == nil || client.ID > query.After.ID })
+	slices.SortFunc(clients, func(a, b *entity.Client) int { return cmp.Compare(a.ID, b.ID) })
+	return clients[:min(query.Limit, len(clients))], nil
+}
+
+func (s *clientStorageMock) RangeClients(context.Context) ([]*entity.Client, error) {
+	return s.read(func(client *entity.Client) bool { return iputil.IsRange(client.IPAddress) }), nil
+}
+
+func (s *clientStorageMock) WarmUpClients(_ context.Context, limit int) ([]*entity.Client, error) {
+	clients := s.read(func(client *entity.Client) bool { return client.Pinned })
+	return clients[:min(limit, len(clients))], nil
+}
+
+func (s *clientStorageMock) ClientsByAddresses(_ context.Context, ipAddresses []string) ([]*entity.Client, error) {
+	return s.read(func(client *entity.Client) bool {
+		for _, ipAddress := range ipAddresses {
+			if client.IPAddress == ipAddress {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func (s *clientStorageMock) Client(_ context.Context, ipAddress string) (*entity.Client, error) {
+	clients := s.read(func(client *entity.Client) bool { return client.IPAddress == ipAddress })
+	if len(clients) == 0 {
+		return nil, storage.ErrClientNotFound
+	}
+	return clients[0], nil
+}
+
+func (s *clientStorageMock) CreateClient(_ context.Context, client *entity.Client) error {
+	s.set(client)
+	return nil
+}
+
+func (s *clientStorageMock) ImportClients(_ context.Context, clients []*entity.Client, _ bool) (created, updated int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, client := range clients {
+		if _, ok := s.clients[client.IPAddress]; ok {
+			updated++
+		} else {
+			created++
+		}
+		s.clients[client.IPAddress] = client
+	}
+	return created, updated, nil
+}
+
+// Plans returns a single plan named "basic".
+func (s *clientStorageMock) Plans(context.Context) ([]*entity.Plan, error) {
+	return []*entity.Plan{{ID: 1, Name: "basic", Capacity: 10, RatePerSecond: 1}}, nil
+}
+
+func (s *clientStorageMock) UpdateClient(_ context.Context, client *entity.Client) error {
+	s.set(client)
+	return nil
+}
+
+func (s *clientStorageMock) DeleteClient(_ context.Context, ipAddress string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clients, ipAddress)
+	return nil
+}
+
+// changeListenerMock delivers the changes sent by the test. Every connection calls onListen first,
+// a connection is dropped with the error sent to drops.
+type changeListenerMock struct {
+	changes  chan *entity.ClientChange
+	applied  chan struct{}
+	drops    chan error
+	listened chan struct{}
+}
+
+func newChangeListenerMock() *changeListenerMock {
+	return &changeListenerMock{
+		changes:  make(chan *entity.ClientChange),
+		applied:  make(chan struct{}),
+		drops:    make(chan error),
+		listened: make(chan struct{}),
+	}
+}
+
+func (l *changeListenerMock) ListenClientChanges(ctx context.Context, onListen func(), onChange func(change *entity.ClientChange)) error {
+	onListen()
+	select {
+	case l.listened <- struct{}{}:
+	case <-ctx.Done():
+		return nil
+	}
+
+	for {
+		select {
+		case change := <-l.changes:
+			onChange(change)
+			l.applied <- struct{}{}
+		case err := <-l.drops:
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// send delivers the change and waits until it is applied.
+func (l *changeListenerMock) send(t *testing.T, change *entity.ClientChange) {
+	t.Helper()
+	l.changes <- change
+	<-l.applied
+}
+
+// waitListen waits until the listener connects and the cache is resynced.
+func (l *changeListenerMock) waitListen(t *testing.T) {
+	t.Helper()
+	select {
+	case <-l.listened:
+	case <-time.After(time.Second):
+		t.Fatal("listener has not connected")
+	}
+}
+
+func newTestClients(clientStorage ClientStorage) (*ClientsUseCase, *cache.ClientsCache) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	clientsCache := cache.NewClientsCache(log, cache.Options{MaxElements: 100})
+	return New(log, clientStorage, nil, clientsCache), clientsCache
+}
+
+func TestClientsUseCase_ListClients(t *testing.T) {
+	clientStorage := newClientStorageMock()
+	for i := range 5 {
+		clientStorage.set(&entity.Client{ID: int64(i + 1), IPAddress: fmt.Sprintf("10.0.0.%d", i+1), Capacity: 10, RatePerSecond: 1})
+	}
+	clients, _ := newTestClients(clientStorage)
+
+	page, err := clients.ListClients(context.Background(), entity.ClientsQuery{Limit: 2})
+	require.NoError(t, err)
+	assert.Len(t, page.Clients, 2)
+	require.NotNil(t, page.Next)
+	assert.Equal(t, int64(2), page.Next.ID, "the next page starts after the last client of the page")
+
+	page, err = clients.ListClients(context.Background(), entity.ClientsQuery{Limit: 3, After: page.Next})
+	require.NoError(t, err)
+	assert.Len(t, page.Clients, 3)
+	assert.Nil(t, page.Next, "there are no more clients")
+
+	page, err = clients.ListClients(context.Background(), entity.ClientsQuery{})
+	require.NoError(t, err)
+	assert.Len(t, page.Clients, 5, "the limit defaults to DefaultClientsLimit")
+	assert.Nil(t, page.Next)
+}
+
+func TestClientsUseCase_WarmUp(t *testing.T) {
+	clientStorage := newClientStorageMock(
+		&entity.Client{ID: 1, IPAddress: "10.0.0.1", Capacity: 10, Pinned: true},
+		&entity.Client{ID: 2, IPAddress: "10.0.0.2", Capacity: 10},
+	)
+	clients, clientsCache := newTestClients(clientStorage)
+
+	require.NoError(t, clients.WarmUp(context.Background(), 10))
+
+	_, ok := clientsCache.Client("10.0.0.1")
+	assert.True(t, ok)
+	_, ok = clientsCache.Client("10.0.0.2")
+	assert.False(t, ok)
+}
+
+// cancelAwareStorage fails the reads with the error of a cancelled context, as the database does.
+type cancelAwareStorage struct {
+	*clientStorageMock
+}
+
+func (s cancelAwareStorage) Client(ctx context.Context, ipAddress string) (*entity.Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.clientStorageMock.Client(ctx, ipAddress)
+}
+
+func TestClientsUseCase_Client_CancelledRequest(t *testing.T) {
+	clientStorage := newClientStorageMock(&entity.Client{ID: 1, IPAddress: "10.0.0.1", Capacity: 10})
+	clients, clientsCache := newTestClients(cancelAwareStorage{clientStorage})
+
+	// The load may be shared by other requests, so it does not stop with the request that has started it.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	client, err := clients.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(10), client.Capacity)
+
+	_, ok := clientsCache.Client("10.0.0.1")
+	assert.True(t, ok)
+}
+
+func TestClientsUseCase_Resync(t *testing.T) {
+	ctx := context.Background()
+	clientStorage := newClientStorageMock(
+		&entity.Client{ID: 1, IPAddress: "10.0.0.1", Capacity: 10},
+		&entity.Client{ID: 2, IPAddress: "10.0.0.2", Capacity: 10},
+		&entity.Client{ID: 3, IPAddress: "10.0.0.3", Capacity: 10},
+		&entity.Client{ID: 4, IPAddress: "192.168.0.0/16", Capacity: 10, LimitMode: entity.LimitModeShared},
+	)
+	clients, clientsCache := newTestClients(clientStorage)
+	require.NoError(t, clients.LoadRanges(ctx))
+
+	for _, ipAddress := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "192.168.1.1"} {
+		client, err := clients.Client(ctx, ipAddress)
+		require.NoError(t, err)
+		require.True(t, client.AllowN(4))
+	}
+
+	clientStorage.set(&entity.Client{ID: 2, IPAddress: "10.0.0.2", Capacity: 20})
+	require.NoError(t, clientStorage.DeleteClient(ctx, "10.0.0.3"))
+
+	require.NoError(t, clients.Resync(ctx))
+
+	unchanged, ok := clientsCache.Client("10.0.0.1")
+	require.True(t, ok)
+	assert.Equal(t, int32(6), unchanged.Tokens.Load())
+
+	updated, ok := clientsCache.Client("10.0.0.2")
+	require.True(t, ok)
+	assert.Equal(t, int32(20), updated.Capacity)
+	assert.Equal(t, int32(12), updated.Tokens.Load(), "the bucket is scaled to the new capacity")
+
+	_, ok = clientsCache.Client("10.0.0.3")
+	assert.False(t, ok)
+
+	rangeClient, ok := clientsCache.Client("192.168.1.1")
+	require.True(t, ok, "clients of an unchanged range stay cached")
+	assert.False(t, rangeClient.AllowN(7), "the bucket of the range is kept")
+}
+
+func TestClientsUseCase_PlanChanged(t *testing.T) {
+	ctx := context.Background()
+	clientStorage := newClientStorageMock(
+		&entity.Client{ID: 1, IPAddress: "10.0.0.1", Capacity: 10, Plan: "basic"},
+		&entity.Client{ID: 2, IPAddress: "10.0.0.2", Capacity: 10},
+		&entity.Client{ID: 3, IPAddress: "192.168.0.0/16", Capacity: 10, LimitMode: entity.LimitModeShared, Plan: "basic"},
+	)
+	clients, clientsCache := newTestClients(clientStorage)
+	require.NoError(t, clients.LoadRanges(ctx))
+
+	for _, ipAddress := range []string{"10.0.0.1", "10.0.0.2", "192.168.1.1"} {
+		_, err := clients.Client(ctx, ipAddress)
+		require.NoError(t, err)
+	}
+
+	// The clients of the plan inherit its new capacity.
+	clientStorage.set(&entity.Client{ID: 1, IPAddress: "10.0.0.1", Capacity: 20, Plan: "basic"})
+	clientStorage.set(&entity.Client{ID: 3, IPAddress: "192.168.0.0/16", Capacity: 20, LimitMode: entity.LimitModeShared, Plan: "basic"})
+
+	// The change of the plan is received from the storage, as if another instance has made it.
+	clients.applyChange(ctx, &entity.ClientChange{Op: entity.ClientUpdated, Plan: "basic"})
+
+	_, ok := clientsCache.Client("10.0.0.1")
+	assert.False(t, ok, "clients of the plan are evicted")
+
+	client, err := clients.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(20), client.Capacity)
+
+	client, err = clients.Client(ctx, "192.168.1.1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(20), client.Capacity, "ranges of the plan are reloaded")
+
+	client, err = clients.Client(ctx, "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, int32(10), client.Capacity)
+}
+
+func TestClientsUseCase_StartChangeListener(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clientStorage := newClientStorageMock(
+		&entity.Client{ID: 1, IPAddress: "10.0.0.1", Capacity: 10},
+		&entity.Client{ID: 2, IPAddress: "10.0.0.2", Capacity: 10},
+	)
+	clients, clientsCache := newTestClients(clientStorage)
+	listener := newChangeListenerMock()
+
+	stopped := make(chan struct{})
+	go func() {
+		clients.StartChangeListener(ctx, listener, time.Millisecond, 10*time.Millisecond)
+		close(stopped)
+	}()
+	listener.waitListen(t)
+
+	for _, ipAddress := range []string{"10.0.0.1", "10.0.0.2"} {
+		client, err := clients.Client(ctx, ipAddress)
+		require.NoError(t, err)
+		require.True(t, client.AllowN(4))
+	}
+	_, err := clients.Client(ctx, "10.0.0.3")
+	require.ErrorIs(t, err, ErrClientNotFound)
+
+	t.Run("Insert", func(t *testing.T) {
+		clientStorage.set(&entity.Client{ID: 3, IPAddress: "10.0.0.3", Capacity: 5})
+		listener.send(t, &entity.ClientChange{Op: entity.ClientInserted, IPAddress: "10.0.0.3"})
+
+		client, err := clients.Client(ctx, "10.0.0.3")
+		require.NoError(t, err, "the missing key is forgotten")
+		assert.Equal(t, int32(5), client.Capacity)
+
+		clientStorage.set(&entity.Client{ID: 4, IPAddress: "192.168.0.0/16", Capacity: 7, LimitMode: entity.LimitModeShared})
+		listener.send(t, &entity.ClientChange{Op: entity.ClientInserted, IPAddress: "192.168.0.0/16"})
+
+		client, err = clients.Client(ctx, "192.168.1.1")
+		require.NoError(t, err, "the new range is applied")
+		assert.Equal(t, int32(7), client.Capacity)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		clientStorage.set(&entity.Client{ID: 1, IPAddress: "10.0.0.1", Capacity: 20})
+		listener.send(t, &entity.ClientChange{Op: entity.ClientUpdated, IPAddress: "10.0.0.1"})
+
+		_, ok := clientsCache.Client("10.0.0.1")
+		assert.False(t, ok, "the changed address is evicted")
+
+		client, err := clients.Client(ctx, "10.0.0.1")
+		require.NoError(t, err)
+		assert.Equal(t, int32(20), client.Capacity)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, clientStorage.DeleteClient(ctx, "10.0.0.2"))
+		listener.send(t, &entity.ClientChange{Op: entity.ClientDeleted, IPAddress: "10.0.0.2"})
+
+		_, ok := clientsCache.Client("10.0.0.2")
+		assert.False(t, ok)
+
+		require.NoError(t, clientStorage.DeleteClient(ctx, "192.168.0.0/16"))
+		listener.send(t, &entity.ClientChange{Op: entity.ClientDeleted, IPAddress: "192.168.0.0/16"})
+
+		_, err := clients.Client(ctx, "192.168.1.1")
+		assert.ErrorIs(t, err, ErrClientNotFound, "the deleted range is not applied")
+	})
+
+	t.Run("Reconnect", func(t *testing.T) {
+		// The changes made around a lost connection are not notified, the cache is resynced on reconnect.
+		clientStorage.set(&entity.Client{ID: 1, IPAddress: "10.0.0.1", Capacity: 30})
+		require.NoError(t, clientStorage.DeleteClient(ctx, "10.0.0.3"))
+		listener.drops <- errors.New("connection reset by peer")
+		listener.waitListen(t)
+
+		client, ok := clientsCache.Client("10.0.0.1")
+		require.True(t, ok)
+		assert.Equal(t, int32(30), client.Capacity)
+
+		_, ok = clientsCache.Client("10.0.0.3")
+		assert.False(t, ok)
+
+		clientStorage.set(&entity.Client{ID: 1, IPAddress: "10.0.0.1", Capacity: 40})
+		listener.send(t, &entity.ClientChange{Op: entity.ClientUpdated, IPAddress: "10.0.0.1"})
+
+		client, err := clients.Client(ctx, "10.0.0.1")
+		require.NoError(t, err)
+		assert.Equal(t, int32(40), client.Capacity, "changes are applied after the reconnect")
+	})
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("listener is not stopped on context cancellation")
+	}
+}
+
+func TestClientsUseCase_ResetBucket(t *testing.T) {
+	ctx := context.Background()
+	clientStorage := newClientStorageMock(
+		&entity.Client{ID: 1, IPAddress: "10.0.0.1", Capacity: 10},
+		&entity.Client{ID: 2, IPAddress: "192.168.0.0/16", Capacity: 10, LimitMode: entity.LimitModeShared},
+	)
+	clients, _ := newTestClients(clientStorage)
+	require.NoError(t, clients.LoadRanges(ctx))
+
+	state, err := clients.ClientState(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.False(t, state.Cached)
+	assert.Equal(t, int32(10), state.Tokens, "a client that is not cached would get a full bucket")
+
+	for _, ipAddress := range []string{"10.0.0.1", "192.168.1.1"} {
+		client, err := clients.Client(ctx, ipAddress)
+		require.NoError(t, err)
+		require.True(t, client.AllowN(7))
+	}
+
+	state, err = clients.ClientState(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, state.Cached)
+	assert.Equal(t, int32(3), state.Tokens)
+
+	state, err = clients.ClientState(ctx, "192.168.0.0/16")
+	require.NoError(t, err)
+	assert.True(t, state.Cached, "ranges are always in memory")
+	assert.Equal(t, int32(3), state.Tokens, "tokens spent by the addresses of a shared range")
+
+	require.NoError(t, clients.ResetBucket(ctx, "10.0.0.1"))
+	require.NoError(t, clients.ResetBucket(ctx, "192.168.0.0/16"))
+
+	for _, ipAddress := range []string{"10.0.0.1", "192.168.0.0/16"} {
+		state, err := clients.ClientState(ctx, ipAddress)
+		require.NoError(t, err)
+		assert.Equal(t, int32(10), state.Tokens)
+		assert.False(t, state.LastRefill.IsZero())
+	}
+
+	assert.ErrorIs(t, clients.ResetBucket(ctx, "192.168.1.1"), ErrClientNotFound, "addresses of ranges are not clients")
+	_, err = clients.ClientState(ctx, "not an address")
+	assert.ErrorIs(t, err, ErrInvalidIPAddress)
+}
+
+func TestClientsUseCase_ImportClients(t *testing.T) {
+	ctx := context.Background()
+	clientStorage := newClientStorageMock(&entity.Client{ID: 1, IPAddress: "10.0.0.1", Capacity: 10, RatePerSecond: 1})
+	clients, _ := newTestClients(clientStorage)
+
+	_, err := clients.Client(ctx, "10.0.0.2")
+	require.ErrorIs(t, err, ErrClientNotFound, "the address is remembered as missing")
+
+	result, err := clients.ImportClients(ctx, []entity.ImportRow{
+		{Line: 1, Client: &entity.Client{IPAddress: "10.0.0.1", Capacity: 20, RatePerSecond: 2}},
+		{Line: 2, Client: &entity.Client{IPAddress: "not an address", Capacity: 20, RatePerSecond: 2}},
+		{Line: 3, Client: &entity.Client{IPAddress: "10.0.0.2"}},
+		{Line: 4, Err: fmt.Errorf("invalid json")},
+		{Line: 5, Client: &entity.Client{IPAddress: "10.0.0.3", Plan: "gold"}},
+		{Line: 6, Client: &entity.Client{IPAddress: "10.0.0.4", Plan: "basic"}},
+		{Line: 7, Client: &entity.Client{IPAddress: "10.0.0.4", Plan: "basic"}},
+		{Line: 8, Client: &entity.Client{IPAddress: "10.0.0.5", Capacity: 1, RatePerSecond: 2}},
+	}, entity.ImportOptions{})
+	require.NoError(t, err)
+	lines := make([]int, 0, len(result.Errors))
+	for _, importError := range result.Errors {
+		lines = append(lines, importError.Line)
+	}
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 7, 8}, lines, "every invalid row is reported in the order of lines")
+	assert.Contains(t, result.Errors[len(result.Errors)-1].Message, "capacity", "the rate must not exceed the capacity")
+	assert.Zero(t, result.Created)
+	_, err = clientStorage.Client(ctx, "10.0.0.4")
+	assert.ErrorIs(t, err, storage.ErrClientNotFound, "nothing is applied if any row is invalid")
+
+	rows := []entity.ImportRow{
+		{Line: 1, Client: &entity.Client{IPAddress: "10.0.0.1", Capacity: 20, RatePerSecond: 2}},
+		{Line: 2, Client: &entity.Client{IPAddress: "10.0.0.2", Plan: "basic"}},
+	}
+	result, err = clients.ImportClients(ctx, rows, entity.ImportOptions{Upsert: true, DryRun: true})
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, int64(1), result.Created)
+	assert.Equal(t, int64(1), result.Updated)
+	_, err = clientStorage.Client(ctx, "10.0.0.2")
+	assert.ErrorIs(t, err, storage.ErrClientNotFound, "a dry run does not apply the rows")
+
+	result, err = clients.ImportClients(ctx, rows, entity.ImportOptions{Upsert: true})
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, int64(1), result.Created)
+	assert.Equal(t, int64(1), result.Updated)
+
+	client, err := clients.Client(ctx, "10.0.0.2")
+	require.NoError(t, err, "the imported address is no longer remembered as missing")
+	assert.Equal(t, "10.0.0.2", client.IPAddress)
+}
+
+func TestClientsUseCase_CreateClient_Invalid(t *testing.T) {
+	ctx := context.Background()
+	clientStorage := newClientStorageMock()
+	clients, _ := newTestClients(clientStorage)
+
+	err := clients.CreateClient(ctx, &entity.Client{IPAddress: "10.0.0.1", Capacity: 1, RatePerSecond: 2})
+	require.ErrorIs(t, err, ErrInvalidClient)
+	assert.ErrorContains(t, err, "capacity")
+
+	_, err = clientStorage.Client(ctx, "10.0.0.1")
+	assert.ErrorIs(t, err, storage.ErrClientNotFound, "an invalid client is not stored")
+}
@@ -0,0 +1,136 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/sl"
+)
+
+// RateLimitBackend is a rate-limit state shared between several instances of the balancer.
+type RateLimitBackend interface {
+	// Exchange publishes the tokens consumed by this instance since the previous exchange
+	// and returns the tokens consumed by the other instances since then.
+	//
+	// The consumed map contains every bucket tracked by this instance, including the ones
+	// with zero consumption. A bucket that was not tracked during the previous exchange starts
+	// from a fresh baseline, so the consumption of other instances is reported only from that moment.
+	Exchange(ctx context.Context, consumed map[string]int64) (map[string]int64, error)
+}
+
+// DistributedLimiter keeps the local token buckets of several balancer instances in sync.
+//
+// The hot path stays local: every instance spends tokens from its own buckets and only records
+// the consumption. Periodically the recorded consumption is exchanged through the RateLimitBackend,
+// and the tokens spent by other instances are drained from the local buckets. So the clients get
+// their limit once across the cluster instead of once per instance, with the error bounded by the
+// traffic of a single sync interval.
+type DistributedLimiter struct {
+	log      *slog.Logger
+	backend  RateLimitBackend
+	interval time.Duration
+	counters sync.Map // string (bucket key) -> *consumption
+}
+
+type consumption struct {
+	pending atomic.Int64
+	client  atomic.Pointer[entity.Client]
+	idle    int // number of exchanges without consumption, only accessed by the sync loop
+}
+
+// maxIdleExchanges is the number of exchanges without consumption after which a bucket is not tracked anymore.
+const maxIdleExchanges = 30
+
+func NewDistributedLimiter(log *slog.Logger, backend RateLimitBackend, interval time.Duration) *DistributedLimiter {
+	return &DistributedLimiter{
+		log:      log,
+		backend:  backend,
+		interval: interval,
+	}
+}
+
+// Record records n tokens spent by the client on this instance.
+//
+// This method is concurrently safe.
+func (d *DistributedLimiter) Record(client *entity.Client, n int32) {
+	key := client.BucketKey()
+
+	v, ok := d.counters.Load(key)
+	if !ok {
+		v, _ = d.counters.LoadOrStore(key, new(consumption))
+	}
+
+	c := v.(*consumption)
+	c.client.Store(client)
+	c.pending.Add(int64(n))
+}
+
+// StartSync exchanges the consumption with other instances every interval until the context is cancelled.
+func (d *DistributedLimiter) StartSync(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	d.log.Info("starting distributed rate limiting sync", slog.Duration("interval", d.interval))
+
+	for {
+		select {
+		case <-ticker.C:
+			d.syncOnce(ctx)
+		case <-ctx.Done():
+			d.log.Info("distributed rate limiting sync is terminated due to context cancellation")
+			return
+		}
+	}
+}
+
+func (d *DistributedLimiter) syncOnce(ctx context.Context) {
+	consumed := make(map[string]int64)
+	tracked := make(map[string]*consumption)
+
+	d.counters.Range(func(k, v any) bool {
+		key, c := k.(string), v.(*consumption)
+
+		n := c.pending.Swap(0)
+		if n == 0 {
+			c.idle++
+			if c.idle > maxIdleExchanges {
+				d.counters.Delete(key)
+				return true
+			}
+		} else {
+			c.idle = 0
+		}
+
+		consumed[key] = n
+		tracked[key] = c
+		return true
+	})
+
+	if len(consumed) == 0 {
+		return
+	}
+
+	others, err := d.backend.Exchange(ctx, consumed)
+	if err != nil {
+		d.log.Error("failed to exchange rate limiting counters", sl.Error(err))
+
+		// Keep the consumption to publish it during the next exchange.
+		for key, n := range consumed {
+			tracked[key].pending.Add(n)
+		}
+		return
+	}
+
+	for key, n := range others {
+		c, ok := tracked[key]
+		if !ok || n <= 0 {
+			continue
+		}
+		c.client.Load().Drain(int32(min(n, math.MaxInt32)))
+	}
+}
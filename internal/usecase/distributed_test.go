@@ -0,0 +1,195 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// localTransport delivers counters between peer backends running in the same process.
+type localTransport struct {
+	mu       sync.Mutex
+	backends map[string]*peer.Backend
+	down     map[string]bool
+}
+
+func (t *localTransport) Send(_ context.Context, to string, counters *entity.PeerCounters) error {
+	t.mu.Lock()
+	backend, down := t.backends[to], t.down[to]
+	t.mu.Unlock()
+
+	if down {
+		return errors.New("peer is down")
+	}
+	backend.Receive(counters)
+	return nil
+}
+
+type testInstance struct {
+	limiter *DistributedLimiter
+	client  *entity.Client
+}
+
+// newTestCluster creates instances that share the limit of the same client through the peer backend.
+// Clients are not refilled, so the total amount of allowed requests is known in advance.
+func newTestCluster(n int, capacity int32) ([]*testInstance, *localTransport) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	transport := &localTransport{
+		backends: make(map[string]*peer.Backend),
+		down:     make(map[string]bool),
+	}
+
+	ids := make([]string, n)
+	for i := range n {
+		ids[i] = fmt.Sprintf("lb-%d", i)
+	}
+
+	instances := make([]*testInstance, n)
+	for i, id := range ids {
+		peers := make([]string, 0, n-1)
+		for _, other := range ids {
+			if other != id {
+				peers = append(peers, other)
+			}
+		}
+
+		backend := peer.New(logger, id, peers, transport)
+		transport.backends[id] = backend
+
+		client := &entity.Client{IPAddress: "192.168.1.1", Capacity: capacity}
+		client.Tokens.Store(capacity)
+
+		instances[i] = &testInstance{
+			limiter: NewDistributedLimiter(logger, backend, time.Second),
+			client:  client,
+		}
+	}
+
+	return instances, transport
+}
+
+// sendRequests sends n requests to every instance and returns how many of them were allowed.
+func sendRequests(instances []*testInstance, n int) int {
+	allowed := 0
+	for _, instance := range instances {
+		for range n {
+			if instance.client.Allow() {
+				instance.limiter.Record(instance.client, 1)
+				allowed++
+			}
+		}
+	}
+	return allowed
+}
+
+func syncAll(instances []*testInstance) {
+	for _, instance := range instances {
+		instance.limiter.syncOnce(context.Background())
+	}
+}
+
+func TestDistributedLimiter_Accuracy(t *testing.T) {
+	const (
+		instancesCount = 3
+		capacity       = 300
+		perRound       = 10
+	)
+
+	instances, _ := newTestCluster(instancesCount, capacity)
+
+	allowed := 0
+	for range 100 {
+		allowed += sendRequests(instances, perRound)
+		syncAll(instances)
+	}
+
+	// Without sharing the client would get instancesCount*capacity requests.
+	// With sharing the error is bounded by the traffic that was not exchanged yet.
+	assert.GreaterOrEqual(t, allowed, capacity)
+	assert.LessOrEqual(t, allowed, capacity+instancesCount*instancesCount*perRound)
+
+	// Once the counters converged, no instance allows requests anymore.
+	syncAll(instances)
+	for _, instance := range instances {
+		assert.False(t, instance.client.Allow())
+	}
+}
+
+func TestDistributedLimiter_ConcurrentRequests(t *testing.T) {
+	const (
+		instancesCount = 3
+		capacity       = 1000
+		perRound       = 50
+		workers        = 4
+	)
+
+	instances, _ := newTestCluster(instancesCount, capacity)
+
+	var (
+		mu      sync.Mutex
+		allowed int
+	)
+	for range 40 {
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n := sendRequests(instances, perRound)
+
+				mu.Lock()
+				allowed += n
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		syncAll(instances)
+	}
+
+	assert.GreaterOrEqual(t, allowed, capacity)
+	assert.LessOrEqual(t, allowed, capacity+instancesCount*instancesCount*perRound*workers)
+}
+
+func TestDistributedLimiter_PeerRecovery(t *testing.T) {
+	const (
+		instancesCount = 3
+		capacity       = 300
+		perRound       = 10
+	)
+
+	instances, transport := newTestCluster(instancesCount, capacity)
+
+	// Start tracking the bucket on every instance.
+	sendRequests(instances, 1)
+	syncAll(instances)
+
+	transport.mu.Lock()
+	transport.down["lb-2"] = true
+	transport.mu.Unlock()
+
+	// lb-2 does not learn about the consumption of the other instances while it is down.
+	for range 5 {
+		sendRequests(instances[:2], perRound)
+		syncAll(instances)
+	}
+	lagging := instances[2].client.Tokens.Load()
+
+	transport.mu.Lock()
+	transport.down["lb-2"] = false
+	transport.mu.Unlock()
+
+	// The undelivered counters are sent again once the peer is back.
+	syncAll(instances)
+	syncAll(instances)
+
+	assert.Equal(t, lagging-2*5*perRound, instances[2].client.Tokens.Load())
+}
@@ -3,6 +3,20 @@ package usecase
 import "errors"
 
 var (
-	ErrClientNotFound = errors.New("client was no found")
-	ErrClientExists   = errors.New("client already exists")
+	ErrClientNotFound      = errors.New("client was no found")
+	ErrClientExists        = errors.New("client already exists")
+	ErrInvalidIPAddress    = errors.New("invalid ip address or cidr")
+	ErrInvalidClient       = errors.New("invalid client")
+	ErrTooManyClients      = errors.New("too many clients")
+	ErrNoQuota             = errors.New("client has no quota")
+	ErrPlanNotFound        = errors.New("plan was not found")
+	ErrPlanExists          = errors.New("plan already exists")
+	ErrPlanInUse           = errors.New("plan is referenced by clients")
+	ErrRuleNotFound        = errors.New("access rule was not found")
+	ErrRuleExists          = errors.New("access rule already exists")
+	ErrBanNotFound         = errors.New("ban was not found")
+	ErrBackendNotFound     = errors.New("backend was not found")
+	ErrStorageUnavailable  = errors.New("storage is unavailable")
+	ErrUnauthenticated     = errors.New("invalid credentials")
+	ErrTooManyAuthFailures = errors.New("too many authentication failures")
 )
@@ -0,0 +1,113 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/sl"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
+)
+
+type PlanStorage interface {
+	Plans(ctx context.Context) ([]*entity.Plan, error)
+	CreatePlan(ctx context.Context, plan *entity.Plan) error
+	UpdatePlan(ctx context.Context, plan *entity.Plan) error
+	DeletePlan(ctx context.Context, name string) error
+}
+
+// PlanListener is notified about the changes of plans to propagate them to the clients of the plan.
+type PlanListener interface {
+	PlanChanged(ctx context.Context, plan string) error
+}
+
+type PlansUseCase struct {
+	log      *slog.Logger
+	storage  PlanStorage
+	listener PlanListener
+}
+
+func NewPlans(log *slog.Logger, storage PlanStorage, listener PlanListener) *PlansUseCase {
+	return &PlansUseCase{
+		log:      log,
+		storage:  storage,
+		listener: listener,
+	}
+}
+
+func (p *PlansUseCase) Plans(ctx context.Context) ([]*entity.Plan, error) {
+	const op = "PlansUseCase.Plans"
+
+	plans, err := p.storage.Plans(ctx)
+	if err != nil {
+		p.log.Error("failed to get plans", sl.Error(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return plans, nil
+}
+
+func (p *PlansUseCase) CreatePlan(ctx context.Context, plan *entity.Plan) error {
+	const op = "PlansUseCase.CreatePlan"
+
+	err := p.storage.CreatePlan(ctx, plan)
+	if err != nil {
+		if errors.Is(err, storage.ErrPlanExists) {
+			p.log.Error("plan already exists", slog.String("plan", plan.Name))
+			return fmt.Errorf("%s: %w", op, ErrPlanExists)
+		}
+
+		p.log.Error("failed to create plan", sl.Error(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UpdatePlan updates the plan and applies its new limits to the clients of the plan immediately.
+func (p *PlansUseCase) UpdatePlan(ctx context.Context, plan *entity.Plan) error {
+	const op = "PlansUseCase.UpdatePlan"
+
+	err := p.storage.UpdatePlan(ctx, plan)
+	if err != nil {
+		if errors.Is(err, storage.ErrPlanNotFound) {
+			p.log.Warn("plan was not found", slog.String("plan", plan.Name))
+			return fmt.Errorf("%s: %w", op, ErrPlanNotFound)
+		}
+
+		p.log.Error("failed to update plan", sl.Error(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The plan is already updated, so a failed propagation is not returned to the caller.
+	// The clients of the plan are evicted anyway and get the new limits once resolved again.
+	if err := p.listener.PlanChanged(ctx, plan.Name); err != nil {
+		p.log.Error("failed to propagate plan change", slog.String("plan", plan.Name), sl.Error(err))
+	}
+
+	return nil
+}
+
+// DeletePlan deletes the plan. A plan that is referenced by clients cannot be deleted.
+func (p *PlansUseCase) DeletePlan(ctx context.Context, name string) error {
+	const op = "PlansUseCase.DeletePlan"
+
+	err := p.storage.DeletePlan(ctx, name)
+	if err != nil {
+		if errors.Is(err, storage.ErrPlanNotFound) {
+			p.log.Warn("plan was not found", slog.String("plan", name))
+			return fmt.Errorf("%s: %w", op, ErrPlanNotFound)
+		}
+		if errors.Is(err, storage.ErrPlanInUse) {
+			p.log.Warn("plan is in use", slog.String("plan", name))
+			return fmt.Errorf("%s: %w", op, ErrPlanInUse)
+		}
+
+		p.log.Error("failed to delete plan", sl.Error(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// planStorageMock keeps the plans by name, the plans of inUse cannot be deleted.
+type planStorageMock struct {
+	mu    sync.Mutex
+	plans map[string]*entity.Plan
+	inUse map[string]bool
+	err   error
+}
+
+func (s *planStorageMock) Plans(context.Context) ([]*entity.Plan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	plans := make([]*entity.Plan, 0, len(s.plans))
+	for _, plan := range s.plans {
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+func (s *planStorageMock) CreatePlan(_ context.Context, plan *entity.Plan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.plans[plan.Name]; ok {
+		return storage.ErrPlanExists
+	}
+	s.plans[plan.Name] = plan
+	return nil
+}
+
+func (s *planStorageMock) UpdatePlan(_ context.Context, plan *entity.Plan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if _, ok := s.plans[plan.Name]; !ok {
+		return storage.ErrPlanNotFound
+	}
+	s.plans[plan.Name] = plan
+	return nil
+}
+
+func (s *planStorageMock) DeletePlan(_ context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.plans[name]; !ok {
+		return storage.ErrPlanNotFound
+	}
+	if s.inUse[name] {
+		return storage.ErrPlanInUse
+	}
+	delete(s.plans, name)
+	return nil
+}
+
+type planListenerMock struct {
+	changed []string
+	err     error
+}
+
+func (l *planListenerMock) PlanChanged(_ context.Context, plan string) error {
+	l.changed = append(l.changed, plan)
+	return l.err
+}
+
+func newTestPlans(plans ...*entity.Plan) (*PlansUseCase, *planStorageMock, *planListenerMock) {
+	planStorage := &planStorageMock{plans: make(map[string]*entity.Plan), inUse: make(map[string]bool)}
+	for _, plan := range plans {
+		planStorage.plans[plan.Name] = plan
+	}
+	listener := &planListenerMock{}
+	return NewPlans(slog.New(slog.NewTextHandler(io.Discard, nil)), planStorage, listener), planStorage, listener
+}
+
+func TestPlansUseCase_CreatePlan(t *testing.T) {
+	ctx := context.Background()
+	plans, _, _ := newTestPlans(&entity.Plan{Name: "pro", Capacity: 100, RatePerSecond: 10})
+
+	require.NoError(t, plans.CreatePlan(ctx, &entity.Plan{Name: "basic", Capacity: 10, RatePerSecond: 1}))
+	assert.ErrorIs(t, plans.CreatePlan(ctx, &entity.Plan{Name: "pro", Capacity: 1, RatePerSecond: 1}), ErrPlanExists)
+
+	all, err := plans.Plans(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+}
+
+func TestPlansUseCase_UpdatePlan(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Clients of the plan are notified", func(t *testing.T) {
+		plans, planStorage, listener := newTestPlans(&entity.Plan{Name: "pro", Capacity: 100, RatePerSecond: 10})
+
+		require.NoError(t, plans.UpdatePlan(ctx, &entity.Plan{Name: "pro", Capacity: 200, RatePerSecond: 20}))
+		assert.Equal(t, int32(200), planStorage.plans["pro"].Capacity)
+		assert.Equal(t, []string{"pro"}, listener.changed)
+	})
+
+	t.Run("Missing plan", func(t *testing.T) {
+		plans, _, listener := newTestPlans()
+
+		err := plans.UpdatePlan(ctx, &entity.Plan{Name: "pro", Capacity: 200, RatePerSecond: 20})
+		assert.ErrorIs(t, err, ErrPlanNotFound)
+		assert.Empty(t, listener.changed, "nothing is propagated if the plan is not updated")
+	})
+
+	t.Run("Storage failure", func(t *testing.T) {
+		plans, planStorage, listener := newTestPlans(&entity.Plan{Name: "pro", Capacity: 100, RatePerSecond: 10})
+		planStorage.err = errors.New("connection refused")
+
+		err := plans.UpdatePlan(ctx, &entity.Plan{Name: "pro", Capacity: 200, RatePerSecond: 20})
+		assert.ErrorIs(t, err, planStorage.err)
+		assert.Empty(t, listener.changed)
+	})
+
+	t.Run("Failed propagation", func(t *testing.T) {
+		plans, planStorage, listener := newTestPlans(&entity.Plan{Name: "pro", Capacity: 100, RatePerSecond: 10})
+		listener.err = errors.New("connection refused")
+
+		require.NoError(t, plans.UpdatePlan(ctx, &entity.Plan{Name: "pro", Capacity: 200, RatePerSecond: 20}),
+			"the plan is updated anyway")
+		assert.Equal(t, int32(200), planStorage.plans["pro"].Capacity)
+	})
+}
+
+func TestPlansUseCase_DeletePlan(t *testing.T) {
+	ctx := context.Background()
+	plans, planStorage, _ := newTestPlans(
+		&entity.Plan{Name: "pro", Capacity: 100, RatePerSecond: 10},
+		&entity.Plan{Name: "basic", Capacity: 10, RatePerSecond: 1},
+	)
+	planStorage.inUse["pro"] = true
+
+	assert.ErrorIs(t, plans.DeletePlan(ctx, "pro"), ErrPlanInUse)
+	assert.Contains(t, planStorage.plans, "pro", "a plan in use is kept")
+
+	require.NoError(t, plans.DeletePlan(ctx, "basic"))
+	assert.ErrorIs(t, plans.DeletePlan(ctx, "basic"), ErrPlanNotFound)
+}
@@ -0,0 +1,139 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+// PoliciesUseCase applies route- and method-scoped rate-limit policies on top of the client's bucket.
+type PoliciesUseCase struct {
+	log      *slog.Logger
+	policies []*entity.Policy
+	global   map[string]*entity.TokenBucket // policy name -> bucket shared by all clients
+	recorder ConsumptionRecorder
+}
+
+// ConsumptionRecorder records the tokens spent from the client's bucket, e.g. to share them with other instances.
+type ConsumptionRecorder interface {
+	Record(client *entity.Client, n int32)
+}
+
+// NewPolicies creates a new PoliciesUseCase. The recorder can be nil.
+func NewPolicies(log *slog.Logger, policies []*entity.Policy, recorder ConsumptionRecorder) *PoliciesUseCase {
+	global := make(map[string]*entity.TokenBucket)
+	for _, policy := range policies {
+		if policy.Scope != entity.PolicyScopeGlobal && policy.Scope != entity.PolicyScopeClient {
+			log.Warn("unknown policy scope, using client scope",
+				slog.String("policy", policy.Name),
+				slog.String("scope", string(policy.Scope)),
+			)
+			policy.Scope = entity.PolicyScopeClient
+		}
+		if policy.Scope == entity.PolicyScopeGlobal && policy.HasBucket() {
+			global[policy.Name] = entity.NewTokenBucket(policy.Capacity, policy.RatePerSecond)
+		}
+	}
+
+	return &PoliciesUseCase{
+		log:      log,
+		policies: policies,
+		global:   global,
+		recorder: recorder,
+	}
+}
+
+// Allow reports whether the client is allowed to make a request with the given method and path.
+//
+// The request costs the highest cost among the matching policies (1 if none match) and has to pass
+// through the client's bucket and the buckets of every matching policy. Either all of the buckets
+// are charged or none of them.
+func (p *PoliciesUseCase) Allow(client *entity.Client, method, path string) bool {
+	var (
+		cost    int32 = 1
+		matched []*entity.Policy
+	)
+	for _, policy := range p.policies {
+		if policy.Matches(method, path) {
+			matched = append(matched, policy)
+			cost = max(cost, client.PolicyCost(policy))
+		}
+	}
+
+	if !client.AllowN(cost) {
+		return false
+	}
+
+	type charge struct {
+		bucket *entity.TokenBucket
+		cost   int32
+	}
+	charged := make([]charge, 0, len(matched))
+
+	for _, policy := range matched {
+		bucket := p.bucket(client, policy)
+		if bucket == nil {
+			continue
+		}
+
+		policyCost := client.PolicyCost(policy)
+		if !bucket.AllowN(policyCost) {
+			p.log.Debug("policy limit exceeded",
+				slog.String("policy", policy.Name),
+				slog.String("ip_address", client.IPAddress),
+			)
+
+			client.Refund(cost)
+			for _, c := range charged {
+				c.bucket.Refund(c.cost)
+			}
+			return false
+		}
+
+		charged = append(charged, charge{bucket: bucket, cost: policyCost})
+	}
+
+	if p.recorder != nil {
+		p.recorder.Record(client, cost)
+	}
+
+	return true
+}
+
+// bucket returns the bucket the request matching the policy has to pass through, or nil if there is none.
+func (p *PoliciesUseCase) bucket(client *entity.Client, policy *entity.Policy) *entity.TokenBucket {
+	if policy.Scope == entity.PolicyScopeGlobal {
+		return p.global[policy.Name]
+	}
+
+	if !policy.HasBucket() && client.PolicyOverrides[policy.Name].Capacity <= 0 {
+		return nil
+	}
+
+	return client.PolicyBucket(policy)
+}
+
+// StartTokenRefiller refills the buckets of the global policies once per second.
+// Buckets of the client-scoped policies are refilled together with the client.
+func (p *PoliciesUseCase) StartTokenRefiller(ctx context.Context) {
+	if len(p.global) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, bucket := range p.global {
+				bucket.RefillOncePerSecond()
+			}
+		case <-ctx.Done():
+			p.log.Info("policy token refiller is terminated due to context cancellation")
+			return
+		}
+	}
+}
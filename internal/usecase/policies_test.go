@@ -0,0 +1,72 @@
+package usecase
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(capacity int32) *entity.Client {
+	client := &entity.Client{IPAddress: "192.168.1.1", Capacity: capacity, RatePerSecond: 1}
+	client.Tokens.Store(capacity)
+	return client
+}
+
+func TestPoliciesUseCase_Allow(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("Request costs the highest cost of matching policies", func(t *testing.T) {
+		policies := NewPolicies(logger, []*entity.Policy{
+			{Name: "search", Methods: []string{"POST"}, Path: "/search", Cost: 5, Scope: entity.PolicyScopeClient},
+			{Name: "static", Methods: []string{"GET"}, Path: "/static/**", Cost: 1, Scope: entity.PolicyScopeClient},
+		}, nil)
+		client := newTestClient(10)
+
+		assert.True(t, policies.Allow(client, "POST", "/search"))
+		assert.Equal(t, int32(5), client.Tokens.Load())
+
+		assert.True(t, policies.Allow(client, "GET", "/static/app.js"))
+		assert.Equal(t, int32(4), client.Tokens.Load())
+
+		// Cost of the unmatched request is 1.
+		assert.True(t, policies.Allow(client, "GET", "/"))
+		assert.Equal(t, int32(3), client.Tokens.Load())
+
+		assert.False(t, policies.Allow(client, "POST", "/search"))
+		assert.Equal(t, int32(3), client.Tokens.Load())
+	})
+
+	t.Run("Global bucket is shared by clients", func(t *testing.T) {
+		policies := NewPolicies(logger, []*entity.Policy{
+			{Name: "search", Path: "/search", Cost: 1, Scope: entity.PolicyScopeGlobal, Capacity: 2},
+		}, nil)
+		client1 := newTestClient(10)
+		client2 := newTestClient(10)
+
+		assert.True(t, policies.Allow(client1, "GET", "/search"))
+		assert.True(t, policies.Allow(client2, "GET", "/search"))
+		assert.False(t, policies.Allow(client1, "GET", "/search"))
+
+		// The client's bucket is refunded when a policy bucket rejects the request.
+		assert.Equal(t, int32(9), client1.Tokens.Load())
+		assert.Equal(t, int32(9), client2.Tokens.Load())
+	})
+
+	t.Run("Client override of policy bucket and cost", func(t *testing.T) {
+		policies := NewPolicies(logger, []*entity.Policy{
+			{Name: "search", Path: "/search", Cost: 1, Scope: entity.PolicyScopeClient, Capacity: 1},
+		}, nil)
+		client := newTestClient(100)
+		client.PolicyOverrides = map[string]entity.PolicyOverride{
+			"search": {Capacity: 6, Cost: 3},
+		}
+
+		assert.True(t, policies.Allow(client, "GET", "/search"))
+		assert.True(t, policies.Allow(client, "GET", "/search"))
+		assert.False(t, policies.Allow(client, "GET", "/search"))
+		assert.Equal(t, int32(94), client.Tokens.Load())
+	})
+}
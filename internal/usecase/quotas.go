@@ -0,0 +1,287 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/sl"
+	"golang.org/x/sync/singleflight"
+)
+
+type QuotaStorage interface {
+	QuotaUsage(ctx context.Context, key string, periodStart time.Time) (int64, error)
+	QuotaUsages(ctx context.Context, keys []entity.QuotaUsageKey) ([]int64, error)
+	AddQuotaUsage(ctx context.Context, deltas []entity.QuotaUsageDelta) error
+}
+
+type QuotaClientProvider interface {
+	Client(ctx context.Context, ipAdress string) (*entity.Client, error)
+}
+
+// QuotasUseCase counts the requests of clients within calendar periods (hour, day, month).
+//
+// Counters are kept in memory and persisted to the storage in batches, so the usage survives restarts
+// without a database write per request. The usage of a period is loaded from the storage
+// on the first request of a client within that period and is re-read on every flush,
+// so the instances sharing the storage see the usage of each other within a flush interval.
+type QuotasUseCase struct {
+	log           *slog.Logger
+	storage       QuotaStorage
+	clients       QuotaClientProvider
+	location      *time.Location
+	flushInterval time.Duration
+	now           func() time.Time
+
+	loads    singleflight.Group
+	flushMu  sync.Mutex
+	counters sync.Map // string (key and period start) -> *quotaCounter
+}
+
+type quotaCounter struct {
+	key         string
+	periodStart time.Time
+	periodEnd   time.Time
+	used        atomic.Int64 // total usage in the period, including the persisted one
+	pending     atomic.Int64 // usage that is not persisted yet
+}
+
+func NewQuotas(log *slog.Logger, storage QuotaStorage, clients QuotaClientProvider, location *time.Location, flushInterval time.Duration) *QuotasUseCase {
+	return &QuotasUseCase{
+		log:           log,
+		storage:       storage,
+		clients:       clients,
+		location:      location,
+		flushInterval: flushInterval,
+		now:           time.Now,
+	}
+}
+
+// Consume counts a request of the client if its quota allows it.
+// It returns the usage of the quota after the request and whether the request is allowed.
+// If the usage of the period can not be loaded, it returns the error and the usage is loaded again on the next request.
+func (q *QuotasUseCase) Consume(ctx context.Context, client *entity.Client) (*entity.QuotaUsage, bool, error) {
+	const op = "QuotasUseCase.Consume"
+
+	counter, err := q.counter(ctx, client, q.now())
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for {
+		used := counter.used.Load()
+		if used >= client.QuotaLimit {
+			return q.usage(client, counter.periodStart, used), false, nil
+		}
+		if counter.used.CompareAndSwap(used, used+1) {
+			counter.pending.Add(1)
+			return q.usage(client, counter.periodStart, used+1), true, nil
+		}
+	}
+}
+
+// Refund takes back a request counted by Consume, for example when it is rejected later and never reaches a backend.
+// usage is the one returned by Consume.
+func (q *QuotasUseCase) Refund(client *entity.Client, usage *entity.QuotaUsage) {
+	v, ok := q.counters.Load(counterKey(client.BucketKey(), usage.PeriodStart))
+	if !ok {
+		return
+	}
+
+	counter := v.(*quotaCounter)
+	counter.used.Add(-1)
+	counter.pending.Add(-1)
+}
+
+// Usage returns the usage of the quota of the client with the given IP address or CIDR range.
+func (q *QuotasUseCase) Usage(ctx context.Context, ipAddress string) (*entity.QuotaUsage, error) {
+	const op = "QuotasUseCase.Usage"
+
+	client, err := q.clients.Client(ctx, ipAddress)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !client.HasQuota() {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoQuota)
+	}
+
+	periodStart := client.QuotaPeriod.Start(q.now(), q.location)
+
+	if v, ok := q.counters.Load(counterKey(client.BucketKey(), periodStart)); ok {
+		return q.usage(client, periodStart, v.(*quotaCounter).used.Load()), nil
+	}
+
+	used, err := q.storage.QuotaUsage(ctx, client.BucketKey(), periodStart)
+	if err != nil {
+		q.log.Error("failed to get quota usage", sl.Error(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return q.usage(client, periodStart, used), nil
+}
+
+// StartFlusher persists the usage every flush interval until the context is cancelled.
+func (q *QuotasUseCase) StartFlusher(ctx context.Context) {
+	ticker := time.NewTicker(q.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			q.Flush(ctx)
+		case <-ctx.Done():
+			q.log.Info("quota flusher is terminated due to context cancellation")
+			return
+		}
+	}
+}
+
+// Flush persists the usage counted since the previous flush, forgets the counters of finished periods
+// and re-reads the usage of the others, which includes the usage persisted by the other instances.
+func (q *QuotasUseCase) Flush(ctx context.Context) {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	now := q.now()
+	deltas := make([]entity.QuotaUsageDelta, 0)
+	flushed := make([]*quotaCounter, 0)
+	live := make([]*quotaCounter, 0)
+
+	q.counters.Range(func(k, v any) bool {
+		counter := v.(*quotaCounter)
+
+		n := counter.pending.Swap(0)
+		if n == 0 {
+			if now.After(counter.periodEnd) {
+				q.counters.Delete(k)
+			} else {
+				live = append(live, counter)
+			}
+			return true
+		}
+		live = append(live, counter)
+
+		deltas = append(deltas, entity.QuotaUsageDelta{
+			Key:         counter.key,
+			PeriodStart: counter.periodStart,
+			Used:        n,
+		})
+		flushed = append(flushed, counter)
+		return true
+	})
+
+	if len(deltas) > 0 {
+		err := q.storage.AddQuotaUsage(ctx, deltas)
+		if err != nil {
+			q.log.Error("failed to persist quota usage", slog.Int("counters", len(deltas)), sl.Error(err))
+
+			// Keep the usage to persist it during the next flush.
+			for i, counter := range flushed {
+				counter.pending.Add(deltas[i].Used)
+			}
+			return
+		}
+
+		q.log.Debug("quota usage is persisted", slog.Int("counters", len(deltas)))
+	}
+
+	q.sync(ctx, live)
+}
+
+// sync sets the usage of the counters to the persisted one and the usage counted since the last flush.
+func (q *QuotasUseCase) sync(ctx context.Context, counters []*quotaCounter) {
+	if len(counters) == 0 {
+		return
+	}
+
+	keys := make([]entity.QuotaUsageKey, len(counters))
+	for i, counter := range counters {
+		keys[i] = entity.QuotaUsageKey{Key: counter.key, PeriodStart: counter.periodStart}
+	}
+
+	persisted, err := q.storage.QuotaUsages(ctx, keys)
+	if err != nil {
+		q.log.Error("failed to sync quota usage", slog.Int("counters", len(keys)), sl.Error(err))
+		return
+	}
+
+	for i, counter := range counters {
+		counter.sync(persisted[i])
+	}
+}
+
+// sync sets the usage to the persisted one and the usage that is not persisted yet.
+// A request counted concurrently is either included or the loop is repeated.
+func (c *quotaCounter) sync(persisted int64) {
+	for {
+		pending := c.pending.Load()
+		used := c.used.Load()
+		if c.used.CompareAndSwap(used, persisted+pending) && c.pending.Load() == pending {
+			return
+		}
+	}
+}
+
+// counter returns the counter of the client for the period containing now, loading the usage from the storage if needed.
+// A counter whose usage can not be loaded is not kept, so the next request loads it again.
+func (q *QuotasUseCase) counter(ctx context.Context, client *entity.Client, now time.Time) (*quotaCounter, error) {
+	key := client.BucketKey()
+	periodStart := client.QuotaPeriod.Start(now, q.location)
+	ck := counterKey(key, periodStart)
+
+	if v, ok := q.counters.Load(ck); ok {
+		return v.(*quotaCounter), nil
+	}
+
+	// Concurrent first requests of a client within a period read the usage only once.
+	v, err, _ := q.loads.Do(ck, func() (any, error) {
+		if v, ok := q.counters.Load(ck); ok {
+			return v, nil
+		}
+
+		// The load is shared, so it does not stop with the request that has started it.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedLoadTimeout)
+		defer cancel()
+
+		used, err := q.storage.QuotaUsage(ctx, key, periodStart)
+		if err != nil {
+			q.log.Error("failed to load quota usage", slog.String("key", key), sl.Error(err))
+			return nil, err
+		}
+
+		counter := &quotaCounter{
+			key:         key,
+			periodStart: periodStart,
+			periodEnd:   client.QuotaPeriod.End(periodStart),
+		}
+		counter.used.Store(used)
+
+		v, _ := q.counters.LoadOrStore(ck, counter)
+		return v, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*quotaCounter), nil
+}
+
+func (q *QuotasUseCase) usage(client *entity.Client, periodStart time.Time, used int64) *entity.QuotaUsage {
+	return &entity.QuotaUsage{
+		IPAddress:   client.BucketKey(),
+		Period:      client.QuotaPeriod,
+		Limit:       client.QuotaLimit,
+		Used:        used,
+		Remaining:   max(client.QuotaLimit-used, 0),
+		PeriodStart: periodStart,
+		ResetsAt:    client.QuotaPeriod.End(periodStart),
+	}
+}
+
+func counterKey(key string, periodStart time.Time) string {
+	return fmt.Sprintf("%s@%d", key, periodStart.Unix())
+}
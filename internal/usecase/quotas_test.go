@@ -0,0 +1,237 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type quotaStorageMock struct {
+	mu    sync.Mutex
+	used  map[entity.QuotaUsageKey]int64
+	err   error
+	loads int
+}
+
+func (s *quotaStorageMock) QuotaUsage(ctx context.Context, key string, periodStart time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loads++
+	if s.err != nil {
+		return 0, s.err
+	}
+	// The reads fail with the error of a cancelled context, as the database does.
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.used[entity.QuotaUsageKey{Key: key, PeriodStart: periodStart}], nil
+}
+
+func (s *quotaStorageMock) QuotaUsages(_ context.Context, keys []entity.QuotaUsageKey) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	used := make([]int64, len(keys))
+	for i, key := range keys {
+		used[i] = s.used[key]
+	}
+	return used, nil
+}
+
+func (s *quotaStorageMock) AddQuotaUsage(_ context.Context, deltas []entity.QuotaUsageDelta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	for _, delta := range deltas {
+		s.used[entity.QuotaUsageKey{Key: delta.Key, PeriodStart: delta.PeriodStart}] += delta.Used
+	}
+	return nil
+}
+
+func (s *quotaStorageMock) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+func newTestQuotas(now *time.Time, storage QuotaStorage) *QuotasUseCase {
+	quotas := NewQuotas(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, nil, time.UTC, time.Second)
+	quotas.now = func() time.Time { return *now }
+	return quotas
+}
+
+func newQuotaClient(limit int64) *entity.Client {
+	return &entity.Client{IPAddress: "10.0.0.1", QuotaPeriod: entity.QuotaPeriodHour, QuotaLimit: limit}
+}
+
+func TestQuotasUseCase_Consume(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 10, 30, 0, 0, time.UTC)
+	hour := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	storage := &quotaStorageMock{used: map[entity.QuotaUsageKey]int64{{Key: "10.0.0.1", PeriodStart: hour}: 1}}
+	quotas := newTestQuotas(&now, storage)
+	client := newQuotaClient(3)
+
+	usage, allowed, err := quotas.Consume(ctx, client)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(2), usage.Used, "the persisted usage is loaded")
+	assert.Equal(t, int64(1), usage.Remaining)
+	assert.Equal(t, hour.Add(time.Hour), usage.ResetsAt)
+
+	_, allowed, err = quotas.Consume(ctx, client)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	usage, allowed, err = quotas.Consume(ctx, client)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Zero(t, usage.Remaining)
+	assert.Equal(t, 1, storage.loads, "the usage is loaded once per period")
+
+	// The next period starts from zero.
+	now = now.Add(time.Hour)
+	usage, allowed, err = quotas.Consume(ctx, client)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(1), usage.Used)
+}
+
+func TestQuotasUseCase_Consume_LoadFailure(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 10, 30, 0, 0, time.UTC)
+	hour := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	storage := &quotaStorageMock{used: map[entity.QuotaUsageKey]int64{{Key: "10.0.0.1", PeriodStart: hour}: 3}}
+	storage.fail(errors.New("connection refused"))
+	quotas := newTestQuotas(&now, storage)
+	client := newQuotaClient(3)
+
+	_, allowed, err := quotas.Consume(ctx, client)
+	require.Error(t, err)
+	assert.False(t, allowed)
+
+	// The failed load is not cached, the next request loads the usage again.
+	storage.fail(nil)
+	_, allowed, err = quotas.Consume(ctx, client)
+	require.NoError(t, err)
+	assert.False(t, allowed, "the persisted usage is not lost")
+	assert.Equal(t, 2, storage.loads)
+}
+
+func TestQuotasUseCase_Consume_CancelledRequest(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 30, 0, 0, time.UTC)
+
+	storage := &quotaStorageMock{used: make(map[entity.QuotaUsageKey]int64)}
+	quotas := newTestQuotas(&now, storage)
+
+	// The load may be shared by other first requests of the period, so it does not stop with the request.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, allowed, err := quotas.Consume(ctx, newQuotaClient(3))
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestQuotasUseCase_Refund(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 10, 30, 0, 0, time.UTC)
+	hour := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	storage := &quotaStorageMock{used: make(map[entity.QuotaUsageKey]int64)}
+	quotas := newTestQuotas(&now, storage)
+	client := newQuotaClient(1)
+
+	usage, allowed, err := quotas.Consume(ctx, client)
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	quotas.Refund(client, usage)
+
+	_, allowed, err = quotas.Consume(ctx, client)
+	require.NoError(t, err)
+	assert.True(t, allowed, "the refunded request is not counted")
+
+	quotas.Refund(client, usage)
+	quotas.Flush(ctx)
+	assert.Zero(t, storage.used[entity.QuotaUsageKey{Key: "10.0.0.1", PeriodStart: hour}])
+}
+
+func TestQuotasUseCase_Flush(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 10, 30, 0, 0, time.UTC)
+	hour := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	storage := &quotaStorageMock{used: make(map[entity.QuotaUsageKey]int64)}
+	quotas := newTestQuotas(&now, storage)
+	client := newQuotaClient(10)
+
+	for range 3 {
+		_, _, err := quotas.Consume(ctx, client)
+		require.NoError(t, err)
+	}
+
+	// A failed flush keeps the usage for the next one.
+	storage.fail(errors.New("connection refused"))
+	quotas.Flush(ctx)
+	storage.fail(nil)
+	quotas.Flush(ctx)
+	assert.Equal(t, int64(3), storage.used[entity.QuotaUsageKey{Key: "10.0.0.1", PeriodStart: hour}])
+
+	quotas.Flush(ctx)
+	assert.Equal(t, int64(3), storage.used[entity.QuotaUsageKey{Key: "10.0.0.1", PeriodStart: hour}], "the usage is persisted once")
+
+	// The counters of finished periods are forgotten.
+	now = now.Add(time.Hour)
+	quotas.Flush(ctx)
+	_, ok := quotas.counters.Load(counterKey("10.0.0.1", hour))
+	assert.False(t, ok)
+}
+
+func TestQuotasUseCase_Flush_SyncsInstances(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 10, 30, 0, 0, time.UTC)
+
+	storage := &quotaStorageMock{used: make(map[entity.QuotaUsageKey]int64)}
+	first := newTestQuotas(&now, storage)
+	second := newTestQuotas(&now, storage)
+	client := newQuotaClient(4)
+
+	for _, quotas := range []*QuotasUseCase{first, second} {
+		for range 2 {
+			_, allowed, err := quotas.Consume(ctx, client)
+			require.NoError(t, err)
+			require.True(t, allowed)
+		}
+	}
+
+	first.Flush(ctx)
+	second.Flush(ctx)
+
+	// The first instance has not seen the usage of the second one until it flushes again.
+	first.Flush(ctx)
+	for _, quotas := range []*QuotasUseCase{first, second} {
+		usage, allowed, err := quotas.Consume(ctx, client)
+		require.NoError(t, err)
+		assert.False(t, allowed, "the quota is shared by the instances")
+		assert.Equal(t, int64(4), usage.Used)
+	}
+}
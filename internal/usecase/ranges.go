@@ -0,0 +1,148 @@
+package usecase
+
+import (
+	"net/netip"
+	"sync"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/radix"
+)
+
+// clientRanges holds clients defined by CIDR ranges and resolves addresses
+// to them using longest-prefix matching.
+type clientRanges struct {
+	mu   sync.RWMutex
+	tree *radix.Tree[*entity.Client]
+}
+
+func newClientRanges() *clientRanges {
+	return &clientRanges{
+		tree: radix.New[*entity.Client](),
+	}
+}
+
+// lookup returns the most specific range that contains the given prefix.
+func (r *clientRanges) lookup(prefix netip.Prefix) (*entity.Client, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	client, _, ok := r.tree.LookupPrefix(prefix)
+	return client, ok
+}
+
+// get returns the range exactly matching the prefix.
+func (r *clientRanges) get(prefix netip.Prefix) (*entity.Client, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.tree.Get(prefix)
+}
+
+// set adds or replaces the range. A replacing range continues with the tokens and the limiters of the replaced one.
+func (r *clientRanges) set(prefix netip.Prefix, client *entity.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if old, ok := r.tree.Get(prefix); ok && old != client {
+		client.InheritTokens(old.Tokens.Load(), old.Capacity)
+		client.InheritLimiters(old.Limiters())
+	}
+	r.tree.Insert(prefix, client)
+}
+
+func (r *clientRanges) delete(prefix netip.Prefix) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tree.Delete(prefix)
+}
+
+// replaceAll replaces all ranges with the given ones. Ranges that were present before
+// continue with their tokens.
+func (r *clientRanges) replaceAll(clients map[netip.Prefix]*entity.Client) {
+	tree := radix.New[*entity.Client]()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for prefix, client := range clients {
+		if old, ok := r.tree.Get(prefix); ok && old != client {
+			client.InheritTokens(old.Tokens.Load(), old.Capacity)
+			client.InheritLimiters(old.Limiters())
+		}
+		tree.Insert(prefix, client)
+	}
+
+	r.tree = tree
+}
+
+// sync replaces all ranges with the given ones and returns the prefixes of the ranges that were added,
+// removed or changed. Unchanged ranges are kept as they are, so their buckets and the clients
+// that share them stay valid.
+func (r *clientRanges) sync(clients map[netip.Prefix]*entity.Client) []netip.Prefix {
+	tree := radix.New[*entity.Client]()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var changed []netip.Prefix
+	r.tree.Walk(func(prefix netip.Prefix, _ *entity.Client) bool {
+		if _, ok := clients[prefix]; !ok {
+			changed = append(changed, prefix)
+		}
+		return true
+	})
+
+	for prefix, client := range clients {
+		old, ok := r.tree.Get(prefix)
+		switch {
+		case !ok:
+			changed = append(changed, prefix)
+		case old.SameConfig(client):
+			client = old
+		default:
+			client.InheritTokens(old.Tokens.Load(), old.Capacity)
+			client.InheritLimiters(old.Limiters())
+			changed = append(changed, prefix)
+		}
+		tree.Insert(prefix, client)
+	}
+
+	r.tree = tree
+
+	return changed
+}
+
+// hasPlan reports whether any range references the plan.
+func (r *clientRanges) hasPlan(plan string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	found := false
+	r.tree.Walk(func(_ netip.Prefix, client *entity.Client) bool {
+		found = client.Plan == plan
+		return !found
+	})
+	return found
+}
+
+func (r *clientRanges) len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.tree.Len()
+}
+
+// refillShared refills the buckets of the ranges whose addresses share a single bucket.
+// Buckets of per-IP ranges live in the clients resolved from them and are refilled by the cache.
+func (r *clientRanges) refillShared() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	r.tree.Walk(func(_ netip.Prefix, client *entity.Client) bool {
+		if client.LimitMode != entity.LimitModePerIP {
+			client.RefillTokensOncePerSecond()
+		}
+		return true
+	})
+}
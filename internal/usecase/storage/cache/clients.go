@@ -3,97 +3,453 @@ package cache
 import (
 	"container/list"
 	"context"
+	"errors"
 	"log/slog"
+	"math/rand/v2"
 	"sync"
 	"time"
 
 	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/metrics"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
+	"golang.org/x/sync/singleflight"
 )
 
-type LRUClientCache struct {
+// Options configures ClientsCache.
+type Options struct {
+	// MaxElements is the maximum number of cached clients and, separately, of cached missing keys.
+	MaxElements int
+	// Policy is the eviction policy of the clients, LRU by default. Missing keys are always evicted in the LRU order.
+	Policy Policy
+	// TTL is how long a client stays in the cache, 0 means until it is evicted.
+	TTL time.Duration
+	// TTLJitter adds a random duration up to TTLJitter to the TTL of every client,
+	// so the clients cached at the same time do not expire at once.
+	TTLJitter time.Duration
+	// NegativeTTL is how long a key that is not found stays in the cache, 0 disables the negative cache.
+	NegativeTTL time.Duration
+	// Stats counts the hits, misses and evictions, it may be shared by several caches. Nil means the stats are not exposed.
+	Stats *Stats
+	// States keeps the buckets of the clients that leave the cache, it may be shared by several caches.
+	// Nil means a client loaded again gets a full bucket.
+	States *BucketStates
+}
+
+// Stats counts the hits, misses and evictions of a cache.
+// A key cached as missing counts as a hit, since it is not looked up in the storage.
+type Stats struct {
+	Hits      *metrics.Counter
+	Misses    *metrics.Counter
+	Evictions *metrics.Counter
+}
+
+// NewStats registers the counters of a cache of clients.
+func NewStats(registry *metrics.Registry) *Stats {
+	return &Stats{
+		Hits:      registry.Counter("lb_clients_cache_hits_total", "Lookups of clients served by the cache."),
+		Misses:    registry.Counter("lb_clients_cache_misses_total", "Lookups of clients not found in the cache."),
+		Evictions: registry.Counter("lb_clients_cache_evictions_total", "Clients evicted from the cache or not admitted to it by the eviction policy."),
+	}
+}
+
+func newUnregisteredStats() *Stats {
+	return &Stats{
+		Hits:      new(metrics.Counter),
+		Misses:    new(metrics.Counter),
+		Evictions: new(metrics.Counter),
+	}
+}
+
+// ClientsCache is a cache of clients of a limited size with the eviction policy chosen by Options.Policy.
+//
+// This type is concurrently safe.
+type ClientsCache struct {
 	log         *slog.Logger
 	maxElements int
-	mu          *sync.Mutex
-	list        *list.List                // least frequently used - the back of the list
-	items       map[string]*list.Element  // string (ipAdress) -> element in list
-	cache       map[string]*entity.Client // string (ipAdress) -> *entity.Client
+	ttl         time.Duration
+	ttlJitter   time.Duration
+	negativeTTL time.Duration
+	stats       *Stats
+	states      *BucketStates
+	now         func() time.Time
+
+	mu      *sync.Mutex
+	policy  evictionPolicy
+	cache   map[string]*entry        // string (ipAdress) -> cached client
+	missing *list.List               // least recently missed - the back of the list
+	misses  map[string]*list.Element // string (ipAdress) -> element in missing holding *miss
+	loading map[string]*pendingLoad  // string (ipAdress) -> load in progress
+
+	loads singleflight.Group
+}
+
+// LRUClientCache is the former name of ClientsCache, whose default eviction policy is LRU.
+type LRUClientCache = ClientsCache
+
+type entry struct {
+	client    *entity.Client
+	expiresAt time.Time // zero if the client does not expire
+}
+
+type miss struct {
+	ipAddress string
+	expiresAt time.Time
+}
+
+// pendingLoad is a load of a key in progress. It is invalidated by the writes to the key made during the load,
+// so the loaded result, which may be older than the write, is not cached.
+type pendingLoad struct {
+	invalidated bool
 }
 
-func NewClientsCache(log *slog.Logger, maxElements int) *LRUClientCache {
-	if maxElements < 0 {
-		maxElements = 0
+func NewClientsCache(log *slog.Logger, opts Options) *ClientsCache {
+	maxElements := max(opts.MaxElements, 0)
+
+	stats := opts.Stats
+	if stats == nil {
+		stats = newUnregisteredStats()
 	}
 
-	return &LRUClientCache{
+	return &ClientsCache{
 		log:         log,
 		maxElements: maxElements,
+		ttl:         opts.TTL,
+		ttlJitter:   opts.TTLJitter,
+		negativeTTL: opts.NegativeTTL,
+		stats:       stats,
+		states:      opts.States,
+		now:         time.Now,
 		mu:          new(sync.Mutex),
-		list:        list.New(),
-		items:       make(map[string]*list.Element),
-		cache:       make(map[string]*entity.Client),
+		policy:      newEvictionPolicy(opts.Policy, maxElements),
+		cache:       make(map[string]*entry),
+		missing:     list.New(),
+		misses:      make(map[string]*list.Element),
+		loading:     make(map[string]*pendingLoad),
 	}
 }
 
-func (c *LRUClientCache) Client(ipAddress string) (*entity.Client, bool) {
+func (c *ClientsCache) Client(ipAddress string) (*entity.Client, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	el, ok := c.items[ipAddress]
+	client, ok := c.clientLocked(ipAddress)
 	if ok {
-		c.list.MoveToFront(el)
-		return c.cache[ipAddress], true
+		c.stats.Hits.Inc()
+	} else {
+		c.stats.Misses.Inc()
 	}
 
-	return nil, false
+	return client, ok
 }
 
-func (c *LRUClientCache) UpdateClient(client *entity.Client) {
-	if c.maxElements == 0 {
+func (c *ClientsCache) clientLocked(ipAddress string) (*entity.Client, bool) {
+	e, ok := c.cache[ipAddress]
+	if !ok {
+		return nil, false
+	}
+
+	if !e.expiresAt.IsZero() && !c.now().Before(e.expiresAt) {
+		c.deleteLocked(ipAddress)
+		return nil, false
+	}
+
+	c.policy.Access(ipAddress)
+	return e.client, true
+}
+
+// Peek returns the cached client without counting a lookup, so it neither affects the eviction order nor the stats.
+func (c *ClientsCache) Peek(ipAddress string) (*entity.Client, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.cache[ipAddress]
+	if !ok || (!e.expiresAt.IsZero() && !c.now().Before(e.expiresAt)) {
+		return nil, false
+	}
+	return e.client, true
+}
+
+// Load returns the cached client or loads it with load if it is not cached.
+//
+// Concurrent loads of the same key share a single call of load. If load returns storage.ErrClientNotFound,
+// the key is cached as missing for NegativeTTL, and Load returns storage.ErrClientNotFound without calling load
+// until it expires or a client with this key is cached. Other errors are not cached.
+//
+// The result is not cached if the key is written (updated, deleted or purged) while it is loaded,
+// since the write may be newer than the result. The client cached by the write is returned instead, if any.
+func (c *ClientsCache) Load(ipAddress string, load func() (*entity.Client, error)) (*entity.Client, error) {
+	if client, ok, missing := c.lookup(ipAddress); ok {
+		c.stats.Hits.Inc()
+		return client, nil
+	} else if missing {
+		c.stats.Hits.Inc()
+		return nil, storage.ErrClientNotFound
+	}
+
+	c.stats.Misses.Inc()
+
+	v, err, _ := c.loads.Do(ipAddress, func() (any, error) {
+		// The key may have been loaded by a call that finished right before this one started.
+		if client, ok, missing := c.lookup(ipAddress); ok {
+			return client, nil
+		} else if missing {
+			return nil, storage.ErrClientNotFound
+		}
+
+		c.mu.Lock()
+		c.loading[ipAddress] = new(pendingLoad)
+		c.mu.Unlock()
+
+		client, err := load()
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		pending := c.loading[ipAddress]
+		delete(c.loading, ipAddress)
+		if pending.invalidated {
+			if cached, ok := c.clientLocked(ipAddress); ok {
+				return cached, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return client, nil
+		}
+
+		if err != nil {
+			if errors.Is(err, storage.ErrClientNotFound) {
+				c.setMissingLocked(ipAddress)
+			}
+			return nil, err
+		}
+
+		c.updateClientLocked(client)
+		return client, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*entity.Client), nil
+}
+
+// lookup returns the cached client, or reports whether the key is cached as missing.
+func (c *ClientsCache) lookup(ipAddress string) (client *entity.Client, ok bool, missing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.clientLocked(ipAddress); ok {
+		return client, true, false
+	}
+
+	el, ok := c.misses[ipAddress]
+	if !ok {
+		return nil, false, false
+	}
+
+	if !c.now().Before(el.Value.(*miss).expiresAt) {
+		c.missing.Remove(el)
+		delete(c.misses, ipAddress)
+		return nil, false, false
+	}
+
+	return nil, false, true
+}
+
+func (c *ClientsCache) setMissingLocked(ipAddress string) {
+	if c.maxElements == 0 || c.negativeTTL <= 0 {
+		return
+	}
+
+	expiresAt := c.now().Add(c.negativeTTL)
+
+	if el, ok := c.misses[ipAddress]; ok {
+		el.Value.(*miss).expiresAt = expiresAt
+		c.missing.MoveToFront(el)
 		return
 	}
 
+	if len(c.misses) >= c.maxElements {
+		delete(c.misses, c.missing.Remove(c.missing.Back()).(*miss).ipAddress)
+	}
+
+	c.misses[ipAddress] = c.missing.PushFront(&miss{ipAddress: ipAddress, expiresAt: expiresAt})
+}
+
+// UpdateClient caches the client for TTL. The key of the client is no longer cached as missing.
+//
+// The client continues with the tokens and the limiters (policy buckets and the concurrency semaphore)
+// of the client it replaces, or of its saved bucket state, with the tokens scaled to its capacity,
+// so neither an update nor an eviction resets the limits.
+func (c *ClientsCache) UpdateClient(client *entity.Client) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if el, ok := c.items[client.IPAddress]; ok {
-		c.cache[client.IPAddress] = client
-		c.list.MoveToFront(el)
+	c.invalidateLoadLocked(client.IPAddress)
+	c.updateClientLocked(client)
+}
+
+func (c *ClientsCache) updateClientLocked(client *entity.Client) {
+	if c.maxElements == 0 {
 		return
 	}
 
-	if len(c.items) >= c.maxElements {
-		ipAddress := c.list.Remove(c.list.Back()).(string)
-		delete(c.items, ipAddress)
-		delete(c.cache, ipAddress)
+	c.deleteMissingLocked(client.IPAddress)
+
+	e := &entry{client: client, expiresAt: c.expiresAt()}
+
+	if old, ok := c.cache[client.IPAddress]; ok {
+		if old.client != client && old.client.OwnsBucket() {
+			client.InheritTokens(old.client.Tokens.Load(), old.client.Capacity)
+			client.InheritLimiters(old.client.Limiters())
+		}
+		c.cache[client.IPAddress] = e
+		c.policy.Access(client.IPAddress)
+		return
+	}
+
+	c.states.Restore(client)
+
+	evicted, ok := c.policy.Add(client.IPAddress)
+	if ok {
+		c.stats.Evictions.Inc()
+		if evicted == client.IPAddress {
+			// The policy does not admit the client.
+			c.states.Save(client)
+			return
+		}
+		c.states.Save(c.cache[evicted].client)
+		delete(c.cache, evicted)
+	}
+
+	c.cache[client.IPAddress] = e
+}
+
+func (c *ClientsCache) expiresAt() time.Time {
+	if c.ttl <= 0 {
+		return time.Time{}
+	}
 
+	ttl := c.ttl
+	if c.ttlJitter > 0 {
+		ttl += rand.N(c.ttlJitter)
 	}
 
-	c.cache[client.IPAddress] = client
-	el := c.list.PushFront(client.IPAddress)
-	c.items[client.IPAddress] = el
+	return c.now().Add(ttl)
 }
 
-func (c *LRUClientCache) DeleteClient(ipAddress string) {
+// DeleteClient removes the client from the cache, the key is no longer cached as missing either.
+func (c *ClientsCache) DeleteClient(ipAddress string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if el, ok := c.items[ipAddress]; ok {
-		c.list.Remove(el)
-		delete(c.items, ipAddress)
+	c.invalidateLoadLocked(ipAddress)
+	c.deleteLocked(ipAddress)
+	c.deleteMissingLocked(ipAddress)
+}
+
+// invalidateLoadLocked makes the load of the key in progress, if any, not cache its result.
+func (c *ClientsCache) invalidateLoadLocked(ipAddress string) {
+	if pending, ok := c.loading[ipAddress]; ok {
+		pending.invalidated = true
+	}
+}
+
+// invalidateLoadsLocked makes all loads in progress not cache their results.
+func (c *ClientsCache) invalidateLoadsLocked() {
+	for _, pending := range c.loading {
+		pending.invalidated = true
+	}
+}
+
+func (c *ClientsCache) deleteLocked(ipAddress string) {
+	if e, ok := c.cache[ipAddress]; ok {
+		c.states.Save(e.client)
+		c.policy.Remove(ipAddress)
 		delete(c.cache, ipAddress)
 	}
 }
 
-func (c *LRUClientCache) refillAllClients() {
+func (c *ClientsCache) deleteMissingLocked(ipAddress string) {
+	if el, ok := c.misses[ipAddress]; ok {
+		c.missing.Remove(el)
+		delete(c.misses, ipAddress)
+	}
+}
+
+// Purge removes all clients and missing keys from the cache. The buckets of the clients are saved.
+func (c *ClientsCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidateLoadsLocked()
+	for _, e := range c.cache {
+		c.states.Save(e.client)
+	}
+	c.policy.Reset()
+	clear(c.cache)
+	c.missing.Init()
+	clear(c.misses)
+}
+
+// DeleteFunc removes all clients for which fn returns true. The clients being loaded are not known yet,
+// so none of them is cached.
+func (c *ClientsCache) DeleteFunc(fn func(client *entity.Client) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidateLoadsLocked()
+	for ipAddress, e := range c.cache {
+		if fn(e.client) {
+			c.deleteLocked(ipAddress)
+		}
+	}
+}
+
+// Clients returns the clients that are cached and not expired.
+func (c *ClientsCache) Clients() []*entity.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	clients := make([]*entity.Client, 0, len(c.cache))
+	for _, e := range c.cache {
+		if e.expiresAt.IsZero() || now.Before(e.expiresAt) {
+			clients = append(clients, e.client)
+		}
+	}
+
+	return clients
+}
+
+// DeleteMissingFunc removes all missing keys for which fn returns true.
+func (c *ClientsCache) DeleteMissingFunc(fn func(ipAddress string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for ipAddress := range c.loading {
+		if fn(ipAddress) {
+			c.invalidateLoadLocked(ipAddress)
+		}
+	}
+	for ipAddress := range c.misses {
+		if fn(ipAddress) {
+			c.deleteMissingLocked(ipAddress)
+		}
+	}
+}
+
+func (c *ClientsCache) refillAllClients() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	for _, client := range c.cache {
-		client.RefillTokensOncePerSecond()
+	for _, e := range c.cache {
+		e.client.RefillTokensOncePerSecond()
 	}
 }
 
-func (c *LRUClientCache) StartTokenRefiller(ctx context.Context) {
+func (c *ClientsCache) StartTokenRefiller(ctx context.Context) {
 	ticker := time.NewTicker(1 * time.Second)
 	c.log.Info("starting token refiller...")
 
@@ -1,13 +1,19 @@
 package cache
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewClientsCache(t *testing.T) {
@@ -36,15 +42,15 @@ func TestNewClientsCache(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-			cache := NewClientsCache(logger, tt.maxElements)
+			cache := NewClientsCache(logger, Options{MaxElements: tt.maxElements})
 
 			assert.NotNil(t, cache)
 			assert.Equal(t, tt.expected, cache.maxElements)
 			assert.NotNil(t, cache.mu)
-			assert.NotNil(t, cache.list)
-			assert.NotNil(t, cache.items)
+			assert.NotNil(t, lruOf(cache).list)
+			assert.NotNil(t, lruOf(cache).items)
 			assert.NotNil(t, cache.cache)
-			assert.Empty(t, cache.items)
+			assert.Empty(t, lruOf(cache).items)
 			assert.Empty(t, cache.cache)
 		})
 	}
@@ -52,7 +58,7 @@ func TestNewClientsCache(t *testing.T) {
 
 func TestLRUClientCache_Client(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-	cache := NewClientsCache(logger, 2)
+	cache := NewClientsCache(logger, Options{MaxElements: 2})
 
 	// Create test clients
 	client1 := &entity.Client{IPAddress: "192.168.1.1"}
@@ -100,7 +106,7 @@ func TestLRUClientCache_Client(t *testing.T) {
 
 	// Test that accessing a client moves it to the front of the list
 	// First, let's get the list back to a known state
-	cache = NewClientsCache(logger, 2)
+	cache = NewClientsCache(logger, Options{MaxElements: 2})
 	cache.UpdateClient(client1) // Most recently used
 	cache.UpdateClient(client2) // Least recently used
 
@@ -127,7 +133,7 @@ func TestLRUClientCache_UpdateClient(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	t.Run("Add clients without exceeding capacity", func(t *testing.T) {
-		cache := NewClientsCache(logger, 3)
+		cache := NewClientsCache(logger, Options{MaxElements: 3})
 
 		clients := []*entity.Client{
 			{IPAddress: "192.168.1.1"},
@@ -148,12 +154,12 @@ func TestLRUClientCache_UpdateClient(t *testing.T) {
 
 		// Verify the size of the cache
 		assert.Equal(t, 3, len(cache.cache))
-		assert.Equal(t, 3, len(cache.items))
-		assert.Equal(t, 3, cache.list.Len())
+		assert.Equal(t, 3, len(lruOf(cache).items))
+		assert.Equal(t, 3, lruOf(cache).list.Len())
 	})
 
 	t.Run("Add clients exceeding capacity", func(t *testing.T) {
-		cache := NewClientsCache(logger, 2)
+		cache := NewClientsCache(logger, Options{MaxElements: 2})
 
 		client1 := &entity.Client{IPAddress: "192.168.1.1"}
 		client2 := &entity.Client{IPAddress: "192.168.1.2"}
@@ -182,12 +188,12 @@ func TestLRUClientCache_UpdateClient(t *testing.T) {
 
 		// Verify the cache size remains the same
 		assert.Equal(t, 2, len(cache.cache))
-		assert.Equal(t, 2, len(cache.items))
-		assert.Equal(t, 2, cache.list.Len())
+		assert.Equal(t, 2, len(lruOf(cache).items))
+		assert.Equal(t, 2, lruOf(cache).list.Len())
 	})
 
 	t.Run("Add client with existing IP address", func(t *testing.T) {
-		cache := NewClientsCache(logger, 2)
+		cache := NewClientsCache(logger, Options{MaxElements: 2})
 
 		client1 := &entity.Client{IPAddress: "192.168.1.1"}
 		client2 := &entity.Client{IPAddress: "192.168.1.1", Tokens: atomic.Int32{}} // Same IP address as client1 but different properties
@@ -198,8 +204,8 @@ func TestLRUClientCache_UpdateClient(t *testing.T) {
 
 		// Verify the cache only contains one client (the updated one)
 		assert.Equal(t, 1, len(cache.cache))
-		assert.Equal(t, 1, len(cache.items))
-		assert.Equal(t, 1, cache.list.Len())
+		assert.Equal(t, 1, len(lruOf(cache).items))
+		assert.Equal(t, 1, lruOf(cache).list.Len())
 
 		// Verify the client in the cache is client2
 		cachedClient, found := cache.Client(client1.IPAddress)
@@ -208,7 +214,7 @@ func TestLRUClientCache_UpdateClient(t *testing.T) {
 	})
 
 	t.Run("Add client to cache with zero capacity", func(t *testing.T) {
-		cache := NewClientsCache(logger, 0)
+		cache := NewClientsCache(logger, Options{MaxElements: 0})
 
 		client := &entity.Client{IPAddress: "192.168.1.1"}
 		cache.UpdateClient(client)
@@ -219,65 +225,425 @@ func TestLRUClientCache_UpdateClient(t *testing.T) {
 
 		// Verify the cache is empty
 		assert.Equal(t, 0, len(cache.cache))
-		assert.Equal(t, 0, len(cache.items))
-		assert.Equal(t, 0, cache.list.Len())
+		assert.Equal(t, 0, len(lruOf(cache).items))
+		assert.Equal(t, 0, lruOf(cache).list.Len())
 	})
 }
 
 func TestLRUClientCache_DeleteClient(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-	cache := NewClientsCache(logger, 3)
-	
+	cache := NewClientsCache(logger, Options{MaxElements: 3})
+
 	// Create and add test clients
 	clients := []*entity.Client{
 		{IPAddress: "192.168.1.1"},
 		{IPAddress: "192.168.1.2"},
 		{IPAddress: "192.168.1.3"},
 	}
-	
+
 	for _, client := range clients {
 		cache.UpdateClient(client)
 	}
-	
+
 	t.Run("Delete existing client", func(t *testing.T) {
 		// Delete client2
 		cache.DeleteClient("192.168.1.2")
-		
+
 		// Verify client2 was deleted
 		_, found := cache.Client("192.168.1.2")
 		assert.False(t, found)
-		
+
 		// Verify the other clients are still there
 		_, found = cache.Client("192.168.1.1")
 		assert.True(t, found)
-		
+
 		_, found = cache.Client("192.168.1.3")
 		assert.True(t, found)
-		
+
 		// Verify the cache size
 		assert.Equal(t, 2, len(cache.cache))
-		assert.Equal(t, 2, len(cache.items))
-		assert.Equal(t, 2, cache.list.Len())
+		assert.Equal(t, 2, len(lruOf(cache).items))
+		assert.Equal(t, 2, lruOf(cache).list.Len())
 	})
-	
+
 	t.Run("Delete non-existing client", func(t *testing.T) {
 		// This should not panic
 		cache.DeleteClient("192.168.1.4")
-		
+
 		// Verify the cache size remains the same
 		assert.Equal(t, 2, len(cache.cache))
-		assert.Equal(t, 2, len(cache.items))
-		assert.Equal(t, 2, cache.list.Len())
+		assert.Equal(t, 2, len(lruOf(cache).items))
+		assert.Equal(t, 2, lruOf(cache).list.Len())
 	})
-	
+
 	t.Run("Delete last client", func(t *testing.T) {
 		// Delete client1 and client3
 		cache.DeleteClient("192.168.1.1")
 		cache.DeleteClient("192.168.1.3")
-		
+
 		// Verify the cache is empty
 		assert.Equal(t, 0, len(cache.cache))
-		assert.Equal(t, 0, len(cache.items))
-		assert.Equal(t, 0, cache.list.Len())
+		assert.Equal(t, 0, len(lruOf(cache).items))
+		assert.Equal(t, 0, lruOf(cache).list.Len())
+	})
+}
+
+func TestLRUClientCache_Purge(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	cache := NewClientsCache(logger, Options{MaxElements: 3})
+
+	cache.UpdateClient(&entity.Client{IPAddress: "192.168.1.1"})
+	cache.UpdateClient(&entity.Client{IPAddress: "192.168.1.2"})
+
+	cache.Purge()
+
+	_, found := cache.Client("192.168.1.1")
+	assert.False(t, found)
+	assert.Equal(t, 0, len(cache.cache))
+	assert.Equal(t, 0, len(lruOf(cache).items))
+	assert.Equal(t, 0, lruOf(cache).list.Len())
+
+	// The cache is still usable after purging.
+	cache.UpdateClient(&entity.Client{IPAddress: "192.168.1.3"})
+	_, found = cache.Client("192.168.1.3")
+	assert.True(t, found)
+}
+
+func TestLRUClientCache_DeleteFunc(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	cache := NewClientsCache(logger, Options{MaxElements: 3})
+
+	cache.UpdateClient(&entity.Client{IPAddress: "10.0.0.1"})
+	cache.UpdateClient(&entity.Client{IPAddress: "10.0.0.2"})
+	cache.UpdateClient(&entity.Client{IPAddress: "192.168.1.1"})
+
+	cache.DeleteFunc(func(client *entity.Client) bool {
+		return client.IPAddress != "192.168.1.1"
+	})
+
+	_, found := cache.Client("10.0.0.1")
+	assert.False(t, found)
+	_, found = cache.Client("192.168.1.1")
+	assert.True(t, found)
+
+	assert.Equal(t, 1, len(cache.cache))
+	assert.Equal(t, 1, len(lruOf(cache).items))
+	assert.Equal(t, 1, lruOf(cache).list.Len())
+}
+
+// fakeClock is a manually advanced clock for the expiration tests.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// lruOf returns the policy of a cache created with the default LRU policy.
+func lruOf(cache *LRUClientCache) *lru {
+	return cache.policy.(*lru)
+}
+
+func newTestCache(opts Options) (*LRUClientCache, *fakeClock) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	cache := NewClientsCache(logger, opts)
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cache.now = clock.Now
+	return cache, clock
+}
+
+func TestLRUClientCache_TTL(t *testing.T) {
+	t.Run("Client expires after TTL", func(t *testing.T) {
+		cache, clock := newTestCache(Options{MaxElements: 2, TTL: time.Minute})
+
+		cache.UpdateClient(&entity.Client{IPAddress: "192.168.1.1"})
+
+		clock.Advance(59 * time.Second)
+		_, found := cache.Client("192.168.1.1")
+		assert.True(t, found)
+
+		clock.Advance(time.Second)
+		_, found = cache.Client("192.168.1.1")
+		assert.False(t, found)
+
+		// The expired client is removed from the cache.
+		assert.Equal(t, 0, len(cache.cache))
+		assert.Equal(t, 0, len(lruOf(cache).items))
+		assert.Equal(t, 0, lruOf(cache).list.Len())
 	})
-}
\ No newline at end of file
+
+	t.Run("Update extends TTL", func(t *testing.T) {
+		cache, clock := newTestCache(Options{MaxElements: 2, TTL: time.Minute})
+
+		cache.UpdateClient(&entity.Client{IPAddress: "192.168.1.1"})
+		clock.Advance(45 * time.Second)
+		cache.UpdateClient(&entity.Client{IPAddress: "192.168.1.1"})
+		clock.Advance(45 * time.Second)
+
+		_, found := cache.Client("192.168.1.1")
+		assert.True(t, found)
+	})
+
+	t.Run("Zero TTL never expires", func(t *testing.T) {
+		cache, clock := newTestCache(Options{MaxElements: 2})
+
+		cache.UpdateClient(&entity.Client{IPAddress: "192.168.1.1"})
+		clock.Advance(24 * time.Hour)
+
+		_, found := cache.Client("192.168.1.1")
+		assert.True(t, found)
+	})
+
+	t.Run("Jitter stays within bounds", func(t *testing.T) {
+		cache, clock := newTestCache(Options{MaxElements: 100, TTL: time.Minute, TTLJitter: 10 * time.Second})
+
+		for i := range 100 {
+			cache.UpdateClient(&entity.Client{IPAddress: fmt.Sprintf("10.0.0.%d", i)})
+		}
+
+		for _, e := range cache.cache {
+			ttl := e.expiresAt.Sub(clock.Now())
+			assert.GreaterOrEqual(t, ttl, time.Minute)
+			assert.Less(t, ttl, time.Minute+10*time.Second)
+		}
+	})
+}
+
+func TestLRUClientCache_Load(t *testing.T) {
+	t.Run("Loads a missing client once", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 2})
+
+		var calls int
+		load := func() (*entity.Client, error) {
+			calls++
+			return &entity.Client{IPAddress: "192.168.1.1"}, nil
+		}
+
+		client, err := cache.Load("192.168.1.1", load)
+		require.NoError(t, err)
+		assert.Equal(t, "192.168.1.1", client.IPAddress)
+
+		cached, err := cache.Load("192.168.1.1", load)
+		require.NoError(t, err)
+		assert.Same(t, client, cached)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Caches not found keys for NegativeTTL", func(t *testing.T) {
+		cache, clock := newTestCache(Options{MaxElements: 2, NegativeTTL: 10 * time.Second})
+
+		var calls int
+		load := func() (*entity.Client, error) {
+			calls++
+			return nil, storage.ErrClientNotFound
+		}
+
+		_, err := cache.Load("192.168.1.1", load)
+		assert.ErrorIs(t, err, storage.ErrClientNotFound)
+
+		clock.Advance(9 * time.Second)
+		_, err = cache.Load("192.168.1.1", load)
+		assert.ErrorIs(t, err, storage.ErrClientNotFound)
+		assert.Equal(t, 1, calls)
+
+		clock.Advance(time.Second)
+		_, err = cache.Load("192.168.1.1", load)
+		assert.ErrorIs(t, err, storage.ErrClientNotFound)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Negative cache is disabled by zero NegativeTTL", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 2})
+
+		var calls int
+		load := func() (*entity.Client, error) {
+			calls++
+			return nil, storage.ErrClientNotFound
+		}
+
+		_, _ = cache.Load("192.168.1.1", load)
+		_, _ = cache.Load("192.168.1.1", load)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Other errors are not cached", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 2, NegativeTTL: time.Minute})
+
+		errDown := errors.New("database is down")
+		var calls int
+		load := func() (*entity.Client, error) {
+			calls++
+			return nil, errDown
+		}
+
+		_, err := cache.Load("192.168.1.1", load)
+		assert.ErrorIs(t, err, errDown)
+		_, err = cache.Load("192.168.1.1", load)
+		assert.ErrorIs(t, err, errDown)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Caching a client clears the missing key", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 2, NegativeTTL: time.Minute})
+
+		_, err := cache.Load("192.168.1.1", func() (*entity.Client, error) {
+			return nil, storage.ErrClientNotFound
+		})
+		require.ErrorIs(t, err, storage.ErrClientNotFound)
+
+		cache.UpdateClient(&entity.Client{IPAddress: "192.168.1.1"})
+
+		client, err := cache.Load("192.168.1.1", func() (*entity.Client, error) {
+			t.Fatal("unexpected load")
+			return nil, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "192.168.1.1", client.IPAddress)
+		assert.Empty(t, cache.misses)
+	})
+
+	t.Run("Deleting a client clears the missing key", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 2, NegativeTTL: time.Minute})
+
+		_, _ = cache.Load("192.168.1.1", func() (*entity.Client, error) {
+			return nil, storage.ErrClientNotFound
+		})
+		cache.DeleteClient("192.168.1.1")
+
+		assert.Empty(t, cache.misses)
+		assert.Equal(t, 0, cache.missing.Len())
+	})
+
+	t.Run("Missing keys are bounded", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 2, NegativeTTL: time.Minute})
+
+		notFound := func() (*entity.Client, error) {
+			return nil, storage.ErrClientNotFound
+		}
+		for _, ip := range []string{"192.168.1.1", "192.168.1.2", "192.168.1.3"} {
+			_, _ = cache.Load(ip, notFound)
+		}
+
+		assert.Len(t, cache.misses, 2)
+		assert.NotContains(t, cache.misses, "192.168.1.1")
+	})
+
+	t.Run("Client updated during the load is not overwritten", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 2})
+
+		updated := &entity.Client{IPAddress: "192.168.1.1", Capacity: 20}
+		client, err := cache.Load("192.168.1.1", func() (*entity.Client, error) {
+			// The update is notified while the stale client is read.
+			cache.UpdateClient(updated)
+			return &entity.Client{IPAddress: "192.168.1.1", Capacity: 10}, nil
+		})
+		require.NoError(t, err)
+		assert.Same(t, updated, client, "the client of the update is returned")
+
+		cached, ok := cache.Peek("192.168.1.1")
+		require.True(t, ok)
+		assert.Same(t, updated, cached)
+	})
+
+	t.Run("Key created during the load is not cached as missing", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 2, NegativeTTL: time.Minute})
+
+		var calls int
+		load := func() (*entity.Client, error) {
+			calls++
+			if calls == 1 {
+				// The creation is notified before the result of the load.
+				cache.DeleteClient("192.168.1.1")
+				return nil, storage.ErrClientNotFound
+			}
+			return &entity.Client{IPAddress: "192.168.1.1"}, nil
+		}
+
+		_, err := cache.Load("192.168.1.1", load)
+		assert.ErrorIs(t, err, storage.ErrClientNotFound)
+		assert.Empty(t, cache.misses)
+
+		client, err := cache.Load("192.168.1.1", load)
+		require.NoError(t, err)
+		assert.Equal(t, "192.168.1.1", client.IPAddress)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Client loaded during a purge is not cached", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 2})
+
+		client, err := cache.Load("192.168.1.1", func() (*entity.Client, error) {
+			cache.Purge()
+			return &entity.Client{IPAddress: "192.168.1.1"}, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "192.168.1.1", client.IPAddress)
+
+		_, ok := cache.Peek("192.168.1.1")
+		assert.False(t, ok)
+		assert.Empty(t, cache.loading)
+	})
+
+	t.Run("Concurrent misses load once", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 2})
+
+		var calls atomic.Int32
+		release := make(chan struct{})
+		load := func() (*entity.Client, error) {
+			calls.Add(1)
+			<-release
+			return &entity.Client{IPAddress: "192.168.1.1"}, nil
+		}
+
+		const n = 50
+		var wg sync.WaitGroup
+		var started sync.WaitGroup
+		clients := make([]*entity.Client, n)
+		for i := range n {
+			wg.Add(1)
+			started.Add(1)
+			go func() {
+				defer wg.Done()
+				started.Done()
+				client, err := cache.Load("192.168.1.1", load)
+				assert.NoError(t, err)
+				clients[i] = client
+			}()
+		}
+
+		started.Wait()
+		// Let the goroutines join the call in flight before it returns.
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), calls.Load())
+		for _, client := range clients {
+			assert.Same(t, clients[0], client)
+		}
+	})
+}
+
+func TestLRUClientCache_DeleteMissingFunc(t *testing.T) {
+	cache, _ := newTestCache(Options{MaxElements: 3, NegativeTTL: time.Minute})
+
+	notFound := func() (*entity.Client, error) {
+		return nil, storage.ErrClientNotFound
+	}
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "192.168.1.1"} {
+		_, _ = cache.Load(ip, notFound)
+	}
+
+	cache.DeleteMissingFunc(func(ipAddress string) bool {
+		return ipAddress != "192.168.1.1"
+	})
+
+	assert.Len(t, cache.misses, 1)
+	assert.Contains(t, cache.misses, "192.168.1.1")
+	assert.Equal(t, 1, cache.missing.Len())
+}
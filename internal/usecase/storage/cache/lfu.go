@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"container/list"
+)
+
+// lfu is the least frequently used eviction policy. Keys of the same frequency are evicted in the LRU order.
+//
+// All operations take constant time: keys are kept in a list per frequency,
+// and a used key moves to the list of the next frequency.
+type lfu struct {
+	capacity int
+	items    map[string]*list.Element // string (ipAdress) -> element holding *lfuItem
+	freqs    map[int]*list.List       // frequency -> keys used that many times, least recently used at the back
+	minFreq  int
+}
+
+type lfuItem struct {
+	key  string
+	freq int
+}
+
+func newLFU(capacity int) *lfu {
+	return &lfu{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		freqs:    make(map[int]*list.List),
+	}
+}
+
+func (p *lfu) Access(key string) {
+	el, ok := p.items[key]
+	if !ok {
+		return
+	}
+
+	item := el.Value.(*lfuItem)
+	p.unlink(el)
+	if p.minFreq == item.freq && p.freqs[item.freq] == nil {
+		p.minFreq++
+	}
+
+	item.freq++
+	p.items[key] = p.link(item)
+}
+
+func (p *lfu) Add(key string) (string, bool) {
+	if _, ok := p.items[key]; ok {
+		p.Access(key)
+		return "", false
+	}
+
+	var (
+		evicted string
+		ok      bool
+	)
+	if len(p.items) >= p.capacity {
+		evicted, ok = p.victim(), true
+		p.Remove(evicted)
+	}
+
+	p.items[key] = p.link(&lfuItem{key: key, freq: 1})
+	p.minFreq = 1
+
+	return evicted, ok
+}
+
+// victim returns the least recently used key of the lowest frequency.
+func (p *lfu) victim() string {
+	if p.freqs[p.minFreq] == nil {
+		// The keys of the lowest frequency were removed, find the next one.
+		p.minFreq = 0
+		for freq := range p.freqs {
+			if p.minFreq == 0 || freq < p.minFreq {
+				p.minFreq = freq
+			}
+		}
+	}
+
+	return p.freqs[p.minFreq].Back().Value.(*lfuItem).key
+}
+
+func (p *lfu) Remove(key string) {
+	if el, ok := p.items[key]; ok {
+		p.unlink(el)
+		delete(p.items, key)
+	}
+}
+
+func (p *lfu) link(item *lfuItem) *list.Element {
+	l, ok := p.freqs[item.freq]
+	if !ok {
+		l = list.New()
+		p.freqs[item.freq] = l
+	}
+	return l.PushFront(item)
+}
+
+func (p *lfu) unlink(el *list.Element) {
+	freq := el.Value.(*lfuItem).freq
+	l := p.freqs[freq]
+	l.Remove(el)
+	if l.Len() == 0 {
+		delete(p.freqs, freq)
+	}
+}
+
+func (p *lfu) Len() int {
+	return len(p.items)
+}
+
+func (p *lfu) Reset() {
+	clear(p.items)
+	clear(p.freqs)
+	p.minFreq = 0
+}
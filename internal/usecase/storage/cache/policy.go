@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"container/list"
+)
+
+// Policy is the eviction policy of a cache.
+type Policy string
+
+const (
+	// PolicyLRU evicts the least recently used client.
+	PolicyLRU Policy = "lru"
+	// PolicyLFU evicts the least frequently used client.
+	PolicyLFU Policy = "lfu"
+	// PolicyTinyLFU is W-TinyLFU: new clients get into a small LRU window and are admitted to the main
+	// segmented LRU only if they are used more often than the client they would evict, so a scan
+	// of one-off addresses does not flush the frequently used clients.
+	PolicyTinyLFU Policy = "tinylfu"
+)
+
+// IsValid reports whether the policy is known.
+func (p Policy) IsValid() bool {
+	return p == PolicyLRU || p == PolicyLFU || p == PolicyTinyLFU
+}
+
+// evictionPolicy decides which keys are kept in a cache of a limited size.
+// It only orders the keys, the values are kept by the cache. It is not concurrently safe.
+type evictionPolicy interface {
+	// Access records a hit of a cached key.
+	Access(key string)
+	// Add records a new key. If the cache is full, it returns the key to evict, which may be the new key itself
+	// if the policy does not admit it.
+	Add(key string) (evicted string, ok bool)
+	// Remove forgets a key removed from the cache.
+	Remove(key string)
+	Len() int
+	Reset()
+}
+
+func newEvictionPolicy(policy Policy, capacity int) evictionPolicy {
+	switch policy {
+	case PolicyLFU:
+		return newLFU(capacity)
+	case PolicyTinyLFU:
+		return newTinyLFU(capacity)
+	default:
+		return newLRU(capacity)
+	}
+}
+
+// lru is the least recently used eviction policy.
+type lru struct {
+	capacity int
+	list     *list.List               // least recently used - the back of the list
+	items    map[string]*list.Element // string (ipAdress) -> element in list
+}
+
+func newLRU(capacity int) *lru {
+	return &lru{
+		capacity: capacity,
+		list:     list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (p *lru) Access(key string) {
+	if el, ok := p.items[key]; ok {
+		p.list.MoveToFront(el)
+	}
+}
+
+func (p *lru) Add(key string) (string, bool) {
+	if el, ok := p.items[key]; ok {
+		p.list.MoveToFront(el)
+		return "", false
+	}
+
+	var (
+		evicted string
+		ok      bool
+	)
+	if len(p.items) >= p.capacity {
+		evicted, ok = p.list.Remove(p.list.Back()).(string), true
+		delete(p.items, evicted)
+	}
+
+	p.items[key] = p.list.PushFront(key)
+
+	return evicted, ok
+}
+
+func (p *lru) Remove(key string) {
+	if el, ok := p.items[key]; ok {
+		p.list.Remove(el)
+		delete(p.items, key)
+	}
+}
+
+func (p *lru) Len() int {
+	return len(p.items)
+}
+
+func (p *lru) Reset() {
+	p.list.Init()
+	clear(p.items)
+}
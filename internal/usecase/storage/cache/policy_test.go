@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLFU(t *testing.T) {
+	t.Run("Evicts the least frequently used key", func(t *testing.T) {
+		p := newLFU(2)
+
+		p.Add("a")
+		p.Add("b")
+		p.Access("a")
+
+		evicted, ok := p.Add("c")
+		assert.True(t, ok)
+		assert.Equal(t, "b", evicted)
+	})
+
+	t.Run("Ties are evicted in LRU order", func(t *testing.T) {
+		p := newLFU(2)
+
+		p.Add("a")
+		p.Add("b")
+
+		evicted, ok := p.Add("c")
+		assert.True(t, ok)
+		assert.Equal(t, "a", evicted)
+	})
+
+	t.Run("Removal of the least frequent keys", func(t *testing.T) {
+		p := newLFU(2)
+
+		p.Add("a")
+		p.Add("b")
+		p.Access("a")
+		p.Access("b")
+		p.Access("b")
+		p.Remove("a")
+		p.Add("c")
+
+		evicted, ok := p.Add("d")
+		assert.True(t, ok)
+		assert.Equal(t, "c", evicted)
+		assert.Equal(t, 2, p.Len())
+	})
+}
+
+func TestTinyLFU(t *testing.T) {
+	t.Run("Fills the window and the main cache", func(t *testing.T) {
+		p := newTinyLFU(100)
+
+		for i := range 100 {
+			_, ok := p.Add(fmt.Sprintf("key-%d", i))
+			assert.False(t, ok)
+		}
+		assert.Equal(t, 100, p.Len())
+	})
+
+	t.Run("Does not admit a one-off key instead of a frequent one", func(t *testing.T) {
+		p := newTinyLFU(100)
+
+		for i := range 100 {
+			p.Add(fmt.Sprintf("hot-%d", i))
+		}
+		for range 5 {
+			for i := range 100 {
+				p.Access(fmt.Sprintf("hot-%d", i))
+			}
+		}
+
+		p.Add("scan-0")
+		evicted, ok := p.Add("scan-1")
+		assert.True(t, ok)
+		assert.Equal(t, "scan-0", evicted)
+	})
+}
+
+func TestClientsCache_Policies(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// hotHits returns how many of the hot clients survive a scan of one-off clients.
+	hotHits := func(policy Policy) int {
+		cache := NewClientsCache(logger, Options{MaxElements: 100, Policy: policy})
+
+		for i := range 50 {
+			cache.UpdateClient(&entity.Client{IPAddress: fmt.Sprintf("10.0.0.%d", i)})
+		}
+		for range 10 {
+			for i := range 50 {
+				cache.Client(fmt.Sprintf("10.0.0.%d", i))
+			}
+		}
+		for i := range 1000 {
+			cache.UpdateClient(&entity.Client{IPAddress: fmt.Sprintf("192.168.%d.%d", i/256, i%256)})
+		}
+
+		var hits int
+		for i := range 50 {
+			if _, ok := cache.Client(fmt.Sprintf("10.0.0.%d", i)); ok {
+				hits++
+			}
+		}
+		return hits
+	}
+
+	assert.Zero(t, hotHits(PolicyLRU))
+	assert.Equal(t, 50, hotHits(PolicyLFU))
+	// The frequencies are estimated, so a rare collision in the sketch may admit a one-off client.
+	assert.GreaterOrEqual(t, hotHits(PolicyTinyLFU), 45)
+}
+
+func TestClientsCache_Stats(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	stats := newUnregisteredStats()
+	cache := NewClientsCache(logger, Options{MaxElements: 1, Stats: stats})
+
+	cache.UpdateClient(&entity.Client{IPAddress: "192.168.1.1"})
+	cache.Client("192.168.1.1")
+	cache.Client("192.168.1.2")
+	cache.UpdateClient(&entity.Client{IPAddress: "192.168.1.2"})
+
+	assert.Equal(t, int64(1), stats.Hits.Value())
+	assert.Equal(t, int64(1), stats.Misses.Value())
+	assert.Equal(t, int64(1), stats.Evictions.Value())
+	assert.Len(t, cache.cache, 1)
+}
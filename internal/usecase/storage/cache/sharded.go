@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"hash/maphash"
+	"log/slog"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+// ShardedClientCache splits the clients between independently locked shards by a hash of their keys,
+// so lookups of different clients rarely contend for the same lock.
+//
+// Every shard holds up to MaxElements / shards clients and evicts them on its own,
+// so the eviction policy applies within a shard rather than across the whole cache.
+type ShardedClientCache struct {
+	log    *slog.Logger
+	seed   maphash.Seed
+	shards []*ClientsCache
+}
+
+// NewShardedClientsCache creates a cache of the given number of shards, at least one.
+func NewShardedClientsCache(log *slog.Logger, shards int, opts Options) *ShardedClientCache {
+	shards = max(shards, 1)
+
+	shardOpts := opts
+	if shardOpts.Stats == nil {
+		shardOpts.Stats = newUnregisteredStats()
+	}
+	if opts.MaxElements > 0 {
+		shardOpts.MaxElements = max(opts.MaxElements/shards, 1)
+	}
+
+	c := &ShardedClientCache{
+		log:    log,
+		seed:   maphash.MakeSeed(),
+		shards: make([]*ClientsCache, shards),
+	}
+	for i := range c.shards {
+		c.shards[i] = NewClientsCache(log, shardOpts)
+	}
+
+	return c
+}
+
+func (c *ShardedClientCache) shard(ipAddress string) *ClientsCache {
+	return c.shards[maphash.String(c.seed, ipAddress)%uint64(len(c.shards))]
+}
+
+func (c *ShardedClientCache) Client(ipAddress string) (*entity.Client, bool) {
+	return c.shard(ipAddress).Client(ipAddress)
+}
+
+// Peek returns the cached client without counting a lookup, see ClientsCache.Peek.
+func (c *ShardedClientCache) Peek(ipAddress string) (*entity.Client, bool) {
+	return c.shard(ipAddress).Peek(ipAddress)
+}
+
+// Load returns the cached client or loads it with load, see ClientsCache.Load.
+func (c *ShardedClientCache) Load(ipAddress string, load func() (*entity.Client, error)) (*entity.Client, error) {
+	return c.shard(ipAddress).Load(ipAddress, load)
+}
+
+func (c *ShardedClientCache) UpdateClient(client *entity.Client) {
+	c.shard(client.IPAddress).UpdateClient(client)
+}
+
+func (c *ShardedClientCache) DeleteClient(ipAddress string) {
+	c.shard(ipAddress).DeleteClient(ipAddress)
+}
+
+// DeleteFunc removes all clients for which fn returns true.
+func (c *ShardedClientCache) DeleteFunc(fn func(client *entity.Client) bool) {
+	for _, shard := range c.shards {
+		shard.DeleteFunc(fn)
+	}
+}
+
+// Clients returns the clients that are cached and not expired.
+func (c *ShardedClientCache) Clients() []*entity.Client {
+	var clients []*entity.Client
+	for _, shard := range c.shards {
+		clients = append(clients, shard.Clients()...)
+	}
+	return clients
+}
+
+// DeleteMissingFunc removes all missing keys for which fn returns true.
+func (c *ShardedClientCache) DeleteMissingFunc(fn func(ipAddress string) bool) {
+	for _, shard := range c.shards {
+		shard.DeleteMissingFunc(fn)
+	}
+}
+
+// Purge removes all clients and missing keys from the cache.
+func (c *ShardedClientCache) Purge() {
+	for _, shard := range c.shards {
+		shard.Purge()
+	}
+}
+
+// StartTokenRefiller refills the buckets of the cached clients once per second, locking one shard at a time.
+func (c *ShardedClientCache) StartTokenRefiller(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	c.log.Info("starting token refiller...", slog.Int("shards", len(c.shards)))
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, shard := range c.shards {
+				shard.refillAllClients()
+			}
+		case <-ctx.Done():
+			c.log.Info("token refiller is terminated due to context cancellation")
+			return
+		}
+	}
+}
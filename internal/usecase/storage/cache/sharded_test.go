@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedClientCache(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("Splits capacity between shards", func(t *testing.T) {
+		cache := NewShardedClientsCache(logger, 4, Options{MaxElements: 100})
+
+		require.Len(t, cache.shards, 4)
+		for _, shard := range cache.shards {
+			assert.Equal(t, 25, shard.maxElements)
+		}
+	})
+
+	t.Run("Same key goes to the same shard", func(t *testing.T) {
+		cache := NewShardedClientsCache(logger, 8, Options{MaxElements: 800})
+
+		for i := range 50 {
+			cache.UpdateClient(&entity.Client{IPAddress: fmt.Sprintf("10.0.0.%d", i)})
+		}
+		for i := range 50 {
+			client, found := cache.Client(fmt.Sprintf("10.0.0.%d", i))
+			require.True(t, found)
+			assert.Equal(t, fmt.Sprintf("10.0.0.%d", i), client.IPAddress)
+		}
+
+		cache.DeleteClient("10.0.0.1")
+		_, found := cache.Client("10.0.0.1")
+		assert.False(t, found)
+	})
+
+	t.Run("Delete functions and purge cover all shards", func(t *testing.T) {
+		cache := NewShardedClientsCache(logger, 4, Options{MaxElements: 40, NegativeTTL: time.Minute})
+
+		for i := range 10 {
+			cache.UpdateClient(&entity.Client{IPAddress: fmt.Sprintf("10.0.0.%d", i)})
+			_, err := cache.Load(fmt.Sprintf("10.0.1.%d", i), func() (*entity.Client, error) {
+				return nil, storage.ErrClientNotFound
+			})
+			require.ErrorIs(t, err, storage.ErrClientNotFound)
+		}
+
+		cache.DeleteFunc(func(client *entity.Client) bool { return true })
+		cache.DeleteMissingFunc(func(ipAddress string) bool { return true })
+
+		for _, shard := range cache.shards {
+			assert.Empty(t, shard.cache)
+			assert.Empty(t, shard.misses)
+		}
+
+		cache.UpdateClient(&entity.Client{IPAddress: "10.0.0.1"})
+		cache.Purge()
+		_, found := cache.Client("10.0.0.1")
+		assert.False(t, found)
+	})
+}
+
+// clientCache is the interface shared by the benchmarked caches.
+type clientCache interface {
+	Client(ipAddress string) (*entity.Client, bool)
+	UpdateClient(client *entity.Client)
+}
+
+const benchmarkClients = 10_000
+
+func benchmarkCache(b *testing.B, cache clientCache, writePercent int) {
+	keys := make([]string, benchmarkClients)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+		cache.UpdateClient(&entity.Client{IPAddress: keys[i]})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+		for pb.Next() {
+			key := keys[r.IntN(len(keys))]
+			if r.IntN(100) < writePercent {
+				cache.UpdateClient(&entity.Client{IPAddress: key})
+			} else {
+				cache.Client(key)
+			}
+		}
+	})
+}
+
+func BenchmarkClientCache(b *testing.B) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, writePercent := range []int{0, 10} {
+		b.Run(fmt.Sprintf("LRU/writes=%d%%", writePercent), func(b *testing.B) {
+			benchmarkCache(b, NewClientsCache(logger, Options{MaxElements: benchmarkClients}), writePercent)
+		})
+
+		for _, shards := range []int{4, 16, 64} {
+			b.Run(fmt.Sprintf("Sharded-%d/writes=%d%%", shards, writePercent), func(b *testing.B) {
+				benchmarkCache(b, NewShardedClientsCache(logger, shards, Options{MaxElements: 2 * benchmarkClients}), writePercent)
+			})
+		}
+	}
+}
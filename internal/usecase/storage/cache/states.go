@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+// BucketStates keeps the token buckets and the limiters (see entity.Limiters) of clients that left the cache
+// by their keys, so a client that is evicted and loaded again continues with the tokens it had instead
+// of a full bucket, with the buckets of its policies and with the requests it has in flight.
+//
+// The limiter state is decoupled from the configuration of a client: a restored client may have
+// another capacity, and the tokens are scaled to it. The states are evicted in the LRU order.
+//
+// This type is concurrently safe.
+type BucketStates struct {
+	maxElements int
+	now         func() time.Time
+
+	mu     sync.Mutex
+	list   *list.List               // least recently saved - the back of the list
+	states map[string]*list.Element // string (ipAdress) -> element in list holding *bucketState
+}
+
+type bucketState struct {
+	ipAddress     string
+	tokens        int32
+	capacity      int32
+	ratePerSecond int32
+	limiters      *entity.Limiters
+	savedAt       time.Time
+}
+
+// NewBucketStates creates a store of up to maxElements states, 0 disables it.
+func NewBucketStates(maxElements int) *BucketStates {
+	return &BucketStates{
+		maxElements: max(maxElements, 0),
+		now:         time.Now,
+		list:        list.New(),
+		states:      make(map[string]*list.Element),
+	}
+}
+
+// Save remembers the bucket and the limiters of the client. Full buckets without limiters are not kept,
+// since a client gets a full bucket anyway.
+// Clients that spend tokens of a CIDR range are skipped, the bucket belongs to the range.
+func (s *BucketStates) Save(client *entity.Client) {
+	if s == nil || s.maxElements == 0 || !client.OwnsBucket() {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteLocked(client.IPAddress)
+
+	tokens := client.Tokens.Load()
+	limiters := client.Limiters()
+	if tokens >= client.Capacity && limiters == nil {
+		return
+	}
+
+	if len(s.states) >= s.maxElements {
+		delete(s.states, s.list.Remove(s.list.Back()).(*bucketState).ipAddress)
+	}
+
+	s.states[client.IPAddress] = s.list.PushFront(&bucketState{
+		ipAddress:     client.IPAddress,
+		tokens:        tokens,
+		capacity:      client.Capacity,
+		ratePerSecond: client.RatePerSecond,
+		limiters:      limiters,
+		savedAt:       s.now(),
+	})
+}
+
+// Restore fills the bucket of the client from its saved state, hands the saved limiters over to it
+// and forgets the state. The tokens refilled since the state was saved are added, and the result is scaled
+// to the capacity of the client. It reports whether the state was found.
+func (s *BucketStates) Restore(client *entity.Client) bool {
+	if s == nil || !client.OwnsBucket() {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.states[client.IPAddress]
+	if !ok {
+		return false
+	}
+	state := el.Value.(*bucketState)
+	s.deleteLocked(client.IPAddress)
+
+	elapsed := s.now().Sub(state.savedAt)
+	refilled := int64(elapsed/time.Second) * int64(state.ratePerSecond)
+	tokens := min(int64(state.tokens)+refilled, int64(state.capacity))
+
+	client.InheritTokens(int32(tokens), state.capacity)
+	if state.limiters != nil {
+		state.limiters.RefillFor(elapsed)
+		client.InheritLimiters(state.limiters)
+	}
+	return true
+}
+
+func (s *BucketStates) deleteLocked(ipAddress string) {
+	if el, ok := s.states[ipAddress]; ok {
+		s.list.Remove(el)
+		delete(s.states, ipAddress)
+	}
+}
+
+func (s *BucketStates) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.states)
+}
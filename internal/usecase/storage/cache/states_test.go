@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func newClient(ipAddress string, capacity, ratePerSecond, tokens int32) *entity.Client {
+	client := &entity.Client{IPAddress: ipAddress, Capacity: capacity, RatePerSecond: ratePerSecond}
+	client.Tokens.Store(tokens)
+	return client
+}
+
+func TestClientsCache_BucketStates(t *testing.T) {
+	t.Run("Evicted client continues with its tokens", func(t *testing.T) {
+		states := NewBucketStates(10)
+		cache, _ := newTestCache(Options{MaxElements: 1, States: states})
+		states.now = cache.now
+
+		cache.UpdateClient(newClient("192.168.1.1", 10, 1, 2))
+		cache.UpdateClient(newClient("192.168.1.2", 10, 1, 10))
+		assert.Equal(t, 1, states.Len())
+
+		cache.UpdateClient(newClient("192.168.1.1", 10, 1, 10))
+
+		client, ok := cache.Client("192.168.1.1")
+		assert.True(t, ok)
+		assert.Equal(t, int32(2), client.Tokens.Load())
+		assert.Zero(t, states.Len(), "the restored state is forgotten and a full bucket is not kept")
+	})
+
+	t.Run("Restored tokens include the refill since the eviction", func(t *testing.T) {
+		states := NewBucketStates(10)
+		cache, clock := newTestCache(Options{MaxElements: 1, States: states})
+		states.now = cache.now
+
+		cache.UpdateClient(newClient("192.168.1.1", 10, 3, 0))
+		cache.DeleteClient("192.168.1.1")
+		clock.Advance(2 * time.Second)
+		cache.UpdateClient(newClient("192.168.1.1", 10, 3, 10))
+
+		client, _ := cache.Client("192.168.1.1")
+		assert.Equal(t, int32(6), client.Tokens.Load())
+	})
+
+	t.Run("Update scales the tokens to the new capacity", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 1})
+
+		cache.UpdateClient(newClient("192.168.1.1", 10, 1, 5))
+		cache.UpdateClient(newClient("192.168.1.1", 20, 1, 20))
+
+		client, _ := cache.Client("192.168.1.1")
+		assert.Equal(t, int32(10), client.Tokens.Load())
+	})
+
+	t.Run("Purge saves the buckets", func(t *testing.T) {
+		states := NewBucketStates(10)
+		cache, _ := newTestCache(Options{MaxElements: 2, States: states})
+
+		cache.UpdateClient(newClient("192.168.1.1", 10, 1, 4))
+		cache.Purge()
+		cache.UpdateClient(newClient("192.168.1.1", 5, 1, 5))
+
+		client, _ := cache.Client("192.168.1.1")
+		assert.Equal(t, int32(2), client.Tokens.Load())
+	})
+
+	t.Run("Limiters survive an update", func(t *testing.T) {
+		cache, _ := newTestCache(Options{MaxElements: 1})
+		policy := &entity.Policy{Name: "search", Capacity: 10, RatePerSecond: 1}
+
+		old := newClient("192.168.1.1", 10, 1, 10)
+		old.MaxConcurrent = 2
+		cache.UpdateClient(old)
+		assert.True(t, old.PolicyBucket(policy).AllowN(4))
+		assert.True(t, old.Concurrency(0).TryAcquire())
+
+		updated := newClient("192.168.1.1", 10, 1, 10)
+		updated.MaxConcurrent = 1
+		updated.PolicyOverrides = map[string]entity.PolicyOverride{"search": {Capacity: 20}}
+		cache.UpdateClient(updated)
+
+		client, _ := cache.Client("192.168.1.1")
+		assert.Equal(t, int32(12), client.PolicyBucket(policy).Tokens(), "the policy bucket is scaled to the override")
+		assert.Same(t, old.Concurrency(0), client.Concurrency(0))
+		assert.False(t, client.Concurrency(0).TryAcquire(), "the request of the replaced client holds the only slot")
+	})
+
+	t.Run("Limiters survive an eviction", func(t *testing.T) {
+		states := NewBucketStates(10)
+		cache, clock := newTestCache(Options{MaxElements: 1, States: states})
+		states.now = cache.now
+		policy := &entity.Policy{Name: "search", Capacity: 10, RatePerSecond: 2}
+
+		old := newClient("192.168.1.1", 10, 1, 10)
+		old.MaxConcurrent = 1
+		cache.UpdateClient(old)
+		assert.True(t, old.PolicyBucket(policy).AllowN(10))
+		assert.True(t, old.Concurrency(0).TryAcquire())
+
+		cache.UpdateClient(newClient("192.168.1.2", 10, 1, 10))
+		assert.Equal(t, 1, states.Len(), "a full bucket is kept for its limiters")
+
+		clock.Advance(2 * time.Second)
+		restored := newClient("192.168.1.1", 10, 1, 10)
+		restored.MaxConcurrent = 1
+		cache.UpdateClient(restored)
+
+		client, _ := cache.Client("192.168.1.1")
+		assert.Equal(t, int32(4), client.PolicyBucket(policy).Tokens(), "the policy bucket is refilled since the eviction")
+		assert.Equal(t, 1, client.Concurrency(0).InFlight())
+	})
+
+	t.Run("Oldest states are evicted", func(t *testing.T) {
+		states := NewBucketStates(1)
+
+		states.Save(newClient("192.168.1.1", 10, 1, 1))
+		states.Save(newClient("192.168.1.2", 10, 1, 1))
+
+		assert.False(t, states.Restore(newClient("192.168.1.1", 10, 1, 10)))
+		assert.True(t, states.Restore(newClient("192.168.1.2", 10, 1, 10)))
+		assert.Zero(t, states.Len())
+	})
+}
@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"container/list"
+	"hash/maphash"
+	"math/bits"
+)
+
+// tinyLFU is the W-TinyLFU eviction policy.
+//
+// New keys get into a small LRU window. A key evicted from the window is a candidate for the main cache,
+// a segmented LRU of the probation and protected segments, and it is admitted only if its estimated frequency
+// is higher than the one of the key it would evict from probation. Keys used again in probation are promoted
+// to protected. The frequencies are estimated by a count-min sketch that is periodically halved,
+// so the policy adapts to changes of the traffic.
+type tinyLFU struct {
+	sketch *countMinSketch
+	window *lru
+
+	mainCapacity      int
+	protectedCapacity int
+	probation         *list.List               // least recently used - the back of the list
+	protected         *list.List               // least recently used - the back of the list
+	items             map[string]*list.Element // string (ipAdress) -> element in probation or protected holding *slruItem
+}
+
+type slruItem struct {
+	key       string
+	protected bool
+}
+
+func newTinyLFU(capacity int) *tinyLFU {
+	windowCapacity := max(capacity/100, 1)
+	mainCapacity := max(capacity-windowCapacity, 0)
+
+	return &tinyLFU{
+		sketch:            newCountMinSketch(capacity),
+		window:            newLRU(windowCapacity),
+		mainCapacity:      mainCapacity,
+		protectedCapacity: mainCapacity * 8 / 10,
+		probation:         list.New(),
+		protected:         list.New(),
+		items:             make(map[string]*list.Element),
+	}
+}
+
+func (p *tinyLFU) Access(key string) {
+	p.sketch.Increment(key)
+
+	if _, ok := p.window.items[key]; ok {
+		p.window.Access(key)
+		return
+	}
+
+	el, ok := p.items[key]
+	if !ok {
+		return
+	}
+
+	item := el.Value.(*slruItem)
+	if item.protected {
+		p.protected.MoveToFront(el)
+		return
+	}
+
+	p.probation.Remove(el)
+	item.protected = true
+	p.items[key] = p.protected.PushFront(item)
+
+	if p.protected.Len() > p.protectedCapacity {
+		demoted := p.protected.Remove(p.protected.Back()).(*slruItem)
+		demoted.protected = false
+		p.items[demoted.key] = p.probation.PushFront(demoted)
+	}
+}
+
+func (p *tinyLFU) Add(key string) (string, bool) {
+	if _, ok := p.window.items[key]; ok {
+		p.Access(key)
+		return "", false
+	}
+	if _, ok := p.items[key]; ok {
+		p.Access(key)
+		return "", false
+	}
+
+	p.sketch.Increment(key)
+
+	candidate, ok := p.window.Add(key)
+	if !ok {
+		return "", false
+	}
+
+	if len(p.items) < p.mainCapacity {
+		p.items[candidate] = p.probation.PushFront(&slruItem{key: candidate})
+		return "", false
+	}
+	if p.mainCapacity == 0 {
+		return candidate, true
+	}
+
+	victimEl := p.probation.Back()
+	if victimEl == nil {
+		victimEl = p.protected.Back()
+	}
+	victim := victimEl.Value.(*slruItem).key
+
+	if p.sketch.Estimate(candidate) <= p.sketch.Estimate(victim) {
+		return candidate, true
+	}
+
+	p.Remove(victim)
+	p.items[candidate] = p.probation.PushFront(&slruItem{key: candidate})
+
+	return victim, true
+}
+
+func (p *tinyLFU) Remove(key string) {
+	if _, ok := p.window.items[key]; ok {
+		p.window.Remove(key)
+		return
+	}
+
+	el, ok := p.items[key]
+	if !ok {
+		return
+	}
+
+	if el.Value.(*slruItem).protected {
+		p.protected.Remove(el)
+	} else {
+		p.probation.Remove(el)
+	}
+	delete(p.items, key)
+}
+
+func (p *tinyLFU) Len() int {
+	return p.window.Len() + len(p.items)
+}
+
+func (p *tinyLFU) Reset() {
+	p.sketch.Reset()
+	p.window.Reset()
+	p.probation.Init()
+	p.protected.Init()
+	clear(p.items)
+}
+
+const (
+	sketchDepth      = 4
+	sketchMaxCounter = 15
+)
+
+// countMinSketch estimates the frequencies of keys in a fixed amount of memory.
+// Its counters saturate at 15 and are halved after every sample of 10 * capacity increments.
+// Rows are 8 times wider than the capacity, so keys that are not cached rarely inflate the estimates of cached ones.
+type countMinSketch struct {
+	seed       maphash.Seed
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	capacity = max(capacity, 16)
+	width := 1 << bits.Len(uint(8*capacity-1))
+
+	s := &countMinSketch{
+		seed:       maphash.MakeSeed(),
+		mask:       uint64(width - 1),
+		sampleSize: 10 * capacity,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+
+	return s
+}
+
+func (s *countMinSketch) Increment(key string) {
+	h := maphash.String(s.seed, key)
+	for i := range s.rows {
+		if idx := s.index(h, i); s.rows[i][idx] < sketchMaxCounter {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.age()
+	}
+}
+
+func (s *countMinSketch) Estimate(key string) uint8 {
+	h := maphash.String(s.seed, key)
+
+	estimate := uint8(sketchMaxCounter)
+	for i := range s.rows {
+		estimate = min(estimate, s.rows[i][s.index(h, i)])
+	}
+
+	return estimate
+}
+
+// index returns the counter of the key in the row i using double hashing.
+func (s *countMinSketch) index(h uint64, i int) uint64 {
+	h1, h2 := uint32(h), uint32(h>>32)
+	return uint64(h1+uint32(i)*h2) & s.mask
+}
+
+// age halves all counters, so old frequencies fade away.
+func (s *countMinSketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] /= 2
+		}
+	}
+	s.additions /= 2
+}
+
+func (s *countMinSketch) Reset() {
+	for i := range s.rows {
+		clear(s.rows[i])
+	}
+	s.additions = 0
+}
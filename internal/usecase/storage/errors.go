@@ -5,4 +5,9 @@ import "errors"
 var (
 	ErrClientNotFound = errors.New("client was not found")
 	ErrClientExists   = errors.New("client already exists")
+	ErrPlanNotFound   = errors.New("plan was not found")
+	ErrPlanExists     = errors.New("plan already exists")
+	ErrPlanInUse      = errors.New("plan is referenced by clients")
+	ErrRuleNotFound   = errors.New("access rule was not found")
+	ErrRuleExists     = errors.New("access rule already exists")
 )
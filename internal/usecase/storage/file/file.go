@@ -0,0 +1,183 @@
+// Package file implements a storage kept in memory and persisted to a single JSON file.
+//
+// Every change is written to the file before it is reported as done. The file is replaced atomically:
+// the new content is written to a temporary file in the same directory, synced and renamed over the old one,
+// so a crash leaves either the old or the new content, never a partially written file.
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/memory"
+)
+
+// Storage reads from memory and writes through to the file.
+// The file is owned by a single process, changes made to it by others are not noticed.
+//
+// If a change can not be written to the file, the error is returned, but the change stays in memory
+// and is written with the next successful change.
+type Storage struct {
+	*memory.Storage
+
+	path string
+	// mu serializes the changes together with their writes, so the file never goes back to an older state.
+	mu sync.Mutex
+}
+
+// Open loads the storage from the file at path, or creates the file if it does not exist.
+func Open(path string) (*Storage, error) {
+	const op = "storage.file.Open"
+
+	s := &Storage{
+		Storage: memory.New(),
+		path:    path,
+	}
+
+	content, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if err := s.save(); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return s, nil
+	case err != nil:
+		return nil, fmt.Errorf("%s: failed to read %q: %w", op, path, err)
+	}
+
+	data := new(memory.Data)
+	if err := json.Unmarshal(content, data); err != nil {
+		return nil, fmt.Errorf("%s: failed to decode %q: %w", op, path, err)
+	}
+	s.Restore(data)
+
+	return s, nil
+}
+
+func (s *Storage) CreateClient(ctx context.Context, client *entity.Client) error {
+	return s.write(func() error {
+		return s.Storage.CreateClient(ctx, client)
+	})
+}
+
+// CreateClients creates the clients with their own limits, skipping the existing ones.
+// It returns the number of created clients.
+func (s *Storage) CreateClients(ctx context.Context, clients []*entity.Client) (int64, error) {
+	var created int64
+	err := s.write(func() error {
+		var err error
+		created, err = s.Storage.CreateClients(ctx, clients)
+		return err
+	})
+	return created, err
+}
+
+func (s *Storage) ImportClients(ctx context.Context, clients []*entity.Client, upsert bool) (created, updated int64, err error) {
+	err = s.write(func() error {
+		var err error
+		created, updated, err = s.Storage.ImportClients(ctx, clients, upsert)
+		return err
+	})
+	return created, updated, err
+}
+
+func (s *Storage) UpdateClient(ctx context.Context, client *entity.Client) error {
+	return s.write(func() error {
+		return s.Storage.UpdateClient(ctx, client)
+	})
+}
+
+func (s *Storage) DeleteClient(ctx context.Context, ipAddress string) error {
+	return s.write(func() error {
+		return s.Storage.DeleteClient(ctx, ipAddress)
+	})
+}
+
+func (s *Storage) CreatePlan(ctx context.Context, plan *entity.Plan) error {
+	return s.write(func() error {
+		return s.Storage.CreatePlan(ctx, plan)
+	})
+}
+
+func (s *Storage) UpdatePlan(ctx context.Context, plan *entity.Plan) error {
+	return s.write(func() error {
+		return s.Storage.UpdatePlan(ctx, plan)
+	})
+}
+
+func (s *Storage) DeletePlan(ctx context.Context, name string) error {
+	return s.write(func() error {
+		return s.Storage.DeletePlan(ctx, name)
+	})
+}
+
+func (s *Storage) CreateAccessRule(ctx context.Context, rule *entity.AccessRule) error {
+	return s.write(func() error {
+		return s.Storage.CreateAccessRule(ctx, rule)
+	})
+}
+
+func (s *Storage) DeleteAccessRule(ctx context.Context, network string) error {
+	return s.write(func() error {
+		return s.Storage.DeleteAccessRule(ctx, network)
+	})
+}
+
+// AddQuotaUsage adds the usage to the persisted one.
+func (s *Storage) AddQuotaUsage(ctx context.Context, deltas []entity.QuotaUsageDelta) error {
+	return s.write(func() error {
+		return s.Storage.AddQuotaUsage(ctx, deltas)
+	})
+}
+
+// write applies the change to memory and writes the storage to the file if the change succeeds.
+func (s *Storage) write(change func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := change(); err != nil {
+		return err
+	}
+
+	return s.save()
+}
+
+func (s *Storage) save() error {
+	const op = "storage.file.save"
+
+	content, err := json.MarshalIndent(s.Snapshot(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("%s: failed to encode: %w", op, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("%s: failed to create temporary file: %w", op, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: failed to write %q: %w", op, tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: failed to sync %q: %w", op, tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%s: failed to close %q: %w", op, tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("%s: failed to replace %q: %w", op, s.path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package file_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/file"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		s, err := file.Open(filepath.Join(t.TempDir(), "storage.json"))
+		require.NoError(t, err)
+		return s
+	})
+}
+
+func TestStorage_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	hour := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	s, err := file.Open(path)
+	require.NoError(t, err)
+
+	require.NoError(t, s.CreatePlan(ctx, &entity.Plan{Name: "pro", Capacity: 100, RatePerSecond: 10}))
+	require.NoError(t, s.CreateClient(ctx, &entity.Client{IPAddress: "10.0.0.1", Plan: "pro", RatePerSecond: 50}))
+	require.NoError(t, s.CreateAccessRule(ctx, &entity.AccessRule{Network: "10.0.0.0/8", Action: entity.AccessDeny}))
+	require.NoError(t, s.AddQuotaUsage(ctx, []entity.QuotaUsageDelta{{Key: "10.0.0.1", PeriodStart: hour, Used: 3}}))
+
+	reopened, err := file.Open(path)
+	require.NoError(t, err)
+
+	client, err := reopened.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "pro", client.Plan)
+	assert.Equal(t, int32(100), client.Capacity)
+	assert.Equal(t, int32(50), client.RatePerSecond)
+
+	rules, err := reopened.AccessRules(ctx)
+	require.NoError(t, err)
+	assert.Len(t, rules, 1)
+
+	used, err := reopened.QuotaUsage(ctx, "10.0.0.1", hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), used)
+
+	plan := &entity.Plan{Name: "basic", Capacity: 10, RatePerSecond: 1}
+	require.NoError(t, reopened.CreatePlan(ctx, plan))
+	assert.Greater(t, plan.ID, rules[0].ID, "identifiers continue after the loaded ones")
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left")
+}
+
+func TestOpen_Corrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+
+	_, err := file.Open(path)
+	assert.Error(t, err)
+}
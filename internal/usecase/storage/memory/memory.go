@@ -0,0 +1,657 @@
+// Package memory implements the storage of clients, plans, access rules and quota usage in memory.
+//
+// It behaves as the PostgreSQL storage does, so the balancer can run without a database,
+// e.g. locally or in tests. The content is lost when the process exits, see the file storage
+// for a persistent one.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
+)
+
+// Data is the whole content of a storage.
+type Data struct {
+	Clients     []*ClientRecord      `json:"clients"`
+	Plans       []*entity.Plan       `json:"plans"`
+	AccessRules []*entity.AccessRule `json:"access_rules"`
+	QuotaUsage  []*QuotaUsageRecord  `json:"quota_usage"`
+}
+
+// ClientRecord is a stored client. Its limits are the ones set for the client itself:
+// zero limits of a client that references a plan are taken from the plan when the client is read.
+type ClientRecord struct {
+	ID              int64                            `json:"id"`
+	IPAddress       string                           `json:"ip_address"`
+	Capacity        int32                            `json:"capacity"`
+	RatePerSecond   int32                            `json:"rate_per_second"`
+	LimitMode       entity.LimitMode                 `json:"limit_mode"`
+	MaxConcurrent   int32                            `json:"max_concurrent"`
+	QuotaPeriod     entity.QuotaPeriod               `json:"quota_period"`
+	QuotaLimit      int64                            `json:"quota_limit"`
+	Plan            string                           `json:"plan"`
+	PolicyOverrides map[string]entity.PolicyOverride `json:"policy_overrides"`
+	Pinned          bool                             `json:"pinned"`
+}
+
+// QuotaUsageRecord is the persisted usage of a client's quota within a period.
+type QuotaUsageRecord struct {
+	Key         string    `json:"key"`
+	PeriodStart time.Time `json:"period_start"`
+	Used        int64     `json:"used"`
+}
+
+type quotaKey struct {
+	key         string
+	periodStart int64 // unix nanoseconds, so the same instant in different locations is the same key
+}
+
+// Storage is concurrently safe. It never returns its internal objects, so the callers may modify the results.
+type Storage struct {
+	now func() time.Time
+
+	mu         sync.RWMutex
+	clients    map[string]*ClientRecord      // string (ipAdress) -> client
+	plans      map[string]*entity.Plan       // string (name) -> plan
+	rules      map[string]*entity.AccessRule // string (network) -> rule
+	quotaUsage map[quotaKey]*QuotaUsageRecord
+	lastID     int64
+}
+
+func New() *Storage {
+	return &Storage{
+		now:        time.Now,
+		clients:    make(map[string]*ClientRecord),
+		plans:      make(map[string]*entity.Plan),
+		rules:      make(map[string]*entity.AccessRule),
+		quotaUsage: make(map[quotaKey]*QuotaUsageRecord),
+	}
+}
+
+// Snapshot returns a copy of the whole content of the storage.
+func (s *Storage) Snapshot() *Data {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data := &Data{
+		Clients:     make([]*ClientRecord, 0, len(s.clients)),
+		Plans:       make([]*entity.Plan, 0, len(s.plans)),
+		AccessRules: make([]*entity.AccessRule, 0, len(s.rules)),
+		QuotaUsage:  make([]*QuotaUsageRecord, 0, len(s.quotaUsage)),
+	}
+	for _, record := range s.clients {
+		data.Clients = append(data.Clients, cloneRecord(record))
+	}
+	for _, plan := range s.plans {
+		data.Plans = append(data.Plans, clonePtr(plan))
+	}
+	for _, rule := range s.rules {
+		data.AccessRules = append(data.AccessRules, clonePtr(rule))
+	}
+	for _, usage := range s.quotaUsage {
+		data.QuotaUsage = append(data.QuotaUsage, clonePtr(usage))
+	}
+
+	slices.SortFunc(data.Clients, func(a, b *ClientRecord) int { return cmp.Compare(a.ID, b.ID) })
+	slices.SortFunc(data.Plans, func(a, b *entity.Plan) int { return cmp.Compare(a.ID, b.ID) })
+	slices.SortFunc(data.AccessRules, func(a, b *entity.AccessRule) int { return cmp.Compare(a.ID, b.ID) })
+	slices.SortFunc(data.QuotaUsage, func(a, b *QuotaUsageRecord) int {
+		return cmp.Or(cmp.Compare(a.Key, b.Key), a.PeriodStart.Compare(b.PeriodStart))
+	})
+
+	return data
+}
+
+// Restore replaces the content of the storage with a copy of data.
+func (s *Storage) Restore(data *Data) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.clients)
+	clear(s.plans)
+	clear(s.rules)
+	clear(s.quotaUsage)
+	s.lastID = 0
+
+	for _, record := range data.Clients {
+		s.clients[record.IPAddress] = cloneRecord(record)
+		s.lastID = max(s.lastID, record.ID)
+	}
+	for _, plan := range data.Plans {
+		s.plans[plan.Name] = clonePtr(plan)
+		s.lastID = max(s.lastID, plan.ID)
+	}
+	for _, rule := range data.AccessRules {
+		s.rules[rule.Network] = clonePtr(rule)
+		s.lastID = max(s.lastID, rule.ID)
+	}
+	for _, usage := range data.QuotaUsage {
+		s.quotaUsage[quotaKey{usage.Key, usage.PeriodStart.UnixNano()}] = clonePtr(usage)
+	}
+}
+
+// nextID returns a new identifier. Identifiers are unique across all entities, which is enough
+// for them to be unique within every kind of entity.
+func (s *Storage) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *Storage) Client(_ context.Context, ipAdress string) (*entity.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.clients[ipAdress]
+	if !ok {
+		return nil, storage.ErrClientNotFound
+	}
+
+	return s.withFullBucket(s.effective(record)), nil
+}
+
+// ListClients returns a page of clients selected by the query.
+func (s *Storage) ListClients(_ context.Context, query entity.ClientsQuery) ([]*entity.Client, error) {
+	clients := s.filter(func(*ClientRecord) bool { return true }, false)
+	clients = slices.DeleteFunc(clients, func(client *entity.Client) bool {
+		return !matches(client, query)
+	})
+
+	compare := func(a, b *entity.ClientsCursor) int {
+		c := cmp.Or(compareBy(a, b, query.Sort), cmp.Compare(a.ID, b.ID))
+		if query.Desc {
+			return -c
+		}
+		return c
+	}
+	slices.SortFunc(clients, func(a, b *entity.Client) int {
+		return compare(entity.CursorOf(a), entity.CursorOf(b))
+	})
+
+	if query.After != nil {
+		clients = slices.DeleteFunc(clients, func(client *entity.Client) bool {
+			return compare(entity.CursorOf(client), query.After) <= 0
+		})
+	}
+
+	clients = clients[:min(len(clients), max(query.Limit, 0))]
+	if query.Raw {
+		clients = s.raw(clients)
+	}
+
+	return clients, nil
+}
+
+// raw returns the clients with the limits set for them, skipping the ones deleted in the meantime.
+func (s *Storage) raw(clients []*entity.Client) []*entity.Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	raw := make([]*entity.Client, 0, len(clients))
+	for _, client := range clients {
+		if record, ok := s.clients[client.IPAddress]; ok && record.ID == client.ID {
+			raw = append(raw, record.client())
+		}
+	}
+
+	return raw
+}
+
+// matches reports whether the client passes the filters of the query.
+func matches(client *entity.Client, query entity.ClientsQuery) bool {
+	if !strings.HasPrefix(client.IPAddress, query.IPPrefix) {
+		return false
+	}
+	if query.Network.IsValid() && !withinNetwork(client.IPAddress, query.Network) {
+		return false
+	}
+	if query.Plan != "" && client.Plan != query.Plan {
+		return false
+	}
+	if query.MinCapacity > 0 && client.Capacity < query.MinCapacity {
+		return false
+	}
+	if query.MaxCapacity > 0 && client.Capacity > query.MaxCapacity {
+		return false
+	}
+	return true
+}
+
+// withinNetwork reports whether the address or the range is contained in the network.
+func withinNetwork(ipAddress string, network netip.Prefix) bool {
+	prefix, err := netip.ParsePrefix(ipAddress)
+	if err != nil {
+		addr, err := netip.ParseAddr(ipAddress)
+		if err != nil {
+			return false
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+
+	return prefix.Bits() >= network.Bits() && network.Contains(prefix.Addr())
+}
+
+// compareBy compares the positions by the field the clients are ordered by.
+func compareBy(a, b *entity.ClientsCursor, sort entity.ClientsSort) int {
+	switch sort {
+	case entity.ClientsSortIPAddress:
+		return strings.Compare(a.IPAddress, b.IPAddress)
+	case entity.ClientsSortCapacity:
+		return cmp.Compare(a.Capacity, b.Capacity)
+	case entity.ClientsSortRatePerSecond:
+		return cmp.Compare(a.RatePerSecond, b.RatePerSecond)
+	default:
+		return 0
+	}
+}
+
+// RangeClients returns the clients defined by CIDR ranges rather than single addresses.
+func (s *Storage) RangeClients(context.Context) ([]*entity.Client, error) {
+	return s.filter(func(record *ClientRecord) bool {
+		return strings.Contains(record.IPAddress, "/")
+	}, true), nil
+}
+
+// WarmUpClients returns up to limit clients with single addresses to preload into the cache:
+// pinned clients first, then the clients of plans, then the others in the order they were created.
+func (s *Storage) WarmUpClients(_ context.Context, limit int) ([]*entity.Client, error) {
+	clients := s.filter(func(record *ClientRecord) bool {
+		return !strings.Contains(record.IPAddress, "/")
+	}, true)
+
+	slices.SortStableFunc(clients, func(a, b *entity.Client) int {
+		if a.Pinned != b.Pinned {
+			if a.Pinned {
+				return -1
+			}
+			return 1
+		}
+		if (a.Plan == "") != (b.Plan == "") {
+			if a.Plan != "" {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	})
+
+	return clients[:min(max(limit, 0), len(clients))], nil
+}
+
+// ClientsByAddresses returns the clients with the given addresses or CIDR ranges, missing ones are skipped.
+func (s *Storage) ClientsByAddresses(_ context.Context, ipAddresses []string) ([]*entity.Client, error) {
+	wanted := make(map[string]struct{}, len(ipAddresses))
+	for _, ipAddress := range ipAddresses {
+		wanted[ipAddress] = struct{}{}
+	}
+
+	return s.filter(func(record *ClientRecord) bool {
+		_, ok := wanted[record.IPAddress]
+		return ok
+	}, true), nil
+}
+
+// filter returns the clients for which fn returns true in the order they were created.
+func (s *Storage) filter(fn func(record *ClientRecord) bool, fullBuckets bool) []*entity.Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clients := make([]*entity.Client, 0)
+	for _, record := range s.clients {
+		if !fn(record) {
+			continue
+		}
+
+		client := s.effective(record)
+		if fullBuckets {
+			client = s.withFullBucket(client)
+		}
+		clients = append(clients, client)
+	}
+
+	slices.SortFunc(clients, func(a, b *entity.Client) int { return cmp.Compare(a.ID, b.ID) })
+
+	return clients
+}
+
+// effective returns the client with its effective limits: limits that are not set for the client are taken from its plan.
+func (s *Storage) effective(record *ClientRecord) *entity.Client {
+	client := record.client()
+
+	if plan, ok := s.plans[record.Plan]; ok {
+		client.Capacity = cmp.Or(client.Capacity, plan.Capacity)
+		client.RatePerSecond = cmp.Or(client.RatePerSecond, plan.RatePerSecond)
+		client.MaxConcurrent = cmp.Or(client.MaxConcurrent, plan.MaxConcurrent)
+		client.QuotaPeriod = cmp.Or(client.QuotaPeriod, plan.QuotaPeriod)
+		client.QuotaLimit = cmp.Or(client.QuotaLimit, plan.QuotaLimit)
+	}
+
+	return client
+}
+
+// client returns the client with the limits set for the client itself.
+func (record *ClientRecord) client() *entity.Client {
+	client := &entity.Client{
+		ID:              record.ID,
+		IPAddress:       record.IPAddress,
+		Capacity:        record.Capacity,
+		RatePerSecond:   record.RatePerSecond,
+		LimitMode:       record.LimitMode,
+		MaxConcurrent:   record.MaxConcurrent,
+		QuotaPeriod:     record.QuotaPeriod,
+		QuotaLimit:      record.QuotaLimit,
+		Plan:            record.Plan,
+		PolicyOverrides: maps.Clone(record.PolicyOverrides),
+		Pinned:          record.Pinned,
+	}
+	if client.PolicyOverrides == nil {
+		client.PolicyOverrides = map[string]entity.PolicyOverride{}
+	}
+
+	return client
+}
+
+func (s *Storage) withFullBucket(client *entity.Client) *entity.Client {
+	client.Tokens.Store(client.Capacity)
+	return client
+}
+
+func (s *Storage) CreateClient(_ context.Context, client *entity.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if client.Plan != "" {
+		if _, ok := s.plans[client.Plan]; !ok {
+			return storage.ErrPlanNotFound
+		}
+	}
+	if _, ok := s.clients[client.IPAddress]; ok {
+		return storage.ErrClientExists
+	}
+
+	record := newRecord(client)
+	record.ID = s.nextID()
+	s.clients[client.IPAddress] = record
+
+	return nil
+}
+
+// CreateClients creates the clients with their own limits, skipping the existing ones.
+// It returns the number of created clients.
+func (s *Storage) CreateClients(_ context.Context, clients []*entity.Client) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var created int64
+	for _, client := range clients {
+		if _, ok := s.clients[client.IPAddress]; ok {
+			continue
+		}
+
+		s.clients[client.IPAddress] = &ClientRecord{
+			ID:            s.nextID(),
+			IPAddress:     client.IPAddress,
+			Capacity:      client.Capacity,
+			RatePerSecond: client.RatePerSecond,
+			LimitMode:     entity.LimitModeShared,
+		}
+		created++
+	}
+
+	return created, nil
+}
+
+// ImportClients creates the clients and, if upsert is set, replaces the existing ones, all or nothing.
+// Without upsert an existing client is ErrClientExists. It returns the numbers of created and replaced clients.
+func (s *Storage) ImportClients(_ context.Context, clients []*entity.Client, upsert bool) (created, updated int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, client := range clients {
+		if client.Plan != "" {
+			if _, ok := s.plans[client.Plan]; !ok {
+				return 0, 0, storage.ErrPlanNotFound
+			}
+		}
+		if _, ok := s.clients[client.IPAddress]; ok && !upsert {
+			return 0, 0, storage.ErrClientExists
+		}
+	}
+
+	for _, client := range clients {
+		record := newRecord(client)
+		if old, ok := s.clients[client.IPAddress]; ok {
+			record.ID = old.ID
+			updated++
+		} else {
+			record.ID = s.nextID()
+			created++
+		}
+		s.clients[client.IPAddress] = record
+	}
+
+	return created, updated, nil
+}
+
+func (s *Storage) UpdateClient(_ context.Context, client *entity.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if client.Plan != "" {
+		if _, ok := s.plans[client.Plan]; !ok {
+			return storage.ErrPlanNotFound
+		}
+	}
+
+	old, ok := s.clients[client.IPAddress]
+	if !ok {
+		return storage.ErrClientNotFound
+	}
+
+	record := newRecord(client)
+	record.ID = old.ID
+	s.clients[client.IPAddress] = record
+
+	return nil
+}
+
+func (s *Storage) DeleteClient(_ context.Context, ipAddress string) error {
+	const op = "storage.memory.DeleteClient"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[ipAddress]; !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrClientNotFound)
+	}
+	delete(s.clients, ipAddress)
+
+	return nil
+}
+
+func newRecord(client *entity.Client) *ClientRecord {
+	limitMode := client.LimitMode
+	if limitMode == "" {
+		limitMode = entity.LimitModeShared
+	}
+
+	return &ClientRecord{
+		IPAddress:       client.IPAddress,
+		Capacity:        client.Capacity,
+		RatePerSecond:   client.RatePerSecond,
+		LimitMode:       limitMode,
+		MaxConcurrent:   client.MaxConcurrent,
+		QuotaPeriod:     client.QuotaPeriod,
+		QuotaLimit:      client.QuotaLimit,
+		Plan:            client.Plan,
+		PolicyOverrides: maps.Clone(client.PolicyOverrides),
+		Pinned:          client.Pinned,
+	}
+}
+
+func (s *Storage) Plans(context.Context) ([]*entity.Plan, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	plans := make([]*entity.Plan, 0, len(s.plans))
+	for _, plan := range s.plans {
+		plans = append(plans, clonePtr(plan))
+	}
+
+	slices.SortFunc(plans, func(a, b *entity.Plan) int { return cmp.Compare(a.Name, b.Name) })
+
+	return plans, nil
+}
+
+func (s *Storage) CreatePlan(_ context.Context, plan *entity.Plan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.plans[plan.Name]; ok {
+		return storage.ErrPlanExists
+	}
+
+	plan.ID = s.nextID()
+	s.plans[plan.Name] = clonePtr(plan)
+
+	return nil
+}
+
+func (s *Storage) UpdatePlan(_ context.Context, plan *entity.Plan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.plans[plan.Name]
+	if !ok {
+		return storage.ErrPlanNotFound
+	}
+
+	plan.ID = old.ID
+	s.plans[plan.Name] = clonePtr(plan)
+
+	return nil
+}
+
+func (s *Storage) DeletePlan(_ context.Context, name string) error {
+	const op = "storage.memory.DeletePlan"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.plans[name]; !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrPlanNotFound)
+	}
+	for _, record := range s.clients {
+		if record.Plan == name {
+			return fmt.Errorf("%s: %w", op, storage.ErrPlanInUse)
+		}
+	}
+	delete(s.plans, name)
+
+	return nil
+}
+
+func (s *Storage) AccessRules(context.Context) ([]*entity.AccessRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules := make([]*entity.AccessRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, clonePtr(rule))
+	}
+
+	slices.SortFunc(rules, func(a, b *entity.AccessRule) int { return cmp.Compare(a.ID, b.ID) })
+
+	return rules, nil
+}
+
+func (s *Storage) CreateAccessRule(_ context.Context, rule *entity.AccessRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rules[rule.Network]; ok {
+		return storage.ErrRuleExists
+	}
+
+	rule.ID = s.nextID()
+	rule.CreatedAt = s.now()
+	s.rules[rule.Network] = clonePtr(rule)
+
+	return nil
+}
+
+func (s *Storage) DeleteAccessRule(_ context.Context, network string) error {
+	const op = "storage.memory.DeleteAccessRule"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rules[network]; !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrRuleNotFound)
+	}
+	delete(s.rules, network)
+
+	return nil
+}
+
+func (s *Storage) QuotaUsage(_ context.Context, key string, periodStart time.Time) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if usage, ok := s.quotaUsage[quotaKey{key, periodStart.UnixNano()}]; ok {
+		return usage.Used, nil
+	}
+	return 0, nil
+}
+
+// QuotaUsages returns the usage of every key in the order of the keys, zero for the unknown ones.
+func (s *Storage) QuotaUsages(_ context.Context, keys []entity.QuotaUsageKey) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	used := make([]int64, len(keys))
+	for i, key := range keys {
+		if usage, ok := s.quotaUsage[quotaKey{key.Key, key.PeriodStart.UnixNano()}]; ok {
+			used[i] = usage.Used
+		}
+	}
+	return used, nil
+}
+
+// AddQuotaUsage adds the usage to the persisted one.
+func (s *Storage) AddQuotaUsage(_ context.Context, deltas []entity.QuotaUsageDelta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, delta := range deltas {
+		key := quotaKey{delta.Key, delta.PeriodStart.UnixNano()}
+		usage, ok := s.quotaUsage[key]
+		if !ok {
+			usage = &QuotaUsageRecord{Key: delta.Key, PeriodStart: delta.PeriodStart}
+			s.quotaUsage[key] = usage
+		}
+		usage.Used += delta.Used
+	}
+
+	return nil
+}
+
+func cloneRecord(record *ClientRecord) *ClientRecord {
+	clone := *record
+	clone.PolicyOverrides = maps.Clone(record.PolicyOverrides)
+	return &clone
+}
+
+func clonePtr[T any](v *T) *T {
+	clone := *v
+	return &clone
+}
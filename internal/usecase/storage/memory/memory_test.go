@@ -0,0 +1,14 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/memory"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return memory.New()
+	})
+}
@@ -0,0 +1,69 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+)
+
+// CountersPath is the path on which balancer instances receive counters from their peers.
+const CountersPath = "/v1/internal/ratelimit/counters"
+
+// HTTPTransport sends counters to the peers with a POST request to CountersPath.
+// Peers are identified by the base URLs of their admin listeners, e.g. https://lb-2:8081.
+//
+// The peers authenticate the requests by the bearer token or by the client certificate of tlsConfig,
+// either of them must have the peer role. The token can be empty if the certificate is used.
+type HTTPTransport struct {
+	client *http.Client
+	token  string
+}
+
+// NewHTTPTransport creates a transport. tlsConfig can be nil, then the default TLS settings are used.
+func NewHTTPTransport(timeout time.Duration, token string, tlsConfig *tls.Config) *HTTPTransport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &HTTPTransport{
+		client: &http.Client{Timeout: timeout, Transport: transport},
+		token:  token,
+	}
+}
+
+func (t *HTTPTransport) Send(ctx context.Context, peer string, counters *entity.PeerCounters) error {
+	const op = "peer.HTTPTransport.Send"
+
+	body, err := json.Marshal(counters)
+	if err != nil {
+		return fmt.Errorf("%s: failed to marshal counters: %w", op, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+CountersPath, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: failed to create request: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: failed to send request: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("%s: unexpected status code: %s", op, resp.Status)
+	}
+
+	return nil
+}
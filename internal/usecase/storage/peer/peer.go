@@ -0,0 +1,150 @@
+// Package peer provides a rate-limit backend in which balancer instances exchange
+// their counters directly with each other, without a shared database.
+//
+// Every instance keeps the total amount of tokens it consumed per bucket and sends the totals that
+// changed to all of its peers on every exchange. Totals only grow, so a lost or reordered message
+// is fixed by the next one.
+package peer
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"sync"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/sl"
+)
+
+// Transport delivers counters of this instance to a peer.
+type Transport interface {
+	Send(ctx context.Context, peer string, counters *entity.PeerCounters) error
+}
+
+type Backend struct {
+	log        *slog.Logger
+	instanceID string
+	peers      []string
+	transport  Transport
+
+	mu       sync.Mutex
+	totals   map[string]int64               // string (bucket key) -> total consumption of this instance
+	received map[string]map[string]int64    // string (instance id) -> string (bucket key) -> total consumption
+	seen     map[string]int64               // string (bucket key) -> total consumption of peers at the previous exchange
+	unsent   map[string]map[string]struct{} // string (peer) -> bucket keys that failed to be delivered to the peer
+}
+
+func New(log *slog.Logger, instanceID string, peers []string, transport Transport) *Backend {
+	return &Backend{
+		log:        log,
+		instanceID: instanceID,
+		peers:      peers,
+		transport:  transport,
+		totals:     make(map[string]int64),
+		received:   make(map[string]map[string]int64),
+		seen:       make(map[string]int64),
+		unsent:     make(map[string]map[string]struct{}),
+	}
+}
+
+// Exchange sends the changed totals of this instance to every peer and returns the tokens
+// consumed by the peers since the previous exchange, as far as this instance knows about them.
+//
+// The consumption is accounted in the totals of this instance as soon as Exchange is called,
+// so a failed delivery is not an error: the totals are sent to that peer again on the next exchange.
+func (b *Backend) Exchange(ctx context.Context, consumed map[string]int64) (map[string]int64, error) {
+	b.mu.Lock()
+	changed := make(map[string]struct{})
+	for key, n := range consumed {
+		if n > 0 {
+			b.totals[key] += n
+			changed[key] = struct{}{}
+		}
+	}
+
+	others := make(map[string]int64)
+	seen := make(map[string]int64, len(consumed))
+	for key := range consumed {
+		var total int64
+		for _, totals := range b.received {
+			total += totals[key]
+		}
+
+		if prev, ok := b.seen[key]; ok && total > prev {
+			others[key] = total - prev
+		}
+		seen[key] = total
+	}
+	b.seen = seen
+
+	messages := make(map[string]*entity.PeerCounters, len(b.peers))
+	for _, peer := range b.peers {
+		keys := maps.Clone(changed)
+		maps.Copy(keys, b.unsent[peer])
+		delete(b.unsent, peer)
+
+		if len(keys) == 0 {
+			continue
+		}
+
+		totals := make(map[string]int64, len(keys))
+		for key := range keys {
+			totals[key] = b.totals[key]
+		}
+		messages[peer] = &entity.PeerCounters{
+			InstanceID: b.instanceID,
+			Totals:     totals,
+		}
+	}
+	b.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for peer, counters := range messages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := b.transport.Send(ctx, peer, counters)
+			if err == nil {
+				return
+			}
+
+			b.log.Warn("failed to send counters to peer", slog.String("peer", peer), sl.Error(err))
+
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			unsent, ok := b.unsent[peer]
+			if !ok {
+				unsent = make(map[string]struct{}, len(counters.Totals))
+				b.unsent[peer] = unsent
+			}
+			for key := range counters.Totals {
+				unsent[key] = struct{}{}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return others, nil
+}
+
+// Receive stores the counters sent by a peer.
+func (b *Backend) Receive(counters *entity.PeerCounters) {
+	if counters.InstanceID == b.instanceID {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	totals, ok := b.received[counters.InstanceID]
+	if !ok {
+		totals = make(map[string]int64, len(counters.Totals))
+		b.received[counters.InstanceID] = totals
+	}
+
+	for key, total := range counters.Totals {
+		totals[key] = max(totals[key], total)
+	}
+}
@@ -0,0 +1,119 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
+	"github.com/kurochkinivan/load_balancer/pkg/pgerr"
+)
+
+const (
+	TableAccessRules = "access_rules"
+)
+
+func (s *Storage) AccessRules(ctx context.Context) ([]*entity.AccessRule, error) {
+	const op = "storage.pg.AccessRules"
+
+	sql, args, err := s.qb.
+		Select("id",
+			"network",
+			"action",
+			"comment",
+			"created_at").
+		From(TableAccessRules).
+		OrderBy("id").
+		ToSql()
+	if err != nil {
+		return nil, pgerr.ErrCreateQuery(op, err)
+	}
+
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, pgerr.ErrDoQuery(op, err)
+	}
+	defer rows.Close()
+
+	rules := make([]*entity.AccessRule, 0)
+
+	for rows.Next() {
+		rule := new(entity.AccessRule)
+		err = rows.Scan(
+			&rule.ID,
+			&rule.Network,
+			&rule.Action,
+			&rule.Comment,
+			&rule.CreatedAt,
+		)
+		if err != nil {
+			return nil, pgerr.ErrScan(op, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, pgerr.ErrScan(op, err)
+	}
+
+	return rules, nil
+}
+
+func (s *Storage) CreateAccessRule(ctx context.Context, rule *entity.AccessRule) error {
+	const op = "storage.pg.CreateAccessRule"
+
+	sql, args, err := s.qb.
+		Insert(TableAccessRules).
+		Columns(
+			"network",
+			"action",
+			"comment",
+		).
+		Values(
+			rule.Network,
+			rule.Action,
+			rule.Comment,
+		).
+		Suffix("ON CONFLICT (network) DO NOTHING RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return pgerr.ErrCreateQuery(op, err)
+	}
+
+	err = s.pool.QueryRow(ctx, sql, args...).Scan(&rule.ID, &rule.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.ErrRuleExists
+		}
+
+		return pgerr.ErrScan(op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteAccessRule(ctx context.Context, network string) error {
+	const op = "storage.pg.DeleteAccessRule"
+
+	sql, args, err := s.qb.
+		Delete(TableAccessRules).
+		Where(sq.Eq{"network": network}).
+		ToSql()
+	if err != nil {
+		return pgerr.ErrCreateQuery(op, err)
+	}
+
+	cmd, err := s.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return pgerr.ErrExec(op, err)
+	}
+
+	if cmd.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRuleNotFound)
+	}
+
+	return nil
+}
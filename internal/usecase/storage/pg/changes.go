@@ -0,0 +1,55 @@
+package pg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/pkg/pgerr"
+)
+
+// ChannelClientsChanged is the channel the triggers on the clients and plans tables notify about their changes on.
+const ChannelClientsChanged = "clients_changed"
+
+// ListenClientChanges listens to the changes of clients made by any balancer instance and passes them to onChange.
+// onListen is called once the connection listens to the channel, every change after that is passed to onChange.
+//
+// It holds a dedicated connection until the context is cancelled, then it returns nil,
+// or until the connection is broken, then it returns the error.
+func (s *Storage) ListenClientChanges(ctx context.Context, onListen func(), onChange func(change *entity.ClientChange)) error {
+	const op = "storage.pg.ListenClientChanges"
+
+	poolConn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: failed to acquire connection: %w", op, err)
+	}
+	// The connection is not returned to the pool, so no other query runs on a listening connection.
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{ChannelClientsChanged}.Sanitize())
+	if err != nil {
+		return pgerr.ErrExec(op, err)
+	}
+
+	onListen()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("%s: failed to wait for notification: %w", op, err)
+		}
+
+		change := new(entity.ClientChange)
+		if err := json.Unmarshal([]byte(notification.Payload), change); err != nil {
+			return fmt.Errorf("%s: failed to decode notification %q: %w", op, notification.Payload, err)
+		}
+
+		onChange(change)
+	}
+}
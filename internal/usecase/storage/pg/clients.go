@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
@@ -15,14 +17,9 @@ import (
 func (s *Storage) Client(ctx context.Context, ipAdress string) (*entity.Client, error) {
 	const op = "storage.pg.Client"
 
-	sql, args, err := s.qb.
-		Select("id",
-			"ip_address",
-			"capacity",
-			"rate_per_second").
-		From(TableClients).
+	sql, args, err := s.selectClients().
 		Where(sq.Eq{
-			"ip_address": ipAdress,
+			"c.ip_address": ipAdress,
 		}).
 		ToSql()
 	if err != nil {
@@ -37,6 +34,13 @@ func (s *Storage) Client(ctx context.Context, ipAdress string) (*entity.Client,
 		&client.IPAddress,
 		&client.Capacity,
 		&client.RatePerSecond,
+		&client.LimitMode,
+		&client.MaxConcurrent,
+		&client.QuotaPeriod,
+		&client.QuotaLimit,
+		&client.PolicyOverrides,
+		&client.Plan,
+		&client.Pinned,
 	)
 	client.Tokens.Store(client.Capacity)
 	if err != nil {
@@ -50,16 +54,140 @@ func (s *Storage) Client(ctx context.Context, ipAdress string) (*entity.Client,
 	return client, nil
 }
 
-func (s *Storage) Clients(ctx context.Context) ([]*entity.Client, error) {
-	const op = "storage.pg.Clients"
+// sortColumns are the expressions the clients are ordered by. Addresses are compared byte-wise,
+// whatever the collation of the database.
+var sortColumns = map[entity.ClientsSort]string{
+	entity.ClientsSortID:            "c.id",
+	entity.ClientsSortIPAddress:     `c.ip_address COLLATE "C"`,
+	entity.ClientsSortCapacity:      "COALESCE(c.capacity, p.capacity)",
+	entity.ClientsSortRatePerSecond: "COALESCE(c.rate_per_second, p.rate_per_second)",
+}
 
-	sql, args, err := s.qb.
-		Select("id",
-			"ip_address",
-			"capacity",
-			"rate_per_second").
-		From(TableClients).
-		ToSql()
+// ListClients returns a page of clients selected by the query.
+func (s *Storage) ListClients(ctx context.Context, query entity.ClientsQuery) ([]*entity.Client, error) {
+	const op = "storage.pg.ListClients"
+
+	sortColumn, ok := sortColumns[query.Sort]
+	if !ok {
+		sortColumn = sortColumns[entity.ClientsSortID]
+	}
+
+	where := sq.And{}
+	if query.IPPrefix != "" {
+		where = append(where, sq.Expr(`c.ip_address LIKE ? ESCAPE '\'`, likePrefix(query.IPPrefix)))
+	}
+	if query.Network.IsValid() {
+		where = append(where, sq.Expr("c.ip_address::inet <<= ?::inet", query.Network.String()))
+	}
+	if query.Plan != "" {
+		where = append(where, sq.Eq{"p.name": query.Plan})
+	}
+	if query.MinCapacity > 0 {
+		where = append(where, sq.GtOrEq{sortColumns[entity.ClientsSortCapacity]: query.MinCapacity})
+	}
+	if query.MaxCapacity > 0 {
+		where = append(where, sq.LtOrEq{sortColumns[entity.ClientsSortCapacity]: query.MaxCapacity})
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	if after := query.After; after != nil {
+		where = append(where, sq.Expr(fmt.Sprintf("(%s, c.id) %s (?, ?)", sortColumn, comparison), cursorValue(after, query.Sort), after.ID))
+	}
+
+	sqlQuery := s.selectClients()
+	if query.Raw {
+		sqlQuery = s.selectRawClients()
+	}
+	sqlQuery = sqlQuery.
+		Where(where).
+		OrderBy(sortColumn+" "+direction, "c.id "+direction).
+		Limit(uint64(max(query.Limit, 0)))
+
+	return s.queryClients(ctx, op, sqlQuery)
+}
+
+// cursorValue returns the value of the field the clients are ordered by at the cursor.
+func cursorValue(cursor *entity.ClientsCursor, sort entity.ClientsSort) any {
+	switch sort {
+	case entity.ClientsSortIPAddress:
+		return cursor.IPAddress
+	case entity.ClientsSortCapacity:
+		return cursor.Capacity
+	case entity.ClientsSortRatePerSecond:
+		return cursor.RatePerSecond
+	default:
+		return cursor.ID
+	}
+}
+
+// likePrefix returns a LIKE pattern matching the strings that start with prefix.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
+// RangeClients returns the clients defined by CIDR ranges rather than single addresses.
+func (s *Storage) RangeClients(ctx context.Context) ([]*entity.Client, error) {
+	const op = "storage.pg.RangeClients"
+
+	clients, err := s.clients(ctx, op, sq.Like{"c.ip_address": "%/%"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, client := range clients {
+		client.Tokens.Store(client.Capacity)
+	}
+
+	return clients, nil
+}
+
+// WarmUpClients returns up to limit clients with single addresses to preload into the cache:
+// pinned clients first, then the clients of plans, then the others in the order they were created.
+func (s *Storage) WarmUpClients(ctx context.Context, limit int) ([]*entity.Client, error) {
+	const op = "storage.pg.WarmUpClients"
+
+	query := s.selectClients().
+		Where(sq.NotLike{"c.ip_address": "%/%"}).
+		OrderBy("c.pinned DESC", "c.plan_id IS NULL", "c.id").
+		Limit(uint64(max(limit, 0)))
+
+	clients, err := s.queryClients(ctx, op, query)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, client := range clients {
+		client.Tokens.Store(client.Capacity)
+	}
+
+	return clients, nil
+}
+
+// ClientsByAddresses returns the clients with the given addresses or CIDR ranges, missing ones are skipped.
+func (s *Storage) ClientsByAddresses(ctx context.Context, ipAddresses []string) ([]*entity.Client, error) {
+	const op = "storage.pg.ClientsByAddresses"
+
+	clients, err := s.clients(ctx, op, sq.Expr("c.ip_address = ANY(?)", ipAddresses))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, client := range clients {
+		client.Tokens.Store(client.Capacity)
+	}
+
+	return clients, nil
+}
+
+func (s *Storage) clients(ctx context.Context, op string, where sq.Sqlizer) ([]*entity.Client, error) {
+	return s.queryClients(ctx, op, s.selectClients().Where(where))
+}
+
+func (s *Storage) queryClients(ctx context.Context, op string, query sq.SelectBuilder) ([]*entity.Client, error) {
+	sql, args, err := query.ToSql()
 	if err != nil {
 		return nil, pgerr.ErrCreateQuery(op, err)
 	}
@@ -79,6 +207,13 @@ func (s *Storage) Clients(ctx context.Context) ([]*entity.Client, error) {
 			&client.IPAddress,
 			&client.Capacity,
 			&client.RatePerSecond,
+			&client.LimitMode,
+			&client.MaxConcurrent,
+			&client.QuotaPeriod,
+			&client.QuotaLimit,
+			&client.PolicyOverrides,
+			&client.Plan,
+			&client.Pinned,
 		)
 		if err != nil {
 			return nil, pgerr.ErrScan(op, err)
@@ -93,48 +228,291 @@ func (s *Storage) Clients(ctx context.Context) ([]*entity.Client, error) {
 	return clients, nil
 }
 
+// selectClients returns a query of clients with their effective limits:
+// limits that are not overridden by a client are taken from its plan.
+func (s *Storage) selectClients() sq.SelectBuilder {
+	return s.selectClientsWith(
+		"COALESCE(c.capacity, p.capacity)",
+		"COALESCE(c.rate_per_second, p.rate_per_second)",
+		"COALESCE(c.max_concurrent, p.max_concurrent, 0)",
+		"COALESCE(c.quota_period, p.quota_period, '')",
+		"COALESCE(c.quota_limit, p.quota_limit, 0)",
+	)
+}
+
+// selectRawClients returns a query of clients with the limits set for them, limits taken from the plan are zero.
+func (s *Storage) selectRawClients() sq.SelectBuilder {
+	return s.selectClientsWith(
+		"COALESCE(c.capacity, 0)",
+		"COALESCE(c.rate_per_second, 0)",
+		"COALESCE(c.max_concurrent, 0)",
+		"COALESCE(c.quota_period, '')",
+		"COALESCE(c.quota_limit, 0)",
+	)
+}
+
+func (s *Storage) selectClientsWith(capacity, ratePerSecond, maxConcurrent, quotaPeriod, quotaLimit string) sq.SelectBuilder {
+	return s.qb.
+		Select("c.id",
+			"c.ip_address",
+			capacity,
+			ratePerSecond,
+			"c.limit_mode",
+			maxConcurrent,
+			quotaPeriod,
+			quotaLimit,
+			"c.policy_overrides",
+			"COALESCE(p.name, '')",
+			"c.pinned").
+		From(TableClients + " c").
+		LeftJoin(TablePlans + " p ON p.id = c.plan_id")
+}
+
 func (s *Storage) CreateClient(ctx context.Context, client *entity.Client) error {
 	const op = "storage.pg.CreateClient"
 
+	planID, err := s.planID(ctx, op, client.Plan)
+	if err != nil {
+		return err
+	}
+
+	sql, args, err := s.insertClient(client, planID).
+		Suffix("ON CONFLICT (ip_address) DO NOTHING").
+		ToSql()
+	if err != nil {
+		return pgerr.ErrCreateQuery(op, err)
+	}
+
+	cmdTag, err := s.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return pgerr.ErrExec(op, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return storage.ErrClientExists
+	}
+
+	return nil
+}
+
+// CreateClients creates the clients with their own limits in a single query, skipping the existing ones.
+// It returns the number of created clients.
+func (s *Storage) CreateClients(ctx context.Context, clients []*entity.Client) (int64, error) {
+	const op = "storage.pg.CreateClients"
+
+	ipAddresses := make([]string, len(clients))
+	capacities := make([]int32, len(clients))
+	ratesPerSecond := make([]int32, len(clients))
+	for i, client := range clients {
+		ipAddresses[i] = client.IPAddress
+		capacities[i] = client.Capacity
+		ratesPerSecond[i] = client.RatePerSecond
+	}
+
 	sql, args, err := s.qb.
+		Insert(TableClients).
+		Columns("ip_address", "capacity", "rate_per_second").
+		Select(sq.Select().
+			Column("unnest(?::text[])", ipAddresses).
+			Column("unnest(?::int[])", capacities).
+			Column("unnest(?::int[])", ratesPerSecond),
+		).
+		Suffix("ON CONFLICT (ip_address) DO NOTHING").
+		ToSql()
+	if err != nil {
+		return 0, pgerr.ErrCreateQuery(op, err)
+	}
+
+	cmdTag, err := s.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, pgerr.ErrExec(op, err)
+	}
+
+	return cmdTag.RowsAffected(), nil
+}
+
+func (s *Storage) insertClient(client *entity.Client, planID *int64) sq.InsertBuilder {
+	return s.qb.
 		Insert(TableClients).
 		Columns(
 			"ip_address",
 			"capacity",
 			"rate_per_second",
+			"limit_mode",
+			"max_concurrent",
+			"quota_period",
+			"quota_limit",
+			"policy_overrides",
+			"plan_id",
+			"pinned",
 		).
 		Values(
 			client.IPAddress,
-			client.Capacity,
-			client.RatePerSecond,
-		).
-		Suffix("ON CONFLICT (ip_address) DO NOTHING").
+			override(client, client.Capacity),
+			override(client, client.RatePerSecond),
+			client.LimitMode,
+			override(client, client.MaxConcurrent),
+			override(client, client.QuotaPeriod),
+			override(client, client.QuotaLimit),
+			policyOverrides(client),
+			planID,
+			client.Pinned,
+		)
+}
+
+// importBatchSize is the number of clients sent to the database in a single round trip.
+const importBatchSize = 500
+
+// upsertClient replaces all columns of an existing client, xmax is zero only for an inserted row.
+const upsertClient = `ON CONFLICT (ip_address) DO UPDATE SET
+	capacity = EXCLUDED.capacity,
+	rate_per_second = EXCLUDED.rate_per_second,
+	limit_mode = EXCLUDED.limit_mode,
+	max_concurrent = EXCLUDED.max_concurrent,
+	quota_period = EXCLUDED.quota_period,
+	quota_limit = EXCLUDED.quota_limit,
+	policy_overrides = EXCLUDED.policy_overrides,
+	plan_id = EXCLUDED.plan_id,
+	pinned = EXCLUDED.pinned
+	RETURNING xmax = 0`
+
+// ImportClients creates the clients and, if upsert is set, replaces the existing ones in a single transaction.
+// Without upsert an existing client is ErrClientExists. It returns the numbers of created and replaced clients.
+func (s *Storage) ImportClients(ctx context.Context, clients []*entity.Client, upsert bool) (created, updated int64, err error) {
+	const op = "storage.pg.ImportClients"
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, pgerr.ErrCreateTx(op, err)
+	}
+	defer tx.Rollback(ctx)
+
+	planIDs, err := s.planIDs(ctx, tx, op, clients)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	suffix := "ON CONFLICT (ip_address) DO NOTHING RETURNING true"
+	if upsert {
+		suffix = upsertClient
+	}
+
+	for chunk := range slices.Chunk(clients, importBatchSize) {
+		batch := new(pgx.Batch)
+		for _, client := range chunk {
+			var planID *int64
+			if id, ok := planIDs[client.Plan]; ok {
+				planID = &id
+			}
+
+			sql, args, err := s.insertClient(client, planID).Suffix(suffix).ToSql()
+			if err != nil {
+				return 0, 0, pgerr.ErrCreateQuery(op, err)
+			}
+
+			batch.Queue(sql, args...).QueryRow(func(row pgx.Row) error {
+				var inserted bool
+				if err := row.Scan(&inserted); err != nil {
+					if errors.Is(err, pgx.ErrNoRows) {
+						return storage.ErrClientExists
+					}
+					return err
+				}
+
+				if inserted {
+					created++
+				} else {
+					updated++
+				}
+				return nil
+			})
+		}
+
+		err = tx.SendBatch(ctx, batch).Close()
+		if err != nil {
+			if errors.Is(err, storage.ErrClientExists) {
+				return 0, 0, storage.ErrClientExists
+			}
+			return 0, 0, pgerr.ErrExec(op, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, pgerr.ErrCommit(op, err)
+	}
+
+	return created, updated, nil
+}
+
+// planIDs returns the identifiers of the plans of the clients by their names. The plans are locked
+// until the end of the transaction, so they can not be deleted while the clients reference them.
+func (s *Storage) planIDs(ctx context.Context, tx pgx.Tx, op string, clients []*entity.Client) (map[string]int64, error) {
+	names := make([]string, 0)
+	for _, client := range clients {
+		if client.Plan != "" && !slices.Contains(names, client.Plan) {
+			names = append(names, client.Plan)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	sql, args, err := s.qb.
+		Select("id", "name").
+		From(TablePlans).
+		Where(sq.Expr("name = ANY(?)", names)).
+		Suffix("FOR SHARE").
 		ToSql()
 	if err != nil {
-		return pgerr.ErrCreateQuery(op, err)
+		return nil, pgerr.ErrCreateQuery(op, err)
 	}
 
-	cmdTag, err := s.pool.Exec(ctx, sql, args...)
+	rows, err := tx.Query(ctx, sql, args...)
 	if err != nil {
-		return pgerr.ErrExec(op, err)
+		return nil, pgerr.ErrDoQuery(op, err)
 	}
 
-	if cmdTag.RowsAffected() == 0 {
-		return storage.ErrClientExists
+	var (
+		id   int64
+		name string
+	)
+	ids := make(map[string]int64, len(names))
+	_, err = pgx.ForEachRow(rows, []any{&id, &name}, func() error {
+		ids[name] = id
+		return nil
+	})
+	if err != nil {
+		return nil, pgerr.ErrScan(op, err)
 	}
 
-	return nil
+	if len(ids) != len(names) {
+		return nil, storage.ErrPlanNotFound
+	}
+
+	return ids, nil
 }
 
 func (s *Storage) UpdateClient(ctx context.Context, client *entity.Client) error {
 	const op = "storage.pg.UpdateClient"
 
+	planID, err := s.planID(ctx, op, client.Plan)
+	if err != nil {
+		return err
+	}
+
 	sql, args, err := s.qb.
 		Update(TableClients).
 		SetMap(
 			map[string]interface{}{
-				"capacity":        client.Capacity,
-				"rate_per_second": client.RatePerSecond,
+				"capacity":         override(client, client.Capacity),
+				"rate_per_second":  override(client, client.RatePerSecond),
+				"limit_mode":       client.LimitMode,
+				"max_concurrent":   override(client, client.MaxConcurrent),
+				"quota_period":     override(client, client.QuotaPeriod),
+				"quota_limit":      override(client, client.QuotaLimit),
+				"policy_overrides": policyOverrides(client),
+				"plan_id":          planID,
+				"pinned":           client.Pinned,
 			}).
 		Where(sq.Eq{"ip_address": client.IPAddress}).
 		ToSql()
@@ -176,3 +554,21 @@ func (s *Storage) DeleteClient(ctx context.Context, ipAddress string) error {
 
 	return nil
 }
+
+// policyOverrides returns the policy overrides of the client, never nil, so they are stored as an empty JSON object.
+func policyOverrides(client *entity.Client) map[string]entity.PolicyOverride {
+	if client.PolicyOverrides == nil {
+		return map[string]entity.PolicyOverride{}
+	}
+	return client.PolicyOverrides
+}
+
+// override returns the value of a limit to store. Zero limits of a client that references a plan
+// are stored as NULL, so the limits of the plan are used instead.
+func override[T comparable](client *entity.Client, v T) any {
+	var zero T
+	if client.Plan != "" && v == zero {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,21 @@
+package pg
+
+import (
+	"testing"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverride(t *testing.T) {
+	withPlan := &entity.Client{Plan: "pro"}
+	withoutPlan := &entity.Client{}
+
+	assert.Nil(t, override(withPlan, int32(0)), "a zero limit of a client of a plan is NULL, the plan's limit is used")
+	assert.Nil(t, override(withPlan, entity.QuotaPeriod("")))
+	assert.Equal(t, int32(20), override(withPlan, int32(20)), "a non-zero limit overrides the plan")
+	assert.Equal(t, int64(50), override(withPlan, int64(50)))
+
+	assert.Equal(t, int32(0), override(withoutPlan, int32(0)), "a client without a plan stores its limits as they are")
+	assert.Equal(t, entity.QuotaPeriod(""), override(withoutPlan, entity.QuotaPeriod("")))
+}
@@ -0,0 +1,163 @@
+package pg
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kurochkinivan/load_balancer/pkg/pgerr"
+)
+
+const (
+	TableRateLimitCounters = "rate_limit_counters"
+)
+
+// RateLimitCounters is a rate-limit backend that shares the consumption of several
+// balancer instances through PostgreSQL.
+//
+// Every instance accumulates its total consumption per bucket in its own row with atomic upserts,
+// and reads the totals of the other instances in the same round trip.
+//
+// Rows not updated for staleAfter, e.g. the rows of stopped instances (instance ids change on restart),
+// are left out of the totals and deleted once per staleAfter. A stale row that is updated again starts
+// from scratch, so it does not bring its old consumption back into the totals.
+type RateLimitCounters struct {
+	pool       *pgxpool.Pool
+	qb         sq.StatementBuilderType
+	instanceID string
+	staleAfter time.Duration
+
+	mu          sync.Mutex
+	seen        map[string]int64 // string (bucket key) -> total consumption of other instances at the previous exchange
+	lastCleanup time.Time
+}
+
+func NewRateLimitCounters(pool *pgxpool.Pool, instanceID string, staleAfter time.Duration) *RateLimitCounters {
+	return &RateLimitCounters{
+		pool:        pool,
+		qb:          sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+		instanceID:  instanceID,
+		staleAfter:  staleAfter,
+		seen:        make(map[string]int64),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (s *RateLimitCounters) Exchange(ctx context.Context, consumed map[string]int64) (map[string]int64, error) {
+	const op = "storage.pg.RateLimitCounters.Exchange"
+
+	keys := slices.Collect(maps.Keys(consumed))
+
+	changedKeys := make([]string, 0, len(consumed))
+	changedValues := make([]int64, 0, len(consumed))
+	for key, n := range consumed {
+		if n > 0 {
+			changedKeys = append(changedKeys, key)
+			changedValues = append(changedValues, n)
+		}
+	}
+
+	staleSeconds := s.staleAfter.Seconds()
+
+	// All queries are sent in a single round trip.
+	batch := new(pgx.Batch)
+
+	if len(changedKeys) > 0 {
+		sql, args, err := s.qb.
+			Insert(TableRateLimitCounters).
+			Columns("key", "instance_id", "consumed").
+			Select(sq.Select().
+				Column("unnest(?::text[])", changedKeys).
+				Column("?", s.instanceID).
+				Column("unnest(?::bigint[])", changedValues),
+			).
+			Suffix(`ON CONFLICT (key, instance_id) DO UPDATE
+				SET consumed = CASE
+						WHEN rate_limit_counters.updated_at < now() - make_interval(secs => ?) THEN EXCLUDED.consumed
+						ELSE rate_limit_counters.consumed + EXCLUDED.consumed
+					END,
+					updated_at = now()`, staleSeconds).
+			ToSql()
+		if err != nil {
+			return nil, pgerr.ErrCreateQuery(op, err)
+		}
+		batch.Queue(sql, args...)
+	}
+
+	sql, args, err := s.qb.
+		Select("key", "SUM(consumed)::bigint").
+		From(TableRateLimitCounters).
+		Where(sq.And{
+			sq.Expr("key = ANY(?)", keys),
+			sq.NotEq{"instance_id": s.instanceID},
+			sq.Expr("updated_at >= now() - make_interval(secs => ?)", staleSeconds),
+		}).
+		GroupBy("key").
+		ToSql()
+	if err != nil {
+		return nil, pgerr.ErrCreateQuery(op, err)
+	}
+
+	totals := make(map[string]int64, len(keys))
+	batch.Queue(sql, args...).Query(func(rows pgx.Rows) error {
+		var (
+			key   string
+			total int64
+		)
+		_, err := pgx.ForEachRow(rows, []any{&key, &total}, func() error {
+			totals[key] = total
+			return nil
+		})
+		return err
+	})
+
+	cleanup := s.cleanupDue()
+	if cleanup {
+		sql, args, err := s.qb.
+			Delete(TableRateLimitCounters).
+			Where(sq.Expr("updated_at < now() - make_interval(secs => ?)", staleSeconds)).
+			ToSql()
+		if err != nil {
+			return nil, pgerr.ErrCreateQuery(op, err)
+		}
+		batch.Queue(sql, args...)
+	}
+
+	err = s.pool.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return nil, pgerr.ErrDoQuery(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cleanup {
+		s.lastCleanup = time.Now()
+	}
+
+	others := make(map[string]int64)
+	seen := make(map[string]int64, len(keys))
+	for _, key := range keys {
+		total := totals[key]
+		if prev, ok := s.seen[key]; ok && total > prev {
+			others[key] = total - prev
+		}
+		seen[key] = total
+	}
+	s.seen = seen
+
+	return others, nil
+}
+
+// cleanupDue reports whether the stale rows have not been deleted for staleAfter.
+func (s *RateLimitCounters) cleanupDue() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Since(s.lastCleanup) >= s.staleAfter
+}
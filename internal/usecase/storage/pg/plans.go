@@ -0,0 +1,193 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
+	"github.com/kurochkinivan/load_balancer/pkg/pgerr"
+)
+
+const (
+	TablePlans = "plans"
+)
+
+func (s *Storage) Plans(ctx context.Context) ([]*entity.Plan, error) {
+	const op = "storage.pg.Plans"
+
+	sql, args, err := s.qb.
+		Select("id",
+			"name",
+			"capacity",
+			"rate_per_second",
+			"max_concurrent",
+			"quota_period",
+			"quota_limit").
+		From(TablePlans).
+		OrderBy("name").
+		ToSql()
+	if err != nil {
+		return nil, pgerr.ErrCreateQuery(op, err)
+	}
+
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, pgerr.ErrDoQuery(op, err)
+	}
+	defer rows.Close()
+
+	plans := make([]*entity.Plan, 0)
+
+	for rows.Next() {
+		plan := new(entity.Plan)
+		err = rows.Scan(
+			&plan.ID,
+			&plan.Name,
+			&plan.Capacity,
+			&plan.RatePerSecond,
+			&plan.MaxConcurrent,
+			&plan.QuotaPeriod,
+			&plan.QuotaLimit,
+		)
+		if err != nil {
+			return nil, pgerr.ErrScan(op, err)
+		}
+		plans = append(plans, plan)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, pgerr.ErrScan(op, err)
+	}
+
+	return plans, nil
+}
+
+func (s *Storage) CreatePlan(ctx context.Context, plan *entity.Plan) error {
+	const op = "storage.pg.CreatePlan"
+
+	sql, args, err := s.qb.
+		Insert(TablePlans).
+		Columns(
+			"name",
+			"capacity",
+			"rate_per_second",
+			"max_concurrent",
+			"quota_period",
+			"quota_limit",
+		).
+		Values(
+			plan.Name,
+			plan.Capacity,
+			plan.RatePerSecond,
+			plan.MaxConcurrent,
+			plan.QuotaPeriod,
+			plan.QuotaLimit,
+		).
+		Suffix("ON CONFLICT (name) DO NOTHING RETURNING id").
+		ToSql()
+	if err != nil {
+		return pgerr.ErrCreateQuery(op, err)
+	}
+
+	err = s.pool.QueryRow(ctx, sql, args...).Scan(&plan.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.ErrPlanExists
+		}
+
+		return pgerr.ErrScan(op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdatePlan(ctx context.Context, plan *entity.Plan) error {
+	const op = "storage.pg.UpdatePlan"
+
+	sql, args, err := s.qb.
+		Update(TablePlans).
+		SetMap(
+			map[string]interface{}{
+				"capacity":        plan.Capacity,
+				"rate_per_second": plan.RatePerSecond,
+				"max_concurrent":  plan.MaxConcurrent,
+				"quota_period":    plan.QuotaPeriod,
+				"quota_limit":     plan.QuotaLimit,
+			}).
+		Where(sq.Eq{"name": plan.Name}).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		return pgerr.ErrCreateQuery(op, err)
+	}
+
+	err = s.pool.QueryRow(ctx, sql, args...).Scan(&plan.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.ErrPlanNotFound
+		}
+
+		return pgerr.ErrScan(op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeletePlan(ctx context.Context, name string) error {
+	const op = "storage.pg.DeletePlan"
+
+	sql, args, err := s.qb.
+		Delete(TablePlans).
+		Where(sq.Eq{"name": name}).
+		ToSql()
+	if err != nil {
+		return pgerr.ErrCreateQuery(op, err)
+	}
+
+	cmd, err := s.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		if pgerr.IsForeignKeyViolation(err) {
+			return fmt.Errorf("%s: %w", op, storage.ErrPlanInUse)
+		}
+
+		return pgerr.ErrExec(op, err)
+	}
+
+	if cmd.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrPlanNotFound)
+	}
+
+	return nil
+}
+
+// planID returns the id of the plan with the given name, or nil if the name is empty.
+func (s *Storage) planID(ctx context.Context, op, name string) (*int64, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	sql, args, err := s.qb.
+		Select("id").
+		From(TablePlans).
+		Where(sq.Eq{"name": name}).
+		ToSql()
+	if err != nil {
+		return nil, pgerr.ErrCreateQuery(op, err)
+	}
+
+	var id int64
+	err = s.pool.QueryRow(ctx, sql, args...).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrPlanNotFound
+		}
+
+		return nil, pgerr.ErrScan(op, err)
+	}
+
+	return &id, nil
+}
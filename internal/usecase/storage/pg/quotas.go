@@ -0,0 +1,130 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/pkg/pgerr"
+)
+
+const (
+	TableQuotaUsage = "quota_usage"
+)
+
+func (s *Storage) QuotaUsage(ctx context.Context, key string, periodStart time.Time) (int64, error) {
+	const op = "storage.pg.QuotaUsage"
+
+	sql, args, err := s.qb.
+		Select("used").
+		From(TableQuotaUsage).
+		Where(sq.Eq{
+			"client_key":   key,
+			"period_start": periodStart,
+		}).
+		ToSql()
+	if err != nil {
+		return 0, pgerr.ErrCreateQuery(op, err)
+	}
+
+	var used int64
+	err = s.pool.QueryRow(ctx, sql, args...).Scan(&used)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+
+		return 0, pgerr.ErrScan(op, err)
+	}
+
+	return used, nil
+}
+
+// QuotaUsages returns the usage of every key in the order of the keys, zero for the unknown ones.
+func (s *Storage) QuotaUsages(ctx context.Context, keys []entity.QuotaUsageKey) ([]int64, error) {
+	const op = "storage.pg.QuotaUsages"
+
+	clientKeys := make([]string, len(keys))
+	periodStarts := make([]time.Time, len(keys))
+	for i, key := range keys {
+		clientKeys[i] = key.Key
+		periodStarts[i] = key.PeriodStart
+	}
+
+	sql, args, err := s.qb.
+		Select("client_key", "period_start", "used").
+		From(TableQuotaUsage).
+		Where("(client_key, period_start) IN (SELECT * FROM unnest(?::text[], ?::timestamptz[]))", clientKeys, periodStarts).
+		ToSql()
+	if err != nil {
+		return nil, pgerr.ErrCreateQuery(op, err)
+	}
+
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, pgerr.ErrDoQuery(op, err)
+	}
+	defer rows.Close()
+
+	// The same instant in different locations is the same period.
+	persisted := make(map[entity.QuotaUsageKey]int64)
+	for rows.Next() {
+		var (
+			key  entity.QuotaUsageKey
+			used int64
+		)
+		if err := rows.Scan(&key.Key, &key.PeriodStart, &used); err != nil {
+			return nil, pgerr.ErrScan(op, err)
+		}
+		key.PeriodStart = key.PeriodStart.UTC()
+		persisted[key] = used
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgerr.ErrScan(op, err)
+	}
+
+	used := make([]int64, len(keys))
+	for i, key := range keys {
+		used[i] = persisted[entity.QuotaUsageKey{Key: key.Key, PeriodStart: key.PeriodStart.UTC()}]
+	}
+	return used, nil
+}
+
+// AddQuotaUsage atomically adds the usage to the persisted one with a single upsert.
+func (s *Storage) AddQuotaUsage(ctx context.Context, deltas []entity.QuotaUsageDelta) error {
+	const op = "storage.pg.AddQuotaUsage"
+
+	keys := make([]string, len(deltas))
+	periodStarts := make([]time.Time, len(deltas))
+	used := make([]int64, len(deltas))
+	for i, delta := range deltas {
+		keys[i] = delta.Key
+		periodStarts[i] = delta.PeriodStart
+		used[i] = delta.Used
+	}
+
+	sql, args, err := s.qb.
+		Insert(TableQuotaUsage).
+		Columns("client_key", "period_start", "used").
+		Select(sq.Select().
+			Column("unnest(?::text[])", keys).
+			Column("unnest(?::timestamptz[])", periodStarts).
+			Column("unnest(?::bigint[])", used),
+		).
+		Suffix(`ON CONFLICT (client_key, period_start) DO UPDATE
+			SET used = quota_usage.used + EXCLUDED.used, updated_at = now()`).
+		ToSql()
+	if err != nil {
+		return pgerr.ErrCreateQuery(op, err)
+	}
+
+	_, err = s.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return pgerr.ErrExec(op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package pg_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kurochkinivan/load_balancer/internal/lib/migrate"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/pg"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/storagetest"
+	"github.com/kurochkinivan/load_balancer/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorage runs against the database of LB_TEST_POSTGRES_DSN. The tables of the database are truncated.
+func TestStorage(t *testing.T) {
+	dsn := os.Getenv("LB_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LB_TEST_POSTGRES_DSN is not set")
+	}
+
+	ctx := context.Background()
+
+	pool, err := pgxpool.New(ctx, dsn)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	migrator, err := migrate.New(pool, migrations.FS)
+	require.NoError(t, err)
+	_, err = migrator.Up(ctx)
+	require.NoError(t, err)
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		_, err := pool.Exec(ctx, "TRUNCATE clients, plans, access_rules, quota_usage, rate_limit_counters RESTART IDENTITY")
+		require.NoError(t, err)
+		return pg.New(pool)
+	})
+}
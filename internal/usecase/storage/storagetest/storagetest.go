@@ -0,0 +1,492 @@
+// Package storagetest is a conformance test suite for the storage drivers.
+// Every driver runs the same cases, so the balancer behaves the same whichever driver it uses.
+package storagetest
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/usecase"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Storage is everything a storage driver implements.
+type Storage interface {
+	usecase.ClientStorage
+	usecase.ClientRegistrar
+	usecase.PlanStorage
+	usecase.AccessRuleStorage
+	usecase.QuotaStorage
+}
+
+// Run runs the suite. newStorage is called for every case and must return an empty storage.
+func Run(t *testing.T, newStorage func(t *testing.T) Storage) {
+	t.Run("Clients", func(t *testing.T) { testClients(t, newStorage(t)) })
+	t.Run("CreateClients", func(t *testing.T) { testCreateClients(t, newStorage(t)) })
+	t.Run("ImportClients", func(t *testing.T) { testImportClients(t, newStorage(t)) })
+	t.Run("Queries of clients", func(t *testing.T) { testClientQueries(t, newStorage(t)) })
+	t.Run("ListClients", func(t *testing.T) { testListClients(t, newStorage(t)) })
+	t.Run("Plans", func(t *testing.T) { testPlans(t, newStorage(t)) })
+	t.Run("Plan limits", func(t *testing.T) { testPlanLimits(t, newStorage(t)) })
+	t.Run("Access rules", func(t *testing.T) { testAccessRules(t, newStorage(t)) })
+	t.Run("Quota usage", func(t *testing.T) { testQuotaUsage(t, newStorage(t)) })
+}
+
+func testClients(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	_, err := s.Client(ctx, "10.0.0.1")
+	assert.ErrorIs(t, err, storage.ErrClientNotFound)
+
+	err = s.CreateClient(ctx, &entity.Client{IPAddress: "10.0.0.1", Capacity: 10, RatePerSecond: 2})
+	require.NoError(t, err)
+
+	err = s.CreateClient(ctx, &entity.Client{IPAddress: "10.0.0.1", Capacity: 20, RatePerSecond: 2})
+	assert.ErrorIs(t, err, storage.ErrClientExists)
+
+	client, err := s.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.NotZero(t, client.ID)
+	assert.Equal(t, int32(10), client.Capacity)
+	assert.Equal(t, int32(2), client.RatePerSecond)
+	assert.Equal(t, entity.LimitModeShared, client.LimitMode, "the limit mode is shared by default")
+	assert.Equal(t, int32(10), client.Tokens.Load(), "a read client has a full bucket")
+
+	err = s.UpdateClient(ctx, &entity.Client{
+		IPAddress:       "10.0.0.1",
+		Capacity:        30,
+		RatePerSecond:   3,
+		LimitMode:       entity.LimitModePerIP,
+		MaxConcurrent:   4,
+		QuotaPeriod:     entity.QuotaPeriodDay,
+		QuotaLimit:      1000,
+		PolicyOverrides: map[string]entity.PolicyOverride{"search": {Cost: 5}},
+		Pinned:          true,
+	})
+	require.NoError(t, err)
+
+	updated, err := s.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, client.ID, updated.ID, "the identifier does not change")
+	assert.Equal(t, int32(30), updated.Capacity)
+	assert.Equal(t, int32(3), updated.RatePerSecond)
+	assert.Equal(t, entity.LimitModePerIP, updated.LimitMode)
+	assert.Equal(t, int32(4), updated.MaxConcurrent)
+	assert.Equal(t, entity.QuotaPeriodDay, updated.QuotaPeriod)
+	assert.Equal(t, int64(1000), updated.QuotaLimit)
+	assert.Equal(t, map[string]entity.PolicyOverride{"search": {Cost: 5}}, updated.PolicyOverrides)
+	assert.True(t, updated.Pinned)
+
+	err = s.UpdateClient(ctx, &entity.Client{IPAddress: "10.0.0.2", Capacity: 10, RatePerSecond: 1})
+	assert.ErrorIs(t, err, storage.ErrClientNotFound)
+
+	require.NoError(t, s.DeleteClient(ctx, "10.0.0.1"))
+	assert.ErrorIs(t, s.DeleteClient(ctx, "10.0.0.1"), storage.ErrClientNotFound)
+
+	_, err = s.Client(ctx, "10.0.0.1")
+	assert.ErrorIs(t, err, storage.ErrClientNotFound)
+}
+
+func testCreateClients(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.CreateClient(ctx, &entity.Client{IPAddress: "10.0.0.1", Capacity: 50, RatePerSecond: 5}))
+
+	created, err := s.CreateClients(ctx, []*entity.Client{
+		{IPAddress: "10.0.0.1", Capacity: 10, RatePerSecond: 1},
+		{IPAddress: "10.0.0.2", Capacity: 10, RatePerSecond: 1},
+		{IPAddress: "10.0.0.3", Capacity: 10, RatePerSecond: 1},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), created)
+
+	existing, err := s.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(50), existing.Capacity, "existing clients are not changed")
+
+	registered, err := s.Client(ctx, "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, int32(10), registered.Capacity)
+	assert.Equal(t, entity.LimitModeShared, registered.LimitMode)
+
+	clients, err := s.ListClients(ctx, entity.ClientsQuery{Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, clients, 3)
+}
+
+func testImportClients(t *testing.T, s Storage) {
+	ctx := context.Background()
+	shared, perIP := entity.LimitModeShared, entity.LimitModePerIP
+
+	require.NoError(t, s.CreatePlan(ctx, &entity.Plan{Name: "basic", Capacity: 100, RatePerSecond: 10}))
+	require.NoError(t, s.CreateClient(ctx, &entity.Client{IPAddress: "10.0.0.1", Capacity: 50, RatePerSecond: 5, LimitMode: shared}))
+	existing, err := s.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+
+	_, _, err = s.ImportClients(ctx, []*entity.Client{
+		{IPAddress: "10.0.0.2", Capacity: 10, RatePerSecond: 1, LimitMode: shared},
+		{IPAddress: "10.0.0.1", Capacity: 10, RatePerSecond: 1, LimitMode: shared},
+	}, false)
+	assert.ErrorIs(t, err, storage.ErrClientExists)
+	_, err = s.Client(ctx, "10.0.0.2")
+	assert.ErrorIs(t, err, storage.ErrClientNotFound, "nothing is imported if any client fails")
+
+	_, _, err = s.ImportClients(ctx, []*entity.Client{{IPAddress: "10.0.0.2", Plan: "gold", LimitMode: shared}}, false)
+	assert.ErrorIs(t, err, storage.ErrPlanNotFound)
+
+	created, updated, err := s.ImportClients(ctx, []*entity.Client{
+		{IPAddress: "10.0.0.1", Capacity: 20, RatePerSecond: 2, LimitMode: shared, Pinned: true},
+		{IPAddress: "10.0.0.2", RatePerSecond: 1, LimitMode: shared, Plan: "basic"},
+		{IPAddress: "10.1.0.0/16", Capacity: 10, RatePerSecond: 1, LimitMode: perIP},
+	}, true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), created)
+	assert.Equal(t, int64(1), updated)
+
+	replaced, err := s.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, existing.ID, replaced.ID, "a replaced client keeps its id")
+	assert.Equal(t, int32(20), replaced.Capacity)
+	assert.True(t, replaced.Pinned)
+
+	planClient, err := s.Client(ctx, "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, int32(100), planClient.Capacity, "the capacity is taken from the plan")
+	assert.Equal(t, int32(1), planClient.RatePerSecond)
+
+	raw, err := s.ListClients(ctx, entity.ClientsQuery{Plan: "basic", Raw: true, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, raw, 1)
+	assert.Equal(t, int32(0), raw[0].Capacity, "raw clients hold the limits set for them")
+	assert.Equal(t, int32(1), raw[0].RatePerSecond)
+	assert.Equal(t, "basic", raw[0].Plan)
+}
+
+func testClientQueries(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.CreatePlan(ctx, &entity.Plan{Name: "pro", Capacity: 100, RatePerSecond: 10}))
+	for _, client := range []*entity.Client{
+		{IPAddress: "10.0.0.1", Capacity: 10, RatePerSecond: 1},
+		{IPAddress: "10.0.0.2", Plan: "pro"},
+		{IPAddress: "10.0.0.3", Capacity: 10, RatePerSecond: 1, Pinned: true},
+		{IPAddress: "192.168.0.0/16", Capacity: 10, RatePerSecond: 1},
+	} {
+		require.NoError(t, s.CreateClient(ctx, client))
+	}
+
+	ranges, err := s.RangeClients(ctx)
+	require.NoError(t, err)
+	require.Len(t, ranges, 1)
+	assert.Equal(t, "192.168.0.0/16", ranges[0].IPAddress)
+	assert.Equal(t, int32(10), ranges[0].Tokens.Load(), "ranges have full buckets")
+
+	warmUp, err := s.WarmUpClients(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.3", "10.0.0.2", "10.0.0.1"}, addresses(warmUp),
+		"pinned clients go first, then the clients of plans, ranges are skipped")
+	assert.Equal(t, int32(100), warmUp[1].Tokens.Load())
+
+	warmUp, err = s.WarmUpClients(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.3"}, addresses(warmUp))
+
+	byAddresses, err := s.ClientsByAddresses(ctx, []string{"10.0.0.1", "192.168.0.0/16", "10.0.0.9"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"10.0.0.1", "192.168.0.0/16"}, addresses(byAddresses))
+}
+
+func testListClients(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.CreatePlan(ctx, &entity.Plan{Name: "pro", Capacity: 100, RatePerSecond: 10}))
+	for _, client := range []*entity.Client{
+		{IPAddress: "10.0.0.2", Capacity: 30, RatePerSecond: 3},
+		{IPAddress: "10.0.0.1", Plan: "pro"},
+		{IPAddress: "10.0.1.0/24", Capacity: 20, RatePerSecond: 2},
+		{IPAddress: "192.168.0.1", Capacity: 20, RatePerSecond: 5},
+		{IPAddress: "2001:db8::1", Capacity: 50, RatePerSecond: 5},
+	} {
+		require.NoError(t, s.CreateClient(ctx, client))
+	}
+
+	list := func(query entity.ClientsQuery) []string {
+		t.Helper()
+		if query.Sort == "" {
+			query.Sort = entity.ClientsSortID
+		}
+		if query.Limit == 0 {
+			query.Limit = 10
+		}
+		clients, err := s.ListClients(ctx, query)
+		require.NoError(t, err)
+		return addresses(clients)
+	}
+
+	all := []string{"10.0.0.2", "10.0.0.1", "10.0.1.0/24", "192.168.0.1", "2001:db8::1"}
+	assert.Equal(t, all, list(entity.ClientsQuery{}), "clients are ordered by id by default")
+	assert.Equal(t, all[:2], list(entity.ClientsQuery{Limit: 2}))
+
+	t.Run("Filters", func(t *testing.T) {
+		assert.Equal(t, []string{"10.0.0.2", "10.0.0.1"}, list(entity.ClientsQuery{IPPrefix: "10.0.0."}))
+		assert.Equal(t, []string{"10.0.0.2", "10.0.0.1", "10.0.1.0/24"},
+			list(entity.ClientsQuery{Network: netip.MustParsePrefix("10.0.0.0/16")}), "ranges within the network match")
+		assert.Equal(t, []string{"10.0.0.2", "10.0.0.1"},
+			list(entity.ClientsQuery{Network: netip.MustParsePrefix("10.0.0.0/24")}))
+		assert.Empty(t, list(entity.ClientsQuery{Network: netip.MustParsePrefix("10.0.1.0/25")}),
+			"a range wider than the network does not match")
+		assert.Equal(t, []string{"2001:db8::1"}, list(entity.ClientsQuery{Network: netip.MustParsePrefix("2001:db8::/32")}))
+		assert.Equal(t, []string{"10.0.0.1"}, list(entity.ClientsQuery{Plan: "pro"}))
+		assert.Equal(t, []string{"10.0.0.2", "10.0.0.1", "2001:db8::1"},
+			list(entity.ClientsQuery{MinCapacity: 30}), "the capacity of a plan counts")
+		assert.Equal(t, []string{"10.0.0.2", "10.0.1.0/24", "192.168.0.1"},
+			list(entity.ClientsQuery{MinCapacity: 20, MaxCapacity: 30}))
+		assert.Equal(t, []string{"10.0.0.2"}, list(entity.ClientsQuery{IPPrefix: "10.", MinCapacity: 30, MaxCapacity: 50}))
+	})
+
+	t.Run("Sorting", func(t *testing.T) {
+		assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.1.0/24", "192.168.0.1", "2001:db8::1"},
+			list(entity.ClientsQuery{Sort: entity.ClientsSortIPAddress}))
+		assert.Equal(t, []string{"10.0.0.1", "2001:db8::1", "10.0.0.2", "192.168.0.1", "10.0.1.0/24"},
+			list(entity.ClientsQuery{Sort: entity.ClientsSortCapacity, Desc: true}), "equal values are ordered by id in the same direction")
+		assert.Equal(t, []string{"10.0.1.0/24", "10.0.0.2", "192.168.0.1", "2001:db8::1", "10.0.0.1"},
+			list(entity.ClientsQuery{Sort: entity.ClientsSortRatePerSecond}))
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		for _, query := range []entity.ClientsQuery{
+			{Sort: entity.ClientsSortID},
+			{Sort: entity.ClientsSortIPAddress, Desc: true},
+			{Sort: entity.ClientsSortCapacity},
+			{Sort: entity.ClientsSortCapacity, Desc: true},
+			{Sort: entity.ClientsSortRatePerSecond},
+		} {
+			want := list(query)
+
+			var got []string
+			query.Limit = 2
+			for {
+				clients, err := s.ListClients(ctx, query)
+				require.NoError(t, err)
+				got = append(got, addresses(clients)...)
+				if len(clients) < query.Limit {
+					break
+				}
+				query.After = entity.CursorOf(clients[len(clients)-1])
+			}
+
+			assert.Equal(t, want, got, "sort %s, desc %t", query.Sort, query.Desc)
+		}
+	})
+}
+
+func testPlans(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	pro := &entity.Plan{Name: "pro", Capacity: 100, RatePerSecond: 10, MaxConcurrent: 5}
+	require.NoError(t, s.CreatePlan(ctx, pro))
+	assert.NotZero(t, pro.ID)
+	require.NoError(t, s.CreatePlan(ctx, &entity.Plan{Name: "basic", Capacity: 10, RatePerSecond: 1}))
+
+	assert.ErrorIs(t, s.CreatePlan(ctx, &entity.Plan{Name: "pro", Capacity: 1, RatePerSecond: 1}), storage.ErrPlanExists)
+
+	plans, err := s.Plans(ctx)
+	require.NoError(t, err)
+	require.Len(t, plans, 2)
+	assert.Equal(t, "basic", plans[0].Name, "plans are ordered by name")
+	assert.Equal(t, *pro, *plans[1])
+
+	err = s.CreateClient(ctx, &entity.Client{IPAddress: "10.0.0.1", Plan: "missing"})
+	assert.ErrorIs(t, err, storage.ErrPlanNotFound)
+
+	require.NoError(t, s.CreateClient(ctx, &entity.Client{IPAddress: "10.0.0.1", Plan: "pro", RatePerSecond: 50}))
+
+	client, err := s.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "pro", client.Plan)
+	assert.Equal(t, int32(100), client.Capacity, "zero limits are taken from the plan")
+	assert.Equal(t, int32(50), client.RatePerSecond, "limits of the client override the plan")
+	assert.Equal(t, int32(5), client.MaxConcurrent)
+
+	require.NoError(t, s.UpdatePlan(ctx, &entity.Plan{Name: "pro", Capacity: 200, RatePerSecond: 20}))
+	client, err = s.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(200), client.Capacity, "clients follow the changes of their plan")
+	assert.Equal(t, int32(50), client.RatePerSecond)
+
+	assert.ErrorIs(t, s.UpdatePlan(ctx, &entity.Plan{Name: "missing", Capacity: 1, RatePerSecond: 1}), storage.ErrPlanNotFound)
+
+	assert.ErrorIs(t, s.DeletePlan(ctx, "pro"), storage.ErrPlanInUse)
+	require.NoError(t, s.DeletePlan(ctx, "basic"))
+	assert.ErrorIs(t, s.DeletePlan(ctx, "basic"), storage.ErrPlanNotFound)
+}
+
+func testPlanLimits(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.CreatePlan(ctx, &entity.Plan{
+		Name:          "pro",
+		Capacity:      100,
+		RatePerSecond: 10,
+		MaxConcurrent: 5,
+		QuotaPeriod:   entity.QuotaPeriodDay,
+		QuotaLimit:    1000,
+	}))
+	for _, client := range []*entity.Client{
+		{IPAddress: "10.0.0.1", Plan: "pro"},
+		{IPAddress: "10.0.0.2", Plan: "pro", Capacity: 20, MaxConcurrent: 1, QuotaPeriod: entity.QuotaPeriodHour, QuotaLimit: 50},
+		{IPAddress: "10.0.0.3", Capacity: 10, RatePerSecond: 1},
+		{IPAddress: "192.168.0.0/16", Plan: "pro", RatePerSecond: 50},
+	} {
+		require.NoError(t, s.CreateClient(ctx, client))
+	}
+
+	inherited, err := s.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(100), inherited.Capacity, "all limits are inherited from the plan")
+	assert.Equal(t, int32(10), inherited.RatePerSecond)
+	assert.Equal(t, int32(5), inherited.MaxConcurrent)
+	assert.Equal(t, entity.QuotaPeriodDay, inherited.QuotaPeriod)
+	assert.Equal(t, int64(1000), inherited.QuotaLimit)
+	assert.Equal(t, int32(100), inherited.Tokens.Load(), "the bucket is filled up to the inherited capacity")
+
+	overridden, err := s.Client(ctx, "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, int32(20), overridden.Capacity, "non-zero limits override the plan")
+	assert.Equal(t, int32(10), overridden.RatePerSecond)
+	assert.Equal(t, int32(1), overridden.MaxConcurrent)
+	assert.Equal(t, entity.QuotaPeriodHour, overridden.QuotaPeriod)
+	assert.Equal(t, int64(50), overridden.QuotaLimit)
+
+	withoutPlan, err := s.Client(ctx, "10.0.0.3")
+	require.NoError(t, err)
+	assert.Empty(t, withoutPlan.Plan)
+	assert.Zero(t, withoutPlan.MaxConcurrent, "zero limits of a client without a plan stay zero")
+	assert.Empty(t, withoutPlan.QuotaPeriod)
+
+	ranges, err := s.RangeClients(ctx)
+	require.NoError(t, err)
+	require.Len(t, ranges, 1)
+	assert.Equal(t, int32(100), ranges[0].Capacity, "ranges inherit the limits of their plan")
+	assert.Equal(t, int32(50), ranges[0].RatePerSecond)
+
+	byAddresses, err := s.ClientsByAddresses(ctx, []string{"10.0.0.1"})
+	require.NoError(t, err)
+	require.Len(t, byAddresses, 1)
+	assert.Equal(t, int32(100), byAddresses[0].Capacity)
+
+	list := func(query entity.ClientsQuery) []string {
+		t.Helper()
+		query.Limit = 10
+		clients, err := s.ListClients(ctx, query)
+		require.NoError(t, err)
+		return addresses(clients)
+	}
+	assert.Equal(t, []string{"10.0.0.3", "10.0.0.2", "10.0.0.1", "192.168.0.0/16"},
+		list(entity.ClientsQuery{Sort: entity.ClientsSortCapacity}), "clients are ordered by their effective limits")
+	assert.Equal(t, []string{"10.0.0.1", "192.168.0.0/16"},
+		list(entity.ClientsQuery{Sort: entity.ClientsSortID, MinCapacity: 50}), "filters apply to the effective limits")
+
+	// A limit reset to zero is taken from the plan again.
+	require.NoError(t, s.UpdateClient(ctx, &entity.Client{IPAddress: "10.0.0.2", Plan: "pro", RatePerSecond: 1}))
+	updated, err := s.Client(ctx, "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, int32(100), updated.Capacity)
+	assert.Equal(t, int32(1), updated.RatePerSecond)
+	assert.Equal(t, int32(5), updated.MaxConcurrent)
+	assert.Equal(t, entity.QuotaPeriodDay, updated.QuotaPeriod)
+
+	// A client that leaves the plan must have its own limits.
+	err = s.UpdateClient(ctx, &entity.Client{IPAddress: "10.0.0.1", Capacity: 30, RatePerSecond: 3})
+	require.NoError(t, err)
+	left, err := s.Client(ctx, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Empty(t, left.Plan)
+	assert.Equal(t, int32(30), left.Capacity)
+	assert.Zero(t, left.MaxConcurrent, "the limits of the plan are not kept")
+
+	assert.ErrorIs(t, s.DeletePlan(ctx, "pro"), storage.ErrPlanInUse, "the plan is still used by the others")
+	for _, ipAddress := range []string{"10.0.0.2", "192.168.0.0/16"} {
+		require.NoError(t, s.DeleteClient(ctx, ipAddress))
+	}
+	require.NoError(t, s.DeletePlan(ctx, "pro"), "an unused plan is deleted")
+}
+
+func testAccessRules(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	deny := &entity.AccessRule{Network: "10.0.0.0/8", Action: entity.AccessDeny, Comment: "office"}
+	require.NoError(t, s.CreateAccessRule(ctx, deny))
+	assert.NotZero(t, deny.ID)
+	assert.False(t, deny.CreatedAt.IsZero())
+
+	require.NoError(t, s.CreateAccessRule(ctx, &entity.AccessRule{Network: "10.0.0.1", Action: entity.AccessAllow}))
+
+	err := s.CreateAccessRule(ctx, &entity.AccessRule{Network: "10.0.0.0/8", Action: entity.AccessAllow})
+	assert.ErrorIs(t, err, storage.ErrRuleExists)
+
+	rules, err := s.AccessRules(ctx)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "10.0.0.0/8", rules[0].Network, "rules are ordered by creation")
+	assert.Equal(t, entity.AccessDeny, rules[0].Action)
+	assert.Equal(t, "office", rules[0].Comment)
+
+	require.NoError(t, s.DeleteAccessRule(ctx, "10.0.0.0/8"))
+	assert.ErrorIs(t, s.DeleteAccessRule(ctx, "10.0.0.0/8"), storage.ErrRuleNotFound)
+
+	rules, err = s.AccessRules(ctx)
+	require.NoError(t, err)
+	assert.Len(t, rules, 1)
+}
+
+func testQuotaUsage(t *testing.T, s Storage) {
+	ctx := context.Background()
+	hour := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	used, err := s.QuotaUsage(ctx, "10.0.0.1", hour)
+	require.NoError(t, err)
+	assert.Zero(t, used)
+
+	require.NoError(t, s.AddQuotaUsage(ctx, []entity.QuotaUsageDelta{
+		{Key: "10.0.0.1", PeriodStart: hour, Used: 3},
+		{Key: "10.0.0.2", PeriodStart: hour, Used: 1},
+	}))
+	require.NoError(t, s.AddQuotaUsage(ctx, []entity.QuotaUsageDelta{
+		{Key: "10.0.0.1", PeriodStart: hour, Used: 4},
+		{Key: "10.0.0.1", PeriodStart: hour.Add(time.Hour), Used: 5},
+	}))
+
+	used, err = s.QuotaUsage(ctx, "10.0.0.1", hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), used)
+
+	used, err = s.QuotaUsage(ctx, "10.0.0.1", hour.In(time.FixedZone("UTC+3", 3*60*60)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), used, "the period is an instant, whatever the location")
+
+	used, err = s.QuotaUsage(ctx, "10.0.0.1", hour.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), used)
+
+	usages, err := s.QuotaUsages(ctx, []entity.QuotaUsageKey{
+		{Key: "10.0.0.2", PeriodStart: hour},
+		{Key: "10.0.0.3", PeriodStart: hour},
+		{Key: "10.0.0.1", PeriodStart: hour.In(time.FixedZone("UTC+3", 3*60*60))},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 0, 7}, usages)
+}
+
+func addresses(clients []*entity.Client) []string {
+	ipAddresses := make([]string, len(clients))
+	for i, client := range clients {
+		ipAddresses[i] = client.IPAddress
+	}
+	return ipAddresses
+}
@@ -0,0 +1,227 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/metrics"
+	"github.com/kurochkinivan/load_balancer/internal/lib/sl"
+)
+
+// UnknownClientPolicy defines how the requests of clients that are not in the storage are handled.
+type UnknownClientPolicy string
+
+const (
+	// UnknownClientReject rejects the requests of unknown clients.
+	UnknownClientReject UnknownClientPolicy = "reject"
+	// UnknownClientDefault limits unknown clients by an in-memory bucket with the default limits.
+	UnknownClientDefault UnknownClientPolicy = "default"
+	// UnknownClientRegister does the same as UnknownClientDefault and also registers the clients
+	// in the storage in the background.
+	UnknownClientRegister UnknownClientPolicy = "register"
+)
+
+// IsValid reports whether the policy is known.
+func (p UnknownClientPolicy) IsValid() bool {
+	return p == UnknownClientReject || p == UnknownClientDefault || p == UnknownClientRegister
+}
+
+// StorageFailurePolicy defines how the requests of clients that can not be resolved
+// because the storage is unavailable are handled.
+type StorageFailurePolicy string
+
+const (
+	// StorageFailOpen limits such clients by an in-memory bucket with the default limits.
+	StorageFailOpen StorageFailurePolicy = "fail_open"
+	// StorageFailClosed rejects the requests of such clients.
+	StorageFailClosed StorageFailurePolicy = "fail_closed"
+)
+
+// IsValid reports whether the policy is known.
+func (p StorageFailurePolicy) IsValid() bool {
+	return p == StorageFailOpen || p == StorageFailClosed
+}
+
+type ClientRegistrar interface {
+	CreateClients(ctx context.Context, clients []*entity.Client) (int64, error)
+}
+
+// UnknownClientsOptions configures UnknownClientsUseCase.
+type UnknownClientsOptions struct {
+	Policy UnknownClientPolicy
+	// StorageFailure defines how the clients are handled while the storage is unavailable.
+	StorageFailure       StorageFailurePolicy
+	DefaultCapacity      int32
+	DefaultRatePerSecond int32
+	// RegisterRatePerSecond caps the number of clients registered per second, the rest are not registered.
+	RegisterRatePerSecond int32
+	// BatchSize is the maximum number of clients registered by a single query.
+	BatchSize int
+	// FlushInterval is how often the pending clients are registered.
+	FlushInterval time.Duration
+}
+
+// UnknownClientsUseCase handles the clients that are not in the storage.
+//
+// Unknown clients never cause a write to the storage on the hot path. In the register policy they are
+// queued, deduplicated and registered in batches by StartRegistrar, at most RegisterRatePerSecond per second.
+type UnknownClientsUseCase struct {
+	log      *slog.Logger
+	opts     UnknownClientsOptions
+	cache    ClientCache
+	storage  ClientRegistrar
+	registry *entity.TokenBucket
+
+	mu      sync.Mutex
+	pending map[string]*entity.Client
+	flush   chan struct{}
+
+	failedOpen   *metrics.Counter
+	failedClosed *metrics.Counter
+}
+
+func NewUnknownClients(log *slog.Logger, opts UnknownClientsOptions, cache ClientCache, storage ClientRegistrar, registry *metrics.Registry) *UnknownClientsUseCase {
+	opts.BatchSize = max(opts.BatchSize, 1)
+
+	return &UnknownClientsUseCase{
+		log:          log,
+		opts:         opts,
+		cache:        cache,
+		storage:      storage,
+		registry:     entity.NewTokenBucket(opts.RegisterRatePerSecond, opts.RegisterRatePerSecond),
+		pending:      make(map[string]*entity.Client),
+		flush:        make(chan struct{}, 1),
+		failedOpen:   registry.Counter("lb_storage_fail_open_total", "Requests limited by the default limits because the storage is unavailable."),
+		failedClosed: registry.Counter("lb_storage_fail_closed_total", "Requests rejected because the storage is unavailable."),
+	}
+}
+
+// Client returns the client to limit the requests of an unknown client with, or false if they are rejected.
+// The client is cached, so the following requests of the client spend tokens of the same bucket.
+//
+// This method is concurrently safe.
+func (u *UnknownClientsUseCase) Client(ctx context.Context, ipAddress string) (*entity.Client, bool) {
+	if u.opts.Policy == UnknownClientReject {
+		return nil, false
+	}
+
+	return u.defaultClient(ipAddress, u.opts.Policy == UnknownClientRegister), true
+}
+
+// Unavailable returns the client to limit the requests of a client that can not be resolved
+// because the storage is unavailable, or false if they are rejected (fail-closed).
+//
+// In the fail-open policy the client gets a cached bucket with the default limits and is never registered,
+// since it may exist in the storage. The cache is purged once the storage is available again.
+//
+// This method is concurrently safe.
+func (u *UnknownClientsUseCase) Unavailable(ctx context.Context, ipAddress string) (*entity.Client, bool) {
+	if u.opts.StorageFailure != StorageFailOpen {
+		u.failedClosed.Inc()
+		return nil, false
+	}
+
+	u.failedOpen.Inc()
+	return u.defaultClient(ipAddress, false), true
+}
+
+// defaultClient returns the cached client with the given address or caches a new one with the default limits.
+func (u *UnknownClientsUseCase) defaultClient(ipAddress string, register bool) *entity.Client {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// Concurrent requests of a new client share a single bucket.
+	if client, ok := u.cache.Client(ipAddress); ok {
+		return client
+	}
+
+	client := &entity.Client{
+		IPAddress:     ipAddress,
+		Capacity:      u.opts.DefaultCapacity,
+		RatePerSecond: u.opts.DefaultRatePerSecond,
+		LimitMode:     entity.LimitModeShared,
+	}
+	client.Tokens.Store(client.Capacity)
+	u.cache.UpdateClient(client)
+
+	if register {
+		u.enqueueLocked(client)
+	}
+
+	return client
+}
+
+func (u *UnknownClientsUseCase) enqueueLocked(client *entity.Client) {
+	if _, ok := u.pending[client.IPAddress]; ok {
+		return
+	}
+
+	if !u.registry.AllowN(1) {
+		u.log.Debug("client registration rate exceeded", slog.String("ip_address", client.IPAddress))
+		return
+	}
+
+	u.pending[client.IPAddress] = client
+
+	if len(u.pending) >= u.opts.BatchSize {
+		select {
+		case u.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// StartRegistrar registers the pending clients every flush interval, or as soon as a batch is full,
+// until the context is cancelled. It does nothing unless the policy is UnknownClientRegister.
+func (u *UnknownClientsUseCase) StartRegistrar(ctx context.Context) {
+	if u.opts.Policy != UnknownClientRegister {
+		return
+	}
+
+	ticker := time.NewTicker(u.opts.FlushInterval)
+	defer ticker.Stop()
+
+	refill := time.NewTicker(1 * time.Second)
+	defer refill.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			u.Flush(ctx)
+		case <-u.flush:
+			u.Flush(ctx)
+		case <-refill.C:
+			u.registry.RefillOncePerSecond()
+		case <-ctx.Done():
+			u.log.Info("client registrar is terminated due to context cancellation")
+			return
+		}
+	}
+}
+
+// Flush registers the pending clients.
+func (u *UnknownClientsUseCase) Flush(ctx context.Context) {
+	u.mu.Lock()
+	clients := make([]*entity.Client, 0, len(u.pending))
+	for _, client := range u.pending {
+		clients = append(clients, client)
+	}
+	clear(u.pending)
+	u.mu.Unlock()
+
+	for batch := range slices.Chunk(clients, u.opts.BatchSize) {
+		registered, err := u.storage.CreateClients(ctx, batch)
+		if err != nil {
+			// The clients are still limited by their cached buckets and are queued again
+			// once they are evicted from the cache and show up again.
+			u.log.Error("failed to register clients", slog.Int("count", len(batch)), sl.Error(err))
+			continue
+		}
+
+		u.log.Info("clients are registered", slog.Int64("count", registered))
+	}
+}
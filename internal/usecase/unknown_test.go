@@ -0,0 +1,111 @@
+package usecase
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/kurochkinivan/load_balancer/internal/entity"
+	"github.com/kurochkinivan/load_balancer/internal/lib/metrics"
+	"github.com/kurochkinivan/load_balancer/internal/usecase/storage/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type registrarMock struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (r *registrarMock) CreateClients(_ context.Context, clients []*entity.Client) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	batch := make([]string, len(clients))
+	for i, client := range clients {
+		batch[i] = client.IPAddress
+	}
+	r.batches = append(r.batches, batch)
+	return int64(len(clients)), nil
+}
+
+func newTestUnknownClients(policy UnknownClientPolicy, registrar ClientRegistrar) *UnknownClientsUseCase {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewUnknownClients(log, UnknownClientsOptions{
+		Policy:                policy,
+		DefaultCapacity:       10,
+		DefaultRatePerSecond:  1,
+		RegisterRatePerSecond: 2,
+		BatchSize:             10,
+	}, cache.NewClientsCache(log, cache.Options{MaxElements: 100}), registrar, metrics.NewRegistry())
+}
+
+func TestUnknownClientsUseCase_Client(t *testing.T) {
+	t.Run("Reject", func(t *testing.T) {
+		u := newTestUnknownClients(UnknownClientReject, &registrarMock{})
+
+		_, ok := u.Client(context.Background(), "10.0.0.1")
+		assert.False(t, ok)
+	})
+
+	t.Run("Default bucket is shared by the requests of a client", func(t *testing.T) {
+		registrar := &registrarMock{}
+		u := newTestUnknownClients(UnknownClientDefault, registrar)
+
+		first, ok := u.Client(context.Background(), "10.0.0.1")
+		require.True(t, ok)
+		assert.Equal(t, int32(10), first.Capacity)
+
+		second, ok := u.Client(context.Background(), "10.0.0.1")
+		require.True(t, ok)
+		assert.Same(t, first, second)
+
+		u.Flush(context.Background())
+		assert.Empty(t, registrar.batches)
+	})
+
+	t.Run("Register deduplicates and caps the rate", func(t *testing.T) {
+		registrar := &registrarMock{}
+		u := newTestUnknownClients(UnknownClientRegister, registrar)
+
+		for _, ip := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+			_, ok := u.Client(context.Background(), ip)
+			require.True(t, ok)
+		}
+
+		u.Flush(context.Background())
+		require.Len(t, registrar.batches, 1)
+		assert.ElementsMatch(t, []string{"10.0.0.1", "10.0.0.2"}, registrar.batches[0])
+
+		// The rest are limited by their default buckets without being registered.
+		_, ok := u.Client(context.Background(), "10.0.0.3")
+		assert.True(t, ok)
+	})
+}
+
+func TestUnknownClientsUseCase_Unavailable(t *testing.T) {
+	t.Run("Fail closed", func(t *testing.T) {
+		u := newTestUnknownClients(UnknownClientRegister, &registrarMock{})
+		u.opts.StorageFailure = StorageFailClosed
+
+		_, ok := u.Unavailable(context.Background(), "10.0.0.1")
+		assert.False(t, ok)
+		assert.Equal(t, int64(1), u.failedClosed.Value())
+	})
+
+	t.Run("Fail open is not registered", func(t *testing.T) {
+		registrar := &registrarMock{}
+		u := newTestUnknownClients(UnknownClientRegister, registrar)
+		u.opts.StorageFailure = StorageFailOpen
+
+		client, ok := u.Unavailable(context.Background(), "10.0.0.1")
+		require.True(t, ok)
+		assert.Equal(t, int32(10), client.Capacity)
+		assert.Equal(t, int64(1), u.failedOpen.Value())
+
+		u.Flush(context.Background())
+		assert.Empty(t, registrar.batches)
+	})
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS clients_pinned_idx;
+ALTER TABLE clients DROP COLUMN IF EXISTS pinned;
@@ -0,0 +1,4 @@
+ALTER TABLE clients
+    ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS clients_pinned_idx ON clients (id) WHERE pinned;
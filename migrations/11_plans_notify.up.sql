@@ -0,0 +1,15 @@
+-- Clients inherit the limits of their plan, so a changed plan changes all of them.
+-- The change is sent on the channel of the clients, the listeners evict the clients of the plan.
+CREATE OR REPLACE FUNCTION notify_plans_changed() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('clients_changed', json_build_object(
+        'op', lower(TG_OP),
+        'plan', OLD.name
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER plans_changed
+    AFTER UPDATE OR DELETE ON plans
+    FOR EACH ROW EXECUTE FUNCTION notify_plans_changed();
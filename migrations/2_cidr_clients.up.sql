@@ -0,0 +1,3 @@
+ALTER TABLE clients
+    ADD COLUMN IF NOT EXISTS limit_mode TEXT NOT NULL DEFAULT 'shared'
+        CHECK (limit_mode IN ('shared', 'per_ip'));
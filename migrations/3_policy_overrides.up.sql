@@ -0,0 +1,2 @@
+ALTER TABLE clients
+    ADD COLUMN IF NOT EXISTS policy_overrides JSONB NOT NULL DEFAULT '{}'::jsonb;
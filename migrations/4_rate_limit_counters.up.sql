@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS rate_limit_counters (
+    key TEXT NOT NULL,
+    instance_id TEXT NOT NULL,
+    consumed BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (key, instance_id)
+);
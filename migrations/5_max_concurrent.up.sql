@@ -0,0 +1,2 @@
+ALTER TABLE clients
+    ADD COLUMN IF NOT EXISTS max_concurrent INT NOT NULL DEFAULT 0 CHECK (max_concurrent >= 0);
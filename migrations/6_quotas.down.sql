@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS quota_usage;
+
+ALTER TABLE clients
+    DROP COLUMN IF EXISTS quota_period,
+    DROP COLUMN IF EXISTS quota_limit;
@@ -0,0 +1,12 @@
+ALTER TABLE clients
+    ADD COLUMN IF NOT EXISTS quota_period TEXT NOT NULL DEFAULT ''
+        CHECK (quota_period IN ('', 'hour', 'day', 'month')),
+    ADD COLUMN IF NOT EXISTS quota_limit BIGINT NOT NULL DEFAULT 0 CHECK (quota_limit >= 0);
+
+CREATE TABLE IF NOT EXISTS quota_usage (
+    client_key TEXT NOT NULL,
+    period_start TIMESTAMPTZ NOT NULL,
+    used BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (client_key, period_start)
+);
@@ -0,0 +1,21 @@
+DROP INDEX IF EXISTS clients_plan_id_idx;
+
+UPDATE clients c SET
+    capacity = COALESCE(c.capacity, p.capacity),
+    rate_per_second = COALESCE(c.rate_per_second, p.rate_per_second),
+    max_concurrent = COALESCE(c.max_concurrent, p.max_concurrent),
+    quota_period = COALESCE(c.quota_period, p.quota_period),
+    quota_limit = COALESCE(c.quota_limit, p.quota_limit)
+FROM plans p
+WHERE p.id = c.plan_id;
+
+ALTER TABLE clients
+    DROP CONSTRAINT IF EXISTS clients_limits_check,
+    DROP COLUMN IF EXISTS plan_id,
+    ALTER COLUMN capacity SET NOT NULL,
+    ALTER COLUMN rate_per_second SET NOT NULL,
+    ALTER COLUMN max_concurrent SET NOT NULL,
+    ALTER COLUMN quota_period SET NOT NULL,
+    ALTER COLUMN quota_limit SET NOT NULL;
+
+DROP TABLE IF EXISTS plans;
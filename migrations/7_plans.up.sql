@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS plans (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY,
+    name TEXT UNIQUE NOT NULL,
+    capacity INT NOT NULL,
+    rate_per_second INT NOT NULL,
+    max_concurrent INT NOT NULL DEFAULT 0 CHECK (max_concurrent >= 0),
+    quota_period TEXT NOT NULL DEFAULT ''
+        CHECK (quota_period IN ('', 'hour', 'day', 'month')),
+    quota_limit BIGINT NOT NULL DEFAULT 0 CHECK (quota_limit >= 0),
+    PRIMARY KEY (id)
+);
+
+-- Limits of a client that references a plan are overrides, NULL means that the limit of the plan is used.
+ALTER TABLE clients
+    ADD COLUMN IF NOT EXISTS plan_id BIGINT REFERENCES plans (id) ON DELETE RESTRICT,
+    ALTER COLUMN capacity DROP NOT NULL,
+    ALTER COLUMN rate_per_second DROP NOT NULL,
+    ALTER COLUMN max_concurrent DROP NOT NULL,
+    ALTER COLUMN quota_period DROP NOT NULL,
+    ALTER COLUMN quota_limit DROP NOT NULL,
+    ADD CONSTRAINT clients_limits_check
+        CHECK (plan_id IS NOT NULL OR (capacity IS NOT NULL AND rate_per_second IS NOT NULL));
+
+CREATE INDEX IF NOT EXISTS clients_plan_id_idx ON clients (plan_id);
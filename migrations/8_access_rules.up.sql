@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS access_rules (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY,
+    network TEXT UNIQUE NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('allow', 'deny')),
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (id)
+);
@@ -0,0 +1,13 @@
+CREATE OR REPLACE FUNCTION notify_clients_changed() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('clients_changed', json_build_object(
+        'op', lower(TG_OP),
+        'ip_address', CASE WHEN TG_OP = 'DELETE' THEN OLD.ip_address ELSE NEW.ip_address END
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER clients_changed
+    AFTER INSERT OR UPDATE OR DELETE ON clients
+    FOR EACH ROW EXECUTE FUNCTION notify_clients_changed();
@@ -0,0 +1,17 @@
+// Package migrations embeds the versioned schema migrations, see the migrate package.
+package migrations
+
+import "embed"
+
+// FS holds the migrations named <version>_<name>.up.sql and <version>_<name>.down.sql.
+//
+//go:embed *.sql
+var FS embed.FS
+
+// InitVersion is the version of the databases created before the migrations were tracked:
+// docker-entrypoint-initdb.d created them from 1_init.up.sql only. InitColumns are the columns of their tables.
+const InitVersion = 1
+
+var InitColumns = map[string][]string{
+	"clients": {"id", "ip_address", "capacity", "rate_per_second"},
+}
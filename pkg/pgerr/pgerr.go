@@ -7,6 +7,10 @@ import (
 	"github.com/pkg/errors"
 )
 
+const (
+	foreignKeyViolation = "23503"
+)
+
 var (
 	ErrNoRowsAffected = errors.New("no rows affected")
 	ErrNoRows         = errors.New("no rows in the result set")
@@ -53,3 +57,9 @@ func ParsePgErr(err error) error {
 	}
 	return err
 }
+
+// IsForeignKeyViolation reports whether the error is caused by a violation of a foreign key constraint.
+func IsForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation
+}